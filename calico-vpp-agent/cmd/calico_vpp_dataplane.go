@@ -27,6 +27,8 @@ import (
 	"github.com/sirupsen/logrus"
 	grpc "google.golang.org/grpc"
 	tomb "gopkg.in/tomb.v2"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 
@@ -76,6 +78,10 @@ func main() {
 	if err != nil {
 		log.Fatalf("Error loading configuration: %v", err)
 	}
+	err = connectivity.ValidateConfig()
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
 	config.PrintAgentConfig(log)
 	log.SetLevel(config.LogLevel)
 
@@ -119,6 +125,13 @@ func main() {
 	if err != nil {
 		log.Fatalf("cannot create k8s client %s", err)
 	}
+	/* Informers shared by the components watching k8s resources, started once they are all registered */
+	informerFactory := informers.NewSharedInformerFactory(k8sclient, 0)
+	dynamicClient, err := dynamic.NewForConfig(clusterConfig)
+	if err != nil {
+		log.Fatalf("cannot create k8s dynamic client %s", err)
+	}
+	common.InitEventRecorder(k8sclient, log.WithFields(logrus.Fields{"component": "events"}))
 	bgpServer := bgpserver.NewBgpServer(
 		bgpserver.GrpcListenAddress("localhost:50051"),
 		bgpserver.GrpcOption([]grpc.ServerOption{
@@ -138,12 +151,17 @@ func main() {
 	prefixWatcher := watchers.NewPrefixWatcher(client, log.WithFields(logrus.Fields{"subcomponent": "prefix-watcher"}))
 	// TODO kernelWatcher := watchers.NewKernelWatcher(ipam, log.WithFields(logrus.Fields{"subcomponent": "kernel-watcher"}))
 	peerWatcher := watchers.NewPeerWatcher(clientv3, log.WithFields(logrus.Fields{"subcomponent": "peer-watcher"}))
-	connectivityServer = connectivity.NewConnectivityServer(vpp, ipam, clientv3, log.WithFields(logrus.Fields{"subcomponent": "connectivity"}))
+	ipsecPSKWatcher := watchers.NewIPsecPSKWatcher(k8sclient, log.WithFields(logrus.Fields{"subcomponent": "ipsec-psk-watcher"}))
+	connectivityServer = connectivity.NewConnectivityServer(vpp, ipam, clientv3, k8sclient, informerFactory, log.WithFields(logrus.Fields{"subcomponent": "connectivity"}))
 	routingServer := routing.NewRoutingServer(vpp, bgpServer, log.WithFields(logrus.Fields{"component": "routing"}))
 	serviceServer := services.NewServiceServer(vpp, k8sclient, log.WithFields(logrus.Fields{"component": "services"}))
 	prometheusServer := prometheus.NewPrometheusServer(vpp, log.WithFields(logrus.Fields{"component": "prometheus"}))
-	cniServer := cni.NewCNIServer(vpp, ipam, log.WithFields(logrus.Fields{"component": "cni"}))
-	localSIDWatcher := watchers.NewLocalSIDWatcher(vpp, clientv3, log.WithFields(logrus.Fields{"subcomponent": "localsid-watcher"}))
+	prometheusServer.SetConnectivityStatusSource(connectivityServer)
+	prometheusServer.SetIPsecStatusSource(connectivityServer)
+	prometheusServer.SetProbeStatusSource(connectivityServer)
+	cniServer := cni.NewCNIServer(vpp, ipam, k8sclient, informerFactory, log.WithFields(logrus.Fields{"component": "cni"}))
+	localSIDWatcher := watchers.NewLocalSIDWatcher(vpp, clientv3, k8sclient, log.WithFields(logrus.Fields{"subcomponent": "localsid-watcher"}))
+	srv6PolicyWatcher := watchers.NewSRv6PolicyWatcher(dynamicClient, informerFactory, log.WithFields(logrus.Fields{"subcomponent": "srv6-policy-watcher"}))
 	policyServer, err := policy.NewPolicyServer(vpp, log.WithFields(logrus.Fields{"component": "policy"}))
 	if err != nil {
 		log.Fatalf("Failed to create policy server %s", err)
@@ -169,6 +187,7 @@ func main() {
 	connectivityServer.SetOurBGPSpec(ourBGPSpec)
 	routingServer.SetOurBGPSpec(ourBGPSpec)
 	serviceServer.SetOurBGPSpec(ourBGPSpec)
+	cniServer.SetOurBGPSpec(ourBGPSpec)
 	policyServer.SetOurBGPSpec(ourBGPSpec)
 	localSIDWatcher.SetOurBGPSpec(ourBGPSpec)
 
@@ -197,11 +216,18 @@ func main() {
 	Go(serviceServer.ServeService)
 	Go(cniServer.ServeCNI)
 	Go(prometheusServer.ServePrometheus)
+	informerFactory.Start(t.Dying())
 	// TODO : Go(kernelWatcher.WatchKernelRoute)
 
-	// watch LocalSID if SRv6 is enabled
+	// watch LocalSID & SRv6 policies if SRv6 is enabled
 	if config.EnableSRv6 {
 		Go(localSIDWatcher.WatchLocalSID)
+		Go(srv6PolicyWatcher.WatchSRv6Policies)
+	}
+
+	// watch the IPsec PSK secret for key rotations
+	if config.EnableIPSec && config.IPSecIkev2PskSecret != "" {
+		Go(ipsecPSKWatcher.WatchIPsecPSK)
 	}
 
 	log.Infof("Agent started")
@@ -0,0 +1,82 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/config"
+	log "github.com/sirupsen/logrus"
+)
+
+func main() {
+	var socket, namespace, pod, iface string
+	var maxPackets uint
+	var duration time.Duration
+	flag.StringVar(&socket, "s", config.PodCaptureSocket, "Agent capture socket path")
+	flag.StringVar(&namespace, "n", "default", "Pod namespace")
+	flag.StringVar(&pod, "p", "", "Pod name")
+	flag.StringVar(&iface, "i", "tun", "Pod interface to capture on (tun or memif)")
+	flag.UintVar(&maxPackets, "c", 1000, "Maximum number of packets to capture")
+	flag.DurationVar(&duration, "d", 10*time.Second, "Capture duration")
+	flag.Parse()
+
+	if pod == "" {
+		log.Errorf("Please specify a pod name with -p")
+		return
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	params := url.Values{}
+	params.Set("namespace", namespace)
+	params.Set("pod", pod)
+	params.Set("interface", iface)
+	params.Set("max", strconv.FormatUint(uint64(maxPackets), 10))
+	params.Set("duration", duration.String())
+
+	log.Infof("Capturing on %s/%s %s for %s...", namespace, pod, iface, duration)
+	resp, err := client.Get("http://calico-vpp-agent/capture?" + params.Encode())
+	if err != nil {
+		log.Errorf("Capture request errored: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Errorf("Error reading capture reply: %v", err)
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		log.Errorf("Capture failed: %s", strings.TrimSpace(string(body)))
+		return
+	}
+	log.Infof("Capture written to %s on the host", strings.TrimSpace(string(body)))
+}
@@ -20,16 +20,22 @@ import (
 	"fmt"
 	types2 "git.fd.io/govpp.git/api/v0"
 	"net"
+	"net/http"
 	"os"
 	"sync"
 	"syscall"
 
 	"github.com/pkg/errors"
 	felixConfig "github.com/projectcalico/calico/felix/config"
+	oldv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
 	pb "github.com/projectcalico/vpp-dataplane/calico-vpp-agent/proto"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 	tomb "gopkg.in/tomb.v2"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 
 	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/cni/pod_interface"
 	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/cni/storage"
@@ -44,12 +50,18 @@ type Server struct {
 	log *logrus.Entry
 	vpp *vpplink.VppLink
 
-	ipam watchers.IpamCache
+	ipam        watchers.IpamCache
+	nodeBGPSpec *oldv3.NodeBGPSpec
+	k8sclient   *kubernetes.Clientset
 
-	grpcServer *grpc.Server
+	grpcServer    *grpc.Server
+	captureServer *http.Server
+	/* cancels the running capture when we stop */
+	cancelCaptures context.CancelFunc
 
 	podInterfaceMap map[string]storage.LocalPodSpec
 	lock            sync.Mutex /* protects Add/DelVppInterace/RescanState */
+	captureLock     sync.Mutex /* VPP only supports one pcap capture at a time */
 
 	memifDriver    *pod_interface.MemifPodInterfaceDriver
 	tuntapDriver   *pod_interface.TunTapPodInterfaceDriver
@@ -58,6 +70,28 @@ type Server struct {
 
 	availableBuffers    uint64
 	buffersNeededPerTap uint64
+
+	tenants         map[string]*TenantVRF
+	namespaceLister listersv1.NamespaceLister
+	podLister       listersv1.PodLister
+	serviceLister   listersv1.ServiceLister
+	endpointsLister listersv1.EndpointsLister
+	informersSynced []cache.InformerSynced
+	/* the pods, services & endpoints informers only run once we have a tenant */
+	informerFactory       informers.SharedInformerFactory
+	informerStop          <-chan struct{}
+	tenantInformersSynced []cache.InformerSynced
+	/* tenants whose members changed, resynced by the CNI loop */
+	pendingTenantsLock sync.Mutex
+	pendingTenants     map[string]bool
+	tenantsChanged     chan struct{}
+	/* events to the connectivity server, sent once s.lock is released */
+	tenantEventsLock sync.Mutex
+	tenantEvents     []common.CalicoVppEvent
+
+	egressIPs map[string]map[string]bool /* egress address -> pods using it */
+
+	cniEventChan chan common.CalicoVppEvent
 }
 
 func swIfIdxToIfName(idx uint32) string {
@@ -77,6 +111,10 @@ func getHostEndpointProto(proto string) types.IPProto {
 	}
 }
 
+func (s *Server) SetOurBGPSpec(nodeBGPSpec *oldv3.NodeBGPSpec) {
+	s.nodeBGPSpec = nodeBGPSpec
+}
+
 func (s *Server) SetFelixConfig(felixConfig *felixConfig.Config) {
 	s.tuntapDriver.SetFelixConfig(felixConfig)
 }
@@ -109,16 +147,22 @@ func (s *Server) newLocalPodSpecFromAdd(request *pb.AddRequest) (*storage.LocalP
 	}
 
 	for _, port := range request.Workload.Ports {
-		hostIP := net.ParseIP(port.HostIp)
 		hostPort := uint16(port.HostPort)
-		if hostPort != 0 && hostIP != nil && !hostIP.IsUnspecified() {
-			podSpec.HostPorts = append(podSpec.HostPorts, storage.HostPortBinding{
-				HostPort:      hostPort,
-				HostIP:        hostIP,
-				ContainerPort: uint16(port.Port),
-				Protocol:      getHostEndpointProto(port.Protocol),
-			})
+		if hostPort == 0 {
+			continue
+		}
+		/* An empty or unspecified hostIP binds on all the node addresses */
+		hostIP := net.ParseIP(port.HostIp)
+		if hostIP == nil && port.HostIp != "" {
+			return nil, fmt.Errorf("Cannot parse hostIP %s for hostPort %d", port.HostIp, hostPort)
 		}
+		podSpec.HostPorts = append(podSpec.HostPorts, storage.HostPortBinding{
+			HostPort:      hostPort,
+			HostIP:        hostIP,
+			ContainerPort: uint16(port.Port),
+			EntryIDs:      make([]uint32, 0),
+			Protocol:      getHostEndpointProto(port.Protocol),
+		})
 	}
 	for _, routeStr := range request.GetContainerRoutes() {
 		_, route, err := net.ParseCIDR(routeStr)
@@ -147,6 +191,28 @@ func (s *Server) newLocalPodSpecFromAdd(request *pb.AddRequest) (*storage.LocalP
 		}
 	}
 
+	if config.EnableTenantVRFs {
+		tenant, err := s.getNamespaceTenant(request.Workload.Namespace)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Cannot get namespace tenant")
+		} else if tenant != nil {
+			podSpec.TenantName = tenant.Name
+		}
+	}
+
+	if config.EnableEgressIPs && workload != nil {
+		var err error
+		/* Pod annotation takes precedence over the namespace one */
+		if value, found := workload.Annotations[VppAnnotationPrefix+EgressIPsAnnotation]; found {
+			podSpec.EgressIPPool, err = s.ParseEgressIPsAnnotation(value)
+		} else {
+			podSpec.EgressIPPool, err = s.getNamespaceEgressIPs(workload.Namespace)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "Cannot parse egress addresses")
+		}
+	}
+
 	if podSpec.DefaultIfType == storage.VppIfTypeUnknown {
 		podSpec.DefaultIfType = storage.VppIfTypeTunTap
 	}
@@ -166,6 +232,10 @@ func (s *Server) Add(ctx context.Context, request *pb.AddRequest) (*pb.AddReply,
 	podSpec, err := s.newLocalPodSpecFromAdd(request)
 	if err != nil {
 		s.log.Errorf("Error parsing interface add request %v %v", request, err)
+		if request.GetWorkload() != nil {
+			common.PodWarningEvent(request.Workload.Namespace, request.Workload.Pod, common.EventReasonPodNetworkFailed,
+				"Error parsing interface add request: %v", err)
+		}
 		return &pb.AddReply{
 			Successful:   false,
 			ErrorMessage: err.Error(),
@@ -178,6 +248,7 @@ func (s *Server) Add(ctx context.Context, request *pb.AddRequest) (*pb.AddReply,
 		}, nil
 	}
 
+	defer s.sendTenantEvents()
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
@@ -192,6 +263,7 @@ func (s *Server) Add(ctx context.Context, request *pb.AddRequest) (*pb.AddReply,
 	swIfIndex, err := s.AddVppInterface(podSpec, true /* doHostSideConf */)
 	if err != nil {
 		s.log.Errorf("Interface add failed %s : %v", podSpec.String(), err)
+		s.podWarningEvent(podSpec, podEventReason(err), "Interface add failed: %v", err)
 		return &pb.AddReply{
 			Successful:   false,
 			ErrorMessage: err.Error(),
@@ -258,6 +330,7 @@ func (s *Server) rescanState() {
 	}
 
 	s.log.Infof("RescanState: re-creating all interfaces")
+	defer s.sendTenantEvents()
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	for _, podSpec := range podSpecs {
@@ -274,6 +347,9 @@ func (s *Server) rescanState() {
 			s.log.Errorf("Interface add failed %s : %v", podSpecCopy.String(), err)
 		}
 	}
+	if config.EnableTenantVRFs {
+		s.gcTenantVRFs()
+	}
 }
 
 func (s *Server) Del(ctx context.Context, request *pb.DelRequest) (*pb.DelReply, error) {
@@ -285,6 +361,7 @@ func (s *Server) Del(ctx context.Context, request *pb.DelRequest) (*pb.DelReply,
 			Successful: true,
 		}, nil
 	}
+	defer s.sendTenantEvents()
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
@@ -310,12 +387,13 @@ func (s *Server) Del(ctx context.Context, request *pb.DelRequest) (*pb.DelReply,
 }
 
 // Serve runs the grpc server for the Calico CNI backend API
-func NewCNIServer(vpp *vpplink.VppLink, ipam watchers.IpamCache, log *logrus.Entry) *Server {
+func NewCNIServer(vpp *vpplink.VppLink, ipam watchers.IpamCache, k8sclient *kubernetes.Clientset, informerFactory informers.SharedInformerFactory, log *logrus.Entry) *Server {
 	server := &Server{
 		vpp: vpp,
 		log: log,
 
-		ipam: ipam,
+		ipam:      ipam,
+		k8sclient: k8sclient,
 
 		grpcServer:      grpc.NewServer(),
 		podInterfaceMap: make(map[string]storage.LocalPodSpec),
@@ -323,10 +401,69 @@ func NewCNIServer(vpp *vpplink.VppLink, ipam watchers.IpamCache, log *logrus.Ent
 		memifDriver:     pod_interface.NewMemifPodInterfaceDriver(vpp, log),
 		vclDriver:       pod_interface.NewVclPodInterfaceDriver(vpp, log),
 		loopbackDriver:  pod_interface.NewLoopbackPodInterfaceDriver(vpp, log),
+		cniEventChan:    make(chan common.CalicoVppEvent, common.ChanSize),
+		tenants:         make(map[string]*TenantVRF),
+		pendingTenants:  make(map[string]bool),
+		tenantsChanged:  make(chan struct{}, 1),
+		egressIPs:       make(map[string]map[string]bool),
+	}
+	if config.EnableEgressIPs || config.EnableTenantVRFs {
+		namespaceInformer := informerFactory.Core().V1().Namespaces()
+		server.namespaceLister = namespaceInformer.Lister()
+		server.informersSynced = append(server.informersSynced, namespaceInformer.Informer().HasSynced)
+	}
+	if config.EnableTenantVRFs {
+		server.initTenantInformers(informerFactory)
+	}
+	reg := common.RegisterHandler(server.cniEventChan, "CNI server events")
+	reg.ExpectEvents(
+		common.PeerNodeStateChanged,
+		common.EncapOverheadChanged,
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/capture", server.handlePodCapture)
+	captureCtx, cancelCaptures := context.WithCancel(context.Background())
+	server.cancelCaptures = cancelCaptures
+	server.captureServer = &http.Server{
+		Handler:     mux,
+		BaseContext: func(net.Listener) context.Context { return captureCtx },
 	}
 	return server
 }
 
+func (s *Server) onOurNodeUpdated(nodeBGPSpec *oldv3.NodeBGPSpec) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	old := s.nodeBGPSpec
+	s.nodeBGPSpec = nodeBGPSpec
+	if old != nil && old.IPv4Address == nodeBGPSpec.IPv4Address && old.IPv6Address == nodeBGPSpec.IPv6Address {
+		return
+	}
+
+	s.log.Infof("pod(upd) node addresses changed, updating hostports")
+	s.resyncUnspecifiedHostPorts()
+	cniServerStateFile := fmt.Sprintf("%s%d", config.CniServerStateFile, storage.CniServerStateFileVersion)
+	err := storage.PersistCniServerState(s.podInterfaceMap, cniServerStateFile)
+	if err != nil {
+		s.log.Errorf("CNI state persist errored %v", err)
+	}
+}
+
+/* updatePodsMtu applies a new default MTU to the existing pods */
+func (s *Server) updatePodsMtu() {
+	for key, podSpec := range s.podInterfaceMap {
+		if podSpec.TunTapSwIfIndex == vpplink.InvalidID {
+			continue
+		}
+		podSpec := podSpec
+		err := s.tuntapDriver.UpdateMtu(&podSpec)
+		if err != nil {
+			s.log.Warnf("Error updating the MTU of pod %s: %v", key, err)
+		}
+	}
+}
+
 func (s *Server) ServeCNI(t *tomb.Tomb) error {
 	syscall.Unlink(config.CNIServerSocket)
 	socketListener, err := net.Listen("unix", config.CNIServerSocket)
@@ -335,16 +472,52 @@ func (s *Server) ServeCNI(t *tomb.Tomb) error {
 	}
 
 	pb.RegisterCniDataplaneServer(s.grpcServer, s)
+	s.informerStop = t.Dying()
+	/* Namespaces give the tenants & egress addresses of the pods we restore */
+	if !cache.WaitForCacheSync(t.Dying(), s.informersSynced...) {
+		return errors.Errorf("CNI informers did not sync")
+	}
 	s.rescanState()
 
 	s.log.Infof("Serve() CNI")
 	go s.grpcServer.Serve(socketListener)
 
-	<-t.Dying()
+	syscall.Unlink(config.PodCaptureSocket)
+	captureListener, err := net.Listen("unix", config.PodCaptureSocket)
+	if err != nil {
+		s.log.WithError(err).Errorf("failed to listen on %s, pod capture disabled", config.PodCaptureSocket)
+	} else {
+		go s.captureServer.Serve(captureListener)
+	}
+
+	for t.Alive() {
+		select {
+		case <-t.Dying():
+		case <-s.tenantsChanged:
+			s.resyncPendingTenants()
+		case evt := <-s.cniEventChan:
+			switch evt.Type {
+			case common.PeerNodeStateChanged:
+				node, _ := evt.New.(*oldv3.Node)
+				if node == nil || node.Name != config.NodeName || node.Spec.BGP == nil {
+					continue
+				}
+				s.onOurNodeUpdated(node.Spec.BGP)
+			case common.EncapOverheadChanged:
+				s.lock.Lock()
+				s.tuntapDriver.SetEncapOverhead(evt.New.(int))
+				s.updatePodsMtu()
+				s.lock.Unlock()
+			}
+		}
+	}
 
 	s.log.Infof("CNI Server returned")
 
 	s.grpcServer.GracefulStop()
+	s.cancelCaptures()
+	s.captureServer.Close()
 	syscall.Unlink(config.CNIServerSocket)
+	syscall.Unlink(config.PodCaptureSocket)
 	return nil
 }
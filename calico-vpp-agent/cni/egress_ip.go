@@ -0,0 +1,128 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cni
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/cni/storage"
+	"github.com/projectcalico/vpp-dataplane/vpplink"
+)
+
+/**
+ * Pods (or namespaces) annotated with a list of egress addresses get one of
+ * them per address family as source address for their SNAT-ed traffic,
+ * instead of the node address cnat uses. The addresses are set as the cnat
+ * SNAT addresses of the pod interfaces, so they only apply to pods whose
+ * pool has natOutgoing. Pods spread over the addresses of their pool, and
+ * egress addresses should be routed to this node by the underlying network.
+ */
+const (
+	EgressIPsAnnotation string = "egress.ips"
+)
+
+func (s *Server) ParseEgressIPsAnnotation(value string) (egressIPs []storage.LocalIP, err error) {
+	for _, ipStr := range strings.Split(value, ",") {
+		ip := net.ParseIP(strings.TrimSpace(ipStr))
+		if ip == nil {
+			return nil, fmt.Errorf("Invalid egress address %s", ipStr)
+		}
+		egressIPs = append(egressIPs, storage.LocalIP{IP: ip})
+	}
+	return egressIPs, nil
+}
+
+func (s *Server) getNamespaceEgressIPs(namespace string) ([]storage.LocalIP, error) {
+	ns, err := s.namespaceLister.Get(namespace)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting namespace %s", namespace)
+	}
+	value := ns.Annotations[VppAnnotationPrefix+EgressIPsAnnotation]
+	if value == "" {
+		return nil, nil
+	}
+	return s.ParseEgressIPsAnnotation(value)
+}
+
+/**
+ * pickEgressIP returns the egress address of ipFamily a pod should use: its
+ * previous one if we are restoring, otherwise the address of its pool used
+ * by the fewest pods. nil if the pool has none of this family.
+ */
+func pickEgressIP(podSpec *storage.LocalPodSpec, ipFamily vpplink.IpFamily, egressIPs map[string]map[string]bool) net.IP {
+	if previous := podSpec.GetEgressIP(ipFamily.IsIp6); previous != nil {
+		return previous
+	}
+	var picked net.IP
+	minCount := 0
+	for _, egressIP := range podSpec.EgressIPPool {
+		if vpplink.IsIP6(egressIP.IP) != ipFamily.IsIp6 {
+			continue
+		}
+		count := len(egressIPs[egressIP.IP.String()])
+		if picked == nil || count < minCount {
+			picked = egressIP.IP
+			minCount = count
+		}
+	}
+	return picked
+}
+
+/* Pick the egress addresses of the pod, keeping the previous ones if we are restoring */
+func (s *Server) allocateEgressIPs(podSpec *storage.LocalPodSpec) {
+	if !podSpec.NeedsSnat {
+		s.log.Warnf("pod(add) %s is not SNAT-ed, ignoring its egress addresses", podSpec.Key())
+		return
+	}
+	hasv4, hasv6 := podSpec.Hasv46()
+	for _, ipFamily := range vpplink.IpFamilies {
+		if (ipFamily.IsIp6 && !hasv6) || (!ipFamily.IsIp6 && !hasv4) {
+			continue
+		}
+		egressIP := pickEgressIP(podSpec, ipFamily, s.egressIPs)
+		podSpec.SetEgressIP(egressIP, ipFamily.IsIp6)
+		if egressIP == nil {
+			continue
+		}
+		pods, found := s.egressIPs[egressIP.String()]
+		if !found {
+			pods = make(map[string]bool)
+			s.egressIPs[egressIP.String()] = pods
+		}
+		pods[podSpec.Key()] = true
+	}
+}
+
+func (s *Server) releaseEgressIPs(podSpec *storage.LocalPodSpec) {
+	for _, ipFamily := range vpplink.IpFamilies {
+		egressIP := podSpec.GetEgressIP(ipFamily.IsIp6)
+		if egressIP == nil {
+			continue
+		}
+		pods, found := s.egressIPs[egressIP.String()]
+		if !found {
+			continue
+		}
+		delete(pods, podSpec.Key())
+		if len(pods) == 0 {
+			delete(s.egressIPs, egressIP.String())
+		}
+	}
+}
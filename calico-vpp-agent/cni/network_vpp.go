@@ -17,6 +17,7 @@ package cni
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/pkg/errors"
@@ -36,14 +37,44 @@ func (e PodNSNotFoundErr) Error() string {
 	return fmt.Sprintf("Netns '%s' doesn't exist, skipping", e.ns)
 }
 
+type OutOfBuffersErr struct {
+	available uint64
+	needed    uint64
+}
+
+func (e OutOfBuffersErr) Error() string {
+	return fmt.Sprintf("Cannot create interface: Out of buffers: available buffers = %d, buffers needed = %d. "+
+		"Increase buffers-per-numa in the VPP configuration or reduce CALICOVPP_TAP_RING_SIZE to allow more "+
+		"pods to be scheduled. Limit the number of pods per node to prevent this error", e.available, e.needed)
+}
+
+/* podWarningEvent emits a Kubernetes Event on the pod described by podSpec */
+func (s *Server) podWarningEvent(podSpec *storage.LocalPodSpec, reason, messageFmt string, args ...interface{}) {
+	namespaceAndName := strings.SplitN(podSpec.WorkloadID, "/", 2)
+	if len(namespaceAndName) != 2 {
+		return
+	}
+	common.PodWarningEvent(namespaceAndName[0], namespaceAndName[1], reason, messageFmt, args...)
+}
+
+/* podEventReason maps an AddVppInterface error to the reason of the Event we emit */
+func podEventReason(err error) string {
+	switch errors.Cause(err).(type) {
+	case PodNSNotFoundErr:
+		return common.EventReasonNetnsNotFound
+	case OutOfBuffersErr:
+		return common.EventReasonOutOfBuffers
+	default:
+		return common.EventReasonPodNetworkFailed
+	}
+}
+
 func (s *Server) checkAvailableBuffers() error {
 	existingPods := uint64(len(s.podInterfaceMap))
 	buffersNeeded := (existingPods + 1) * s.buffersNeededPerTap
 	s.log.Infof("pod(add) checking available buffers, %d existing pods, request %d / %d", existingPods, buffersNeeded, s.availableBuffers)
 	if buffersNeeded > s.availableBuffers {
-		return errors.Errorf("Cannot create interface: Out of buffers: available buffers = %d, buffers needed = %d. "+
-			"Increase buffers-per-numa in the VPP configuration or reduce CALICOVPP_TAP_RING_SIZE to allow more "+
-			"pods to be scheduled. Limit the number of pods per node to prevent this error", s.availableBuffers, buffersNeeded)
+		return OutOfBuffersErr{available: s.availableBuffers, needed: buffersNeeded}
 	}
 	return nil
 }
@@ -71,12 +102,14 @@ func (s *Server) findPodVRFs(podSpec *storage.LocalPodSpec) bool {
 
 	if (podSpec.V4VrfId != types.InvalidID) != (podSpec.V6VrfId != types.InvalidID) {
 		s.log.Errorf("Partial VRF state v4=%d v6=%d key=%s", podSpec.V4VrfId, podSpec.V6VrfId, podSpec.Key())
+		s.podWarningEvent(podSpec, common.EventReasonPartialVRFState,
+			"Partial VRF state in VPP v4=%d v6=%d", podSpec.V4VrfId, podSpec.V6VrfId)
 	}
 
 	return false
 }
 
-func (s *Server) removeConflictingContainers(newAddresses []storage.LocalIP) {
+func (s *Server) removeConflictingContainers(newPodSpec *storage.LocalPodSpec, newAddresses []storage.LocalIP) {
 	addrMap := make(map[string]storage.LocalPodSpec)
 	for _, podSpec := range s.podInterfaceMap {
 		for _, addr := range podSpec.ContainerIps {
@@ -89,6 +122,10 @@ func (s *Server) removeConflictingContainers(newAddresses []storage.LocalIP) {
 		if found {
 			s.log.Warnf("podSpec conflict newAddr=%s, podSpec=%s", newAddr, podSpec.String())
 			podSpecsToDelete[podSpec.Key()] = podSpec
+			s.podWarningEvent(&podSpec, common.EventReasonAddressConflict,
+				"Address %s was assigned to %s, removing this pod's interface", newAddr.String(), newPodSpec.WorkloadID)
+			s.podWarningEvent(newPodSpec, common.EventReasonAddressConflict,
+				"Address %s was still used by %s, removing its interface", newAddr.String(), podSpec.WorkloadID)
 		}
 	}
 	for _, podSpec := range podSpecsToDelete {
@@ -120,6 +157,14 @@ func (s *Server) AddVppInterface(podSpec *storage.LocalPodSpec, doHostSideConf b
 	 */
 	if s.findPodVRFs(podSpec) {
 		s.log.Infof("VRF already exists in VPP podSpec=%s", podSpec.Key())
+		err = s.AddPodToTenant(podSpec)
+		if err == nil {
+			err = s.AttachPodToTenantACLs(podSpec)
+		}
+		if err != nil {
+			s.log.Errorf("Error restoring tenant %s for podSpec=%s: %v", podSpec.TenantName, podSpec.Key(), err)
+		}
+		s.allocateEgressIPs(podSpec)
 		return podSpec.TunTapSwIfIndex, nil
 	}
 
@@ -131,7 +176,7 @@ func (s *Server) AddVppInterface(podSpec *storage.LocalPodSpec, doHostSideConf b
 	 * As we did not find the VRF in VPP, we shouldn't find
 	 * ourselves in s.podInterfaceMap
 	 */
-	s.removeConflictingContainers(podSpec.ContainerIps)
+	s.removeConflictingContainers(podSpec, podSpec.ContainerIps)
 
 	stack := s.vpp.NewCleanupStack()
 
@@ -140,6 +185,22 @@ func (s *Server) AddVppInterface(podSpec *storage.LocalPodSpec, doHostSideConf b
 		goto err
 	}
 
+	/* This needs to happen before creating the interfaces, which get the egress addresses as SNAT addresses */
+	if len(podSpec.EgressIPPool) > 0 {
+		s.allocateEgressIPs(podSpec)
+		stack.Push(s.releaseEgressIPs, podSpec)
+	}
+
+	if podSpec.TenantName != "" {
+		s.log.Infof("pod(add) tenant %s", podSpec.TenantName)
+		err = s.AddPodToTenant(podSpec)
+		if err != nil {
+			goto err
+		} else {
+			stack.Push(s.DelPodFromTenant, podSpec)
+		}
+	}
+
 	s.log.Infof("pod(add) VRF")
 	err = s.CreatePodVRF(podSpec, stack)
 	if err != nil {
@@ -207,6 +268,14 @@ func (s *Server) AddVppInterface(podSpec *storage.LocalPodSpec, doHostSideConf b
 		}
 	}
 
+	if podSpec.TenantName != "" {
+		s.log.Infof("pod(add) tenant ACLs")
+		err = s.AttachPodToTenantACLs(podSpec)
+		if err != nil {
+			goto err
+		}
+	}
+
 	s.log.Infof("pod(add) announcing pod Addresses")
 	for _, containerIP := range podSpec.GetContainerIps() {
 		common.SendEvent(common.CalicoVppEvent{
@@ -296,6 +365,13 @@ func (s *Server) DelVppInterface(podSpec *storage.LocalPodSpec) {
 	s.log.Infof("pod(del) VRF")
 	s.DeletePodVRF(podSpec)
 
+	s.releaseEgressIPs(podSpec)
+
+	if podSpec.TenantName != "" {
+		s.log.Infof("pod(del) tenant %s", podSpec.TenantName)
+		s.DelPodFromTenant(podSpec)
+	}
+
 	common.SendEvent(common.CalicoVppEvent{
 		Type: common.PodDeleted,
 		Old:  podSpec,
@@ -16,20 +16,54 @@
 package cni
 
 import (
+	"net"
+
 	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/cni/storage"
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/common"
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/config"
 	"github.com/projectcalico/vpp-dataplane/vpplink"
 	"github.com/projectcalico/vpp-dataplane/vpplink/types"
 )
 
-func (s *Server) AddHostPort(podSpec *storage.LocalPodSpec, stack *vpplink.CleanupStack) error {
-	for idx, hostPort := range podSpec.HostPorts {
+/* Addresses on which a hostPort with an unspecified hostIP is exposed */
+func (s *Server) getNodeAddresses() (addresses []net.IP) {
+	if s.nodeBGPSpec == nil {
+		return nil
+	}
+	nodeIP4, nodeIP6 := common.GetBGPSpecAddresses(s.nodeBGPSpec)
+	for _, nodeIP := range []*net.IP{nodeIP4, nodeIP6} {
+		if nodeIP == nil {
+			continue
+		}
+		addresses = append(addresses, *nodeIP)
+		if config.EnableIPSec {
+			/* IPsec assumes the node owns IpsecAddressCount consecutive addresses */
+			for i := 1; i < config.IpsecAddressCount; i++ {
+				addresses = append(addresses, common.IpsecTunnelAddress(*nodeIP, i))
+			}
+		}
+	}
+	return addresses
+}
+
+func (s *Server) getHostPortHostIPs(hostPort *storage.HostPortBinding) []net.IP {
+	if hostPort.IsUnspecified() {
+		return s.getNodeAddresses()
+	}
+	return []net.IP{hostPort.HostIP}
+}
+
+func (s *Server) addHostPortEntries(podSpec *storage.LocalPodSpec, idx int, stack *vpplink.CleanupStack) error {
+	hostPort := &podSpec.HostPorts[idx]
+	hostPort.EntryIDs = make([]uint32, 0)
+	for _, hostIP := range s.getHostPortHostIPs(hostPort) {
 		for _, containerAddr := range podSpec.ContainerIps {
-			if !vpplink.AddrFamilyDiffers(containerAddr.IP, hostPort.HostIP) {
+			if !vpplink.AddrFamilyDiffers(containerAddr.IP, hostIP) {
 				continue
 			}
 			entry := &types.CnatTranslateEntry{
 				Endpoint: types.CnatEndpoint{
-					IP:   hostPort.HostIP,
+					IP:   hostIP,
 					Port: hostPort.HostPort,
 				},
 				Backends: []types.CnatEndpointTuple{{
@@ -49,7 +83,28 @@ func (s *Server) AddHostPort(podSpec *storage.LocalPodSpec, stack *vpplink.Clean
 			} else {
 				stack.Push(s.vpp.CnatTranslateDel, id)
 			}
-			podSpec.HostPorts[idx].EntryID = id
+			hostPort.EntryIDs = append(hostPort.EntryIDs, id)
+		}
+	}
+	return nil
+}
+
+func (s *Server) delHostPortEntries(hostPort *storage.HostPortBinding) {
+	for _, entryID := range hostPort.EntryIDs {
+		err := s.vpp.CnatTranslateDel(entryID)
+		if err != nil {
+			s.log.Errorf("(del) Error deleting entry with ID %d: %v", entryID, err)
+		}
+		s.log.Infof("pod(del) hostport entry=%d", entryID)
+	}
+	hostPort.EntryIDs = make([]uint32, 0)
+}
+
+func (s *Server) AddHostPort(podSpec *storage.LocalPodSpec, stack *vpplink.CleanupStack) error {
+	for idx := range podSpec.HostPorts {
+		err := s.addHostPortEntries(podSpec, idx, stack)
+		if err != nil {
+			return err
 		}
 	}
 	return nil
@@ -58,14 +113,37 @@ func (s *Server) AddHostPort(podSpec *storage.LocalPodSpec, stack *vpplink.Clean
 func (s *Server) DelHostPort(podSpec *storage.LocalPodSpec) {
 	initialSpec, ok := s.podInterfaceMap[podSpec.Key()]
 	if ok {
-		for _, hostPort := range initialSpec.HostPorts {
-			err := s.vpp.CnatTranslateDel(hostPort.EntryID)
-			if err != nil {
-				s.log.Errorf("(del) Error deleting entry with ID %d: %v", hostPort.EntryID, err)
-			}
-			s.log.Infof("pod(del) hostport entry=%d", hostPort.EntryID)
+		for idx := range initialSpec.HostPorts {
+			s.delHostPortEntries(&initialSpec.HostPorts[idx])
 		}
 	} else {
 		s.log.Warnf("Initial spec not found")
 	}
 }
+
+/**
+ * Node addresses changed, re-create the entries of the hostPorts
+ * exposed on all the node addresses. Expects s.lock to be held.
+ */
+func (s *Server) resyncUnspecifiedHostPorts() {
+	for key, podSpec := range s.podInterfaceMap {
+		changed := false
+		for idx := range podSpec.HostPorts {
+			if !podSpec.HostPorts[idx].IsUnspecified() {
+				continue
+			}
+			s.delHostPortEntries(&podSpec.HostPorts[idx])
+			stack := s.vpp.NewCleanupStack()
+			err := s.addHostPortEntries(&podSpec, idx, stack)
+			if err != nil {
+				s.log.Errorf("Error re-creating hostport %s for %s: %v", podSpec.HostPorts[idx].String(), podSpec.String(), err)
+				stack.Execute()
+				podSpec.HostPorts[idx].EntryIDs = make([]uint32, 0)
+			}
+			changed = true
+		}
+		if changed {
+			s.podInterfaceMap[key] = podSpec
+		}
+	}
+}
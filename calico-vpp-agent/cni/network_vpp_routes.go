@@ -145,12 +145,13 @@ func (s *Server) CreatePodVRF(podSpec *storage.LocalPodSpec, stack *vpplink.Clea
 
 	for _, ipFamily := range vpplink.IpFamilies {
 		vrfId := podSpec.GetVrfId(ipFamily)
-		s.log.Infof("pod(add) VRF %d %s default route via VRF %d", vrfId, ipFamily.Str, common.PodVRFIndex)
-		err = s.vpp.AddDefaultRouteViaTable(podSpec.GetVrfId(ipFamily), common.PodVRFIndex, ipFamily.IsIp6)
+		uplinkVrfId := s.getPodUplinkVRF(podSpec, ipFamily)
+		s.log.Infof("pod(add) VRF %d %s default route via VRF %d", vrfId, ipFamily.Str, uplinkVrfId)
+		err = s.vpp.AddDefaultRouteViaTable(vrfId, uplinkVrfId, ipFamily.IsIp6)
 		if err != nil {
-			return errors.Wrapf(err, "error adding VRF %d %s default route via VRF %d", vrfId, ipFamily.Str, common.PodVRFIndex)
+			return errors.Wrapf(err, "error adding VRF %d %s default route via VRF %d", vrfId, ipFamily.Str, uplinkVrfId)
 		} else {
-			stack.Push(s.vpp.DelDefaultRouteViaTable, vrfId, common.PodVRFIndex, ipFamily.IsIp6)
+			stack.Push(s.vpp.DelDefaultRouteViaTable, vrfId, uplinkVrfId, ipFamily.IsIp6)
 		}
 	}
 	return nil
@@ -160,10 +161,11 @@ func (s *Server) DeletePodVRF(podSpec *storage.LocalPodSpec) {
 	var err error
 	for _, ipFamily := range vpplink.IpFamilies {
 		vrfId := podSpec.GetVrfId(ipFamily)
-		s.log.Infof("pod(del) VRF %d %s default route via VRF %d", vrfId, ipFamily.Str, common.PodVRFIndex)
-		err = s.vpp.DelDefaultRouteViaTable(vrfId, common.PodVRFIndex, ipFamily.IsIp6)
+		uplinkVrfId := s.getPodUplinkVRF(podSpec, ipFamily)
+		s.log.Infof("pod(del) VRF %d %s default route via VRF %d", vrfId, ipFamily.Str, uplinkVrfId)
+		err = s.vpp.DelDefaultRouteViaTable(vrfId, uplinkVrfId, ipFamily.IsIp6)
 		if err != nil {
-			s.log.Errorf("Error  VRF %d %s default route via VRF %d : %s", vrfId, ipFamily.Str, common.PodVRFIndex, err)
+			s.log.Errorf("Error  VRF %d %s default route via VRF %d : %s", vrfId, ipFamily.Str, uplinkVrfId, err)
 		}
 	}
 
@@ -0,0 +1,217 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cni
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/cni/storage"
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/config"
+	"github.com/projectcalico/vpp-dataplane/vpplink/types"
+)
+
+const (
+	DefaultCaptureMaxPackets = 1000
+	DefaultCaptureDuration   = 10 * time.Second
+	MaxCaptureMaxPackets     = 100000
+	MaxCaptureDuration       = 5 * time.Minute
+)
+
+type PodCaptureRequest struct {
+	Namespace  string
+	Pod        string
+	IfType     storage.VppInterfaceType
+	MaxPackets uint32
+	Duration   time.Duration
+}
+
+func (r *PodCaptureRequest) String() string {
+	return fmt.Sprintf("%s/%s if:%s max:%d duration:%s", r.Namespace, r.Pod, r.IfType.String(), r.MaxPackets, r.Duration)
+}
+
+func (r *PodCaptureRequest) FileName() string {
+	return fmt.Sprintf("%s_%s_%s_%d.pcap", r.Namespace, r.Pod, r.IfType.String(), time.Now().Unix())
+}
+
+func parsePodCaptureRequest(req *http.Request) (*PodCaptureRequest, error) {
+	q := req.URL.Query()
+	r := &PodCaptureRequest{
+		Namespace:  q.Get("namespace"),
+		Pod:        q.Get("pod"),
+		IfType:     storage.VppIfTypeTunTap,
+		MaxPackets: DefaultCaptureMaxPackets,
+		Duration:   DefaultCaptureDuration,
+	}
+	if r.Namespace == "" || r.Pod == "" {
+		return nil, fmt.Errorf("namespace and pod are required")
+	}
+	switch q.Get("interface") {
+	case "", "tun":
+		r.IfType = storage.VppIfTypeTunTap
+	case "memif":
+		r.IfType = storage.VppIfTypeMemif
+	default:
+		return nil, fmt.Errorf("invalid interface %s, expected tun or memif", q.Get("interface"))
+	}
+	if s := q.Get("max"); s != "" {
+		maxPackets, err := strconv.ParseUint(s, 10, 32)
+		if err != nil || maxPackets == 0 || maxPackets > MaxCaptureMaxPackets {
+			return nil, fmt.Errorf("invalid max %s, expected 1-%d", s, MaxCaptureMaxPackets)
+		}
+		r.MaxPackets = uint32(maxPackets)
+	}
+	if s := q.Get("duration"); s != "" {
+		duration, err := time.ParseDuration(s)
+		if err != nil || duration <= 0 || duration > MaxCaptureDuration {
+			return nil, fmt.Errorf("invalid duration %s, expected up to %s", s, MaxCaptureDuration)
+		}
+		r.Duration = duration
+	}
+	return r, nil
+}
+
+func (s *Server) getPodSwIfIndex(namespace, pod string, ifType storage.VppInterfaceType) (uint32, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	workloadID := namespace + "/" + pod
+	for _, podSpec := range s.podInterfaceMap {
+		if podSpec.WorkloadID != workloadID {
+			continue
+		}
+		swIfIndex, _ := podSpec.GetParamsForIfType(ifType)
+		if swIfIndex == types.InvalidID || swIfIndex == 0 {
+			return types.InvalidID, fmt.Errorf("pod %s has no %s interface", workloadID, ifType.String())
+		}
+		return swIfIndex, nil
+	}
+	return types.InvalidID, fmt.Errorf("pod %s not found", workloadID)
+}
+
+/**
+ * CapturePod runs a bounded pcap capture on the interface of a pod and returns the
+ * path of the pcap file on the host, empty when no packet was captured. VPP only
+ * supports a single capture at a time. The capture stops early when ctx is done,
+ * e.g. when the agent shuts down.
+ */
+func (s *Server) CapturePod(ctx context.Context, r *PodCaptureRequest) (string, error) {
+	swIfIndex, err := s.getPodSwIfIndex(r.Namespace, r.Pod, r.IfType)
+	if err != nil {
+		return "", err
+	}
+
+	s.captureLock.Lock()
+	defer s.captureLock.Unlock()
+
+	fileName := r.FileName()
+	s.log.Infof("capture(start) %s swIfIndex=%d file=%s", r.String(), swIfIndex, fileName)
+	reply, err := s.vpp.PcapTraceStart(swIfIndex, r.MaxPackets, fileName)
+	if err != nil {
+		return "", errors.Wrapf(err, "error starting capture for %s", r.String())
+	} else if reply != "" {
+		return "", fmt.Errorf("error starting capture for %s: %s", r.String(), reply)
+	}
+
+	timer := time.NewTimer(r.Duration)
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		timer.Stop()
+		s.log.Infof("capture(cancel) %s: %v", r.String(), ctx.Err())
+	}
+
+	nPackets, vppFile, err := s.vpp.PcapTraceStop()
+	if err != nil {
+		return "", errors.Wrapf(err, "error stopping capture for %s", r.String())
+	}
+	if vppFile == "" {
+		s.log.Infof("capture(stop) %s: no packets captured", r.String())
+		return "", nil
+	}
+	s.log.Infof("capture(stop) %s: %d packets written to %s", r.String(), nPackets, vppFile)
+
+	path := filepath.Join(config.PodCaptureHostDir, fileName)
+	err = s.moveCaptureFile(vppFile, path)
+	if err != nil {
+		return "", errors.Wrapf(err, "error retrieving capture for %s", r.String())
+	}
+	return path, nil
+}
+
+/**
+ * moveCaptureFile moves the pcap file VPP wrote in its own /tmp to path.
+ * We share the host pid namespace with VPP, so its filesystem is reachable
+ * through /proc.
+ */
+func (s *Server) moveCaptureFile(vppFile string, path string) error {
+	vppPid, err := s.vpp.GetVPPPid()
+	if err != nil {
+		return err
+	}
+	src := filepath.Join(fmt.Sprintf("/proc/%d/root", vppPid), vppFile)
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.Wrapf(err, "cannot open %s", src)
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "cannot create %s", path)
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		os.Remove(path)
+		return errors.Wrapf(err, "cannot copy %s to %s", src, path)
+	}
+	err = out.Close()
+	if err != nil {
+		return errors.Wrapf(err, "cannot write %s", path)
+	}
+	err = os.Remove(src)
+	if err != nil {
+		s.log.Warnf("Error removing %s: %v", src, err)
+	}
+	return nil
+}
+
+func (s *Server) handlePodCapture(w http.ResponseWriter, req *http.Request) {
+	r, err := parsePodCaptureRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	path, err := s.CapturePod(req.Context(), r)
+	if err != nil {
+		s.log.WithError(err).Errorf("capture failed for %s", r.String())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if path == "" {
+		fmt.Fprintf(w, "No packets captured\n")
+		return
+	}
+	fmt.Fprintf(w, "%s\n", path)
+}
@@ -65,8 +65,14 @@ func (i *PodInterfaceDriverData) SpreadRxQueuesOnWorkers(swIfIndex uint32) {
 	}
 }
 
-func (i *PodInterfaceDriverData) UndoPodIfNatConfiguration(swIfIndex uint32) {
+func (i *PodInterfaceDriverData) UndoPodIfNatConfiguration(podSpec *storage.LocalPodSpec, swIfIndex uint32) {
 	var err error
+	if podSpec.HasEgressIP() {
+		err = i.vpp.CnatClearInterfaceSnatAddresses(swIfIndex)
+		if err != nil {
+			i.log.Errorf("Error clearing egress snat addresses %v", err)
+		}
+	}
 	err = i.vpp.RemovePodInterface(swIfIndex)
 	if err != nil {
 		i.log.Errorf("error deregistering pod interface: %v", err)
@@ -91,6 +97,16 @@ func (i *PodInterfaceDriverData) DoPodIfNatConfiguration(podSpec *storage.LocalP
 				stack.Push(i.vpp.DisableCnatSNAT, swIfIndex, false)
 			}
 		}
+		if podSpec.HasEgressIP() {
+			egressIP4, egressIP6 := podSpec.GetEgressIP(false), podSpec.GetEgressIP(true)
+			i.log.Infof("pod(add) interface[%d] SNAT addresses %s %s", swIfIndex, egressIP4, egressIP6)
+			err = i.vpp.CnatSetInterfaceSnatAddresses(swIfIndex, egressIP4, egressIP6)
+			if err != nil {
+				return errors.Wrapf(err, "Error setting egress snat addresses")
+			} else {
+				stack.Push(i.vpp.CnatClearInterfaceSnatAddresses, swIfIndex)
+			}
+		}
 	}
 
 	err = i.vpp.RegisterPodInterface(swIfIndex)
@@ -72,7 +72,7 @@ func (i *LoopbackPodInterfaceDriver) CreateInterface(podSpec *storage.LocalPodSp
 }
 
 func (i *LoopbackPodInterfaceDriver) DeleteInterface(podSpec *storage.LocalPodSpec) {
-	i.UndoPodIfNatConfiguration(podSpec.LoopbackSwIfIndex)
+	i.UndoPodIfNatConfiguration(podSpec, podSpec.LoopbackSwIfIndex)
 
 	iface := types2.Interface{SwIfIndex: podSpec.LoopbackSwIfIndex}
 
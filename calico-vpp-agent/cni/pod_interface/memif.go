@@ -101,7 +101,7 @@ func (i *MemifPodInterfaceDriver) DeleteInterface(podSpec *storage.LocalPodSpec)
 	}
 
 	i.UndoPodInterfaceConfiguration(podSpec.MemifSwIfIndex)
-	i.UndoPodIfNatConfiguration(podSpec.MemifSwIfIndex)
+	i.UndoPodIfNatConfiguration(podSpec, podSpec.MemifSwIfIndex)
 
 	err := i.vpp.DeleteMemif(podSpec.MemifSwIfIndex)
 	if err != nil {
@@ -37,6 +37,8 @@ import (
 type TunTapPodInterfaceDriver struct {
 	PodInterfaceDriverData
 	felixConfig *felixConfig.Config
+	/* worst overhead of the connectivity in use, -1 until known */
+	encapOverhead int
 }
 
 func NewTunTapPodInterfaceDriver(vpp *vpplink.VppLink, log *logrus.Entry) *TunTapPodInterfaceDriver {
@@ -44,6 +46,7 @@ func NewTunTapPodInterfaceDriver(vpp *vpplink.VppLink, log *logrus.Entry) *TunTa
 	i.vpp = vpp
 	i.log = log
 	i.name = "tun"
+	i.encapOverhead = -1
 	return i
 }
 
@@ -58,6 +61,16 @@ func (i *TunTapPodInterfaceDriver) computeDefaultPodMtu() int {
 
 	podMtu := config.HostMtu
 
+	if i.encapOverhead >= 0 {
+		/* The connectivity server knows the encapsulations configured & in use */
+		podMtu = config.HostMtu - i.encapOverhead
+		/* MTUs set in the felix configuration are honoured */
+		reduceMtuIf(&podMtu, fc.IpInIpMtu, fc.IpInIpEnabled)
+		reduceMtuIf(&podMtu, fc.VXLANMTU, fc.VXLANEnabled)
+		reduceMtuIf(&podMtu, fc.WireguardMTU, fc.WireguardEnabled)
+		return podMtu
+	}
+
 	// Reproduce felix algorithm in determinePodMTU to determine pod MTU
 	// The part where it defaults to the host MTU is done in AddVppInterface
 	// TODO: move the code that retrieves the host mtu to this module...
@@ -69,6 +82,38 @@ func (i *TunTapPodInterfaceDriver) computeDefaultPodMtu() int {
 	return podMtu
 }
 
+/* SetEncapOverhead is called when the connectivity configured or in use changes */
+func (i *TunTapPodInterfaceDriver) SetEncapOverhead(encapOverhead int) {
+	i.encapOverhead = encapOverhead
+}
+
+/**
+ * UpdateMtu applies the default MTU to an existing pod that doesn't set its
+ * own, on both sides of its tun, so that VPP also fragments or answers ICMP
+ * too big for the packets sent to the pod.
+ */
+func (i *TunTapPodInterfaceDriver) UpdateMtu(podSpec *storage.LocalPodSpec) error {
+	if podSpec.Mtu > 0 {
+		return nil
+	}
+	podMtu := i.computeDefaultPodMtu()
+	err := i.vpp.SetInterfaceMtu(&types2.Interface{SwIfIndex: podSpec.TunTapSwIfIndex}, podMtu)
+	if err != nil {
+		return errors.Wrapf(err, "Error setting MTU %d on tun[%d]", podMtu, podSpec.TunTapSwIfIndex)
+	}
+	return ns.WithNetNSPath(podSpec.NetnsName, func(_ ns.NetNS) error {
+		link, err := netlink.LinkByName(podSpec.InterfaceName)
+		if err != nil {
+			return errors.Wrapf(err, "cannot find interface %s", podSpec.InterfaceName)
+		}
+		if link.Attrs().MTU == podMtu {
+			return nil
+		}
+		i.log.Infof("pod(upd) %s MTU %d->%d", podSpec.Key(), link.Attrs().MTU, podMtu)
+		return netlink.LinkSetMTU(link, podMtu)
+	})
+}
+
 func (i *TunTapPodInterfaceDriver) SetFelixConfig(felixConfig *felixConfig.Config) {
 	i.felixConfig = felixConfig
 }
@@ -132,6 +177,12 @@ func (i *TunTapPodInterfaceDriver) CreateInterface(podSpec *storage.LocalPodSpec
 		return err
 	}
 
+	/* Like UpdateMtu, VPP sends the pod packets that fit its MTU */
+	err = i.vpp.SetInterfaceMtu(&types2.Interface{SwIfIndex: swIfIndex}, podMtu)
+	if err != nil {
+		return errors.Wrapf(err, "Error setting MTU %d on tun[%d]", podMtu, swIfIndex)
+	}
+
 	if doHostSideConf {
 		err = i.configureLinux(podSpec, swIfIndex)
 		if err != nil {
@@ -147,7 +198,7 @@ func (i *TunTapPodInterfaceDriver) DeleteInterface(podSpec *storage.LocalPodSpec
 	i.unconfigureLinux(podSpec)
 
 	i.UndoPodInterfaceConfiguration(podSpec.TunTapSwIfIndex)
-	i.UndoPodIfNatConfiguration(podSpec.TunTapSwIfIndex)
+	i.UndoPodIfNatConfiguration(podSpec, podSpec.TunTapSwIfIndex)
 
 	iface := types2.Interface{SwIfIndex: podSpec.TunTapSwIfIndex}
 
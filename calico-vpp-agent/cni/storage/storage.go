@@ -35,7 +35,7 @@ import (
 )
 
 const (
-	CniServerStateFileVersion = 5  // Used to ensure compatibility wen we reload data
+	CniServerStateFileVersion = 8  // Used to ensure compatibility wen we reload data
 	MaxApiTagLen              = 63 /* No more than 64 characters in API tags */
 	vrfTagHashLen             = 8  /* how many hash charatecters (b64) of the name in tag prefix (useful when trucated) */
 )
@@ -100,6 +100,9 @@ func (ps *LocalPodSpec) UpdateSizes() {
 	for _, n := range ps.Routes {
 		n.UpdateSizes()
 	}
+	for i := range ps.HostPorts {
+		ps.HostPorts[i].UpdateSizes()
+	}
 }
 
 func (ps *LocalPodSpec) Key() string {
@@ -135,6 +138,8 @@ func (ps *LocalPodSpec) FullString() string {
 	s += fmt.Sprintf("OrchestratorID:     %s\n", ps.OrchestratorID)
 	s += fmt.Sprintf("WorkloadID:         %s\n", ps.WorkloadID)
 	s += fmt.Sprintf("EndpointID:         %s\n", ps.EndpointID)
+	s += fmt.Sprintf("TenantName:         %s\n", ps.TenantName)
+	s += fmt.Sprintf("EgressIPPool:       %s\n", types.StrableListToString("", ps.EgressIPPool))
 	s += fmt.Sprintf("HostPorts:          %s\n", types.StrableListToString("", ps.HostPorts))
 	s += fmt.Sprintf("IfPortConfigs:      %s\n", types.StrableListToString("", ps.IfPortConfigs))
 	s += fmt.Sprintf("PortFilteredIfType: %s\n", ps.PortFilteredIfType.String())
@@ -149,6 +154,8 @@ func (ps *LocalPodSpec) FullString() string {
 	s += fmt.Sprintf("PblIndexes:         %s\n", ps.PblIndexes)
 	s += fmt.Sprintf("V4VrfId:            %d\n", ps.V4VrfId)
 	s += fmt.Sprintf("V6VrfId:            %d\n", ps.V6VrfId)
+	s += fmt.Sprintf("EgressIP4:          %s\n", ps.EgressIP4)
+	s += fmt.Sprintf("EgressIP6:          %s\n", ps.EgressIP6)
 	return s
 }
 
@@ -197,6 +204,12 @@ type LocalPodSpec struct {
 	WorkloadID         string
 	EndpointIDSize     int `struc:"int16,sizeof=EndpointID"`
 	EndpointID         string
+	// Tenant VRF the pod belongs to, empty if none
+	TenantNameSize int `struc:"int16,sizeof=TenantName"`
+	TenantName     string
+	// Candidate egress source addresses, from the pod or namespace annotations
+	EgressIPPoolSize int `struc:"int16,sizeof=EgressIPPool"`
+	EgressIPPool     []LocalIP
 	// HostPort
 	HostPortsSize int `struc:"int16,sizeof=HostPorts"`
 	HostPorts     []HostPortBinding
@@ -233,6 +246,9 @@ type LocalPodSpec struct {
 	V4VrfId   uint32
 	V6VrfId   uint32
 	NeedsSnat bool
+	/* Egress addresses picked in EgressIPPool, unspecified if none */
+	EgressIP4 net.IP `struc:"[16]byte"`
+	EgressIP6 net.IP `struc:"[16]byte"`
 }
 
 func (ps *LocalPodSpec) Copy() LocalPodSpec {
@@ -240,7 +256,11 @@ func (ps *LocalPodSpec) Copy() LocalPodSpec {
 
 	newPs.Routes = append(make([]LocalIPNet, 0), ps.Routes...)
 	newPs.ContainerIps = append(make([]LocalIP, 0), ps.ContainerIps...)
+	newPs.EgressIPPool = append(make([]LocalIP, 0), ps.EgressIPPool...)
 	newPs.HostPorts = append(make([]HostPortBinding, 0), ps.HostPorts...)
+	for i, hostPort := range ps.HostPorts {
+		newPs.HostPorts[i].EntryIDs = append(make([]uint32, 0), hostPort.EntryIDs...)
+	}
 	newPs.IfPortConfigs = append(make([]LocalIfPortConfigs, 0), ps.IfPortConfigs...)
 	newPs.PblIndexes = append(make([]uint32, 0), ps.PblIndexes...)
 
@@ -250,17 +270,27 @@ func (ps *LocalPodSpec) Copy() LocalPodSpec {
 
 // XXX: Increment CniServerStateFileVersion when changing this struct
 type HostPortBinding struct {
-	HostPort      uint16
+	HostPort uint16
+	/* An unspecified HostIP binds on all the node addresses */
 	HostIP        net.IP `struc:"[16]byte"`
 	ContainerPort uint16
-	EntryID       uint32
+	EntryIDsSize  int `struc:"int16,sizeof=EntryIDs"`
+	EntryIDs      []uint32
 	Protocol      types.IPProto
 }
 
+func (hp *HostPortBinding) UpdateSizes() {
+	hp.EntryIDsSize = len(hp.EntryIDs)
+}
+
+func (hp *HostPortBinding) IsUnspecified() bool {
+	return hp.HostIP == nil || hp.HostIP.IsUnspecified()
+}
+
 func (hp *HostPortBinding) String() string {
-	s := fmt.Sprintf("%s %s:%p", hp.Protocol.String(), hp.HostIP, hp.HostPort)
+	s := fmt.Sprintf("%s %s:%d", hp.Protocol.String(), hp.HostIP, hp.HostPort)
 	s += fmt.Sprintf(" cport=%d", hp.ContainerPort)
-	s += fmt.Sprintf(" id=%d", hp.EntryID)
+	s += fmt.Sprintf(" ids=%v", hp.EntryIDs)
 	return s
 }
 
@@ -340,6 +370,30 @@ func (ps *LocalPodSpec) SetVrfId(id uint32, ipFamily vpplink.IpFamily) {
 	}
 }
 
+func (ps *LocalPodSpec) HasEgressIP() bool {
+	return ps.GetEgressIP(false) != nil || ps.GetEgressIP(true) != nil
+}
+
+/* GetEgressIP returns the egress address of the pod for a family, nil if none */
+func (ps *LocalPodSpec) GetEgressIP(isIp6 bool) net.IP {
+	egressIP := ps.EgressIP4
+	if isIp6 {
+		egressIP = ps.EgressIP6
+	}
+	if egressIP == nil || egressIP.IsUnspecified() {
+		return nil
+	}
+	return egressIP
+}
+
+func (ps *LocalPodSpec) SetEgressIP(egressIP net.IP, isIp6 bool) {
+	if isIp6 {
+		ps.EgressIP6 = egressIP
+	} else {
+		ps.EgressIP4 = egressIP
+	}
+}
+
 type SavedState struct {
 	Version    int `struc:"int32"`
 	SpecsCount int `struc:"int32,sizeof=Specs"`
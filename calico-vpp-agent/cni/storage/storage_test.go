@@ -0,0 +1,91 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/lunixbochs/struc"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/projectcalico/vpp-dataplane/vpplink/types"
+)
+
+var hostPortUnspecified = []struct {
+	hostIP      net.IP
+	unspecified bool
+}{
+	{nil, true},
+	{net.ParseIP("0.0.0.0"), true},
+	{net.ParseIP("::"), true},
+	{net.ParseIP("192.168.0.1"), false},
+	{net.ParseIP("fd00::1"), false},
+}
+
+func TestHostPortIsUnspecified(t *testing.T) {
+	for _, tc := range hostPortUnspecified {
+		hp := &HostPortBinding{HostIP: tc.hostIP}
+		assert.Equal(t, tc.unspecified, hp.IsUnspecified(), tc.hostIP.String())
+	}
+}
+
+func TestHostPortEntryIDsPersisted(t *testing.T) {
+	podSpec := LocalPodSpec{
+		InterfaceName: "eth0",
+		NetnsName:     "/var/run/netns/cni-1",
+		HostPorts: []HostPortBinding{{
+			HostPort:      8080,
+			ContainerPort: 80,
+			EntryIDs:      []uint32{3, 4, 5},
+			Protocol:      types.TCP,
+		}, {
+			HostPort:      53,
+			HostIP:        net.ParseIP("192.168.0.1"),
+			ContainerPort: 53,
+			EntryIDs:      []uint32{},
+			Protocol:      types.UDP,
+		}},
+	}
+	podSpec.UpdateSizes()
+	state := &SavedState{
+		Version:    CniServerStateFileVersion,
+		SpecsCount: 1,
+		Specs:      []LocalPodSpec{podSpec},
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, struc.Pack(&buf, state))
+
+	var loaded SavedState
+	assert.NoError(t, struc.Unpack(&buf, &loaded))
+	assert.Len(t, loaded.Specs, 1)
+	hostPorts := loaded.Specs[0].HostPorts
+	assert.Len(t, hostPorts, 2)
+	assert.Equal(t, []uint32{3, 4, 5}, hostPorts[0].EntryIDs)
+	assert.True(t, hostPorts[0].IsUnspecified())
+	assert.Empty(t, hostPorts[1].EntryIDs)
+	assert.True(t, hostPorts[1].HostIP.Equal(net.ParseIP("192.168.0.1")))
+}
+
+func TestCopyDoesNotShareEntryIDs(t *testing.T) {
+	podSpec := LocalPodSpec{
+		HostPorts: []HostPortBinding{{HostPort: 8080, EntryIDs: []uint32{1, 2}}},
+	}
+	newSpec := podSpec.Copy()
+	newSpec.HostPorts[0].EntryIDs[0] = 42
+	assert.Equal(t, uint32(1), podSpec.HostPorts[0].EntryIDs[0])
+}
@@ -0,0 +1,982 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cni
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/cni/storage"
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/common"
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/config"
+	"github.com/projectcalico/vpp-dataplane/vpplink"
+	"github.com/projectcalico/vpp-dataplane/vpplink/types"
+)
+
+/* Namespace annotations, prefixed with VppAnnotationPrefix */
+const (
+	TenantAnnotation             string = "tenant"
+	TenantLeakAnnotation         string = "tenant.leak"
+	TenantLeakServicesAnnotation string = "tenant.leak.services"
+	TenantGatewayAnnotation      string = "tenant.gateway"
+
+	maxTenantNameLen          = 48
+	tenantVrfTagPrefix        = "tenant-"
+	tenantIngressACLTagPrefix = "tenant-ingress-"
+	tenantReflectACLTagPrefix = "tenant-reflect-"
+)
+
+/**
+ * A TenantVRF is shared by the pods of all the namespaces annotated with
+ * the same tenant. Pod VRFs default to it instead of the PodVRF.
+ *
+ * It only routes the tenant members: the pods of its namespaces on all the
+ * nodes and the endpoints of the services scoped to the tenant, i.e. the
+ * services of its namespaces and the ones they leak (kube-dns by default),
+ * as well as the node addresses and the leaked prefixes. Everything else is
+ * dropped, unless gateways are configured (one per address family), in
+ * which case they are used as default routes. Local members are routed via
+ * the PodVRF, the connectivity server routes the remote ones through the
+ * provider reaching their node (see connectivity/tenants.go).
+ *
+ * Traffic sent to the tenant pods goes through an ACL that only permits the
+ * same sources, and the addresses of the services scoped to the tenant (the
+ * source of the replies of their endpoints), so that other tenants, pods
+ * outside of tenants and other nodes can't reach them. Their own sessions
+ * are permitted back, so that the replies coming through the gateways get in.
+ * Service addresses are translated to their endpoints before the lookup in
+ * the tenant VRF, so only services with members or leaked endpoints are
+ * reachable.
+ *
+ * The leaked prefixes & gateways of the first namespace creating the tenant
+ * are used, the leaked services of all its namespaces.
+ */
+type TenantVRF struct {
+	Name     string
+	V4VrfId  uint32
+	V6VrfId  uint32
+	Leaked   []*net.IPNet
+	Gateways []net.IP
+
+	/* routes programmed in the tenant VRF, by destination */
+	routes map[string]*types.Route
+	/* ACL on the traffic sent to the tenant pods, and its rules as last programmed */
+	ingressACL   *types.ACL
+	ingressRules []types.ACLRule
+	/* ACL permitting back the sessions the tenant pods open */
+	reflectACL *types.ACL
+}
+
+func (t *TenantVRF) String() string {
+	return fmt.Sprintf("%s v4:%d v6:%d leaked:%v gw:%v", t.Name, t.V4VrfId, t.V6VrfId, t.Leaked, t.Gateways)
+}
+
+func (t *TenantVRF) GetVrfTag(ipFamily vpplink.IpFamily) string {
+	return fmt.Sprintf("%s%s-%s", tenantVrfTagPrefix, ipFamily.ShortStr, t.Name)
+}
+
+func (t *TenantVRF) GetVrfId(ipFamily vpplink.IpFamily) uint32 {
+	if ipFamily.IsIp6 {
+		return t.V6VrfId
+	}
+	return t.V4VrfId
+}
+
+func (t *TenantVRF) SetVrfId(id uint32, ipFamily vpplink.IpFamily) {
+	if ipFamily.IsIp6 {
+		t.V6VrfId = id
+	} else {
+		t.V4VrfId = id
+	}
+}
+
+func (t *TenantVRF) getIngressACLTag() string {
+	return tenantIngressACLTagPrefix + t.Name
+}
+
+func (t *TenantVRF) getReflectACLTag() string {
+	return tenantReflectACLTagPrefix + t.Name
+}
+
+func namespaceFromWorkloadID(workloadID string) string {
+	return strings.SplitN(workloadID, "/", 2)[0]
+}
+
+/* Returns the tenant configuration of a namespace, nil if it isn't part of a tenant */
+func parseNamespaceTenant(ns *v1.Namespace) (*TenantVRF, error) {
+	name := ns.Annotations[VppAnnotationPrefix+TenantAnnotation]
+	if name == "" {
+		return nil, nil
+	}
+	if len(name) > maxTenantNameLen {
+		return nil, fmt.Errorf("tenant name %s should be less than %d characters", name, maxTenantNameLen)
+	}
+	tenant := &TenantVRF{
+		Name:    name,
+		V4VrfId: types.InvalidID,
+		V6VrfId: types.InvalidID,
+		routes:  make(map[string]*types.Route),
+	}
+	tenant.Leaked = append(tenant.Leaked, config.TenantLeakedPrefixes...)
+	if value := ns.Annotations[VppAnnotationPrefix+TenantLeakAnnotation]; value != "" {
+		for _, prefixStr := range strings.Split(value, ",") {
+			_, prefix, err := net.ParseCIDR(strings.TrimSpace(prefixStr))
+			if err != nil {
+				return nil, errors.Wrapf(err, "error parsing %s%s in namespace %s", VppAnnotationPrefix, TenantLeakAnnotation, ns.Name)
+			}
+			tenant.Leaked = append(tenant.Leaked, prefix)
+		}
+	}
+	if value := ns.Annotations[VppAnnotationPrefix+TenantGatewayAnnotation]; value != "" {
+		for _, gwStr := range strings.Split(value, ",") {
+			gw := net.ParseIP(strings.TrimSpace(gwStr))
+			if gw == nil {
+				return nil, fmt.Errorf("error parsing %s%s=%s in namespace %s", VppAnnotationPrefix, TenantGatewayAnnotation, value, ns.Name)
+			}
+			for _, other := range tenant.Gateways {
+				if vpplink.IsIP4(other) == vpplink.IsIP4(gw) {
+					return nil, fmt.Errorf("%s%s=%s in namespace %s has several gateways of the same family", VppAnnotationPrefix, TenantGatewayAnnotation, value, ns.Name)
+				}
+			}
+			tenant.Gateways = append(tenant.Gateways, gw)
+		}
+	}
+	return tenant, nil
+}
+
+/* The services (namespace/name) the namespace leaks to its tenant, including the ones leaked to all tenants */
+func namespaceLeakedServices(ns *v1.Namespace) []string {
+	services := append([]string{}, config.TenantLeakedServices...)
+	if value := ns.Annotations[VppAnnotationPrefix+TenantLeakServicesAnnotation]; value != "" {
+		for _, service := range strings.Split(value, ",") {
+			service = strings.TrimSpace(service)
+			if !strings.Contains(service, "/") {
+				service = ns.Name + "/" + service
+			}
+			services = append(services, service)
+		}
+	}
+	return services
+}
+
+/**
+ * tenantServiceScope returns the services scoped to a tenant, given its
+ * namespaces: all the services of these namespaces ("namespace/"), and
+ * the ones they leak.
+ */
+func tenantServiceScope(namespaces []*v1.Namespace) map[string]bool {
+	scope := make(map[string]bool)
+	for _, ns := range namespaces {
+		scope[ns.Name+"/"] = true
+		for _, service := range namespaceLeakedServices(ns) {
+			scope[service] = true
+		}
+	}
+	return scope
+}
+
+func serviceInScope(scope map[string]bool, namespace string, name string) bool {
+	return scope[namespace+"/"] || scope[namespace+"/"+name]
+}
+
+func (s *Server) getNamespaceTenant(namespace string) (*TenantVRF, error) {
+	ns, err := s.namespaceLister.Get(namespace)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting namespace %s", namespace)
+	}
+	return parseNamespaceTenant(ns)
+}
+
+/* tenantMembers are the addresses a tenant routes, and accepts traffic from */
+type tenantMembers struct {
+	/* members on this node, routed via the PodVRF */
+	local []*net.IPNet
+	/* members on other nodes, routed by the connectivity server */
+	remote []*net.IPNet
+	/* addresses of the services scoped to the tenant */
+	services []*net.IPNet
+}
+
+/**
+ * Addresses of the members of a tenant: its pods on other nodes (local pods
+ * come from the pods we create), the endpoints of the services scoped to
+ * it, and the addresses of these services.
+ */
+func tenantMemberPrefixes(pods []*v1.Pod, endpoints []*v1.Endpoints, services []*v1.Service, nodeName string) *tenantMembers {
+	members := &tenantMembers{}
+	for _, pod := range pods {
+		if !isRemoteTenantMember(pod, nodeName) {
+			continue
+		}
+		for _, podIP := range pod.Status.PodIPs {
+			if ip := net.ParseIP(podIP.IP); ip != nil {
+				members.remote = append(members.remote, common.ToMaxLenCIDR(ip))
+			}
+		}
+	}
+	for _, ep := range endpoints {
+		for _, subset := range ep.Subsets {
+			for _, address := range subset.Addresses {
+				ip := net.ParseIP(address.IP)
+				if ip == nil {
+					continue
+				}
+				if address.NodeName != nil && *address.NodeName != nodeName {
+					members.remote = append(members.remote, common.ToMaxLenCIDR(ip))
+				} else {
+					members.local = append(members.local, common.ToMaxLenCIDR(ip))
+				}
+			}
+		}
+	}
+	for _, service := range services {
+		for _, clusterIP := range service.Spec.ClusterIPs {
+			if ip := net.ParseIP(clusterIP); ip != nil {
+				members.services = append(members.services, common.ToMaxLenCIDR(ip))
+			}
+		}
+	}
+	return members
+}
+
+func anyPrefix(isIp6 bool) *net.IPNet {
+	if isIp6 {
+		return &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}
+	}
+	return &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}
+}
+
+/* Routes the tenant VRF should contain, by destination, to reach prefixes via the PodVRF */
+func tenantRoutes(tenant *TenantVRF, prefixes []*net.IPNet) map[string]*types.Route {
+	routes := make(map[string]*types.Route)
+	for _, prefix := range prefixes {
+		routes[prefix.String()] = &types.Route{
+			Dst:   prefix,
+			Table: tenant.GetVrfId(vpplink.IpFamilyFromIPNet(prefix)),
+			Paths: []types.RoutePath{{
+				Table:     common.PodVRFIndex,
+				SwIfIndex: types.InvalidID,
+			}},
+		}
+	}
+	for _, gw := range tenant.Gateways {
+		dst := anyPrefix(!vpplink.IsIP4(gw))
+		routes[dst.String()] = &types.Route{
+			Dst:   dst,
+			Table: tenant.GetVrfId(vpplink.IpFamilyFromIPNet(dst)),
+			Paths: []types.RoutePath{{
+				Gw:        gw,
+				Table:     common.PodVRFIndex,
+				SwIfIndex: types.InvalidID,
+			}},
+		}
+	}
+	return routes
+}
+
+/**
+ * Rules of the ACL on the traffic sent to the tenant pods: it permits the
+ * sources the tenant routes, the gateways and the addresses of the services
+ * scoped to the tenant. The ACL denies everything else.
+ */
+func tenantIngressRules(tenant *TenantVRF, routed []*net.IPNet, members *tenantMembers) []types.ACLRule {
+	sources := append(append([]*net.IPNet{}, routed...), members.remote...)
+	sources = append(sources, members.services...)
+	for _, gw := range tenant.Gateways {
+		sources = append(sources, common.ToMaxLenCIDR(gw))
+	}
+	rules := make([]types.ACLRule, 0, len(sources))
+	seen := make(map[string]bool)
+	for _, src := range sources {
+		if seen[src.String()] {
+			continue
+		}
+		seen[src.String()] = true
+		rules = append(rules, types.ACLRule{
+			Src: *src,
+			Dst: *anyPrefix(!vpplink.IsIP4(src.IP)),
+		})
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Src.String() < rules[j].Src.String()
+	})
+	return rules
+}
+
+/* Rules of the ACL on the traffic the tenant pods send, permitting its replies back */
+func tenantReflectRules() []types.ACLRule {
+	rules := make([]types.ACLRule, 0, 2)
+	for _, isIp6 := range []bool{false, true} {
+		rules = append(rules, types.ACLRule{
+			Src:     *anyPrefix(isIp6),
+			Dst:     *anyPrefix(isIp6),
+			Reflect: true,
+		})
+	}
+	return rules
+}
+
+/* Routes read back from VPP have an unspecified gateway instead of none */
+func sameTenantRoutePath(a *types.Route, b *types.Route) bool {
+	if len(a.Paths) != 1 || len(b.Paths) != 1 || a.Paths[0].Table != b.Paths[0].Table {
+		return false
+	}
+	gwA, gwB := a.Paths[0].Gw, b.Paths[0].Gw
+	if gwA == nil || gwA.IsUnspecified() {
+		return gwB == nil || gwB.IsUnspecified()
+	}
+	return gwA.Equal(gwB)
+}
+
+/* getTenantMembers returns the members of a tenant known to the informers */
+func (s *Server) getTenantMembers(tenant *TenantVRF) *tenantMembers {
+	if s.namespaceLister == nil {
+		return &tenantMembers{}
+	}
+	allNamespaces, err := s.namespaceLister.List(labels.Everything())
+	if err != nil {
+		s.log.Errorf("Error listing namespaces for tenant %s: %v", tenant.Name, err)
+		return &tenantMembers{}
+	}
+	namespaces := make([]*v1.Namespace, 0)
+	pods := make([]*v1.Pod, 0)
+	for _, ns := range allNamespaces {
+		if ns.Annotations[VppAnnotationPrefix+TenantAnnotation] != tenant.Name {
+			continue
+		}
+		namespaces = append(namespaces, ns)
+		nsPods, err := s.podLister.Pods(ns.Name).List(labels.Everything())
+		if err != nil {
+			s.log.Errorf("Error listing pods of namespace %s: %v", ns.Name, err)
+		}
+		pods = append(pods, nsPods...)
+	}
+	scope := tenantServiceScope(namespaces)
+	services := make([]*v1.Service, 0)
+	endpoints := make([]*v1.Endpoints, 0)
+	for key := range scope {
+		namespace, name, err := cache.SplitMetaNamespaceKey(key)
+		if err != nil {
+			continue
+		}
+		if name == "" {
+			nsServices, err := s.serviceLister.Services(namespace).List(labels.Everything())
+			if err != nil {
+				s.log.Errorf("Error listing services of namespace %s: %v", namespace, err)
+			}
+			services = append(services, nsServices...)
+			nsEndpoints, err := s.endpointsLister.Endpoints(namespace).List(labels.Everything())
+			if err != nil {
+				s.log.Errorf("Error listing endpoints of namespace %s: %v", namespace, err)
+			}
+			endpoints = append(endpoints, nsEndpoints...)
+			continue
+		}
+		if scope[namespace+"/"] {
+			/* Already listed with its namespace */
+			continue
+		}
+		if service, err := s.serviceLister.Services(namespace).Get(name); err == nil {
+			services = append(services, service)
+		}
+		if ep, err := s.endpointsLister.Endpoints(namespace).Get(name); err == nil {
+			endpoints = append(endpoints, ep)
+		}
+	}
+	return tenantMemberPrefixes(pods, endpoints, services, config.NodeName)
+}
+
+/* Pods not on nodeName whose addresses are routed in the tenant VRFs of their namespace */
+func isRemoteTenantMember(pod *v1.Pod, nodeName string) bool {
+	return pod.Spec.NodeName != nodeName && !pod.Spec.HostNetwork &&
+		pod.Status.Phase != v1.PodSucceeded && pod.Status.Phase != v1.PodFailed
+}
+
+/* updateTenantIngressACL replaces the rules of the tenant ingress ACL when they change */
+func (s *Server) updateTenantIngressACL(tenant *TenantVRF, rules []types.ACLRule) error {
+	if tenant.ingressACL == nil || reflect.DeepEqual(rules, tenant.ingressRules) {
+		return nil
+	}
+	s.log.Infof("tenant(upd) %s ingress ACL %d with %d sources", tenant.Name, tenant.ingressACL.ACLIndex, len(rules))
+	tenant.ingressACL.Rules = rules
+	err := s.vpp.UpdateACL(tenant.ingressACL)
+	if err != nil {
+		return errors.Wrapf(err, "error updating the ingress ACL of tenant %s", tenant.Name)
+	}
+	tenant.ingressRules = rules
+	return nil
+}
+
+/**
+ * resyncTenantRoutes reconciles the routes in the tenant VRF, hands its
+ * remote members to the connectivity server and updates its ingress ACL.
+ * Local members are the pods in podInterfaceMap, plus added that is not in
+ * it yet, minus removed that is being deleted. Expects s.lock to be held.
+ */
+func (s *Server) resyncTenantRoutes(tenant *TenantVRF, added *storage.LocalPodSpec, removed *storage.LocalPodSpec) error {
+	members := s.getTenantMembers(tenant)
+	prefixes := append([]*net.IPNet{}, tenant.Leaked...)
+	for _, addr := range s.getNodeAddresses() {
+		prefixes = append(prefixes, common.ToMaxLenCIDR(addr))
+	}
+	prefixes = append(prefixes, members.local...)
+	/* Local pods might not have their addresses in their status yet */
+	for key, podSpec := range s.podInterfaceMap {
+		if podSpec.TenantName == tenant.Name && (removed == nil || key != removed.Key()) {
+			prefixes = append(prefixes, podSpec.GetContainerIps()...)
+		}
+	}
+	if added != nil {
+		prefixes = append(prefixes, added.GetContainerIps()...)
+	}
+
+	s.queueTenantEvent(common.CalicoVppEvent{
+		Type: common.TenantMembersChanged,
+		New: &common.TenantMembers{
+			Name:    tenant.Name,
+			V4VrfId: tenant.V4VrfId,
+			V6VrfId: tenant.V6VrfId,
+			Remote:  members.remote,
+		},
+	})
+
+	/* The ingress ACL allows new members before they are routed, and denies the ones leaving before their routes go */
+	err := s.updateTenantIngressACL(tenant, tenantIngressRules(tenant, prefixes, members))
+	routes := tenantRoutes(tenant, prefixes)
+	for key, route := range tenant.routes {
+		if desired, found := routes[key]; found && sameTenantRoutePath(desired, route) {
+			continue
+		}
+		s.log.Infof("tenant(del) %s route [tenantVRF->PodVRF] %s", tenant.Name, route.String())
+		delErr := s.vpp.RouteDel(route)
+		if delErr != nil {
+			s.log.Errorf("Error deleting route %s in tenant %s: %v", route.String(), tenant.Name, delErr)
+		}
+		delete(tenant.routes, key)
+	}
+	keys := make([]string, 0, len(routes))
+	for key := range routes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, found := tenant.routes[key]; found {
+			continue
+		}
+		route := routes[key]
+		s.log.Infof("tenant(add) %s route [tenantVRF->PodVRF] %s", tenant.Name, route.String())
+		addErr := s.vpp.RouteAdd(route)
+		if addErr != nil {
+			err = errors.Wrapf(addErr, "error adding route %s in tenant %s", route.String(), tenant.Name)
+			continue
+		}
+		tenant.routes[key] = route
+	}
+	return err
+}
+
+/**
+ * findTenantVRFs adopts the VRFs, routes via the PodVRF & ACLs remaining
+ * from a previous run of the agent. The connectivity server adopts the
+ * other routes.
+ */
+func (s *Server) findTenantVRFs(tenant *TenantVRF) error {
+	vrfs, err := s.vpp.ListVRFs()
+	if err != nil {
+		return errors.Wrap(err, "error listing VRFs")
+	}
+	for _, vrf := range vrfs {
+		for _, ipFamily := range vpplink.IpFamilies {
+			if vrf.Name != tenant.GetVrfTag(ipFamily) {
+				continue
+			}
+			tenant.SetVrfId(vrf.VrfID, ipFamily)
+			routes, err := s.vpp.GetRoutes(vrf.VrfID, ipFamily.IsIp6)
+			if err != nil {
+				return errors.Wrapf(err, "error listing routes of tenant VRF %d", vrf.VrfID)
+			}
+			for i, route := range routes {
+				if len(route.Paths) == 1 && route.Paths[0].Table == common.PodVRFIndex {
+					tenant.routes[route.Dst.String()] = &routes[i]
+				}
+			}
+		}
+	}
+	acls, err := s.vpp.ListACLs()
+	if err != nil {
+		return errors.Wrap(err, "error listing ACLs")
+	}
+	for i, acl := range acls {
+		switch acl.Tag {
+		case tenant.getIngressACLTag():
+			/* Its rules are replaced by the first resync */
+			tenant.ingressACL = &acls[i]
+		case tenant.getReflectACLTag():
+			tenant.reflectACL = &acls[i]
+		}
+	}
+	return nil
+}
+
+func (s *Server) createTenantVRF(tenant *TenantVRF, podSpec *storage.LocalPodSpec) (err error) {
+	/* VRFs might remain from a previous run of the agent */
+	err = s.findTenantVRFs(tenant)
+	if err != nil {
+		return err
+	}
+	stack := s.vpp.NewCleanupStack()
+	for _, ipFamily := range vpplink.IpFamilies {
+		if tenant.GetVrfId(ipFamily) != types.InvalidID {
+			continue
+		}
+		vrfId, err := s.vpp.AllocateVRF(ipFamily.IsIp6, tenant.GetVrfTag(ipFamily))
+		if err != nil {
+			stack.Execute()
+			return errors.Wrapf(err, "error allocating tenant VRF %s", ipFamily.Str)
+		}
+		stack.Push(s.vpp.DelVRF, vrfId, ipFamily.IsIp6)
+		tenant.SetVrfId(vrfId, ipFamily)
+		s.log.Infof("tenant(add) %s VRF %d %s", tenant.Name, vrfId, ipFamily.Str)
+	}
+
+	if tenant.reflectACL == nil {
+		acl := &types.ACL{Tag: tenant.getReflectACLTag(), Rules: tenantReflectRules()}
+		err = s.vpp.AddACL(acl)
+		if err != nil {
+			stack.Execute()
+			return errors.Wrapf(err, "error adding the reflect ACL of tenant %s", tenant.Name)
+		}
+		stack.Push(s.vpp.DelACL, acl.ACLIndex)
+		tenant.reflectACL = acl
+		s.log.Infof("tenant(add) %s reflect ACL %d", tenant.Name, acl.ACLIndex)
+	}
+	if tenant.ingressACL == nil {
+		/* Denies everything until the resync below */
+		acl := &types.ACL{Tag: tenant.getIngressACLTag()}
+		err = s.vpp.AddACL(acl)
+		if err != nil {
+			stack.Execute()
+			return errors.Wrapf(err, "error adding the ingress ACL of tenant %s", tenant.Name)
+		}
+		stack.Push(s.vpp.DelACL, acl.ACLIndex)
+		tenant.ingressACL = acl
+		s.log.Infof("tenant(add) %s ingress ACL %d", tenant.Name, acl.ACLIndex)
+	}
+
+	err = s.resyncTenantRoutes(tenant, podSpec, nil)
+	if err != nil {
+		stack.Execute()
+		return err
+	}
+	return nil
+}
+
+func (s *Server) deleteTenantVRF(tenant *TenantVRF) {
+	s.queueTenantEvent(common.CalicoVppEvent{
+		Type: common.TenantDeleted,
+		Old:  &common.TenantMembers{Name: tenant.Name, V4VrfId: tenant.V4VrfId, V6VrfId: tenant.V6VrfId},
+	})
+	/* Deleting the tables also flushes their routes */
+	for _, ipFamily := range vpplink.IpFamilies {
+		vrfId := tenant.GetVrfId(ipFamily)
+		s.log.Infof("tenant(del) %s VRF %d %s", tenant.Name, vrfId, ipFamily.Str)
+		err := s.vpp.DelVRF(vrfId, ipFamily.IsIp6)
+		if err != nil {
+			s.log.Errorf("Error deleting tenant VRF %d %s : %s", vrfId, ipFamily.Str, err)
+		}
+	}
+	for _, acl := range []*types.ACL{tenant.ingressACL, tenant.reflectACL} {
+		if acl == nil {
+			continue
+		}
+		s.log.Infof("tenant(del) %s ACL %d", tenant.Name, acl.ACLIndex)
+		err := s.vpp.DelACL(acl.ACLIndex)
+		if err != nil {
+			s.log.Errorf("Error deleting tenant ACL %d : %s", acl.ACLIndex, err)
+		}
+	}
+}
+
+/**
+ * gcTenantVRFs deletes the tenant VRFs & ACLs without pods, that might remain
+ * from a previous run of the agent. Expects s.lock to be held.
+ */
+func (s *Server) gcTenantVRFs() {
+	vrfs, err := s.vpp.ListVRFs()
+	if err != nil {
+		s.log.Errorf("Error listing VRFs, not deleting stale tenant VRFs: %v", err)
+		return
+	}
+	inUse := make(map[string]bool)
+	for _, tenant := range s.tenants {
+		for _, ipFamily := range vpplink.IpFamilies {
+			inUse[tenant.GetVrfTag(ipFamily)] = true
+		}
+		inUse[tenant.getIngressACLTag()] = true
+		inUse[tenant.getReflectACLTag()] = true
+	}
+	for _, vrf := range vrfs {
+		if !strings.HasPrefix(vrf.Name, tenantVrfTagPrefix) || inUse[vrf.Name] {
+			continue
+		}
+		s.log.Infof("tenant(del) stale VRF %d %s", vrf.VrfID, vrf.Name)
+		err = s.vpp.DelVRF(vrf.VrfID, vrf.IsIP6)
+		if err != nil {
+			s.log.Errorf("Error deleting stale tenant VRF %d: %v", vrf.VrfID, err)
+		}
+	}
+	acls, err := s.vpp.ListACLs()
+	if err != nil {
+		s.log.Errorf("Error listing ACLs, not deleting stale tenant ACLs: %v", err)
+		return
+	}
+	for _, acl := range acls {
+		if inUse[acl.Tag] || (!strings.HasPrefix(acl.Tag, tenantIngressACLTagPrefix) && !strings.HasPrefix(acl.Tag, tenantReflectACLTagPrefix)) {
+			continue
+		}
+		s.log.Infof("tenant(del) stale ACL %d %s", acl.ACLIndex, acl.Tag)
+		err = s.vpp.DelACL(acl.ACLIndex)
+		if err != nil {
+			s.log.Errorf("Error deleting stale tenant ACL %d: %v", acl.ACLIndex, err)
+		}
+	}
+}
+
+/* Attach a pod to its tenant VRF, creating it if needed */
+func (s *Server) AddPodToTenant(podSpec *storage.LocalPodSpec) error {
+	if podSpec.TenantName == "" {
+		return nil
+	}
+	tenant, found := s.tenants[podSpec.TenantName]
+	if found {
+		return s.resyncTenantRoutes(tenant, podSpec, nil)
+	}
+	namespace := namespaceFromWorkloadID(podSpec.WorkloadID)
+	tenant, err := s.getNamespaceTenant(namespace)
+	if err != nil {
+		return err
+	} else if tenant == nil || tenant.Name != podSpec.TenantName {
+		return fmt.Errorf("namespace %s is not part of tenant %s anymore", namespace, podSpec.TenantName)
+	}
+	err = s.startTenantMemberInformers()
+	if err != nil {
+		return err
+	}
+	err = s.createTenantVRF(tenant, podSpec)
+	if err != nil {
+		return errors.Wrapf(err, "error creating tenant %s", tenant.Name)
+	}
+	s.log.Infof("tenant(add) %s", tenant.String())
+	s.tenants[tenant.Name] = tenant
+	return nil
+}
+
+/**
+ * AttachPodToTenantACLs polices the traffic to and from the interfaces of
+ * a tenant pod with the ACLs of its tenant
+ */
+func (s *Server) AttachPodToTenantACLs(podSpec *storage.LocalPodSpec) error {
+	tenant, found := s.tenants[podSpec.TenantName]
+	if !found || podSpec.TenantName == "" {
+		return nil
+	}
+	for _, swIfIndex := range []uint32{podSpec.TunTapSwIfIndex, podSpec.MemifSwIfIndex} {
+		if swIfIndex == types.InvalidID {
+			continue
+		}
+		s.log.Infof("pod(add) tenant %s ACLs on if[%d]", tenant.Name, swIfIndex)
+		err := s.vpp.SetInterfaceACLs(swIfIndex, []uint32{tenant.reflectACL.ACLIndex}, []uint32{tenant.ingressACL.ACLIndex})
+		if err != nil {
+			return errors.Wrapf(err, "error applying the ACLs of tenant %s", tenant.Name)
+		}
+	}
+	return nil
+}
+
+/* Detach a pod from its tenant, deleting the tenant VRF with its last local pod */
+func (s *Server) DelPodFromTenant(podSpec *storage.LocalPodSpec) {
+	tenant, found := s.tenants[podSpec.TenantName]
+	if !found {
+		return
+	}
+	for key, other := range s.podInterfaceMap {
+		if key != podSpec.Key() && other.TenantName == tenant.Name {
+			err := s.resyncTenantRoutes(tenant, nil, podSpec)
+			if err != nil {
+				s.log.Errorf("Error updating tenant %s routes: %v", tenant.Name, err)
+			}
+			return
+		}
+	}
+	s.deleteTenantVRF(tenant)
+	delete(s.tenants, tenant.Name)
+}
+
+/* VRF the pod VRFs default to, the tenant VRF if any, otherwise the PodVRF */
+func (s *Server) getPodUplinkVRF(podSpec *storage.LocalPodSpec, ipFamily vpplink.IpFamily) uint32 {
+	if tenant, found := s.tenants[podSpec.TenantName]; found && podSpec.TenantName != "" {
+		return tenant.GetVrfId(ipFamily)
+	}
+	return common.PodVRFIndex
+}
+
+/**
+ * queueTenantResync records tenants whose members changed. It runs in the
+ * informer goroutines, the CNI loop resyncs the local tenants concerned.
+ */
+func (s *Server) queueTenantResync(tenantNames []string) {
+	s.pendingTenantsLock.Lock()
+	queued := false
+	for _, name := range tenantNames {
+		if name != "" {
+			s.pendingTenants[name] = true
+			queued = true
+		}
+	}
+	s.pendingTenantsLock.Unlock()
+	if queued {
+		select {
+		case s.tenantsChanged <- struct{}{}:
+		default:
+			/* a resync is already pending */
+		}
+	}
+}
+
+/**
+ * queueTenantEvent queues an event for the connectivity server, sent by
+ * sendTenantEvents once s.lock is released: the connectivity server can be
+ * blocked sending us events we handle under s.lock. Expects s.lock to be held.
+ */
+func (s *Server) queueTenantEvent(event common.CalicoVppEvent) {
+	s.tenantEvents = append(s.tenantEvents, event)
+}
+
+/* sendTenantEvents sends the queued tenant events, in order. Expects s.lock not to be held. */
+func (s *Server) sendTenantEvents() {
+	s.tenantEventsLock.Lock()
+	defer s.tenantEventsLock.Unlock()
+	s.lock.Lock()
+	events := s.tenantEvents
+	s.tenantEvents = nil
+	s.lock.Unlock()
+	for _, event := range events {
+		common.SendEvent(event)
+	}
+}
+
+/* resyncPendingTenants updates the routes of the local tenants queued by the informers */
+func (s *Server) resyncPendingTenants() {
+	s.pendingTenantsLock.Lock()
+	tenantNames := s.pendingTenants
+	s.pendingTenants = make(map[string]bool)
+	s.pendingTenantsLock.Unlock()
+
+	defer s.sendTenantEvents()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, tenant := range s.tenants {
+		if !tenantNames[tenant.Name] {
+			continue
+		}
+		err := s.resyncTenantRoutes(tenant, nil, nil)
+		if err != nil {
+			s.log.Errorf("Error updating tenant %s routes: %v", tenant.Name, err)
+		}
+	}
+}
+
+func (s *Server) namespaceTenantName(namespace string) string {
+	ns, err := s.namespaceLister.Get(namespace)
+	if err != nil {
+		return ""
+	}
+	return ns.Annotations[VppAnnotationPrefix+TenantAnnotation]
+}
+
+/* serviceTenants returns the tenants a service is scoped to */
+func (s *Server) serviceTenants(namespace string, name string) []string {
+	allNamespaces, err := s.namespaceLister.List(labels.Everything())
+	if err != nil {
+		s.log.Errorf("Error listing namespaces for service %s/%s: %v", namespace, name, err)
+		return nil
+	}
+	namespacesByTenant := make(map[string][]*v1.Namespace)
+	for _, ns := range allNamespaces {
+		if tenantName := ns.Annotations[VppAnnotationPrefix+TenantAnnotation]; tenantName != "" {
+			namespacesByTenant[tenantName] = append(namespacesByTenant[tenantName], ns)
+		}
+	}
+	tenantNames := make([]string, 0)
+	for tenantName, namespaces := range namespacesByTenant {
+		if serviceInScope(tenantServiceScope(namespaces), namespace, name) {
+			tenantNames = append(tenantNames, tenantName)
+		}
+	}
+	return tenantNames
+}
+
+func deletedObject(obj interface{}) interface{} {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		return tombstone.Obj
+	}
+	return obj
+}
+
+/* tenantPodChanged returns whether an update changes what tenantMemberPrefixes derives from the pod */
+func tenantPodChanged(old *v1.Pod, pod *v1.Pod) bool {
+	return isRemoteTenantMember(old, config.NodeName) != isRemoteTenantMember(pod, config.NodeName) ||
+		!reflect.DeepEqual(old.Status.PodIPs, pod.Status.PodIPs)
+}
+
+/**
+ * onTenantPodEvent only considers remote pods, local pods are tracked
+ * through the pods we create & delete
+ */
+func (s *Server) onTenantPodEvent(old interface{}, obj interface{}) {
+	oldPod, _ := deletedObject(old).(*v1.Pod)
+	pod, _ := deletedObject(obj).(*v1.Pod)
+	if oldPod != nil && pod != nil && !tenantPodChanged(oldPod, pod) {
+		return
+	}
+	if pod == nil {
+		pod = oldPod
+	}
+	if pod == nil || pod.Spec.NodeName == config.NodeName {
+		return
+	}
+	s.queueTenantResync([]string{s.namespaceTenantName(pod.Namespace)})
+}
+
+func (s *Server) onTenantEndpointsEvent(old interface{}, obj interface{}) {
+	oldEp, _ := deletedObject(old).(*v1.Endpoints)
+	ep, _ := deletedObject(obj).(*v1.Endpoints)
+	if oldEp != nil && ep != nil && reflect.DeepEqual(oldEp.Subsets, ep.Subsets) {
+		return
+	}
+	if ep == nil {
+		ep = oldEp
+	}
+	if ep == nil {
+		return
+	}
+	s.queueTenantResync(s.serviceTenants(ep.Namespace, ep.Name))
+}
+
+func (s *Server) onTenantServiceEvent(old interface{}, obj interface{}) {
+	oldService, _ := deletedObject(old).(*v1.Service)
+	service, _ := deletedObject(obj).(*v1.Service)
+	if oldService != nil && service != nil && reflect.DeepEqual(oldService.Spec.ClusterIPs, service.Spec.ClusterIPs) {
+		return
+	}
+	if service == nil {
+		service = oldService
+	}
+	if service == nil {
+		return
+	}
+	s.queueTenantResync(s.serviceTenants(service.Namespace, service.Name))
+}
+
+func (s *Server) onTenantNamespaceEvent(old interface{}, obj interface{}) {
+	oldNs, _ := deletedObject(old).(*v1.Namespace)
+	ns, _ := deletedObject(obj).(*v1.Namespace)
+	names := make([]string, 0, 2)
+	leaks := make([]string, 0, 2)
+	for _, n := range []*v1.Namespace{oldNs, ns} {
+		if n != nil {
+			names = append(names, n.Annotations[VppAnnotationPrefix+TenantAnnotation])
+			leaks = append(leaks, n.Annotations[VppAnnotationPrefix+TenantLeakServicesAnnotation])
+		}
+	}
+	if oldNs != nil && ns != nil && names[0] == names[1] && leaks[0] == leaks[1] {
+		/* Leaked prefixes & gateways are read when the tenant is created */
+		return
+	}
+	s.queueTenantResync(names)
+}
+
+/**
+ * initTenantInformers watches the namespaces for tenants. The pods, services
+ * & endpoints the tenant members are derived from are only watched once we
+ * have a tenant, see startTenantMemberInformers.
+ */
+func (s *Server) initTenantInformers(informerFactory informers.SharedInformerFactory) {
+	s.informerFactory = informerFactory
+	namespaceInformer := informerFactory.Core().V1().Namespaces()
+	namespaceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { s.onTenantNamespaceEvent(nil, obj) },
+		UpdateFunc: s.onTenantNamespaceEvent,
+		DeleteFunc: func(obj interface{}) { s.onTenantNamespaceEvent(obj, nil) },
+	})
+}
+
+/**
+ * startTenantMemberInformers starts watching the pods, services & endpoints
+ * when the first tenant is created, and waits for their caches. Expects
+ * s.lock to be held.
+ */
+func (s *Server) startTenantMemberInformers() error {
+	if s.podLister == nil {
+		s.log.Infof("tenant(add) watching pods, services & endpoints")
+		podInformer := s.informerFactory.Core().V1().Pods()
+		serviceInformer := s.informerFactory.Core().V1().Services()
+		endpointsInformer := s.informerFactory.Core().V1().Endpoints()
+		s.addTenantMemberHandlers(podInformer.Informer(), serviceInformer.Informer(), endpointsInformer.Informer())
+
+		s.podLister = podInformer.Lister()
+		s.serviceLister = serviceInformer.Lister()
+		s.endpointsLister = endpointsInformer.Lister()
+		s.tenantInformersSynced = []cache.InformerSynced{
+			podInformer.Informer().HasSynced,
+			serviceInformer.Informer().HasSynced,
+			endpointsInformer.Informer().HasSynced,
+		}
+		/* Only starts the informers not started yet */
+		s.informerFactory.Start(s.informerStop)
+	}
+	if !cache.WaitForCacheSync(s.informerStop, s.tenantInformersSynced...) {
+		return errors.Errorf("tenant informers did not sync")
+	}
+	return nil
+}
+
+func (s *Server) addTenantMemberHandlers(podInformer, serviceInformer, endpointsInformer cache.SharedIndexInformer) {
+	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { s.onTenantPodEvent(nil, obj) },
+		UpdateFunc: s.onTenantPodEvent,
+		DeleteFunc: func(obj interface{}) { s.onTenantPodEvent(obj, nil) },
+	})
+	serviceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { s.onTenantServiceEvent(nil, obj) },
+		UpdateFunc: s.onTenantServiceEvent,
+		DeleteFunc: func(obj interface{}) { s.onTenantServiceEvent(obj, nil) },
+	})
+	endpointsInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { s.onTenantEndpointsEvent(nil, obj) },
+		UpdateFunc: s.onTenantEndpointsEvent,
+		DeleteFunc: func(obj interface{}) { s.onTenantEndpointsEvent(obj, nil) },
+	})
+}
@@ -135,6 +135,19 @@ func FullyQualified(addr net.IP) *net.IPNet {
 	}
 }
 
+/**
+ * IpsecTunnelAddress returns the i-th of the IpsecAddressCount consecutive
+ * addresses IPsec assumes a node owns, incrementing the second to last byte
+ */
+func IpsecTunnelAddress(addr net.IP, i int) net.IP {
+	tunnelAddr := net.IP(append([]byte(nil), addr.To16()...))
+	if !vpplink.IsIP6(addr) {
+		tunnelAddr = net.IP(append([]byte(nil), addr.To4()...))
+	}
+	tunnelAddr[len(tunnelAddr)-2] += byte(i)
+	return tunnelAddr
+}
+
 // This function and the related mechanism in vpmanager are curently kept around
 // in case they're useful for the Host Endpoint policies implementation
 func GetVppTapSwifIndex() (swIfIndex uint32, err error) {
@@ -396,17 +409,107 @@ func GetStringChangeType(old, new string) ChangeType {
 	}
 }
 
+/**
+ * TenantMembers are the members of a tenant VRF running on other nodes, the
+ * connectivity server routes them in the tenant VRF through the provider
+ * reaching their node.
+ */
+type TenantMembers struct {
+	Name    string
+	V4VrfId uint32
+	V6VrfId uint32
+	Remote  []*net.IPNet
+}
+
+func (t *TenantMembers) GetVrfId(isIp6 bool) uint32 {
+	if isIp6 {
+		return t.V6VrfId
+	}
+	return t.V4VrfId
+}
+
 type NodeConnectivity struct {
 	Dst              net.IPNet
 	NextHop          net.IP
 	ResolvedProvider string
-	Custom           interface{}
+	/* Provider parameters of the connectivity policy rule that picked ResolvedProvider */
+	Parameters map[string]string
+	Custom     interface{}
 }
 
 func (cn *NodeConnectivity) String() string {
 	return fmt.Sprintf("%s-%s", cn.Dst.String(), cn.NextHop.String())
 }
 
+const (
+	ConnectivityStateUp      = "up"
+	ConnectivityStatePending = "pending"
+	ConnectivityStateDown    = "down"
+)
+
+/* NodeConnectivityStatus is the state of the connectivity towards a remote node */
+type NodeConnectivityStatus struct {
+	NodeName  string   `json:"nodeName"`
+	NextHop   net.IP   `json:"nextHop"`
+	Prefixes  []string `json:"prefixes"`
+	Provider  string   `json:"provider"`
+	SwIfIndex uint32   `json:"swIfIndex"`
+	Mtu       int      `json:"mtu,omitempty"`
+	State     string   `json:"state"`
+	Detail    string   `json:"detail,omitempty"`
+	LastError string   `json:"lastError,omitempty"`
+}
+
+type ConnectivityStatusSource interface {
+	GetConnectivityStatus() []NodeConnectivityStatus
+}
+
+/* IPsecTunnelStatus are the SAs of one of the IPsec tunnels towards a remote node */
+type IPsecTunnelStatus struct {
+	NodeName  string
+	NextHop   net.IP
+	Tunnel    string
+	SwIfIndex uint32
+	OutSA     *types.IPsecSA
+	InSAs     []types.IPsecSA
+	IKESA     *types.IKEv2SA
+	/* When the OutSA was first seen, it is rekeyed before SALifetime */
+	OutSAEstablished time.Time
+	/* Configured lifetime of the child SAs, 0 for VPP's default */
+	SALifetime time.Duration
+}
+
+type IPsecStatusSource interface {
+	GetIPsecTunnelStatus() []IPsecTunnelStatus
+}
+
+/**
+ * PeerProbeStatus are the results of the probes sent to a remote node,
+ * Loss is the ratio of lost probes among the last ones.
+ */
+type PeerProbeStatus struct {
+	NodeName  string        `json:"nodeName"`
+	NextHop   net.IP        `json:"nextHop"`
+	Provider  string        `json:"provider"`
+	Reachable bool          `json:"reachable"`
+	Rtt       time.Duration `json:"rtt"`
+	Sent      uint64        `json:"sent"`
+	Lost      uint64        `json:"lost"`
+	Loss      float64       `json:"loss"`
+}
+
+type ProbeStatusSource interface {
+	GetProbeStatus() []PeerProbeStatus
+}
+
+/* IPsecPSKs are the IKEv2 pre-shared keys read from the PSK secret, with their IDs */
+type IPsecPSKs struct {
+	Current   string
+	CurrentID string
+	Next      string
+	NextID    string
+}
+
 type SRv6Tunnel struct {
 	Dst      net.IP
 	Bsid     net.IP
@@ -416,6 +519,16 @@ type SRv6Tunnel struct {
 	Priority uint32
 }
 
+/**
+ * SRv6TEPolicy is an SR policy with explicit segment lists, from an
+ * SRv6Policy resource, and the prefixes steered into it on this node.
+ */
+type SRv6TEPolicy struct {
+	Name     string
+	Policy   *types.SrPolicy
+	Prefixes []net.IPNet
+}
+
 func GetBGPSpecAddresses(nodeBGPSpec *oldv3.NodeBGPSpec) (*net.IP, *net.IP) {
 	var ip4 *net.IP
 	var ip6 *net.IP
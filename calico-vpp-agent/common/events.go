@@ -0,0 +1,110 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/config"
+)
+
+/* Reasons of the Kubernetes Events we emit */
+const (
+	EventReasonPodNetworkFailed   = "VppPodNetworkFailed"
+	EventReasonNetnsNotFound      = "VppNetnsNotFound"
+	EventReasonOutOfBuffers       = "VppOutOfBuffers"
+	EventReasonPartialVRFState    = "VppPartialVRFState"
+	EventReasonAddressConflict    = "VppAddressConflict"
+	EventReasonConnectivityFailed = "VppConnectivityFailed"
+)
+
+const eventComponent = "calico-vpp-agent"
+
+var (
+	eventRecorder  record.EventRecorder
+	eventK8sClient kubernetes.Interface
+	eventLog       *logrus.Entry
+)
+
+/**
+ * InitEventRecorder sets up the recorder used to emit Kubernetes Events on
+ * pods and on our node. Events are sent asynchronously, emitting them before
+ * this is called is a no-op.
+ */
+func InitEventRecorder(k8sclient *kubernetes.Clientset, log *logrus.Entry) {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: k8sclient.CoreV1().Events(""),
+	})
+	eventRecorder = broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{
+		Component: eventComponent,
+		Host:      config.NodeName,
+	})
+	eventK8sClient = k8sclient
+	eventLog = log
+}
+
+/**
+ * PodWarningEvent emits a Warning Event on the given pod. The pod is fetched
+ * to reference its UID, so that kubectl describe shows the Event, we do
+ * this in the background as this is called with locks held.
+ */
+func PodWarningEvent(namespace, name, reason, messageFmt string, args ...interface{}) {
+	if eventRecorder == nil || namespace == "" || name == "" {
+		return
+	}
+	message := fmt.Sprintf(messageFmt, args...)
+	go func() {
+		ref := &corev1.ObjectReference{
+			Kind:       "Pod",
+			APIVersion: "v1",
+			Namespace:  namespace,
+			Name:       name,
+		}
+		pod, err := eventK8sClient.CoreV1().Pods(namespace).Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			eventLog.Warnf("Error getting pod %s/%s for event %s: %v", namespace, name, reason, err)
+		} else {
+			ref.UID = pod.UID
+			ref.ResourceVersion = pod.ResourceVersion
+		}
+		eventRecorder.Event(ref, corev1.EventTypeWarning, reason, message)
+	}()
+}
+
+/* NodeWarningEvent emits a Warning Event on our node */
+func NodeWarningEvent(reason, messageFmt string, args ...interface{}) {
+	if eventRecorder == nil {
+		return
+	}
+	/* The kubelet uses the node name as UID for node Events */
+	ref := &corev1.ObjectReference{
+		Kind: "Node",
+		Name: config.NodeName,
+		UID:  k8stypes.UID(config.NodeName),
+	}
+	eventRecorder.Eventf(ref, corev1.EventTypeWarning, reason, messageFmt, args...)
+}
@@ -35,6 +35,8 @@ const (
 	SRv6PolicyAdded   CalicoVppEventType = "SRv6PolicyAdded"
 	SRv6PolicyDeleted CalicoVppEventType = "SRv6PolicyDeleted"
 
+	SRv6TEPoliciesChanged CalicoVppEventType = "SRv6TEPoliciesChanged"
+
 	PodAdded   CalicoVppEventType = "PodAdded"
 	PodDeleted CalicoVppEventType = "PodDeleted"
 
@@ -56,6 +58,17 @@ const (
 
 	BGPReloadIP4 CalicoVppEventType = "BGPReloadIP4"
 	BGPReloadIP6 CalicoVppEventType = "BGPReloadIP6"
+
+	BFDSessionDown CalicoVppEventType = "BFDSessionDown"
+
+	IPsecPSKChanged CalicoVppEventType = "IPsecPSKChanged"
+
+	WireguardPublicKeyChanged CalicoVppEventType = "WireguardPublicKeyChanged"
+
+	EncapOverheadChanged CalicoVppEventType = "EncapOverheadChanged"
+
+	TenantMembersChanged CalicoVppEventType = "TenantMembersChanged"
+	TenantDeleted        CalicoVppEventType = "TenantDeleted"
 )
 
 var (
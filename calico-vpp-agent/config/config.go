@@ -16,6 +16,7 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	types2 "git.fd.io/govpp.git/api/v0"
 	"io/ioutil"
@@ -26,6 +27,8 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/projectcalico/calico/libcalico-go/lib/selector"
+	"github.com/projectcalico/vpp-dataplane/vpplink/types"
 	"github.com/sirupsen/logrus"
 )
 
@@ -39,6 +42,10 @@ const (
 	VppManagerLinuxMtu     = "/var/run/vpp/vppmanagerlinuxmtu"
 	CalicoVppPidFile       = "/var/run/vpp/calico_vpp.pid"
 	CniServerStateFile     = "/var/run/vpp/calico_vpp_pod_state"
+	PodCaptureSocket       = "/var/run/vpp/calico_vpp_capture.sock"
+	ProbeSocket            = "/var/run/vpp/calico_vpp_probe.sock"
+	/* Host directory mounted in the agent container, where pcap files are moved once written */
+	PodCaptureHostDir = "/var/lib/vpp/pcap"
 
 	NodeNameEnvVar             = "NODENAME"
 	TapNumRxQueuesEnvVar       = "CALICOVPP_TAP_RX_QUEUES"
@@ -53,6 +60,14 @@ const (
 	EnableIPSecEnvVar          = "CALICOVPP_IPSEC_ENABLED"
 	IPSecExtraAddressesEnvVar  = "CALICOVPP_IPSEC_ASSUME_EXTRA_ADDRESSES"
 	IPSecIkev2PskEnvVar        = "CALICOVPP_IPSEC_IKEV2_PSK"
+	IPSecIkev2AuthEnvVar       = "CALICOVPP_IPSEC_IKEV2_AUTH"
+	IPSecIkev2CertDirEnvVar    = "CALICOVPP_IPSEC_IKEV2_CERT_DIR"
+	IPSecIkev2CertSecretEnvVar = "CALICOVPP_IPSEC_IKEV2_CERT_SECRET"
+	IPSecIkev2KeyDirEnvVar     = "CALICOVPP_IPSEC_IKEV2_KEY_DIR"
+	IPSecIkev2CAFileEnvVar     = "CALICOVPP_IPSEC_IKEV2_CA_FILE"
+	IPSecIkev2PskSecretEnvVar  = "CALICOVPP_IPSEC_IKEV2_PSK_SECRET"
+	IPSecIkev2TransformsEnvVar = "CALICOVPP_IPSEC_IKEV2_TRANSFORMS"
+	EncryptionModeEnvVar       = "CALICOVPP_ENCRYPTION_MODE"
 	TapRxModeEnvVar            = "CALICOVPP_TAP_RX_MODE"
 	TapQueueSizeEnvVar         = "CALICOVPP_TAP_RING_SIZE"
 	IpsecNbAsyncCryptoThEnvVar = "CALICOVPP_IPSEC_NB_ASYNC_CRYPTO_THREAD"
@@ -61,11 +76,45 @@ const (
 	EnableSRv6EnvVar           = "CALICOVPP_SRV6_ENABLED"
 	SRv6LocalsidPoolEnvVar     = "CALICOVPP_SR_LS_POOL"
 	SRv6PolicyPoolEnvVar       = "CALICOVPP_SR_POLICY_POOL"
+	EnableTenantVRFsEnvVar     = "CALICOVPP_TENANT_VRFS_ENABLED"
+	TenantLeakedPrefixesEnvVar = "CALICOVPP_TENANT_VRFS_LEAKED_PREFIXES"
+	TenantLeakedServicesEnvVar = "CALICOVPP_TENANT_VRFS_LEAKED_SERVICES"
+	EnableEgressIPsEnvVar      = "CALICOVPP_EGRESS_IPS_ENABLED"
+	EnableBFDEnvVar            = "CALICOVPP_BFD_ENABLED"
+	BFDIntervalEnvVar          = "CALICOVPP_BFD_INTERVAL"
+	BFDMultiplierEnvVar        = "CALICOVPP_BFD_MULTIPLIER"
+	DefaultEncapEnvVar         = "CALICOVPP_DEFAULT_ENCAPSULATION"
+	WireguardKeyRotationEnvVar = "CALICOVPP_WIREGUARD_KEY_ROTATION_INTERVAL"
+	ConnectivityPolicyEnvVar   = "CALICOVPP_CONNECTIVITY_POLICY"
+	UplinkECMPEnvVar           = "CALICOVPP_UPLINK_ECMP"
+	UplinkFlowHashEnvVar       = "CALICOVPP_UPLINK_FLOW_HASH"
+	ProvidersConfigEnvVar      = "CALICOVPP_CONNECTIVITY_PROVIDERS_CONFIG"
+	TunnelMSSClampEnvVar       = "CALICOVPP_TUNNEL_MSS_CLAMP"
+	EnableProbesEnvVar         = "CALICOVPP_PROBES_ENABLED"
+	ProbeIntervalEnvVar        = "CALICOVPP_PROBE_INTERVAL"
+	ProbePortEnvVar            = "CALICOVPP_PROBE_PORT"
 
 	MemifSocketName      = "@vpp/memif"
 	DefaultVXLANVni      = 4096
 	DefaultVXLANPort     = 4789
+	DefaultGeneveVni     = 4096
 	DefaultWireguardPort = 51820
+	DefaultBFDInterval   = 300 * time.Millisecond
+	DefaultBFDMultiplier = 3
+	DefaultProbeInterval = 5 * time.Second
+	DefaultProbePort     = 7766
+
+	/* Spread flows across the uplinks on their 5-tuple */
+	DefaultUplinkFlowHash = types.FlowHashSrcIP | types.FlowHashDstIP |
+		types.FlowHashSrcPort | types.FlowHashDstPort | types.FlowHashProto
+
+	IPSecIkev2AuthPSK        = "psk"
+	IPSecIkev2AuthCert       = "cert"
+	DefaultIPSecIkev2CertDir = "/var/run/vpp/ikev2"
+
+	/* Encrypt IPIP traffic to every node, or only to nodes outside of our subnet */
+	EncryptionModeAlways      = "Always"
+	EncryptionModeCrossSubnet = "CrossSubnet"
 
 	defaultRxMode = types2.Adaptative
 )
@@ -86,11 +135,35 @@ var (
 	IpsecAddressCount        = 1
 	CrossIpsecTunnels        = false
 	IPSecIkev2Psk            = ""
+	IPSecIkev2Auth           = IPSecIkev2AuthPSK
+	IPSecIkev2CertDir        = DefaultIPSecIkev2CertDir
+	IPSecIkev2CertSecret     = ""
+	IPSecIkev2KeyDir         = ""
+	IPSecIkev2CAFile         = ""
+	IPSecIkev2PskSecret      = ""
+	EncryptionMode           = EncryptionModeAlways
 	TapRxMode                = defaultRxMode
 	BgpLogLevel              = logrus.InfoLevel
 	LogLevel                 = logrus.InfoLevel
 	NodeName                 = ""
 	ServiceCIDRs             []*net.IPNet
+	EnableTenantVRFs         = false
+	TenantLeakedPrefixes     []*net.IPNet
+	TenantLeakedServices     = []string{"kube-system/kube-dns"}
+	ConnectivityPolicy       []ConnectivityRule
+	ProvidersConfig          map[string]json.RawMessage
+	EnableEgressIPs          = false
+	EnableBFD                = false
+	BFDInterval              = DefaultBFDInterval
+	BFDMultiplier            = DefaultBFDMultiplier
+	DefaultEncapsulation     = ""
+	EnableUplinkECMP         = false
+	UplinkFlowHash           = DefaultUplinkFlowHash
+	EnableTunnelMSSClamp     = false
+	EnableProbes             = false
+	ProbeInterval            = DefaultProbeInterval
+	ProbePort                = DefaultProbePort
+	WireguardKeyRotation     time.Duration
 	TapRxQueueSize           int = 0
 	TapTxQueueSize           int = 0
 	HostMtu                  int = 0
@@ -105,6 +178,8 @@ var (
 	ContainerSideMacAddress, _ = net.ParseMAC("02:00:00:00:00:01")
 )
 
+var IPSecIkev2Transforms = IPsecTransforms{}
+
 func PrintAgentConfig(log *logrus.Logger) {
 	log.Infof("Config:TapNumRxQueues    %d", TapNumRxQueues)
 	log.Infof("Config:MemifEnabled      %t", MemifEnabled)
@@ -115,11 +190,173 @@ func PrintAgentConfig(log *logrus.Logger) {
 	log.Infof("Config:CrossIpsecTunnels %t", CrossIpsecTunnels)
 	log.Infof("Config:EnablePolicies    %t", EnablePolicies)
 	log.Infof("Config:IpsecAddressCount %d", IpsecAddressCount)
+	log.Infof("Config:IPSecIkev2Auth    %s", IPSecIkev2Auth)
+	log.Infof("Config:IPSecIkev2Transforms %s", IPSecIkev2Transforms.String())
+	log.Infof("Config:EncryptionMode    %s", EncryptionMode)
 	log.Infof("Config:RxMode            %d", TapRxMode)
 	log.Infof("Config:LogLevel          %d", LogLevel)
 	log.Infof("Config:HostMtu           %d", HostMtu)
 	log.Infof("Config:IpsecNbAsyncCryptoThread  %d", IpsecNbAsyncCryptoThread)
 	log.Infof("Config:EnableSRv6        %t", EnableSRv6)
+	log.Infof("Config:EnableTenantVRFs  %t", EnableTenantVRFs)
+	log.Infof("Config:TenantLeakedPrefixes %v", TenantLeakedPrefixes)
+	log.Infof("Config:TenantLeakedServices %v", TenantLeakedServices)
+	log.Infof("Config:EnableEgressIPs   %t", EnableEgressIPs)
+	log.Infof("Config:EnableBFD         %t", EnableBFD)
+	log.Infof("Config:BFDInterval       %s", BFDInterval)
+	log.Infof("Config:BFDMultiplier     %d", BFDMultiplier)
+	log.Infof("Config:DefaultEncapsulation %s", DefaultEncapsulation)
+	log.Infof("Config:WireguardKeyRotation %s", WireguardKeyRotation)
+	log.Infof("Config:ConnectivityPolicy %d rules", len(ConnectivityPolicy))
+	log.Infof("Config:ProvidersConfig %d providers", len(ProvidersConfig))
+	log.Infof("Config:EnableUplinkECMP  %t", EnableUplinkECMP)
+	log.Infof("Config:UplinkFlowHash    %s", UplinkFlowHash)
+	log.Infof("Config:EnableTunnelMSSClamp %t", EnableTunnelMSSClamp)
+	log.Infof("Config:EnableProbes      %t", EnableProbes)
+	log.Infof("Config:ProbeInterval     %s", ProbeInterval)
+	log.Infof("Config:ProbePort         %d", ProbePort)
+}
+
+/**
+ * ConnectivityRule picks the connectivity provider towards the nodes it
+ * matches. CALICOVPP_CONNECTIVITY_POLICY is a JSON list of rules, the first
+ * matching one whose provider is usable wins, e.g.
+ * [{"differentLabels": ["topology.kubernetes.io/zone"], "provider": "wireguard"},
+ *  {"sameLabels": ["rack"], "provider": "vxlan", "parameters": {"vni": "5000"}},
+ *  {"sameLabels": ["rack"], "provider": "flat"}]
+ * All the criteria given in a rule must match, omitted ones match anything.
+ * The parameters of the matching rule take precedence over the IPPool
+ * annotations for the provider.
+ */
+type ConnectivityRule struct {
+	/* Calico selector on the labels of the destination node */
+	NodeSelector string `json:"nodeSelector"`
+	/* Labels the destination node must have with the same value as ours */
+	SameLabels []string `json:"sameLabels"`
+	/* Labels of which at least one must differ between the destination node and ours */
+	DifferentLabels []string `json:"differentLabels"`
+	/* CIDRs containing the next hop (the destination node address) */
+	Subnets []string `json:"subnets"`
+	/* Names of the IPPools containing the destination prefix */
+	Pools    []string `json:"pools"`
+	Provider string   `json:"provider"`
+	/* Provider settings for the connectivity the rule matches, e.g. {"vni": "5000"} for vxlan */
+	Parameters map[string]string `json:"parameters"`
+
+	Selector   selector.Selector `json:"-"`
+	SubnetNets []*net.IPNet      `json:"-"`
+}
+
+func (r *ConnectivityRule) parse() (err error) {
+	if r.Provider == "" {
+		return fmt.Errorf("missing provider")
+	}
+	if r.NodeSelector != "" {
+		r.Selector, err = selector.Parse(r.NodeSelector)
+		if err != nil {
+			return errors.Wrapf(err, "invalid nodeSelector %s", r.NodeSelector)
+		}
+	}
+	for _, subnet := range r.Subnets {
+		_, ipNet, err := net.ParseCIDR(subnet)
+		if err != nil {
+			return errors.Wrapf(err, "invalid subnet %s", subnet)
+		}
+		r.SubnetNets = append(r.SubnetNets, ipNet)
+	}
+	return nil
+}
+
+/**
+ * IPsecTransforms is the crypto used by the IKEv2 profiles. VPP proposes a
+ * single suite for the IKE SA and one for the child SAs, e.g.
+ * {"ike": {"encryption": "aes-gcm-16", "keySize": 256, "dhGroup": "ecp-256"},
+ *  "esp": {"encryption": "aes-cbc", "keySize": 256, "integrity": "sha2-256-128"},
+ *  "lifetime": "1h", "lifetimeJitter": "5m", "handover": "1m"}
+ * Suites not given keep the defaults, lifetimes not given the VPP ones.
+ */
+type IPsecTransformSuite struct {
+	Encryption string `json:"encryption"`
+	KeySize    uint32 `json:"keySize"`
+	Integrity  string `json:"integrity"`
+	DHGroup    string `json:"dhGroup"`
+}
+
+type IPsecTransforms struct {
+	IKE *IPsecTransformSuite `json:"ike"`
+	ESP *IPsecTransformSuite `json:"esp"`
+	/* Child SAs are rekeyed after lifetime plus a random jitter up to lifetimeJitter */
+	Lifetime       string `json:"lifetime"`
+	LifetimeJitter string `json:"lifetimeJitter"`
+	/* How long the old SAs are kept after a rekey */
+	Handover string `json:"handover"`
+	/* Also rekey after this many bytes, if not zero */
+	LifetimeMaxData uint64 `json:"lifetimeMaxData"`
+
+	LifetimeDuration       time.Duration `json:"-"`
+	LifetimeJitterDuration time.Duration `json:"-"`
+	HandoverDuration       time.Duration `json:"-"`
+}
+
+func parseDuration(name string, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid %s %s", name, value)
+	}
+	if duration < time.Second {
+		return 0, fmt.Errorf("invalid %s %s, expected at least 1s", name, value)
+	}
+	return duration, nil
+}
+
+/* The suites are checked by the connectivity package, which knows what VPP supports */
+func (t *IPsecTransforms) parse() (err error) {
+	t.LifetimeDuration, err = parseDuration("lifetime", t.Lifetime)
+	if err != nil {
+		return err
+	}
+	t.LifetimeJitterDuration, err = parseDuration("lifetimeJitter", t.LifetimeJitter)
+	if err != nil {
+		return err
+	}
+	t.HandoverDuration, err = parseDuration("handover", t.Handover)
+	if err != nil {
+		return err
+	}
+	if !t.HasLifetime() {
+		return nil
+	}
+	if t.LifetimeDuration == 0 {
+		return fmt.Errorf("lifetime is required with lifetimeJitter, handover or lifetimeMaxData")
+	}
+	if t.HandoverDuration >= t.LifetimeDuration {
+		return fmt.Errorf("handover %s should be shorter than lifetime %s", t.HandoverDuration, t.LifetimeDuration)
+	}
+	return nil
+}
+
+/* HasLifetime tells whether the SA lifetime is configured, otherwise VPP defaults apply */
+func (t *IPsecTransforms) HasLifetime() bool {
+	return t.LifetimeDuration > 0 || t.LifetimeJitterDuration > 0 || t.HandoverDuration > 0 || t.LifetimeMaxData > 0
+}
+
+func (t *IPsecTransforms) String() string {
+	str := "ike=default"
+	if t.IKE != nil {
+		str = fmt.Sprintf("ike=%+v", *t.IKE)
+	}
+	if t.ESP != nil {
+		str += fmt.Sprintf(" esp=%+v", *t.ESP)
+	} else {
+		str += " esp=default"
+	}
+	if t.HasLifetime() {
+		str += fmt.Sprintf(" lifetime=%s jitter=%s handover=%s maxData=%d", t.LifetimeDuration, t.LifetimeJitterDuration, t.HandoverDuration, t.LifetimeMaxData)
+	}
+	return str
 }
 
 var supportedEnvVars map[string]bool
@@ -300,9 +537,206 @@ func LoadConfig(log *logrus.Logger) (err error) {
 		SRv6localSidIPPool = conf
 	}
 
+	if conf := getEnvValue(EnableTenantVRFsEnvVar); conf != "" {
+		enableTenantVRFs, err := strconv.ParseBool(conf)
+		if err != nil {
+			return fmt.Errorf("Invalid %s configuration: %s parses to %v err %v", EnableTenantVRFsEnvVar, conf, enableTenantVRFs, err)
+		}
+		EnableTenantVRFs = enableTenantVRFs
+	}
+
+	if conf := getEnvValue(TenantLeakedPrefixesEnvVar); conf != "" {
+		for _, prefixStr := range strings.Split(conf, ",") {
+			_, prefix, err := net.ParseCIDR(strings.TrimSpace(prefixStr))
+			if err != nil {
+				return errors.Errorf("invalid %s configuration: %s %s", TenantLeakedPrefixesEnvVar, prefixStr, err)
+			}
+			TenantLeakedPrefixes = append(TenantLeakedPrefixes, prefix)
+		}
+	}
+
+	/* An empty value leaks no service */
+	if _, found := os.LookupEnv(TenantLeakedServicesEnvVar); found {
+		TenantLeakedServices = make([]string, 0)
+		for _, service := range strings.Split(getEnvValue(TenantLeakedServicesEnvVar), ",") {
+			service = strings.TrimSpace(service)
+			if service == "" {
+				continue
+			}
+			if len(strings.Split(service, "/")) != 2 {
+				return errors.Errorf("invalid %s configuration: %s should be namespace/name", TenantLeakedServicesEnvVar, service)
+			}
+			TenantLeakedServices = append(TenantLeakedServices, service)
+		}
+	}
+
+	if conf := getEnvValue(EnableEgressIPsEnvVar); conf != "" {
+		enableEgressIPs, err := strconv.ParseBool(conf)
+		if err != nil {
+			return fmt.Errorf("Invalid %s configuration: %s parses to %v err %v", EnableEgressIPsEnvVar, conf, enableEgressIPs, err)
+		}
+		EnableEgressIPs = enableEgressIPs
+	}
+
+	if conf := getEnvValue(EnableBFDEnvVar); conf != "" {
+		enableBFD, err := strconv.ParseBool(conf)
+		if err != nil {
+			return fmt.Errorf("Invalid %s configuration: %s parses to %v err %v", EnableBFDEnvVar, conf, enableBFD, err)
+		}
+		EnableBFD = enableBFD
+	}
+
+	if conf := getEnvValue(BFDIntervalEnvVar); conf != "" {
+		bfdInterval, err := time.ParseDuration(conf)
+		if err != nil || bfdInterval < time.Millisecond {
+			return fmt.Errorf("Invalid %s configuration: %s parses to %v err %v", BFDIntervalEnvVar, conf, bfdInterval, err)
+		}
+		BFDInterval = bfdInterval
+	}
+
+	if conf := getEnvValue(BFDMultiplierEnvVar); conf != "" {
+		bfdMultiplier, err := strconv.ParseUint(conf, 10, 8)
+		if err != nil || bfdMultiplier == 0 {
+			return fmt.Errorf("Invalid %s configuration: %s parses to %v err %v", BFDMultiplierEnvVar, conf, bfdMultiplier, err)
+		}
+		BFDMultiplier = int(bfdMultiplier)
+	}
+
+	if conf := getEnvValue(WireguardKeyRotationEnvVar); conf != "" {
+		rotationInterval, err := time.ParseDuration(conf)
+		if err != nil || rotationInterval < 0 {
+			return fmt.Errorf("Invalid %s configuration: %s parses to %v err %v", WireguardKeyRotationEnvVar, conf, rotationInterval, err)
+		}
+		WireguardKeyRotation = rotationInterval
+	}
+
+	if conf := getEnvValue(ConnectivityPolicyEnvVar); conf != "" {
+		err = json.Unmarshal([]byte(conf), &ConnectivityPolicy)
+		if err != nil {
+			return fmt.Errorf("Invalid %s configuration: %s err %v", ConnectivityPolicyEnvVar, conf, err)
+		}
+		for i := range ConnectivityPolicy {
+			err = ConnectivityPolicy[i].parse()
+			if err != nil {
+				return fmt.Errorf("Invalid %s configuration: rule %d %v", ConnectivityPolicyEnvVar, i, err)
+			}
+		}
+	}
+
+	if conf := getEnvValue(UplinkECMPEnvVar); conf != "" {
+		enableUplinkECMP, err := strconv.ParseBool(conf)
+		if err != nil {
+			return fmt.Errorf("Invalid %s configuration: %s parses to %v err %v", UplinkECMPEnvVar, conf, enableUplinkECMP, err)
+		}
+		EnableUplinkECMP = enableUplinkECMP
+	}
+
+	if conf := getEnvValue(UplinkFlowHashEnvVar); conf != "" {
+		uplinkFlowHash, err := types.ParseIPFlowHash(conf)
+		if err != nil {
+			return fmt.Errorf("Invalid %s configuration: %s err %v", UplinkFlowHashEnvVar, conf, err)
+		}
+		UplinkFlowHash = uplinkFlowHash
+	}
+
+	if conf := getEnvValue(TunnelMSSClampEnvVar); conf != "" {
+		enableTunnelMSSClamp, err := strconv.ParseBool(conf)
+		if err != nil {
+			return fmt.Errorf("Invalid %s configuration: %s parses to %v err %v", TunnelMSSClampEnvVar, conf, enableTunnelMSSClamp, err)
+		}
+		EnableTunnelMSSClamp = enableTunnelMSSClamp
+	}
+
+	if conf := getEnvValue(EnableProbesEnvVar); conf != "" {
+		enableProbes, err := strconv.ParseBool(conf)
+		if err != nil {
+			return fmt.Errorf("Invalid %s configuration: %s parses to %v err %v", EnableProbesEnvVar, conf, enableProbes, err)
+		}
+		EnableProbes = enableProbes
+	}
+
+	if conf := getEnvValue(ProbeIntervalEnvVar); conf != "" {
+		probeInterval, err := time.ParseDuration(conf)
+		if err != nil || probeInterval < 100*time.Millisecond {
+			return fmt.Errorf("Invalid %s configuration: %s parses to %v err %v", ProbeIntervalEnvVar, conf, probeInterval, err)
+		}
+		ProbeInterval = probeInterval
+	}
+
+	if conf := getEnvValue(ProbePortEnvVar); conf != "" {
+		probePort, err := strconv.ParseUint(conf, 10, 16)
+		if err != nil || probePort == 0 {
+			return fmt.Errorf("Invalid %s configuration: %s parses to %v err %v", ProbePortEnvVar, conf, probePort, err)
+		}
+		ProbePort = int(probePort)
+	}
+
+	if conf := getEnvValue(ProvidersConfigEnvVar); conf != "" {
+		err = json.Unmarshal([]byte(conf), &ProvidersConfig)
+		if err != nil {
+			return fmt.Errorf("Invalid %s configuration: %s err %v", ProvidersConfigEnvVar, conf, err)
+		}
+	}
+
+	/* Registered providers are known once the agent starts, they are checked then */
+	DefaultEncapsulation = getEnvValue(DefaultEncapEnvVar)
+
+	switch conf := getEnvValue(IPSecIkev2AuthEnvVar); conf {
+	case "":
+	case IPSecIkev2AuthPSK, IPSecIkev2AuthCert:
+		IPSecIkev2Auth = conf
+	default:
+		return fmt.Errorf("Invalid %s configuration: %s, expected %s or %s", IPSecIkev2AuthEnvVar, conf, IPSecIkev2AuthPSK, IPSecIkev2AuthCert)
+	}
+
+	switch conf := getEnvValue(EncryptionModeEnvVar); conf {
+	case "":
+	case EncryptionModeAlways, EncryptionModeCrossSubnet:
+		EncryptionMode = conf
+	default:
+		return fmt.Errorf("Invalid %s configuration: %s, expected %s or %s", EncryptionModeEnvVar, conf, EncryptionModeAlways, EncryptionModeCrossSubnet)
+	}
+
+	if conf := getEnvValue(IPSecIkev2CertDirEnvVar); conf != "" {
+		IPSecIkev2CertDir = conf
+	}
+
+	/* Our private key stays on the node, by default next to the certificates */
+	IPSecIkev2KeyDir = IPSecIkev2CertDir
+	if conf := getEnvValue(IPSecIkev2KeyDirEnvVar); conf != "" {
+		IPSecIkev2KeyDir = conf
+	}
+
+	IPSecIkev2CAFile = getEnvValue(IPSecIkev2CAFileEnvVar)
+
+	if conf := getEnvValue(IPSecIkev2CertSecretEnvVar); conf != "" {
+		if len(strings.Split(conf, "/")) != 2 {
+			return fmt.Errorf("Invalid %s configuration: %s, expected namespace/name", IPSecIkev2CertSecretEnvVar, conf)
+		}
+		IPSecIkev2CertSecret = conf
+	}
+
+	if conf := getEnvValue(IPSecIkev2PskSecretEnvVar); conf != "" {
+		if len(strings.Split(conf, "/")) != 2 {
+			return fmt.Errorf("Invalid %s configuration: %s, expected namespace/name", IPSecIkev2PskSecretEnvVar, conf)
+		}
+		IPSecIkev2PskSecret = conf
+	}
+
+	if conf := getEnvValue(IPSecIkev2TransformsEnvVar); conf != "" {
+		err = json.Unmarshal([]byte(conf), &IPSecIkev2Transforms)
+		if err != nil {
+			return fmt.Errorf("Invalid %s configuration: %s err %v", IPSecIkev2TransformsEnvVar, conf, err)
+		}
+		err = IPSecIkev2Transforms.parse()
+		if err != nil {
+			return fmt.Errorf("Invalid %s configuration: %v", IPSecIkev2TransformsEnvVar, err)
+		}
+	}
+
 	psk := getEnvValue(IPSecIkev2PskEnvVar)
-	if EnableIPSec && psk == "" {
-		return errors.New("IKEv2 PSK not configured: nothing found in CALICOVPP_IPSEC_IKEV2_PSK environment variable")
+	if EnableIPSec && IPSecIkev2Auth == IPSecIkev2AuthPSK && psk == "" && IPSecIkev2PskSecret == "" {
+		return errors.New("IKEv2 PSK not configured: nothing found in CALICOVPP_IPSEC_IKEV2_PSK nor CALICOVPP_IPSEC_IKEV2_PSK_SECRET environment variables")
 	}
 	IPSecIkev2Psk = psk
 
@@ -0,0 +1,362 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/common"
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/config"
+	"github.com/projectcalico/vpp-dataplane/vpplink"
+	"github.com/projectcalico/vpp-dataplane/vpplink/types"
+)
+
+/**
+ * When BFD is enabled, we run BFD sessions towards each remote node we
+ * have connectivity to, and towards each BGP peer. Sessions run inside the
+ * tunnels of the providers implementing TunnelProvider (one per tunnel,
+ * e.g. per uplink with ECMP), where the remote node is a single hop away,
+ * and on the uplink otherwise (flat routing, on-link BGP peers). BGP peers
+ * outside of our uplink subnet get a multihop session, not bound to an
+ * interface.
+ *
+ * VPP notifies us of the state changes (want_bfd_events). When a session
+ * that was up goes down:
+ * - on the uplink, the routes through the peer are withdrawn until the
+ *   session comes back up.
+ * - in a tunnel, VPP stops forwarding through the tunnel adjacency until
+ *   the session comes back. We keep the routes, withdrawing them would
+ *   delete the tunnel and the session with it.
+ * - the BGP session to the peer is reset instead of waiting for the BGP
+ *   hold timer, BGP then reconnects on its own.
+ *
+ * Sessions that never came up (e.g. the peer does not run BFD) are ignored.
+ * Sessions left by a previous run of the agent are adopted, and deleted if
+ * still unused after bfdRescanGracePeriod.
+ */
+const (
+	bfdBGPPeerRef = "bgp"
+
+	/**
+	 * Sessions adopted on rescan that are still unused once the BGP graceful
+	 * restart time elapsed, when the peers & paths of the nodes we still
+	 * have connectivity to were learnt again, are deleted
+	 */
+	bfdRescanGracePeriod = 120 * time.Second
+)
+
+type bfdSession struct {
+	session *types.BFDSession
+	/* connectivities & BGP peer using this session */
+	refs map[string]bool
+	/* the session went down after being up */
+	isDown bool
+}
+
+func bfdConnectivityRef(cn *common.NodeConnectivity) string {
+	return "cn-" + cn.String()
+}
+
+func bfdSessionKey(swIfIndex uint32, addr net.IP) string {
+	return fmt.Sprintf("%d-%s", swIfIndex, addr.String())
+}
+
+/**
+ * bfdAggregateState returns the best state among sessions, which are
+ * considered down only when none is up and one went down after being up.
+ */
+func bfdAggregateState(sessions []*bfdSession) (state types.BFDState, isDown bool) {
+	state = types.BFDStateAdminDown
+	for _, bs := range sessions {
+		if bs.session.State > state {
+			state = bs.session.State
+		}
+		if bs.isDown {
+			isDown = true
+		}
+	}
+	return state, isDown && state != types.BFDStateUp
+}
+
+func (s *ConnectivityServer) newBFDSession(swIfIndex uint32, addr net.IP) *types.BFDSession {
+	ip4, ip6 := s.GetNodeIPs()
+	session := &types.BFDSession{
+		SwIfIndex:     swIfIndex,
+		PeerAddr:      addr,
+		DesiredMinTx:  uint32(config.BFDInterval / time.Microsecond),
+		RequiredMinRx: uint32(config.BFDInterval / time.Microsecond),
+		DetectMult:    uint8(config.BFDMultiplier),
+		State:         types.BFDStateDown,
+	}
+	if vpplink.IsIP6(addr) && ip6 != nil {
+		session.LocalAddr = *ip6
+	} else if !vpplink.IsIP6(addr) && ip4 != nil {
+		session.LocalAddr = *ip4
+	} else {
+		return nil
+	}
+	return session
+}
+
+/* Adopt the sessions left in VPP by a previous instance of the agent */
+func (s *ConnectivityServer) rescanBFDSessions() {
+	if !config.EnableBFD {
+		return
+	}
+	s.bfdSessions = make(map[string]*bfdSession)
+	sessions, err := s.vpp.ListBFDSessions()
+	if err != nil {
+		s.log.Errorf("Error listing BFD sessions: %v", err)
+		return
+	}
+	for _, session := range sessions {
+		s.log.Infof("Found existing BFD session %s", session.String())
+		s.bfdSessions[bfdSessionKey(session.SwIfIndex, session.PeerAddr)] = &bfdSession{
+			session: session,
+			refs:    make(map[string]bool),
+		}
+	}
+}
+
+/* Keys of the sessions no connectivity nor BGP peer uses */
+func unusedBFDSessions(sessions map[string]*bfdSession) []string {
+	keys := make([]string, 0)
+	for key, bs := range sessions {
+		if len(bs.refs) == 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+/* gcBFDSessions deletes the adopted sessions nothing claimed after the rescan, it expects s.lock to be held */
+func (s *ConnectivityServer) gcBFDSessions() {
+	for _, key := range unusedBFDSessions(s.bfdSessions) {
+		bs := s.bfdSessions[key]
+		s.log.Infof("bfd(del) unused session %s", bs.session.String())
+		err := s.vpp.DelBFDSession(bs.session)
+		if err != nil {
+			s.log.Warnf("Error deleting BFD session %s: %v", bs.session.String(), err)
+		}
+		delete(s.bfdSessions, key)
+	}
+}
+
+/* Interface on which to run BFD with a BGP peer, InvalidID for a multihop session */
+func (s *ConnectivityServer) bfdBGPPeerInterface(addr net.IP) uint32 {
+	ipNet := s.GetNodeIPNet(vpplink.IsIP6(addr))
+	if ipNet != nil && ipNet.Contains(addr) {
+		return config.DataInterfaceSwIfIndex
+	}
+	return vpplink.InvalidID
+}
+
+func (s *ConnectivityServer) bfdAddBGPPeer(addr net.IP) {
+	if !config.EnableBFD || addr == nil {
+		return
+	}
+	s.bfdAddRef(s.bfdBGPPeerInterface(addr), addr, bfdBGPPeerRef)
+}
+
+/* Our uplink subnet might have changed since the session was added, so look it up by address */
+func (s *ConnectivityServer) bfdDelBGPPeer(addr net.IP) {
+	if !config.EnableBFD || addr == nil {
+		return
+	}
+	for _, bs := range s.bfdSessions {
+		if bs.refs[bfdBGPPeerRef] && bs.session.PeerAddr.Equal(addr) {
+			s.bfdDelRef(bs.session.SwIfIndex, bs.session.PeerAddr, bfdBGPPeerRef)
+		}
+	}
+}
+
+/* Interfaces on which to run BFD with the next hop of cn */
+func (s *ConnectivityServer) bfdInterfaces(providerType string, cn *common.NodeConnectivity) []uint32 {
+	if tunnelProvider, ok := s.providers[providerType].(TunnelProvider); ok {
+		return tunnelProvider.GetTunnelSwIfIndexes(cn.NextHop)
+	}
+	return []uint32{config.DataInterfaceSwIfIndex}
+}
+
+/* bfdSyncConnectivity moves the BFD references of cn to the interfaces it currently uses */
+func (s *ConnectivityServer) bfdSyncConnectivity(providerType string, cn *common.NodeConnectivity) {
+	if !config.EnableBFD {
+		return
+	}
+	ref := bfdConnectivityRef(cn)
+	inUse := make(map[string]bool)
+	for _, swIfIndex := range s.bfdInterfaces(providerType, cn) {
+		if swIfIndex == vpplink.InvalidID {
+			continue
+		}
+		inUse[bfdSessionKey(swIfIndex, cn.NextHop)] = true
+		s.bfdAddRef(swIfIndex, cn.NextHop, ref)
+	}
+	for key, bs := range s.bfdSessions {
+		if bs.refs[ref] && !inUse[key] {
+			s.bfdDelRef(bs.session.SwIfIndex, bs.session.PeerAddr, ref)
+		}
+	}
+}
+
+func (s *ConnectivityServer) bfdDelConnectivity(cn *common.NodeConnectivity) {
+	if !config.EnableBFD {
+		return
+	}
+	ref := bfdConnectivityRef(cn)
+	for _, bs := range s.bfdSessions {
+		if bs.refs[ref] {
+			s.bfdDelRef(bs.session.SwIfIndex, bs.session.PeerAddr, ref)
+		}
+	}
+}
+
+func (s *ConnectivityServer) bfdAddRef(swIfIndex uint32, addr net.IP, ref string) {
+	if !config.EnableBFD || addr == nil {
+		return
+	}
+	key := bfdSessionKey(swIfIndex, addr)
+	bs, found := s.bfdSessions[key]
+	if !found {
+		session := s.newBFDSession(swIfIndex, addr)
+		if session == nil {
+			s.log.Warnf("No local address to run BFD with %s", addr)
+			return
+		}
+		s.log.Infof("bfd(add) session %s", session.String())
+		err := s.vpp.AddBFDSession(session)
+		if err != nil {
+			s.log.Errorf("Error adding BFD session %s: %v", session.String(), err)
+			return
+		}
+		bs = &bfdSession{
+			session: session,
+			refs:    make(map[string]bool),
+		}
+		s.bfdSessions[key] = bs
+	}
+	bs.refs[ref] = true
+}
+
+func (s *ConnectivityServer) bfdDelRef(swIfIndex uint32, addr net.IP, ref string) {
+	if !config.EnableBFD || addr == nil {
+		return
+	}
+	key := bfdSessionKey(swIfIndex, addr)
+	bs, found := s.bfdSessions[key]
+	if !found {
+		return
+	}
+	delete(bs.refs, ref)
+	if len(bs.refs) > 0 {
+		return
+	}
+	s.log.Infof("bfd(del) session %s", bs.session.String())
+	err := s.vpp.DelBFDSession(bs.session)
+	if err != nil {
+		/* VPP removes the sessions of the tunnels it deletes */
+		s.log.Warnf("Error deleting BFD session %s: %v", bs.session.String(), err)
+	}
+	delete(s.bfdSessions, key)
+}
+
+/* Sessions used by cn */
+func (s *ConnectivityServer) bfdConnectivitySessions(cn *common.NodeConnectivity) []*bfdSession {
+	ref := bfdConnectivityRef(cn)
+	sessions := make([]*bfdSession, 0)
+	for _, bs := range s.bfdSessions {
+		if bs.refs[ref] {
+			sessions = append(sessions, bs)
+		}
+	}
+	return sessions
+}
+
+/* Routes of cn are withdrawn while the uplink session it uses is down */
+func (s *ConnectivityServer) isBFDDown(cn *common.NodeConnectivity) bool {
+	bs, found := s.bfdSessions[bfdSessionKey(config.DataInterfaceSwIfIndex, cn.NextHop)]
+	return found && bs.isDown && bs.refs[bfdConnectivityRef(cn)]
+}
+
+func (s *ConnectivityServer) getBFDState(cn *common.NodeConnectivity) (state types.BFDState, isDown bool, found bool) {
+	sessions := s.bfdConnectivitySessions(cn)
+	if len(sessions) == 0 {
+		return types.BFDStateDown, false, false
+	}
+	state, isDown = bfdAggregateState(sessions)
+	return state, isDown, true
+}
+
+/* handleBFDEvent reacts on session state changes, it expects s.lock to be held */
+func (s *ConnectivityServer) handleBFDEvent(session *types.BFDSession) {
+	bs, found := s.bfdSessions[bfdSessionKey(session.SwIfIndex, session.PeerAddr)]
+	if !found {
+		return
+	}
+	oldState := bs.session.State
+	bs.session.State = session.State
+	if oldState == types.BFDStateUp && session.State != types.BFDStateUp {
+		s.log.Warnf("bfd(down) session %s", session.String())
+		bs.isDown = true
+		s.bfdSessionDown(bs)
+	} else if bs.isDown && session.State == types.BFDStateUp {
+		s.log.Infof("bfd(up) session %s", session.String())
+		bs.isDown = false
+		s.bfdSessionUp(bs)
+	}
+}
+
+func (s *ConnectivityServer) bfdSessionDown(bs *bfdSession) {
+	addr := bs.session.PeerAddr
+	if bs.session.SwIfIndex == config.DataInterfaceSwIfIndex {
+		for _, cn := range s.connectivityMap {
+			if !bs.refs[bfdConnectivityRef(&cn)] {
+				continue
+			}
+			err := s.providers[cn.ResolvedProvider].DelConnectivity(&cn)
+			if err != nil {
+				s.log.Errorf("Error withdrawing connectivity %s after BFD down: %v", cn.String(), err)
+			}
+		}
+	}
+	if bs.refs[bfdBGPPeerRef] {
+		common.SendEvent(common.CalicoVppEvent{
+			Type: common.BFDSessionDown,
+			New:  addr.String(),
+		})
+	}
+	common.NodeWarningEvent(common.EventReasonConnectivityFailed,
+		"BFD session to %s on interface %d went down", addr, bs.session.SwIfIndex)
+}
+
+func (s *ConnectivityServer) bfdSessionUp(bs *bfdSession) {
+	if bs.session.SwIfIndex == config.DataInterfaceSwIfIndex {
+		for _, cn := range s.connectivityMap {
+			if !bs.refs[bfdConnectivityRef(&cn)] {
+				continue
+			}
+			err := s.providers[cn.ResolvedProvider].AddConnectivity(&cn)
+			s.setLastError(&cn, err)
+			if err != nil {
+				s.log.Errorf("Error restoring connectivity %s after BFD up: %v", cn.String(), err)
+			}
+		}
+	}
+}
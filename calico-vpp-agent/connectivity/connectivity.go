@@ -34,6 +34,23 @@ const (
 	IPIP      = "ipip"
 	WIREGUARD = "wireguard"
 	SRv6      = "srv6"
+	GENEVE    = "geneve"
+	GRE       = "gre"
+)
+
+/**
+ * IPPools annotated with EncapAnnotation: geneve (or gre, or the name of a
+ * registered provider, see RegisterProvider) use this tunnel
+ * instead of the VXLAN/IPIP ones, only for cross subnet traffic if the pool's
+ * VXLANMode or IPIPMode is CrossSubnet, always otherwise. Pools using
+ * VXLAN or IPIP without the annotation use config.DefaultEncapsulation when
+ * set. The pool encapsulation wins over encryption, IPsec & Wireguard only
+ * replace IPIP.
+ */
+const (
+	EncapAnnotation = "cni.projectcalico.org/vpp.encapsulation"
+	EncapGeneve     = "geneve"
+	EncapGRE        = "gre"
 )
 
 type ConnectivityProviderData struct {
@@ -52,8 +69,52 @@ type ConnectivityProvider interface {
 	/* is it enabled in the config ? */
 	Enabled(cn *common.NodeConnectivity) bool
 	EnableDisable(isEnable bool) ()
+	/* Provider specific state of the connectivity towards nextHop */
+	GetStatus(nextHop net.IP) common.NodeConnectivityStatus
+	/* Bytes added to packets sent with this connectivity */
+	EncapOverhead(cn *common.NodeConnectivity) int
+}
+
+/* IP & TCP headers, without options, the MSS excludes */
+const (
+	ip4TCPHeadersSize = 20 + 20
+	ip6TCPHeadersSize = 40 + 20
+)
+
+/* Size of the outer IP header used to reach nextHop */
+func outerIPHeaderSize(nextHop net.IP) int {
+	if vpplink.IsIP6(nextHop) {
+		return 40
+	}
+	return 20
+}
+
+/**
+ * Providers whose GetStatus needs to dump VPP state implement this, so that
+ * the dump happens once per status collection instead of once per peer.
+ */
+type StatusSnapshotter interface {
+	SnapshotStatus()
+}
+
+/**
+ * Providers reaching nextHop through point to point tunnels implement this,
+ * BFD then runs inside the tunnels where nextHop is a single hop away.
+ */
+type TunnelProvider interface {
+	/* Tunnel interfaces currently used towards nextHop */
+	GetTunnelSwIfIndexes(nextHop net.IP) []uint32
 }
 
+func (p *ConnectivityProviderData) Vpp() *vpplink.VppLink {
+	return p.vpp
+}
+func (p *ConnectivityProviderData) Log() *logrus.Entry {
+	return p.log
+}
+func (p *ConnectivityProviderData) GetNodeIPNet(isv6 bool) *net.IPNet {
+	return p.server.GetNodeIPNet(isv6)
+}
 func (p *ConnectivityProviderData) GetNodeByIp(addr net.IP) *oldv3.Node {
 	return p.server.GetNodeByIp(addr)
 }
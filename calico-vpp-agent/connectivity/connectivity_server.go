@@ -18,7 +18,13 @@ package connectivity
 import (
 	"fmt"
 	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
+	bgpapi "github.com/osrg/gobgp/api"
 	"github.com/pkg/errors"
 	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
 	felixConfig "github.com/projectcalico/calico/felix/config"
@@ -26,11 +32,17 @@ import (
 	calicov3cli "github.com/projectcalico/calico/libcalico-go/lib/clientv3"
 	"github.com/sirupsen/logrus"
 	tomb "gopkg.in/tomb.v2"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 
 	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/common"
 	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/config"
 	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/watchers"
 	"github.com/projectcalico/vpp-dataplane/vpplink"
+	"github.com/projectcalico/vpp-dataplane/vpplink/types"
 )
 
 type ConnectivityServer struct {
@@ -40,12 +52,51 @@ type ConnectivityServer struct {
 	connectivityMap map[string]common.NodeConnectivity
 	ipam            watchers.IpamCache
 	Clientv3        calicov3cli.Interface
+	k8sclient       *kubernetes.Clientset
 	nodeBGPSpec     *oldv3.NodeBGPSpec
 	vpp             *vpplink.VppLink
 
 	felixConfig *felixConfig.Config
 	nodeByAddr  map[string]oldv3.Node
 
+	/* Kubernetes nodes cache, for the annotations peers publish */
+	nodeLister  listersv1.NodeLister
+	nodesSynced cache.InformerSynced
+	/* signaled when a peer publishes new IPsec PSK IDs */
+	peerPSKsChanged chan struct{}
+	/* signaled when a peer publishes new uplink addresses */
+	peerUplinksChanged chan struct{}
+	/* signaled when the grace period of a replaced wireguard tunnel ends */
+	wireguardRetire chan struct{}
+
+	/* remote members of the tenant VRFs, by tenant name */
+	tenants map[string]*tenantRoutes
+
+	/* protects the providers state against status queries */
+	lock       sync.Mutex
+	lastErrors map[string]string
+
+	bfdSessions  map[string]*bfdSession
+	bfdEventChan chan *types.BFDSession
+
+	uplinks         []uplink
+	peerUplinks     map[string][]net.IP
+	uplinkEventChan chan *types.InterfaceEvent
+
+	probes         map[string]*peerProbe
+	probeConn      *net.UnixConn
+	probeVppSocket *net.UnixAddr
+	/* isIp6 of the families we registered a probe punt socket for */
+	probePuntFamilies []bool
+	probeSwIfIndex    uint32
+
+	/* worst encapsulation overhead of the connectivity configured or in use, -1 until computed */
+	encapOverhead int
+	/* MTU of the host routes to the peers pod prefixes, see mtu.go */
+	hostRouteMtus map[string]int
+	/* pool/encapsulation already reported as unknown */
+	unknownEncaps map[string]bool
+
 	connectivityEventChan chan common.CalicoVppEvent
 }
 
@@ -64,16 +115,49 @@ func (s *ConnectivityServer) SetFelixConfig(felixConfig *felixConfig.Config) {
 	s.felixConfig = felixConfig
 }
 
+/* ValidateConfig checks at startup the connectivity configuration that depends on what VPP supports */
+func ValidateConfig() error {
+	_, _, err := ParseIPsecTransforms(&config.IPSecIkev2Transforms)
+	if err != nil {
+		return errors.Wrapf(err, "invalid %s", config.IPSecIkev2TransformsEnvVar)
+	}
+	err = validateDefaultEncapsulation()
+	if err != nil {
+		return errors.Wrapf(err, "invalid %s", config.DefaultEncapEnvVar)
+	}
+	return nil
+}
+
 func NewConnectivityServer(vpp *vpplink.VppLink, ipam watchers.IpamCache,
-	clientv3 calicov3cli.Interface, log *logrus.Entry) *ConnectivityServer {
+	clientv3 calicov3cli.Interface, k8sclient *kubernetes.Clientset, informerFactory informers.SharedInformerFactory,
+	log *logrus.Entry) *ConnectivityServer {
 	server := ConnectivityServer{
 		log:                   log,
 		vpp:                   vpp,
 		ipam:                  ipam,
 		Clientv3:              clientv3,
+		k8sclient:             k8sclient,
 		connectivityMap:       make(map[string]common.NodeConnectivity),
 		connectivityEventChan: make(chan common.CalicoVppEvent, common.ChanSize),
 		nodeByAddr:            make(map[string]oldv3.Node),
+		tenants:               make(map[string]*tenantRoutes),
+		lastErrors:            make(map[string]string),
+		bfdSessions:           make(map[string]*bfdSession),
+		bfdEventChan:          make(chan *types.BFDSession, common.ChanSize),
+		peerUplinks:           make(map[string][]net.IP),
+		uplinkEventChan:       make(chan *types.InterfaceEvent, common.ChanSize),
+		peerPSKsChanged:       make(chan struct{}, 1),
+		peerUplinksChanged:    make(chan struct{}, 1),
+		wireguardRetire:       make(chan struct{}, 1),
+		probes:                make(map[string]*peerProbe),
+		encapOverhead:         -1,
+		hostRouteMtus:         make(map[string]int),
+	}
+	if (config.EnableIPSec && config.IPSecIkev2PskSecret != "") || config.EnableUplinkECMP {
+		server.initNodeInformer(informerFactory)
+	}
+	if config.EnableUplinkECMP {
+		log.Infof("Uplink ECMP only applies to the %s and %s connectivity, other providers use the main uplink", FLAT, IPIP)
 	}
 
 	reg := common.RegisterHandler(server.connectivityEventChan, "connectivity server events")
@@ -85,6 +169,12 @@ func NewConnectivityServer(vpp *vpplink.VppLink, ipam watchers.IpamCache,
 		common.IpamConfChanged,
 		common.SRv6PolicyAdded,
 		common.SRv6PolicyDeleted,
+		common.SRv6TEPoliciesChanged,
+		common.BGPPeerAdded,
+		common.BGPPeerDeleted,
+		common.IPsecPSKChanged,
+		common.TenantMembersChanged,
+		common.TenantDeleted,
 	)
 
 	nDataThreads := common.FetchNDataThreads(vpp, log)
@@ -97,10 +187,66 @@ func NewConnectivityServer(vpp *vpplink.VppLink, ipam watchers.IpamCache,
 	server.providers[VXLAN] = NewVXLanProvider(providerData)
 	server.providers[WIREGUARD] = NewWireguardProvider(providerData)
 	server.providers[SRv6] = NewSRv6Provider(providerData)
+	server.providers[GENEVE] = NewGeneveProvider(providerData)
+	server.providers[GRE] = NewGREProvider(providerData)
+	server.addRegisteredProviders(providerData)
+
+	server.checkConnectivityPolicy()
 
 	return &server
 }
 
+/* initNodeInformer caches the Kubernetes nodes instead of querying the API server on each peer update */
+func (s *ConnectivityServer) initNodeInformer(informerFactory informers.SharedInformerFactory) {
+	nodeInformer := informerFactory.Core().V1().Nodes()
+	nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { s.onNodeEvent(nil, obj) },
+		UpdateFunc: s.onNodeEvent,
+		DeleteFunc: func(obj interface{}) { s.onNodeEvent(obj, nil) },
+	})
+	s.nodeLister = nodeInformer.Lister()
+	s.nodesSynced = nodeInformer.Informer().HasSynced
+}
+
+/* onNodeEvent runs in the informer goroutine, it only signals the connectivity loop */
+func (s *ConnectivityServer) onNodeEvent(old interface{}, obj interface{}) {
+	oldNode, _ := old.(*v1.Node)
+	node, _ := obj.(*v1.Node)
+	if node == nil && oldNode == nil {
+		return
+	}
+	if (node != nil && node.Name == config.NodeName) || (oldNode != nil && oldNode.Name == config.NodeName) {
+		return
+	}
+	if node != nil && nodeAnnotationChanged(oldNode, node, IPsecPSKIDsAnnotation) {
+		signalLoop(s.peerPSKsChanged)
+	}
+	if nodeAnnotationChanged(oldNode, node, UplinksAnnotation) {
+		signalLoop(s.peerUplinksChanged)
+	}
+}
+
+func nodeAnnotationChanged(old *v1.Node, new *v1.Node, annotation string) bool {
+	if old == nil || new == nil {
+		return true
+	}
+	return old.Annotations[annotation] != new.Annotations[annotation]
+}
+
+/* signalLoop wakes up the connectivity loop, signals coalesce while it is busy */
+func signalLoop(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+		/* a reconciliation is already pending */
+	}
+}
+
+/* signalWireguardRetire runs in a timer goroutine, it only signals the connectivity loop */
+func (s *ConnectivityServer) signalWireguardRetire() {
+	signalLoop(s.wireguardRetire)
+}
+
 func isCrossSubnet(gw net.IP, subnet net.IPNet) bool {
 	return !subnet.Contains(gw)
 }
@@ -130,109 +276,386 @@ func (s *ConnectivityServer) GetNodeIPNet(isv6 bool) *net.IPNet {
 func (s *ConnectivityServer) updateAllIPConnectivity() {
 	for _, cn := range s.connectivityMap {
 		err := s.updateIPConnectivity(&cn, false /* isWithdraw */)
+		s.setLastError(&cn, err)
 		if err != nil {
 			s.log.Errorf("Error while re-updating connectivity %s", err)
 		}
 	}
+	s.syncAllTenantRoutes()
 }
 
 func (s *ConnectivityServer) ServeConnectivity(t *tomb.Tomb) error {
 	/**
 	 * There might be leftover state in VPP in case we restarted
 	 * so first check what is present */
+	if config.EnableBFD {
+		/* Subscribe before listing the sessions, not to miss changes */
+		stopBFDEvents, err := s.vpp.WatchBFDSessions(s.bfdEventChan)
+		if err != nil {
+			s.log.Errorf("Error watching BFD sessions, their state will not be tracked: %v", err)
+		} else {
+			defer stopBFDEvents()
+		}
+	}
+	if config.EnableUplinkECMP {
+		/* Subscribe before listing the uplinks, not to miss changes */
+		stopUplinkEvents, err := s.vpp.WatchInterfaceEvents(s.uplinkEventChan)
+		if err != nil {
+			s.log.Errorf("Error watching interface events, uplink states will not be tracked: %v", err)
+		} else {
+			defer stopUplinkEvents()
+		}
+	}
+	if s.nodesSynced != nil && !cache.WaitForCacheSync(t.Dying(), s.nodesSynced) {
+		return errors.Errorf("node informer did not sync")
+	}
+	s.lock.Lock()
+	if config.EnableUplinkECMP {
+		s.setUplinkFlowHash()
+		s.refreshUplinks()
+		s.refreshPeerUplinks()
+	}
 	for _, provider := range s.providers {
 		provider.RescanState()
 	}
+	s.rescanBFDSessions()
+	s.updateEncapOverhead()
+	s.lock.Unlock()
+
+	var bfdGCTimer <-chan time.Time
+	if config.EnableBFD {
+		bfdGCTimer = time.After(bfdRescanGracePeriod)
+	}
+	var ipsecSATick <-chan time.Time
+	if config.EnableIPSec {
+		ipsecSATicker := time.NewTicker(ipsecSAPollInterval)
+		defer ipsecSATicker.Stop()
+		ipsecSATick = ipsecSATicker.C
+	}
+	var wireguardKeyTick <-chan time.Time
+	if config.WireguardKeyRotation > 0 {
+		wireguardKeyTicker := time.NewTicker(config.WireguardKeyRotation)
+		defer wireguardKeyTicker.Stop()
+		wireguardKeyTick = wireguardKeyTicker.C
+	}
+	var probeTick <-chan time.Time
+	if config.EnableProbes {
+		err := s.startProbes(t)
+		if err != nil {
+			s.log.Errorf("Error starting probes, peers will not be probed: %v", err)
+		} else {
+			defer s.stopProbes()
+			probeTicker := time.NewTicker(config.ProbeInterval)
+			defer probeTicker.Stop()
+			probeTick = probeTicker.C
+		}
+	}
 	for {
 		select {
 		case <-t.Dying():
 			s.log.Infof("Connectivity Server asked to stop")
 			return nil
 		case evt := <-s.connectivityEventChan:
-			/* Note: we will only receive events we ask for when registering the chan */
-			switch evt.Type {
-			case common.ConnectivityAdded:
-				new := evt.New.(*common.NodeConnectivity)
-				err := s.updateIPConnectivity(new, false /* isWithdraw */)
-				if err != nil {
-					s.log.Errorf("Error while adding connectivity %s", err)
-				}
-			case common.ConnectivityDeleted:
-				old := evt.Old.(*common.NodeConnectivity)
-				err := s.updateIPConnectivity(old, true /* isWithdraw */)
-				if err != nil {
-					s.log.Errorf("Error while deleting connectivity %s", err)
-				}
-			case common.PeerNodeStateChanged:
-				old, _ := evt.Old.(*oldv3.Node)
-				new, _ := evt.New.(*oldv3.Node)
-				if old != nil {
-					oldV4IP, oldV6IP := common.GetNodeSpecAddresses(old)
-					if oldV4IP != "" {
-						delete(s.nodeByAddr, oldV4IP)
-					}
-					if oldV6IP != "" {
-						delete(s.nodeByAddr, oldV6IP)
-					}
-				}
-				if new != nil {
-					newV4IP, newV6IP := common.GetNodeSpecAddresses(new)
-					if newV4IP != "" {
-						s.nodeByAddr[newV4IP] = *new
-					}
-					if newV6IP != "" {
-						s.nodeByAddr[newV6IP] = *new
-					}
-				}
-				if old != nil && new != nil {
-					change := common.GetStringChangeType(old.Status.WireguardPublicKey, new.Status.WireguardPublicKey)
-					if change != common.ChangeSame {
-						s.log.Infof("connectivity(upd) WireguardPublicKey Changed (%s) %s->%s", old.Name, old.Status.WireguardPublicKey, new.Status.WireguardPublicKey)
-						s.updateAllIPConnectivity()
-					}
-				}
-			case common.FelixConfChanged:
-				old, _ := evt.Old.(*felixConfig.Config)
-				new, _ := evt.New.(*felixConfig.Config)
-				if new == nil || old == nil {
-					/* First/last update, do nothing more */
-					continue
-				}
-				s.felixConfig = new
-				if old.WireguardEnabled != new.WireguardEnabled {
-					s.log.Infof("connectivity(upd) WireguardEnabled Changed %t->%t", old.WireguardEnabled, new.WireguardEnabled)
-					s.providers[WIREGUARD].EnableDisable(new.WireguardEnabled)
-					s.updateAllIPConnectivity()
-				} else if old.WireguardListeningPort != new.WireguardListeningPort {
-					s.log.Warnf("connectivity(upd) WireguardListeningPort Changed [NOT IMPLEMENTED]")
-				}
-			case common.IpamConfChanged:
-				old, _ := evt.Old.(*calicov3.IPPool)
-				new, _ := evt.New.(*calicov3.IPPool)
-				if old == nil || new == nil {
-					/* First/last update, do nothing*/
-					continue
-				}
-				if new.Spec.VXLANMode != old.Spec.VXLANMode ||
-					new.Spec.IPIPMode != old.Spec.IPIPMode {
-					s.log.Infof("connectivity(upd) VXLAN/IPIPMode Changed")
-					s.updateAllIPConnectivity()
-				}
-			case common.SRv6PolicyAdded:
-				new := evt.New.(*common.NodeConnectivity)
-				err := s.updateSRv6Policy(new, false /* isWithdraw */)
-				if err != nil {
-					s.log.Errorf("Error while adding SRv6 Policy %s", err)
-				}
-			case common.SRv6PolicyDeleted:
-				old := evt.Old.(*common.NodeConnectivity)
-				err := s.updateSRv6Policy(old, true /* isWithdraw */)
-				if err != nil {
-					s.log.Errorf("Error while deleting SRv6 Policy %s", err)
+			s.handleConnectivityEvent(evt)
+		case session := <-s.bfdEventChan:
+			s.lock.Lock()
+			s.handleBFDEvent(session)
+			s.lock.Unlock()
+		case <-bfdGCTimer:
+			s.lock.Lock()
+			s.gcBFDSessions()
+			s.lock.Unlock()
+		case <-s.peerPSKsChanged:
+			s.lock.Lock()
+			s.providers[IPSEC].(*IpsecProvider).ReconcilePSKs()
+			s.lock.Unlock()
+		case <-ipsecSATick:
+			s.lock.Lock()
+			s.providers[IPSEC].(*IpsecProvider).PollSAs()
+			s.lock.Unlock()
+		case <-wireguardKeyTick:
+			s.lock.Lock()
+			s.rotateWireguardKey()
+			s.lock.Unlock()
+		case <-s.wireguardRetire:
+			s.lock.Lock()
+			s.providers[WIREGUARD].(*WireguardProvider).RetireOldTunnelIfDue(time.Now())
+			s.lock.Unlock()
+		case event := <-s.uplinkEventChan:
+			s.lock.Lock()
+			if s.isUplinkEvent(event) && s.refreshUplinks() {
+				s.updateAllIPConnectivity()
+			}
+			s.lock.Unlock()
+		case <-s.peerUplinksChanged:
+			s.lock.Lock()
+			if s.refreshPeerUplinks() {
+				s.log.Infof("connectivity(upd) Peer uplinks Changed")
+				s.updateAllIPConnectivity()
+			}
+			s.lock.Unlock()
+		case <-probeTick:
+			s.lock.Lock()
+			s.sendProbes()
+			s.lock.Unlock()
+		}
+	}
+}
+
+func (s *ConnectivityServer) handleConnectivityEvent(evt common.CalicoVppEvent) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	defer s.updateEncapOverhead()
+
+	/* Note: we will only receive events we ask for when registering the chan */
+	switch evt.Type {
+	case common.ConnectivityAdded:
+		new := evt.New.(*common.NodeConnectivity)
+		err := s.updateIPConnectivity(new, false /* isWithdraw */)
+		s.setLastError(new, err)
+		if err != nil {
+			s.log.Errorf("Error while adding connectivity %s", err)
+			common.NodeWarningEvent(common.EventReasonConnectivityFailed,
+				"Error adding connectivity to %s: %v", new.String(), err)
+		}
+		s.syncAllTenantRoutes()
+	case common.ConnectivityDeleted:
+		old := evt.Old.(*common.NodeConnectivity)
+		/* Before the tunnels carrying the sessions go away */
+		s.bfdDelConnectivity(old)
+		err := s.updateIPConnectivity(old, true /* isWithdraw */)
+		delete(s.lastErrors, old.String())
+		if err != nil {
+			s.log.Errorf("Error while deleting connectivity %s", err)
+			common.NodeWarningEvent(common.EventReasonConnectivityFailed,
+				"Error deleting connectivity to %s: %v", old.String(), err)
+		}
+		s.syncAllTenantRoutes()
+	case common.PeerNodeStateChanged:
+		old, _ := evt.Old.(*oldv3.Node)
+		new, _ := evt.New.(*oldv3.Node)
+		if old != nil {
+			oldV4IP, oldV6IP := common.GetNodeSpecAddresses(old)
+			if oldV4IP != "" {
+				delete(s.nodeByAddr, oldV4IP)
+			}
+			if oldV6IP != "" {
+				delete(s.nodeByAddr, oldV6IP)
+			}
+		}
+		if new != nil {
+			newV4IP, newV6IP := common.GetNodeSpecAddresses(new)
+			if newV4IP != "" {
+				s.nodeByAddr[newV4IP] = *new
+			}
+			if newV6IP != "" {
+				s.nodeByAddr[newV6IP] = *new
+			}
+		}
+		if old != nil && new != nil {
+			change := common.GetStringChangeType(old.Status.WireguardPublicKey, new.Status.WireguardPublicKey)
+			if change != common.ChangeSame {
+				s.log.Infof("connectivity(upd) WireguardPublicKey Changed (%s) %s->%s", old.Name, old.Status.WireguardPublicKey, new.Status.WireguardPublicKey)
+				s.updateAllIPConnectivity()
+			}
+			if new.Name == config.NodeName && new.Annotations[WireguardRotateKeyAnnotation] != "" &&
+				new.Annotations[WireguardRotateKeyAnnotation] != old.Annotations[WireguardRotateKeyAnnotation] {
+				s.log.Infof("connectivity(upd) Wireguard key rotation requested")
+				s.rotateWireguardKey()
+			}
+		}
+		if connectivityPolicyNodeChanged(old, new) {
+			s.log.Infof("connectivity(upd) Node labels Changed (%s)", new.Name)
+			s.updateAllIPConnectivity()
+		}
+	case common.FelixConfChanged:
+		old, _ := evt.Old.(*felixConfig.Config)
+		new, _ := evt.New.(*felixConfig.Config)
+		if new == nil || old == nil {
+			/* First/last update, do nothing more */
+			return
+		}
+		s.felixConfig = new
+		if old.WireguardEnabled != new.WireguardEnabled {
+			s.log.Infof("connectivity(upd) WireguardEnabled Changed %t->%t", old.WireguardEnabled, new.WireguardEnabled)
+			s.providers[WIREGUARD].EnableDisable(new.WireguardEnabled)
+			s.updateAllIPConnectivity()
+		} else if old.WireguardListeningPort != new.WireguardListeningPort {
+			s.log.Infof("connectivity(upd) WireguardListeningPort Changed %d->%d", old.WireguardListeningPort, new.WireguardListeningPort)
+			err := s.providers[WIREGUARD].(*WireguardProvider).UpdatePort()
+			if err != nil {
+				s.log.Errorf("Error updating wireguard port: %v", err)
+			}
+			s.updateAllIPConnectivity()
+		}
+	case common.IpamConfChanged:
+		old, _ := evt.Old.(*calicov3.IPPool)
+		new, _ := evt.New.(*calicov3.IPPool)
+		if old == nil || new == nil {
+			/* First/last update, do nothing*/
+			return
+		}
+		if new.Spec.VXLANMode != old.Spec.VXLANMode ||
+			new.Spec.IPIPMode != old.Spec.IPIPMode ||
+			new.Annotations[EncapAnnotation] != old.Annotations[EncapAnnotation] ||
+			new.Annotations[VXLANVniAnnotation] != old.Annotations[VXLANVniAnnotation] ||
+			new.Annotations[VXLANPortAnnotation] != old.Annotations[VXLANPortAnnotation] {
+			s.log.Infof("connectivity(upd) VXLAN/IPIPMode/Encapsulation Changed")
+			s.updateAllIPConnectivity()
+		}
+	case common.IPsecPSKChanged:
+		psks := evt.New.(*common.IPsecPSKs)
+		ipsecProvider := s.providers[IPSEC].(*IpsecProvider)
+		hadPSK := ipsecProvider.pskCurrent != "" || config.IPSecIkev2Psk != ""
+		ipsecProvider.SetPSKs(psks)
+		if !hadPSK {
+			/* Peers were refused until we got a key */
+			s.log.Infof("connectivity(upd) first IPsec PSK received")
+			s.updateAllIPConnectivity()
+		}
+	case common.BGPPeerAdded:
+		peer := evt.New.(*bgpapi.Peer)
+		s.bfdAddBGPPeer(net.ParseIP(peer.Conf.NeighborAddress))
+	case common.BGPPeerDeleted:
+		addr := evt.New.(string)
+		s.bfdDelBGPPeer(net.ParseIP(addr))
+	case common.SRv6PolicyAdded:
+		new := evt.New.(*common.NodeConnectivity)
+		err := s.updateSRv6Policy(new, false /* isWithdraw */)
+		if err != nil {
+			s.log.Errorf("Error while adding SRv6 Policy %s", err)
+		}
+	case common.SRv6PolicyDeleted:
+		old := evt.Old.(*common.NodeConnectivity)
+		err := s.updateSRv6Policy(old, true /* isWithdraw */)
+		if err != nil {
+			s.log.Errorf("Error while deleting SRv6 Policy %s", err)
+		}
+	case common.TenantMembersChanged:
+		s.onTenantMembersChanged(evt.New.(*common.TenantMembers))
+	case common.TenantDeleted:
+		s.onTenantDeleted(evt.Old.(*common.TenantMembers))
+	case common.SRv6TEPoliciesChanged:
+		policies := evt.New.([]common.SRv6TEPolicy)
+		s.providers[SRv6].(*SRv6Provider).SetTEPolicies(policies)
+	}
+}
+
+func (s *ConnectivityServer) rotateWireguardKey() {
+	if s.felixConfig == nil || !s.felixConfig.WireguardEnabled {
+		return
+	}
+	err := s.providers[WIREGUARD].(*WireguardProvider).RotateKey()
+	if err != nil {
+		s.log.Errorf("Error rotating wireguard key: %v", err)
+		/* Add back the peers that could not be moved to the new tunnel */
+		s.updateAllIPConnectivity()
+	}
+}
+
+/* Routes through nodes whose BFD session is down are only programmed when it comes back up */
+func (s *ConnectivityServer) addConnectivity(providerType string, cn *common.NodeConnectivity) error {
+	if s.isBFDDown(cn) {
+		s.log.Infof("connectivity(add) BFD down, deferring cn=%s", cn.String())
+		return nil
+	}
+	err := s.providers[providerType].AddConnectivity(cn)
+	if err != nil {
+		return err
+	}
+	s.setConnectivityMtu(providerType, cn)
+	s.syncHostRouteMtu(cn.Dst)
+	s.bfdSyncConnectivity(providerType, cn)
+	return nil
+}
+
+func (s *ConnectivityServer) delConnectivity(providerType string, cn *common.NodeConnectivity) error {
+	/* cn is already out of the connectivity map */
+	s.syncHostRouteMtu(cn.Dst)
+	if s.isBFDDown(cn) {
+		/* Already withdrawn */
+		return nil
+	}
+	return s.providers[providerType].DelConnectivity(cn)
+}
+
+func (s *ConnectivityServer) setLastError(cn *common.NodeConnectivity, err error) {
+	if err != nil {
+		s.lastErrors[cn.String()] = err.Error()
+	} else {
+		delete(s.lastErrors, cn.String())
+	}
+}
+
+/**
+ * GetConnectivityStatus returns the state of the connectivity towards each
+ * remote node, aggregating the prefixes routed through the same next hop.
+ */
+func (s *ConnectivityServer) GetConnectivityStatus() []common.NodeConnectivityStatus {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, provider := range s.providers {
+		if snapshotter, ok := provider.(StatusSnapshotter); ok {
+			snapshotter.SnapshotStatus()
+		}
+	}
+	statusByPeer := make(map[string]*common.NodeConnectivityStatus)
+	for _, cn := range s.connectivityMap {
+		key := cn.ResolvedProvider + "-" + cn.NextHop.String()
+		status, found := statusByPeer[key]
+		if !found {
+			provider, ok := s.providers[cn.ResolvedProvider]
+			if !ok {
+				continue
+			}
+			providerStatus := provider.GetStatus(cn.NextHop)
+			status = &providerStatus
+			status.NextHop = cn.NextHop
+			status.Provider = cn.ResolvedProvider
+			if bfdState, isDown, found := s.getBFDState(&cn); found {
+				status.Detail = strings.TrimSpace(status.Detail + " bfd " + bfdState.String())
+				if isDown {
+					status.State = common.ConnectivityStateDown
 				}
 			}
+			if node := s.GetNodeByIp(cn.NextHop); node != nil {
+				status.NodeName = node.Name
+			}
+			if overhead := provider.EncapOverhead(&cn); overhead > 0 && config.HostMtu > 0 {
+				status.Mtu = config.HostMtu - overhead
+			}
+			statusByPeer[key] = status
+		}
+		status.Prefixes = append(status.Prefixes, cn.Dst.String())
+		if lastError, found := s.lastErrors[cn.String()]; found {
+			status.LastError = lastError
+		}
+	}
+
+	statuses := make([]common.NodeConnectivityStatus, 0, len(statusByPeer))
+	for _, status := range statusByPeer {
+		sort.Strings(status.Prefixes)
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].NextHop.String() < statuses[j].NextHop.String()
+	})
+	return statuses
+}
+
+/* GetIPsecTunnelStatus returns the SAs of each IPsec tunnel as of the last poll */
+func (s *ConnectivityServer) GetIPsecTunnelStatus() []common.IPsecTunnelStatus {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	statuses := s.providers[IPSEC].(*IpsecProvider).GetTunnelStatus()
+	for i := range statuses {
+		if node := s.GetNodeByIp(statuses[i].NextHop); node != nil {
+			statuses[i].NodeName = node.Name
 		}
 	}
+	return statuses
 }
 
 func (s *ConnectivityServer) updateSRv6Policy(cn *common.NodeConnectivity, IsWithdraw bool) (err error) {
@@ -251,17 +674,23 @@ func (s *ConnectivityServer) getProviderType(cn *common.NodeConnectivity) (strin
 	if config.EnableSRv6 {
 		return SRv6, nil
 	}
+	/* Only policy rules carry provider parameters */
+	cn.Parameters = nil
+	if rule := s.getPolicyRule(cn, ipPool); rule != nil {
+		cn.Parameters = rule.Parameters
+		return rule.Provider, nil
+	}
 	if ipPool == nil {
 		return FLAT, nil
 	}
+	encap := getPoolEncapsulation(ipPool)
+	if providerType := s.getEncapsulationProviderType(encap); providerType != "" {
+		return s.getEncapProviderType(cn, ipPool, providerType)
+	} else if encap != "" {
+		s.warnUnknownEncapsulation(ipPool, encap)
+	}
 	if ipPool.Spec.IPIPMode == calicov3.IPIPModeAlways {
-		if s.providers[IPSEC].Enabled(cn) {
-			return IPSEC, nil
-		} else if s.providers[WIREGUARD].Enabled(cn) {
-			return WIREGUARD, nil
-		} else {
-			return IPIP, nil
-		}
+		return s.getIPIPProviderType(cn)
 	}
 	ipNet := s.GetNodeIPNet(vpplink.IsIP6(cn.Dst.IP))
 	if ipPool.Spec.IPIPMode == calicov3.IPIPModeCrossSubnet {
@@ -269,13 +698,7 @@ func (s *ConnectivityServer) getProviderType(cn *common.NodeConnectivity) (strin
 			return FLAT, fmt.Errorf("missing node IPnet")
 		}
 		if !isCrossSubnet(cn.NextHop, *ipNet) {
-			if s.providers[IPSEC].Enabled(cn) {
-				return IPSEC, nil
-			} else if s.providers[WIREGUARD].Enabled(cn) {
-				return WIREGUARD, nil
-			} else {
-				return IPIP, nil
-			}
+			return s.getIPIPProviderType(cn)
 		}
 	}
 	if ipPool.Spec.VXLANMode == calicov3.VXLANModeAlways {
@@ -292,6 +715,102 @@ func (s *ConnectivityServer) getProviderType(cn *common.NodeConnectivity) (strin
 	return FLAT, nil
 }
 
+/* Pool annotations can't be validated upfront, warn once per pool & encapsulation */
+func (s *ConnectivityServer) warnUnknownEncapsulation(ipPool *calicov3.IPPool, encap string) {
+	key := ipPool.Name + "/" + encap
+	if s.unknownEncaps[key] {
+		return
+	}
+	if s.unknownEncaps == nil {
+		s.unknownEncaps = make(map[string]bool)
+	}
+	s.unknownEncaps[key] = true
+	s.log.Warnf("Unknown encapsulation %s for pool %s, ignoring it", encap, ipPool.Name)
+}
+
+/* The encrypting provider to use towards cn, "" if encryption is disabled */
+func (s *ConnectivityServer) getEncryptionProviderType(cn *common.NodeConnectivity) string {
+	if s.providers[IPSEC].Enabled(cn) {
+		return IPSEC
+	} else if s.providers[WIREGUARD].Enabled(cn) {
+		return WIREGUARD
+	}
+	return ""
+}
+
+/**
+ * IPsec and Wireguard replace IPIP when enabled, pools using another
+ * encapsulation are not encrypted. With CALICOVPP_ENCRYPTION_MODE=CrossSubnet,
+ * nodes in the subnet of our uplink address are trusted and reached with
+ * plain IPIP, like Calico's CrossSubnet IPIP/VXLAN modes only encapsulate
+ * across subnets.
+ */
+func (s *ConnectivityServer) getIPIPProviderType(cn *common.NodeConnectivity) (string, error) {
+	providerType := s.getEncryptionProviderType(cn)
+	if providerType == "" {
+		return IPIP, nil
+	}
+	if config.EncryptionMode == config.EncryptionModeCrossSubnet {
+		ipNet := s.GetNodeIPNet(vpplink.IsIP6(cn.Dst.IP))
+		if ipNet == nil {
+			return FLAT, fmt.Errorf("missing node IPnet")
+		}
+		if !isCrossSubnet(cn.NextHop, *ipNet) {
+			return IPIP, nil
+		}
+	}
+	return providerType, nil
+}
+
+/* Pools whose traffic is encapsulated across nodes (or across subnets) */
+func poolEncapsulates(ipPool *calicov3.IPPool) bool {
+	return (ipPool.Spec.IPIPMode != "" && ipPool.Spec.IPIPMode != calicov3.IPIPModeNever) ||
+		(ipPool.Spec.VXLANMode != "" && ipPool.Spec.VXLANMode != calicov3.VXLANModeNever)
+}
+
+/**
+ * The EncapAnnotation of the pool, config.DefaultEncapsulation replaces
+ * IPIP & VXLAN in pools using them, pools without encapsulation keep none.
+ */
+func getPoolEncapsulation(ipPool *calicov3.IPPool) string {
+	if encap, found := ipPool.Annotations[EncapAnnotation]; found {
+		return encap
+	}
+	if poolEncapsulates(ipPool) {
+		return config.DefaultEncapsulation
+	}
+	return ""
+}
+
+/* The provider implementing an encapsulation of EncapAnnotation, "" if unknown */
+func (s *ConnectivityServer) getEncapsulationProviderType(encap string) string {
+	switch encap {
+	case EncapGeneve:
+		return GENEVE
+	case EncapGRE:
+		return GRE
+	}
+	if _, found := s.providers[encap]; found && isRegisteredProvider(encap) {
+		return encap
+	}
+	return ""
+}
+
+func (s *ConnectivityServer) getEncapProviderType(cn *common.NodeConnectivity, ipPool *calicov3.IPPool, providerType string) (string, error) {
+	if ipPool.Spec.VXLANMode != calicov3.VXLANModeCrossSubnet &&
+		ipPool.Spec.IPIPMode != calicov3.IPIPModeCrossSubnet {
+		return providerType, nil
+	}
+	ipNet := s.GetNodeIPNet(vpplink.IsIP6(cn.Dst.IP))
+	if ipNet == nil {
+		return FLAT, fmt.Errorf("missing node IPnet")
+	}
+	if isCrossSubnet(cn.NextHop, *ipNet) {
+		return providerType, nil
+	}
+	return FLAT, nil
+}
+
 func (s *ConnectivityServer) updateIPConnectivity(cn *common.NodeConnectivity, IsWithdraw bool) (err error) {
 	var providerType string
 	if IsWithdraw {
@@ -306,8 +825,10 @@ func (s *ConnectivityServer) updateIPConnectivity(cn *common.NodeConnectivity, I
 			providerType = oldCn.ResolvedProvider
 			delete(s.connectivityMap, oldCn.String())
 			s.log.Infof("connectivity(del) path providerType=%s cn=%s", providerType, oldCn.String())
+			/* With the parameters it was added with */
+			cn = &oldCn
 		}
-		return s.providers[providerType].DelConnectivity(cn)
+		return s.delConnectivity(providerType, cn)
 	} else {
 		providerType, err = s.getProviderType(cn)
 		if err != nil {
@@ -316,24 +837,25 @@ func (s *ConnectivityServer) updateIPConnectivity(cn *common.NodeConnectivity, I
 		oldCn, found := s.connectivityMap[cn.String()]
 		if found {
 			oldProviderType := oldCn.ResolvedProvider
-			if oldProviderType != providerType {
-				s.log.Infof("connectivity(upd) provider Change providerType=%s->%s cn=%s", oldProviderType, providerType, cn.String())
-				err := s.providers[oldProviderType].DelConnectivity(cn)
+			if oldProviderType != providerType || !reflect.DeepEqual(oldCn.Parameters, cn.Parameters) {
+				s.log.Infof("connectivity(upd) provider Change providerType=%s->%s parameters=%v->%v cn=%s",
+					oldProviderType, providerType, oldCn.Parameters, cn.Parameters, cn.String())
+				err := s.providers[oldProviderType].DelConnectivity(&oldCn)
 				if err != nil {
 					s.log.Errorf("Error del connectivity when changing provider %s->%s : %s", oldProviderType, providerType, err)
 				}
 				cn.ResolvedProvider = providerType
 				s.connectivityMap[cn.String()] = *cn
-				return s.providers[providerType].AddConnectivity(cn)
+				return s.addConnectivity(providerType, cn)
 			} else {
 				s.log.Infof("connectivity(same) path providerType=%s cn=%s", providerType, cn.String())
-				return s.providers[providerType].AddConnectivity(cn)
+				return s.addConnectivity(providerType, cn)
 			}
 		} else {
 			s.log.Infof("connectivity(add) path providerType=%s cn=%s", providerType, cn.String())
 			cn.ResolvedProvider = providerType
 			s.connectivityMap[cn.String()] = *cn
-			return s.providers[providerType].AddConnectivity(cn)
+			return s.addConnectivity(providerType, cn)
 		}
 	}
 }
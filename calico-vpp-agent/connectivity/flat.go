@@ -16,6 +16,7 @@
 package connectivity
 
 import (
+	"fmt"
 	"net"
 
 	"github.com/pkg/errors"
@@ -47,6 +48,21 @@ func (p *FlatL3Provider) Enabled(cn *common.NodeConnectivity) bool {
 	return true
 }
 
+func (p *FlatL3Provider) EncapOverhead(cn *common.NodeConnectivity) int {
+	return 0
+}
+
+func (p *FlatL3Provider) GetStatus(nextHop net.IP) common.NodeConnectivityStatus {
+	status := common.NodeConnectivityStatus{
+		SwIfIndex: vpplink.InvalidID,
+		State:     common.ConnectivityStateUp,
+	}
+	if paths := p.server.getUplinkPaths(nextHop); len(paths) > 0 {
+		status.Detail = fmt.Sprintf("ecmp over %d uplinks", len(paths))
+	}
+	return status
+}
+
 func NewFlatL3Provider(d *ConnectivityProviderData) *FlatL3Provider {
 	return &FlatL3Provider{d}
 }
@@ -54,6 +70,10 @@ func NewFlatL3Provider(d *ConnectivityProviderData) *FlatL3Provider {
 func (p *FlatL3Provider) AddConnectivity(cn *common.NodeConnectivity) error {
 	p.log.Infof("connectivity(add) route to VPP cn=%s", cn.String())
 	paths := getRoutePaths(cn.NextHop)
+	if uplinkPaths := p.server.getUplinkPaths(cn.NextHop); len(uplinkPaths) > 0 {
+		p.log.Infof("connectivity(add) ecmp cn=%s paths=%v", cn.String(), uplinkPaths)
+		paths = uplinkRoutePaths(uplinkPaths)
+	}
 	err := p.vpp.RouteAdd(&types.Route{
 		Paths: paths,
 		Dst:   &cn.Dst,
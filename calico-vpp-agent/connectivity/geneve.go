@@ -0,0 +1,296 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/pkg/errors"
+
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/common"
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/config"
+	"github.com/projectcalico/vpp-dataplane/vpplink"
+	"github.com/projectcalico/vpp-dataplane/vpplink/types"
+
+	types2 "git.fd.io/govpp.git/api/v0"
+)
+
+/**
+ * Geneve tunnels in L3 mode, on the standard UDP port 6081. This is selected
+ * on IPPools annotated with EncapAnnotation: geneve, for underlays where
+ * only Geneve is allowed through.
+ */
+type GeneveProvider struct {
+	*ConnectivityProviderData
+	geneveIfs    map[string]types.GeneveTunnel
+	geneveRoutes map[uint32]map[string]bool
+	ip4NodeIndex uint32
+	ip6NodeIndex uint32
+}
+
+func NewGeneveProvider(d *ConnectivityProviderData) *GeneveProvider {
+	return &GeneveProvider{d, make(map[string]types.GeneveTunnel), make(map[uint32]map[string]bool), 0, 0}
+}
+
+func (p *GeneveProvider) EnableDisable(isEnable bool) {
+}
+
+func (p *GeneveProvider) Enabled(cn *common.NodeConnectivity) bool {
+	return true
+}
+
+func (p *GeneveProvider) EncapOverhead(cn *common.NodeConnectivity) int {
+	/* UDP + Geneve headers, tunnels are L3 so no inner ethernet */
+	return outerIPHeaderSize(cn.NextHop) + 8 + 8
+}
+
+func (p *GeneveProvider) GetTunnelSwIfIndexes(nextHop net.IP) []uint32 {
+	tunnel, found := p.geneveIfs[nextHop.String()]
+	if !found {
+		return nil
+	}
+	return []uint32{tunnel.SwIfIndex}
+}
+
+func (p *GeneveProvider) GetStatus(nextHop net.IP) common.NodeConnectivityStatus {
+	tunnel, found := p.geneveIfs[nextHop.String()]
+	if !found {
+		return common.NodeConnectivityStatus{
+			SwIfIndex: vpplink.InvalidID,
+			State:     common.ConnectivityStateDown,
+			Detail:    "no tunnel",
+		}
+	}
+	return common.NodeConnectivityStatus{
+		SwIfIndex: tunnel.SwIfIndex,
+		State:     common.ConnectivityStateUp,
+		Detail:    fmt.Sprintf("vni %d", tunnel.Vni),
+	}
+}
+
+func (p *GeneveProvider) configureGeneveNodes() error {
+	var err error
+	p.ip4NodeIndex, err = p.vpp.AddNodeNext("geneve4-input", "ip4-input")
+	if err != nil {
+		p.log.Fatal("Couldn't find node id for ip4-input : %v", err)
+	}
+	p.ip6NodeIndex, err = p.vpp.AddNodeNext("geneve6-input", "ip6-input")
+	if err != nil {
+		p.log.Fatal("Couldn't find node id for ip6-input : %v", err)
+	}
+	return nil
+}
+
+func (p *GeneveProvider) RescanState() {
+	p.log.Infof("Rescanning existing Geneve tunnels")
+	p.configureGeneveNodes()
+	p.geneveIfs = make(map[string]types.GeneveTunnel)
+	tunnels, err := p.vpp.ListGeneveTunnels()
+	if err != nil {
+		p.log.Errorf("Error listing Geneve tunnels: %v", err)
+	}
+	ip4, ip6 := p.server.GetNodeIPs()
+	for _, tunnel := range tunnels {
+		if (ip4 != nil && tunnel.SrcAddress.Equal(*ip4)) || (ip6 != nil && tunnel.SrcAddress.Equal(*ip6)) {
+			if tunnel.Vni == config.DefaultGeneveVni {
+				p.log.Infof("Found existing tunnel: %s", tunnel.String())
+				p.geneveIfs[tunnel.DstAddress.String()] = tunnel
+			}
+		}
+	}
+
+	tunnelBySwIfIndex := make(map[uint32]bool)
+	for _, tunnel := range p.geneveIfs {
+		tunnelBySwIfIndex[tunnel.SwIfIndex] = true
+	}
+	p.log.Infof("Rescanning existing routes")
+	p.geneveRoutes = make(map[uint32]map[string]bool)
+	routes, err := p.vpp.GetRoutes(0, false)
+	if err != nil {
+		p.log.Errorf("Error listing routes: %v", err)
+	}
+	for _, route := range routes {
+		for _, routePath := range route.Paths {
+			_, exists := tunnelBySwIfIndex[routePath.SwIfIndex]
+			if exists {
+				_, found := p.geneveRoutes[routePath.SwIfIndex]
+				if !found {
+					p.geneveRoutes[routePath.SwIfIndex] = make(map[string]bool)
+				}
+				p.geneveRoutes[routePath.SwIfIndex][route.Dst.String()] = true
+			}
+		}
+	}
+}
+
+func (p *GeneveProvider) getNodeIpForConnectivity(cn *common.NodeConnectivity) (nodeIP net.IP, err error) {
+	ip4, ip6 := p.server.GetNodeIPs()
+	if vpplink.IsIP6(cn.NextHop) && ip6 != nil {
+		return *ip6, nil
+	} else if !vpplink.IsIP6(cn.NextHop) && ip4 != nil {
+		return *ip4, nil
+	} else {
+		return nodeIP, fmt.Errorf("Missing node address")
+	}
+}
+
+func (p *GeneveProvider) AddConnectivity(cn *common.NodeConnectivity) error {
+	p.log.Debugf("Adding geneve Tunnel to VPP")
+	nodeIP, err := p.getNodeIpForConnectivity(cn)
+	if err != nil {
+		return err
+	}
+
+	_, found := p.geneveIfs[cn.NextHop.String()]
+	if !found {
+		p.log.Infof("connectivity(add) Geneve %s->%s", nodeIP.String(), cn.NextHop.String())
+		tunnel := &types.GeneveTunnel{
+			SrcAddress:     nodeIP,
+			DstAddress:     cn.NextHop,
+			Vni:            config.DefaultGeneveVni,
+			DecapNextIndex: p.ip4NodeIndex,
+		}
+		if vpplink.IsIP6(cn.NextHop) {
+			tunnel.DecapNextIndex = p.ip6NodeIndex
+		}
+		swIfIndex, err := p.vpp.AddGeneveTunnel(tunnel)
+		if err != nil {
+			return errors.Wrapf(err, "Error adding geneve tunnel %s -> %s", nodeIP.String(), cn.NextHop.String())
+		}
+
+		iface := types2.Interface{SwIfIndex: swIfIndex}
+
+		err = p.vpp.InterfaceSetUnnumbered(iface.SwIfIndex, config.DataInterfaceSwIfIndex)
+		if err != nil {
+			p.delGeneveTunnel(tunnel)
+			return errors.Wrapf(err, "Error setting geneve tunnel unnumbered")
+		}
+
+		err = p.vpp.EnableGSOFeature(&iface)
+		if err != nil {
+			p.delGeneveTunnel(tunnel)
+			return errors.Wrapf(err, "Error enabling gso for geneve interface")
+		}
+
+		err = p.vpp.CnatEnableFeatures(iface.SwIfIndex)
+		if err != nil {
+			p.delGeneveTunnel(tunnel)
+			return errors.Wrapf(err, "Error enabling nat for geneve interface")
+		}
+
+		err = p.vpp.InterfaceAdminUp(&iface)
+		if err != nil {
+			p.delGeneveTunnel(tunnel)
+			return errors.Wrapf(err, "Error setting geneve interface up")
+		}
+		p.server.setTunnelMtu(GENEVE, iface.SwIfIndex, p.EncapOverhead(cn))
+
+		p.log.Debugf("Routing pod->node %s traffic into tunnel (swIfIndex %d)", cn.NextHop.String(), iface.SwIfIndex)
+		err = p.vpp.RouteAdd(&types.Route{
+			Dst: common.ToMaxLenCIDR(cn.NextHop),
+			Paths: []types.RoutePath{{
+				SwIfIndex: iface.SwIfIndex,
+				Gw:        nil,
+			}},
+			Table: common.PodVRFIndex,
+		})
+		if err != nil {
+			p.delGeneveTunnel(tunnel)
+			return errors.Wrapf(err, "Error adding route to %s in geneve tunnel %d for pods", cn.NextHop.String(), iface.SwIfIndex)
+		}
+
+		p.geneveIfs[cn.NextHop.String()] = *tunnel
+		p.log.Infof("connectivity(add) Geneve Added tunnel=%s", tunnel.String())
+		common.SendEvent(common.CalicoVppEvent{
+			Type: common.TunnelAdded,
+			New:  iface.SwIfIndex,
+		})
+	}
+	tunnel := p.geneveIfs[cn.NextHop.String()]
+
+	p.log.Infof("connectivity(add) geneve route dst=%s via swIfIndex=%d", cn.Dst.IP.String(), tunnel.SwIfIndex)
+	route := &types.Route{
+		Dst: &cn.Dst,
+		Paths: []types.RoutePath{{
+			SwIfIndex: tunnel.SwIfIndex,
+			Gw:        nodeIP,
+		}},
+	}
+	_, found = p.geneveRoutes[tunnel.SwIfIndex]
+	if !found {
+		p.geneveRoutes[tunnel.SwIfIndex] = make(map[string]bool)
+	}
+	p.geneveRoutes[tunnel.SwIfIndex][route.Dst.String()] = true
+	return p.vpp.RouteAdd(route)
+}
+
+func (p *GeneveProvider) delGeneveTunnel(tunnel *types.GeneveTunnel) {
+	err := p.vpp.DelGeneveTunnel(tunnel)
+	if err != nil {
+		p.log.Errorf("Error deleting Geneve tunnel %s after error: %v", tunnel.String(), err)
+	}
+}
+
+func (p *GeneveProvider) DelConnectivity(cn *common.NodeConnectivity) error {
+	tunnel, found := p.geneveIfs[cn.NextHop.String()]
+	if !found {
+		return errors.Errorf("Deleting unknown geneve tunnel cn=%s", cn.String())
+	}
+	nodeIP, err := p.getNodeIpForConnectivity(cn)
+	if err != nil {
+		return err
+	}
+
+	p.log.Infof("connectivity(del) Geneve cn=%s swIfIndex=%d", cn.String(), tunnel.SwIfIndex)
+	routeToDelete := &types.Route{
+		Dst: &cn.Dst,
+		Paths: []types.RoutePath{{
+			SwIfIndex: tunnel.SwIfIndex,
+			Gw:        nodeIP,
+		}},
+	}
+	err = p.vpp.RouteDel(routeToDelete)
+	if err != nil {
+		return errors.Wrapf(err, "Error deleting geneve tunnel route")
+	}
+
+	delete(p.geneveRoutes[tunnel.SwIfIndex], routeToDelete.Dst.String())
+
+	remainingRoutes, found := p.geneveRoutes[tunnel.SwIfIndex]
+	if !found || len(remainingRoutes) == 0 {
+		p.log.Infof("connectivity(del) all gone. Deleting Geneve tunnel swIfIndex=%d", tunnel.SwIfIndex)
+		err = p.vpp.RouteDel(&types.Route{
+			Dst: common.ToMaxLenCIDR(cn.NextHop),
+			Paths: []types.RoutePath{{
+				SwIfIndex: tunnel.SwIfIndex,
+				Gw:        nil,
+			}},
+			Table: common.PodVRFIndex,
+		})
+		if err != nil {
+			p.log.Errorf("Error deleting geneve route dst=%s via tunnel swIfIndex=%d %s", cn.NextHop.String(), tunnel.SwIfIndex, err)
+		}
+		p.server.releaseTunnelMtu(GENEVE, tunnel.SwIfIndex)
+		p.delGeneveTunnel(&tunnel)
+		delete(p.geneveIfs, cn.NextHop.String())
+		common.SendEvent(common.CalicoVppEvent{
+			Type: common.TunnelDeleted,
+			Old:  tunnel.SwIfIndex,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/pkg/errors"
+
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/common"
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/config"
+	"github.com/projectcalico/vpp-dataplane/vpplink"
+	"github.com/projectcalico/vpp-dataplane/vpplink/types"
+
+	types2 "git.fd.io/govpp.git/api/v0"
+)
+
+type GREProvider struct {
+	*ConnectivityProviderData
+	greIfs    map[string]*types.GRETunnel
+	greRoutes map[uint32]map[string]bool
+}
+
+func NewGREProvider(d *ConnectivityProviderData) *GREProvider {
+	return &GREProvider{d, make(map[string]*types.GRETunnel), make(map[uint32]map[string]bool)}
+}
+
+func (p *GREProvider) EnableDisable(isEnable bool) {
+}
+
+func (p *GREProvider) Enabled(cn *common.NodeConnectivity) bool {
+	return true
+}
+
+func (p *GREProvider) EncapOverhead(cn *common.NodeConnectivity) int {
+	/* GRE header without key nor sequence number */
+	return outerIPHeaderSize(cn.NextHop) + 4
+}
+
+func (p *GREProvider) RescanState() {
+	p.log.Infof("Rescanning existing GRE tunnels")
+	p.greIfs = make(map[string]*types.GRETunnel)
+	tunnels, err := p.vpp.ListGRETunnels()
+	if err != nil {
+		p.log.Errorf("Error listing gre tunnels: %v", err)
+	}
+
+	ip4, ip6 := p.server.GetNodeIPs()
+	for _, tunnel := range tunnels {
+		if (ip4 != nil && tunnel.Src.Equal(*ip4)) || (ip6 != nil && tunnel.Src.Equal(*ip6)) {
+			p.log.Infof("Found existing tunnel: %s", tunnel)
+			p.greIfs[tunnel.Dst.String()] = tunnel
+		}
+	}
+
+	indexTunnel := make(map[uint32]*types.GRETunnel)
+	for _, tunnel := range p.greIfs {
+		indexTunnel[tunnel.SwIfIndex] = tunnel
+	}
+	p.log.Infof("Rescanning existing routes")
+	p.greRoutes = make(map[uint32]map[string]bool)
+	routes, err := p.vpp.GetRoutes(0, false)
+	if err != nil {
+		p.log.Errorf("Error listing routes: %v", err)
+	}
+	for _, route := range routes {
+		for _, routePath := range route.Paths {
+			_, exists := indexTunnel[routePath.SwIfIndex]
+			if exists {
+				_, found := p.greRoutes[routePath.SwIfIndex]
+				if !found {
+					p.greRoutes[routePath.SwIfIndex] = make(map[string]bool)
+				}
+				p.greRoutes[routePath.SwIfIndex][route.Dst.String()] = true
+			}
+		}
+	}
+}
+
+func (p *GREProvider) GetTunnelSwIfIndexes(nextHop net.IP) []uint32 {
+	tunnel, found := p.greIfs[nextHop.String()]
+	if !found {
+		return nil
+	}
+	return []uint32{tunnel.SwIfIndex}
+}
+
+func (p *GREProvider) GetStatus(nextHop net.IP) common.NodeConnectivityStatus {
+	tunnel, found := p.greIfs[nextHop.String()]
+	if !found {
+		return common.NodeConnectivityStatus{
+			SwIfIndex: vpplink.InvalidID,
+			State:     common.ConnectivityStateDown,
+			Detail:    "no tunnel",
+		}
+	}
+	return common.NodeConnectivityStatus{
+		SwIfIndex: tunnel.SwIfIndex,
+		State:     common.ConnectivityStateUp,
+	}
+}
+
+func (p *GREProvider) errorCleanup(tunnel *types.GRETunnel) {
+	err := p.vpp.DelGRETunnel(tunnel)
+	if err != nil {
+		p.log.Errorf("Error deleting gre tunnel %s after error: %v", tunnel.String(), err)
+	}
+}
+
+func (p *GREProvider) AddConnectivity(cn *common.NodeConnectivity) error {
+	p.log.Debugf("connectivity(add) GRE Tunnel to VPP")
+	tunnel, found := p.greIfs[cn.NextHop.String()]
+	if !found {
+		tunnel = &types.GRETunnel{
+			Dst: cn.NextHop,
+		}
+		ip4, ip6 := p.server.GetNodeIPs()
+		if vpplink.IsIP6(cn.NextHop) && ip6 != nil {
+			tunnel.Src = *ip6
+		} else if !vpplink.IsIP6(cn.NextHop) && ip4 != nil {
+			tunnel.Src = *ip4
+		} else {
+			return fmt.Errorf("Missing node address")
+		}
+
+		p.log.Infof("connectivity(add) create GRE tunnel=%s", tunnel.String())
+
+		swIfIndex, err := p.vpp.AddGRETunnel(tunnel)
+		if err != nil {
+			return errors.Wrapf(err, "Error adding gre tunnel %s", tunnel.String())
+		}
+
+		iface := types2.Interface{SwIfIndex: swIfIndex}
+
+		err = p.vpp.InterfaceSetUnnumbered(iface.SwIfIndex, config.DataInterfaceSwIfIndex)
+		if err != nil {
+			p.errorCleanup(tunnel)
+			return errors.Wrapf(err, "Error setting gre tunnel unnumbered")
+		}
+
+		// Always enable GSO feature on GRE tunnel, only a tiny negative effect on perf if GSO is not enabled on the taps
+		err = p.vpp.EnableGSOFeature(&iface)
+		if err != nil {
+			p.errorCleanup(tunnel)
+			return errors.Wrapf(err, "Error enabling gso for gre interface")
+		}
+
+		err = p.vpp.CnatEnableFeatures(iface.SwIfIndex)
+		if err != nil {
+			p.errorCleanup(tunnel)
+			return errors.Wrapf(err, "Error enabling nat for gre interface")
+		}
+
+		err = p.vpp.InterfaceAdminUp(&iface)
+		if err != nil {
+			p.errorCleanup(tunnel)
+			return errors.Wrapf(err, "Error setting gre interface up")
+		}
+		p.server.setTunnelMtu(GRE, iface.SwIfIndex, p.EncapOverhead(cn))
+
+		p.log.Debugf("Routing pod->node %s traffic into tunnel (swIfIndex %d)", cn.NextHop.String(), iface.SwIfIndex)
+		err = p.vpp.RouteAdd(&types.Route{
+			Dst: common.ToMaxLenCIDR(cn.NextHop),
+			Paths: []types.RoutePath{{
+				SwIfIndex: iface.SwIfIndex,
+				Gw:        nil,
+			}},
+			Table: common.PodVRFIndex,
+		})
+		if err != nil {
+			p.errorCleanup(tunnel)
+			return errors.Wrapf(err, "Error adding route to %s in gre tunnel %d for pods", cn.NextHop.String(), iface.SwIfIndex)
+		}
+
+		p.greIfs[cn.NextHop.String()] = tunnel
+		common.SendEvent(common.CalicoVppEvent{
+			Type: common.TunnelAdded,
+			New:  iface.SwIfIndex,
+		})
+	}
+	p.log.Infof("connectivity(add) using GRE tunnel=%s", tunnel.String())
+	p.log.Debugf("connectivity(add) gre tunnel route dst=%s via tunnel swIfIndex=%d", cn.Dst.IP.String(), tunnel.SwIfIndex)
+
+	route := &types.Route{
+		Dst: &cn.Dst,
+		Paths: []types.RoutePath{{
+			SwIfIndex: tunnel.SwIfIndex,
+			Gw:        nil,
+		}},
+	}
+	err := p.vpp.RouteAdd(route)
+	if err != nil {
+		return errors.Wrapf(err, "Error Adding route to gre tunnel")
+	}
+	_, found = p.greRoutes[tunnel.SwIfIndex]
+	if !found {
+		p.greRoutes[tunnel.SwIfIndex] = make(map[string]bool)
+	}
+	p.greRoutes[tunnel.SwIfIndex][route.Dst.String()] = true
+	return nil
+}
+
+func (p *GREProvider) DelConnectivity(cn *common.NodeConnectivity) error {
+	tunnel, found := p.greIfs[cn.NextHop.String()]
+	if !found {
+		return errors.Errorf("Deleting unknown gre tunnel cn=%s", cn.String())
+	}
+	p.log.Infof("connectivity(del) Removed GRE connectivity cn=%s swIfIndex=%d", cn.String(), tunnel.SwIfIndex)
+	routeToDelete := &types.Route{
+		Dst: &cn.Dst,
+		Paths: []types.RoutePath{{
+			SwIfIndex: tunnel.SwIfIndex,
+			Gw:        nil,
+		}},
+	}
+	err := p.vpp.RouteDel(routeToDelete)
+	if err != nil {
+		return errors.Wrapf(err, "Error deleting gre tunnel route")
+	}
+
+	delete(p.greRoutes[tunnel.SwIfIndex], routeToDelete.Dst.String())
+
+	remaining_routes, found := p.greRoutes[tunnel.SwIfIndex]
+	if !found || len(remaining_routes) == 0 {
+		p.log.Infof("connectivity(del) all gone. Deleting GRE tunnel swIfIndex=%d", tunnel.SwIfIndex)
+		err = p.vpp.RouteDel(&types.Route{
+			Dst: common.ToMaxLenCIDR(cn.NextHop),
+			Paths: []types.RoutePath{{
+				SwIfIndex: tunnel.SwIfIndex,
+				Gw:        nil,
+			}},
+			Table: common.PodVRFIndex,
+		})
+		if err != nil {
+			p.log.Errorf("Error deleting gre route dst=%s via tunnel swIfIndex=%d %s", cn.NextHop.String(), tunnel.SwIfIndex, err)
+		}
+		p.log.Infof("connectivity(del) GRE tunnel=%s", tunnel)
+		p.server.releaseTunnelMtu(GRE, tunnel.SwIfIndex)
+		err := p.vpp.DelGRETunnel(tunnel)
+		if err != nil {
+			p.log.Errorf("Error deleting gre tunnel %s after error: %v", tunnel.String(), err)
+		}
+		delete(p.greIfs, cn.NextHop.String())
+		common.SendEvent(common.CalicoVppEvent{
+			Type: common.TunnelDeleted,
+			Old:  tunnel.SwIfIndex,
+		})
+	}
+	return nil
+}
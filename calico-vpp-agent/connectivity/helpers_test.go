@@ -0,0 +1,76 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"net"
+
+	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+	oldv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	"github.com/sirupsen/logrus"
+	tomb "gopkg.in/tomb.v2"
+
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/common"
+)
+
+type testProvider struct {
+	enabled  bool
+	overhead int
+}
+
+func (p *testProvider) AddConnectivity(cn *common.NodeConnectivity) error { return nil }
+func (p *testProvider) DelConnectivity(cn *common.NodeConnectivity) error { return nil }
+func (p *testProvider) RescanState()                                      {}
+func (p *testProvider) Enabled(cn *common.NodeConnectivity) bool          { return p.enabled }
+func (p *testProvider) EnableDisable(isEnable bool)                       {}
+func (p *testProvider) GetStatus(nextHop net.IP) common.NodeConnectivityStatus {
+	return common.NodeConnectivityStatus{}
+}
+func (p *testProvider) EncapOverhead(cn *common.NodeConnectivity) int { return p.overhead }
+
+type testIpamCache struct {
+	pool *calicov3.IPPool
+}
+
+func (c *testIpamCache) GetPrefixIPPool(*net.IPNet) *calicov3.IPPool { return c.pool }
+func (c *testIpamCache) GetIPPools() []calicov3.IPPool {
+	if c.pool == nil {
+		return nil
+	}
+	return []calicov3.IPPool{*c.pool}
+}
+func (c *testIpamCache) SyncIPAM(t *tomb.Tomb) error           { return nil }
+func (c *testIpamCache) WaitReady()                            {}
+func (c *testIpamCache) IPNetNeedsSNAT(prefix *net.IPNet) bool { return false }
+
+/* Server with a test provider of each type, the ones in enabled are enabled */
+func newTestProviderServer(pool *calicov3.IPPool, enabled ...string) *ConnectivityServer {
+	s := &ConnectivityServer{
+		log:         logrus.NewEntry(logrus.New()),
+		providers:   make(map[string]ConnectivityProvider),
+		ipam:        &testIpamCache{pool: pool},
+		nodeBGPSpec: &oldv3.NodeBGPSpec{IPv4Address: "10.0.0.1/24"},
+	}
+	for _, name := range []string{FLAT, IPSEC, WIREGUARD, IPIP, VXLAN, GENEVE, GRE} {
+		s.providers[name] = &testProvider{}
+	}
+	for _, name := range enabled {
+		if name != "" {
+			s.providers[name] = &testProvider{enabled: true}
+		}
+	}
+	return s
+}
@@ -17,6 +17,8 @@ package connectivity
 
 import (
 	"fmt"
+	"net"
+	"sort"
 
 	"github.com/pkg/errors"
 
@@ -31,12 +33,20 @@ import (
 
 type IpipProvider struct {
 	*ConnectivityProviderData
+	/* Tunnels by ipipTunnelKey */
 	ipipIfs    map[string]*vpptypes.IPIPTunnel
 	ipipRoutes map[uint32]map[string]bool
+	/* Node address each tunnel (by key) leads to, they differ with uplink ECMP */
+	ipipNextHops map[string]string
+}
+
+/* Uplinks in the same subnet reach the same peer address from different sources */
+func ipipTunnelKey(src net.IP, dst net.IP) string {
+	return src.String() + "->" + dst.String()
 }
 
 func NewIPIPProvider(d *ConnectivityProviderData) *IpipProvider {
-	return &IpipProvider{d, make(map[string]*vpptypes.IPIPTunnel), make(map[uint32]map[string]bool)}
+	return &IpipProvider{d, make(map[string]*vpptypes.IPIPTunnel), make(map[uint32]map[string]bool), make(map[string]string)}
 }
 
 func (p *IpipProvider) EnableDisable(isEnable bool) {
@@ -46,9 +56,14 @@ func (p *IpipProvider) Enabled(cn *common.NodeConnectivity) bool {
 	return true
 }
 
+func (p *IpipProvider) EncapOverhead(cn *common.NodeConnectivity) int {
+	return outerIPHeaderSize(cn.NextHop)
+}
+
 func (p *IpipProvider) RescanState() {
 	p.log.Infof("Rescanning existing tunnels")
 	p.ipipIfs = make(map[string]*vpptypes.IPIPTunnel)
+	p.ipipNextHops = make(map[string]string)
 	tunnels, err := p.vpp.ListIPIPTunnels()
 	if err != nil {
 		p.log.Errorf("Error listing ipip tunnels: %v", err)
@@ -56,9 +71,12 @@ func (p *IpipProvider) RescanState() {
 
 	ip4, ip6 := p.server.GetNodeIPs()
 	for _, tunnel := range tunnels {
-		if (ip4 != nil && tunnel.Src.Equal(*ip4)) || (ip6 != nil && tunnel.Src.Equal(*ip6)) {
+		if (ip4 != nil && tunnel.Src.Equal(*ip4)) || (ip6 != nil && tunnel.Src.Equal(*ip6)) ||
+			p.server.isUplinkAddress(tunnel.Src) {
 			p.log.Infof("Found existing tunnel: %s", tunnel)
-			p.ipipIfs[tunnel.Dst.String()] = tunnel
+			key := ipipTunnelKey(tunnel.Src, tunnel.Dst)
+			p.ipipIfs[key] = tunnel
+			p.ipipNextHops[key] = tunnel.Dst.String()
 		}
 	}
 
@@ -84,6 +102,61 @@ func (p *IpipProvider) RescanState() {
 			}
 		}
 	}
+	/* Pod->node routes tell which node the tunnels built on other uplinks lead to */
+	for _, isIP6 := range []bool{false, true} {
+		podRoutes, err := p.vpp.GetRoutes(common.PodVRFIndex, isIP6)
+		if err != nil {
+			p.log.Errorf("Error listing pod routes: %v", err)
+		}
+		for _, route := range podRoutes {
+			for _, routePath := range route.Paths {
+				if tunnel, exists := indexTunnel[routePath.SwIfIndex]; exists {
+					p.ipipNextHops[ipipTunnelKey(tunnel.Src, tunnel.Dst)] = route.Dst.IP.String()
+				}
+			}
+		}
+	}
+}
+
+/* Tunnels leading to the node with address nextHop, sorted by swIfIndex */
+func (p *IpipProvider) getNextHopTunnels(nextHop net.IP) []*vpptypes.IPIPTunnel {
+	tunnels := make([]*vpptypes.IPIPTunnel, 0)
+	for key, tunnel := range p.ipipIfs {
+		if p.ipipNextHops[key] == nextHop.String() {
+			tunnels = append(tunnels, tunnel)
+		}
+	}
+	sort.Slice(tunnels, func(i, j int) bool {
+		return tunnels[i].SwIfIndex < tunnels[j].SwIfIndex
+	})
+	return tunnels
+}
+
+func (p *IpipProvider) GetTunnelSwIfIndexes(nextHop net.IP) []uint32 {
+	swIfIndexes := make([]uint32, 0)
+	for _, tunnel := range p.getNextHopTunnels(nextHop) {
+		swIfIndexes = append(swIfIndexes, tunnel.SwIfIndex)
+	}
+	return swIfIndexes
+}
+
+func (p *IpipProvider) GetStatus(nextHop net.IP) common.NodeConnectivityStatus {
+	tunnels := p.getNextHopTunnels(nextHop)
+	if len(tunnels) == 0 {
+		return common.NodeConnectivityStatus{
+			SwIfIndex: vpplink.InvalidID,
+			State:     common.ConnectivityStateDown,
+			Detail:    "no tunnel",
+		}
+	}
+	status := common.NodeConnectivityStatus{
+		SwIfIndex: tunnels[0].SwIfIndex,
+		State:     common.ConnectivityStateUp,
+	}
+	if len(tunnels) > 1 {
+		status.Detail = fmt.Sprintf("ecmp over %d tunnels", len(tunnels))
+	}
+	return status
 }
 
 func (p *IpipProvider) errorCleanup(tunnel *vpptypes.IPIPTunnel) {
@@ -93,142 +166,205 @@ func (p *IpipProvider) errorCleanup(tunnel *vpptypes.IPIPTunnel) {
 	}
 }
 
-func (p *IpipProvider) AddConnectivity(cn *common.NodeConnectivity) error {
-	p.log.Debugf("connectivity(add) IPIP Tunnel to VPP")
-	tunnel, found := p.ipipIfs[cn.NextHop.String()]
-	if !found {
-		tunnel = &vpptypes.IPIPTunnel{
-			Dst: cn.NextHop,
-		}
-		ip4, ip6 := p.server.GetNodeIPs()
-		if vpplink.IsIP6(cn.NextHop) && ip6 != nil {
-			tunnel.Src = *ip6
-		} else if !vpplink.IsIP6(cn.NextHop) && ip4 != nil {
-			tunnel.Src = *ip4
-		} else {
-			return fmt.Errorf("Missing node address")
-		}
+/* One tunnel per uplink with ECMP, a single one from the node address otherwise */
+func (p *IpipProvider) getTunnelEndpoints(cn *common.NodeConnectivity) ([]uplinkPath, error) {
+	if paths := p.server.getUplinkPaths(cn.NextHop); len(paths) > 0 {
+		return paths, nil
+	}
+	ip4, ip6 := p.server.GetNodeIPs()
+	if vpplink.IsIP6(cn.NextHop) && ip6 != nil {
+		return []uplinkPath{{SwIfIndex: vpplink.InvalidID, Src: *ip6, Gw: cn.NextHop}}, nil
+	} else if !vpplink.IsIP6(cn.NextHop) && ip4 != nil {
+		return []uplinkPath{{SwIfIndex: vpplink.InvalidID, Src: *ip4, Gw: cn.NextHop}}, nil
+	}
+	return nil, fmt.Errorf("Missing node address")
+}
 
-		p.log.Infof("connectivity(add) create IPIP tunnel=%s", tunnel.String())
+func (p *IpipProvider) addTunnel(src net.IP, dst net.IP) (*vpptypes.IPIPTunnel, error) {
+	tunnel := &vpptypes.IPIPTunnel{
+		Src: src,
+		Dst: dst,
+	}
+	p.log.Infof("connectivity(add) create IPIP tunnel=%s", tunnel.String())
 
-		swIfIndex, err := p.vpp.AddIPIPTunnel(tunnel)
-		if err != nil {
-			return errors.Wrapf(err, "Error adding ipip tunnel %s", tunnel.String())
-		}
+	swIfIndex, err := p.vpp.AddIPIPTunnel(tunnel)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error adding ipip tunnel %s", tunnel.String())
+	}
 
-		iface := types2.Interface{SwIfIndex: swIfIndex}
+	iface := types2.Interface{SwIfIndex: swIfIndex}
 
-		err = p.vpp.InterfaceSetUnnumbered(iface.SwIfIndex, config.DataInterfaceSwIfIndex)
-		if err != nil {
-			p.errorCleanup(tunnel)
-			return errors.Wrapf(err, "Error setting ipip tunnel unnumbered")
-		}
+	err = p.vpp.InterfaceSetUnnumbered(iface.SwIfIndex, config.DataInterfaceSwIfIndex)
+	if err != nil {
+		p.errorCleanup(tunnel)
+		return nil, errors.Wrapf(err, "Error setting ipip tunnel unnumbered")
+	}
 
-		// Always enable GSO feature on IPIP tunnel, only a tiny negative effect on perf if GSO is not enabled on the taps
-		err = p.vpp.EnableGSOFeature(&iface)
-		if err != nil {
-			p.errorCleanup(tunnel)
-			return errors.Wrapf(err, "Error enabling gso for ipip interface")
-		}
+	// Always enable GSO feature on IPIP tunnel, only a tiny negative effect on perf if GSO is not enabled on the taps
+	err = p.vpp.EnableGSOFeature(&iface)
+	if err != nil {
+		p.errorCleanup(tunnel)
+		return nil, errors.Wrapf(err, "Error enabling gso for ipip interface")
+	}
 
-		err = p.vpp.CnatEnableFeatures(iface.SwIfIndex)
-		if err != nil {
-			p.errorCleanup(tunnel)
-			return errors.Wrapf(err, "Error enabling nat for ipip interface")
-		}
+	err = p.vpp.CnatEnableFeatures(iface.SwIfIndex)
+	if err != nil {
+		p.errorCleanup(tunnel)
+		return nil, errors.Wrapf(err, "Error enabling nat for ipip interface")
+	}
 
-		err = p.vpp.InterfaceAdminUp(&iface)
-		if err != nil {
-			p.errorCleanup(tunnel)
-			return errors.Wrapf(err, "Error setting ipip interface up")
-		}
+	err = p.vpp.InterfaceAdminUp(&iface)
+	if err != nil {
+		p.errorCleanup(tunnel)
+		return nil, errors.Wrapf(err, "Error setting ipip interface up")
+	}
+	p.server.setTunnelMtu(IPIP, iface.SwIfIndex, outerIPHeaderSize(dst))
 
-		p.log.Debugf("Routing pod->node %s traffic into tunnel (swIfIndex %d)", cn.NextHop.String(), iface.SwIfIndex)
-		err = p.vpp.RouteAdd(&types.Route{
-			Dst: common.ToMaxLenCIDR(cn.NextHop),
-			Paths: []types.RoutePath{{
-				SwIfIndex: iface.SwIfIndex,
-				Gw:        nil,
-			}},
-			Table: common.PodVRFIndex,
+	p.ipipIfs[ipipTunnelKey(src, dst)] = tunnel
+	common.SendEvent(common.CalicoVppEvent{
+		Type: common.TunnelAdded,
+		New:  iface.SwIfIndex,
+	})
+	return tunnel, nil
+}
+
+func (p *IpipProvider) delTunnel(tunnel *vpptypes.IPIPTunnel) {
+	p.log.Infof("connectivity(del) IPIP tunnel=%s", tunnel)
+	p.server.releaseTunnelMtu(IPIP, tunnel.SwIfIndex)
+	err := p.vpp.DelIPIPTunnel(tunnel)
+	if err != nil {
+		p.log.Errorf("Error deleting ipip tunnel %s: %v", tunnel.String(), err)
+	}
+	key := ipipTunnelKey(tunnel.Src, tunnel.Dst)
+	delete(p.ipipIfs, key)
+	delete(p.ipipNextHops, key)
+	delete(p.ipipRoutes, tunnel.SwIfIndex)
+	common.SendEvent(common.CalicoVppEvent{
+		Type: common.TunnelDeleted,
+		Old:  tunnel.SwIfIndex,
+	})
+}
+
+/**
+ * syncNextHop routes pod->node traffic towards nextHop into the tunnels
+ * still carrying routes, and deletes the tunnels that don't anymore
+ * (e.g. built on an uplink that went down).
+ */
+func (p *IpipProvider) syncNextHop(nextHop net.IP) error {
+	paths := make([]types.RoutePath, 0)
+	unused := make([]*vpptypes.IPIPTunnel, 0)
+	for _, tunnel := range p.getNextHopTunnels(nextHop) {
+		if len(p.ipipRoutes[tunnel.SwIfIndex]) == 0 {
+			unused = append(unused, tunnel)
+			continue
+		}
+		paths = append(paths, types.RoutePath{
+			SwIfIndex: tunnel.SwIfIndex,
+			Gw:        nil,
 		})
+	}
+	podRoute := &types.Route{
+		Dst:   common.ToMaxLenCIDR(nextHop),
+		Paths: paths,
+		Table: common.PodVRFIndex,
+	}
+	var err error
+	if len(paths) > 0 {
+		p.log.Debugf("Routing pod->node %s traffic into tunnels %+v", nextHop.String(), paths)
+		err = p.vpp.RouteAdd(podRoute)
+		if err != nil {
+			err = errors.Wrapf(err, "Error adding route to %s in ipip tunnels for pods", nextHop.String())
+		}
+	} else {
+		p.log.Infof("connectivity(del) all gone. Deleting IPIP tunnels to %s", nextHop.String())
+		err = p.vpp.RouteDel(podRoute)
 		if err != nil {
-			p.errorCleanup(tunnel)
-			return errors.Wrapf(err, "Error adding route to %s in ipip tunnel %d for pods", cn.NextHop.String(), iface.SwIfIndex)
+			p.log.Errorf("Error deleting ipip route dst=%s %s", nextHop.String(), err)
+			err = nil
 		}
+	}
+	for _, tunnel := range unused {
+		p.delTunnel(tunnel)
+	}
+	return err
+}
 
-		p.ipipIfs[cn.NextHop.String()] = tunnel
-		common.SendEvent(common.CalicoVppEvent{
-			Type: common.TunnelAdded,
-			New:  iface.SwIfIndex,
+func (p *IpipProvider) AddConnectivity(cn *common.NodeConnectivity) error {
+	p.log.Debugf("connectivity(add) IPIP Tunnel to VPP")
+	endpoints, err := p.getTunnelEndpoints(cn)
+	if err != nil {
+		return err
+	}
+	paths := make([]types.RoutePath, 0, len(endpoints))
+	inUse := make(map[uint32]bool)
+	for _, endpoint := range endpoints {
+		key := ipipTunnelKey(endpoint.Src, endpoint.Gw)
+		tunnel, found := p.ipipIfs[key]
+		if !found {
+			tunnel, err = p.addTunnel(endpoint.Src, endpoint.Gw)
+			if err != nil {
+				return err
+			}
+		}
+		p.ipipNextHops[key] = cn.NextHop.String()
+		p.log.Infof("connectivity(add) using IPIP tunnel=%s", tunnel.String())
+		paths = append(paths, types.RoutePath{
+			SwIfIndex: tunnel.SwIfIndex,
+			Gw:        nil,
 		})
+		inUse[tunnel.SwIfIndex] = true
 	}
-	p.log.Infof("connectivity(add) using IPIP tunnel=%s", tunnel.String())
-	p.log.Debugf("connectivity(add) ipip tunnel route dst=%s via tunnel swIfIndex=%d", cn.Dst.IP.String(), tunnel.SwIfIndex)
+	p.log.Debugf("connectivity(add) ipip tunnel route dst=%s via tunnels %+v", cn.Dst.IP.String(), paths)
 
 	route := &types.Route{
-		Dst: &cn.Dst,
-		Paths: []types.RoutePath{{
-			SwIfIndex: tunnel.SwIfIndex,
-			Gw:        nil,
-		}},
+		Dst:   &cn.Dst,
+		Paths: paths,
 	}
-	err := p.vpp.RouteAdd(route)
+	err = p.vpp.RouteAdd(route)
 	if err != nil {
 		return errors.Wrapf(err, "Error Adding route to ipip tunnel")
 	}
-	_, found = p.ipipRoutes[tunnel.SwIfIndex]
-	if !found {
-		p.ipipRoutes[tunnel.SwIfIndex] = make(map[string]bool)
+	/* The route replaced the paths through tunnels we don't use anymore */
+	for swIfIndex, routes := range p.ipipRoutes {
+		if !inUse[swIfIndex] {
+			delete(routes, route.Dst.String())
+		}
+	}
+	for swIfIndex := range inUse {
+		_, found := p.ipipRoutes[swIfIndex]
+		if !found {
+			p.ipipRoutes[swIfIndex] = make(map[string]bool)
+		}
+		p.ipipRoutes[swIfIndex][route.Dst.String()] = true
 	}
-	p.ipipRoutes[tunnel.SwIfIndex][route.Dst.String()] = true
-	return nil
+	return p.syncNextHop(cn.NextHop)
 }
 
 func (p *IpipProvider) DelConnectivity(cn *common.NodeConnectivity) error {
-	tunnel, found := p.ipipIfs[cn.NextHop.String()]
-	if !found {
+	paths := make([]types.RoutePath, 0)
+	for swIfIndex, routes := range p.ipipRoutes {
+		if routes[cn.Dst.String()] {
+			paths = append(paths, types.RoutePath{
+				SwIfIndex: swIfIndex,
+				Gw:        nil,
+			})
+		}
+	}
+	if len(paths) == 0 {
 		return errors.Errorf("Deleting unknown ipip tunnel cn=%s", cn.String())
 	}
-	p.log.Infof("connectivity(del) Removed IPIP connectivity cn=%s swIfIndex=%d", cn.String(), tunnel.SwIfIndex)
+	p.log.Infof("connectivity(del) Removed IPIP connectivity cn=%s paths=%+v", cn.String(), paths)
 	routeToDelete := &types.Route{
-		Dst: &cn.Dst,
-		Paths: []types.RoutePath{{
-			SwIfIndex: tunnel.SwIfIndex,
-			Gw:        nil,
-		}},
+		Dst:   &cn.Dst,
+		Paths: paths,
 	}
 	err := p.vpp.RouteDel(routeToDelete)
 	if err != nil {
 		return errors.Wrapf(err, "Error deleting ipip tunnel route")
 	}
 
-	delete(p.ipipRoutes[tunnel.SwIfIndex], routeToDelete.Dst.String())
-
-	remaining_routes, found := p.ipipRoutes[tunnel.SwIfIndex]
-	if !found || len(remaining_routes) == 0 {
-		p.log.Infof("connectivity(del) all gone. Deleting IPIP tunnel swIfIndex=%d", tunnel.SwIfIndex)
-		err = p.vpp.RouteDel(&types.Route{
-			Dst: common.ToMaxLenCIDR(cn.NextHop),
-			Paths: []types.RoutePath{{
-				SwIfIndex: tunnel.SwIfIndex,
-				Gw:        nil,
-			}},
-			Table: common.PodVRFIndex,
-		})
-		if err != nil {
-			p.log.Errorf("Error deleting ipip route dst=%s via tunnel swIfIndex=%d %s", cn.NextHop.String(), tunnel.SwIfIndex, err)
-		}
-		p.log.Infof("connectivity(del) IPIP tunnel=%s", tunnel)
-		err := p.vpp.DelIPIPTunnel(tunnel)
-		if err != nil {
-			p.log.Errorf("Error deleting ipip tunnel %s after error: %v", tunnel.String(), err)
-		}
-		delete(p.ipipIfs, cn.NextHop.String())
-		common.SendEvent(common.CalicoVppEvent{
-			Type: common.TunnelDeleted,
-			Old:  tunnel.SwIfIndex,
-		})
+	for _, path := range paths {
+		delete(p.ipipRoutes[path.SwIfIndex], routeToDelete.Dst.String())
 	}
-	return nil
+	return p.syncNextHop(cn.NextHop)
 }
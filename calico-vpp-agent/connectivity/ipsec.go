@@ -17,9 +17,11 @@ package connectivity
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
 	"fmt"
 	"net"
-	"strings"
 	"time"
 
 	vpptypes "git.fd.io/govpp.git/api/v0"
@@ -41,17 +43,19 @@ func NewIpsecTunnel(ipipTunnel *vpptypes.IPIPTunnel) *IpsecTunnel {
 	return &IpsecTunnel{IPIPTunnel: ipipTunnel, cancel: func() {}}
 }
 
-func ipToSafeString(addr net.IP) string {
-	return strings.ReplaceAll(strings.ReplaceAll(addr.String(), ".", "_"), ":", "_")
-}
-
+/**
+ * Profile is the name of the IKEv2 profile of the tunnel. VPP limits it to 64
+ * bytes, so we use a hash of the tunnel endpoints rather than the addresses,
+ * RescanState finds the profiles back by computing it for existing tunnels.
+ */
 func (tunnel *IpsecTunnel) Profile() string {
-	return fmt.Sprintf("pr_%s_to_%s", ipToSafeString(tunnel.Src), ipToSafeString(tunnel.Dst))
+	sum := sha256.Sum256(append(append([]byte{}, tunnel.Src.To16()...), tunnel.Dst.To16()...))
+	return "pr_" + hex.EncodeToString(sum[:16])
 }
 
 func (tunnel *IpsecTunnel) IsInitiator() bool {
 	// Compare addresses lexicographically to select an initiator
-	return bytes.Compare(tunnel.Src.To4(), tunnel.Dst.To4()) > 0
+	return bytes.Compare(tunnel.Src.To16(), tunnel.Dst.To16()) > 0
 }
 
 type IpsecProvider struct {
@@ -59,6 +63,29 @@ type IpsecProvider struct {
 	ipsecIfs     map[string][]IpsecTunnel
 	ipsecRoutes  map[string]map[string]bool
 	nDataThreads int
+	/* our IKEv2 private key was given to VPP */
+	localKeySet bool
+	/* CA signing the node certificates, nil to trust them as they are */
+	ikev2Roots *x509.CertPool
+
+	/* PSK rotation state, see ipsec_psk.go */
+	pskCurrent         string
+	pskNext            string
+	pskPrevious        string
+	pskIDs             map[string]string /* key -> ID from the secret */
+	peerPSKs           map[string]string
+	pskRotationPending bool
+
+	/* Transform suites of the IKEv2 profiles, from CALICOVPP_IPSEC_IKEV2_TRANSFORMS */
+	ikeTransforms *vpplink.IKEv2Transforms
+	espTransforms *vpplink.IKEv2Transforms
+
+	/* Last SAs snapshot, see ipsec_stats.go */
+	tunnelStatus map[string]common.IPsecTunnelStatus
+
+	/* Number of protections of each tunnel as of the last status snapshot */
+	statusProtections map[uint32]int
+	statusErr         error
 }
 
 func (p *IpsecProvider) EnableDisable(isEnable bool) {
@@ -68,8 +95,93 @@ func (p *IpsecProvider) Enabled(cn *common.NodeConnectivity) bool {
 	return config.EnableIPSec
 }
 
+func (p *IpsecProvider) EncapOverhead(cn *common.NodeConnectivity) int {
+	nextHop := cn.NextHop
+	if _, remote, err := p.getTunnelAddresses(cn.NextHop); err == nil {
+		nextHop = remote
+	}
+	/* ESP header, IV, padding, trailer & ICV */
+	return outerIPHeaderSize(nextHop) + 40
+}
+
+func countTunnelProtections(protections []types.IPsecTunnelProtection) map[uint32]int {
+	counts := make(map[uint32]int)
+	for _, protection := range protections {
+		counts[protection.SwIfIndex]++
+	}
+	return counts
+}
+
+/* Dump the protections of all the tunnels once per status collection */
+func (p *IpsecProvider) SnapshotStatus() {
+	protections, err := p.vpp.ListIPsecTunnelProtections()
+	p.statusErr = err
+	p.statusProtections = countTunnelProtections(protections)
+}
+
+/* The connectivity is up once IKEv2 negotiated SAs protecting the tunnels */
+func (p *IpsecProvider) GetTunnelSwIfIndexes(nextHop net.IP) []uint32 {
+	if _, remote, err := p.getTunnelAddresses(nextHop); err == nil {
+		nextHop = remote
+	}
+	swIfIndexes := make([]uint32, 0)
+	for _, tunnel := range p.ipsecIfs[nextHop.String()] {
+		swIfIndexes = append(swIfIndexes, tunnel.SwIfIndex)
+	}
+	return swIfIndexes
+}
+
+func (p *IpsecProvider) GetStatus(nextHop net.IP) common.NodeConnectivityStatus {
+	if _, remote, err := p.getTunnelAddresses(nextHop); err == nil {
+		nextHop = remote
+	}
+	tunnels, found := p.ipsecIfs[nextHop.String()]
+	if !found || len(tunnels) == 0 {
+		return common.NodeConnectivityStatus{
+			SwIfIndex: vpplink.InvalidID,
+			State:     common.ConnectivityStateDown,
+			Detail:    "no tunnel",
+		}
+	}
+	if p.statusErr != nil {
+		return common.NodeConnectivityStatus{
+			SwIfIndex: tunnels[0].SwIfIndex,
+			State:     common.ConnectivityStateDown,
+			Detail:    fmt.Sprintf("error listing tunnel protections: %v", p.statusErr),
+		}
+	}
+	established := 0
+	for _, tunnel := range tunnels {
+		if p.statusProtections[tunnel.SwIfIndex] > 0 {
+			established++
+		}
+	}
+	status := common.NodeConnectivityStatus{
+		SwIfIndex: tunnels[0].SwIfIndex,
+		State:     common.ConnectivityStatePending,
+		Detail:    fmt.Sprintf("%d/%d SAs established", established, len(tunnels)),
+	}
+	if established > 0 {
+		status.State = common.ConnectivityStateUp
+	}
+	if pskStatus := p.getPSKStatus(nextHop.String()); pskStatus != "" {
+		status.Detail += ", " + pskStatus
+	}
+	return status
+}
+
 func (p *IpsecProvider) RescanState() {
 	p.ipsecIfs = make(map[string][]IpsecTunnel)
+	p.localKeySet = false
+	/* We don't know which keys the existing profiles use, set them again */
+	p.peerPSKs = make(map[string]string)
+	p.pskRotationPending = true
+	if config.EnableIPSec && useIKEv2Certificates() {
+		err := p.setupIKEv2LocalKey()
+		if err != nil {
+			p.log.Errorf("Error setting up IKEv2 private key: %v", err)
+		}
+	}
 	tunnels, err := p.vpp.ListIPIPTunnels()
 	if err != nil {
 		p.log.Errorf("Error listing ipip tunnels: %v", err)
@@ -134,33 +246,58 @@ func (p *IpsecProvider) RescanState() {
 }
 
 func NewIPsecProvider(d *ConnectivityProviderData, nDataThreads int) *IpsecProvider {
-	return &IpsecProvider{
+	p := &IpsecProvider{
 		ConnectivityProviderData: d,
 		ipsecIfs:                 make(map[string][]IpsecTunnel),
 		ipsecRoutes:              make(map[string]map[string]bool),
 		nDataThreads:             nDataThreads,
+		pskIDs:                   make(map[string]string),
+		peerPSKs:                 make(map[string]string),
+		tunnelStatus:             make(map[string]common.IPsecTunnelStatus),
 	}
+	var err error
+	p.ikeTransforms, p.espTransforms, err = ParseIPsecTransforms(&config.IPSecIkev2Transforms)
+	if err != nil {
+		/* ValidateConfig prevents this at startup */
+		p.log.Errorf("Invalid IPsec transforms, using the defaults: %v", err)
+		p.ikeTransforms, p.espTransforms = &vpplink.DefaultIKEv2IKETransforms, &vpplink.DefaultIKEv2ESPTransforms
+	}
+	return p
 }
 
-func (p *IpsecProvider) getIPSECTunnelSpecs(nodeIP4, destNodeAddr *net.IP) (tunnels []IpsecTunnel) {
+/* ParseIPsecTransforms checks the configured suites are ones VPP negotiates, those not given keep the defaults */
+func ParseIPsecTransforms(t *config.IPsecTransforms) (ike *vpplink.IKEv2Transforms, esp *vpplink.IKEv2Transforms, err error) {
+	ike, esp = &vpplink.DefaultIKEv2IKETransforms, &vpplink.DefaultIKEv2ESPTransforms
+	if t.IKE != nil {
+		ike, err = vpplink.ParseIKEv2Transforms(t.IKE.Encryption, t.IKE.KeySize, t.IKE.Integrity, t.IKE.DHGroup, false /* isESP */)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "invalid ike transforms")
+		}
+	}
+	if t.ESP != nil {
+		esp, err = vpplink.ParseIKEv2Transforms(t.ESP.Encryption, t.ESP.KeySize, t.ESP.Integrity, t.ESP.DHGroup, true /* isESP */)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "invalid esp transforms")
+		}
+	}
+	return ike, esp, nil
+}
+
+func (p *IpsecProvider) getIPSECTunnelSpecs(nodeAddr, destNodeAddr *net.IP) (tunnels []IpsecTunnel) {
 	if config.CrossIpsecTunnels {
 		for i := 0; i < config.IpsecAddressCount; i++ {
 			for j := 0; j < config.IpsecAddressCount; j++ {
 				tunnel := NewIpsecTunnel(&vpptypes.IPIPTunnel{})
-				tunnel.Src = net.IP(append([]byte(nil), nodeIP4.To4()...))
-				tunnel.Src[2] += byte(i)
-				tunnel.Dst = net.IP(append([]byte(nil), destNodeAddr.To4()...))
-				tunnel.Dst[2] += byte(j)
+				tunnel.Src = common.IpsecTunnelAddress(*nodeAddr, i)
+				tunnel.Dst = common.IpsecTunnelAddress(*destNodeAddr, j)
 				tunnels = append(tunnels, *tunnel)
 			}
 		}
 	} else {
 		for i := 0; i < config.IpsecAddressCount; i++ {
 			tunnel := NewIpsecTunnel(&vpptypes.IPIPTunnel{})
-			tunnel.Src = net.IP(append([]byte(nil), nodeIP4.To4()...))
-			tunnel.Src[2] += byte(i)
-			tunnel.Dst = net.IP(append([]byte(nil), destNodeAddr.To4()...))
-			tunnel.Dst[2] += byte(i)
+			tunnel.Src = common.IpsecTunnelAddress(*nodeAddr, i)
+			tunnel.Dst = common.IpsecTunnelAddress(*destNodeAddr, i)
 			tunnels = append(tunnels, *tunnel)
 		}
 	}
@@ -168,7 +305,7 @@ func (p *IpsecProvider) getIPSECTunnelSpecs(nodeIP4, destNodeAddr *net.IP) (tunn
 	return tunnels
 }
 
-func (p *IpsecProvider) createIPSECTunnel(tunnel *IpsecTunnel, psk string, stack *vpplink.CleanupStack) error {
+func (p *IpsecProvider) createIPSECTunnel(tunnel *IpsecTunnel, psk string, peerName string, stack *vpplink.CleanupStack) error {
 	swIfIndex, err := p.vpp.AddIPIPTunnel(tunnel.IPIPTunnel)
 	if err != nil {
 		return errors.Wrapf(err, "Error adding ipip tunnel %s", tunnel.String())
@@ -197,6 +334,7 @@ func (p *IpsecProvider) createIPSECTunnel(tunnel *IpsecTunnel, psk string, stack
 	if err != nil {
 		return errors.Wrapf(err, "Error enabling gso for ipip interface")
 	}
+	p.server.setTunnelMtu(IPSEC, iface.SwIfIndex, p.EncapOverhead(&common.NodeConnectivity{NextHop: tunnel.Dst}))
 
 	err = p.vpp.CnatEnableFeatures(iface.SwIfIndex)
 	if err != nil {
@@ -233,24 +371,46 @@ func (p *IpsecProvider) createIPSECTunnel(tunnel *IpsecTunnel, psk string, stack
 		return errors.Wrapf(err, "error configuring IPsec tunnel %s", tunnel.String())
 	}
 
-	err = p.vpp.SetIKEv2PSKAuth(tunnel.Profile(), psk)
-	if err != nil {
-		return errors.Wrapf(err, "error configuring IPsec tunnel %s", tunnel.String())
+	if useIKEv2Certificates() {
+		err = p.setIKEv2CertAuth(tunnel, peerName)
+		if err != nil {
+			return errors.Wrapf(err, "error configuring IPsec tunnel %s certificates", tunnel.String())
+		}
+	} else {
+		err = p.vpp.SetIKEv2PSKAuth(tunnel.Profile(), psk)
+		if err != nil {
+			return errors.Wrapf(err, "error configuring IPsec tunnel %s", tunnel.String())
+		}
+
+		err = p.vpp.SetIKEv2LocalIDAddress(tunnel.Profile(), tunnel.Src)
+		if err != nil {
+			return errors.Wrapf(err, "error configuring IPsec tunnel %s", tunnel.String())
+		}
+
+		err = p.vpp.SetIKEv2RemoteIDAddress(tunnel.Profile(), tunnel.Dst)
+		if err != nil {
+			return errors.Wrapf(err, "error configuring IPsec tunnel %s", tunnel.String())
+		}
 	}
 
-	err = p.vpp.SetIKEv2LocalIDAddress(tunnel.Profile(), tunnel.Src)
+	err = p.vpp.SetIKEv2PermissiveTrafficSelectors(tunnel.Profile())
 	if err != nil {
 		return errors.Wrapf(err, "error configuring IPsec tunnel %s", tunnel.String())
 	}
 
-	err = p.vpp.SetIKEv2RemoteIDAddress(tunnel.Profile(), tunnel.Dst)
+	/* Both ends need the transforms and lifetimes, either may rekey */
+	transforms := &config.IPSecIkev2Transforms
+	err = p.vpp.SetIKEv2Transforms(tunnel.Profile(), p.ikeTransforms, p.espTransforms)
 	if err != nil {
-		return errors.Wrapf(err, "error configuring IPsec tunnel %s", tunnel.String())
+		return errors.Wrapf(err, "error configuring IPsec tunnel %s transforms", tunnel.String())
 	}
 
-	err = p.vpp.SetIKEv2PermissiveTrafficSelectors(tunnel.Profile())
-	if err != nil {
-		return errors.Wrapf(err, "error configuring IPsec tunnel %s", tunnel.String())
+	if transforms.HasLifetime() {
+		err = p.vpp.SetIKEv2SALifetime(tunnel.Profile(), transforms.LifetimeDuration,
+			transforms.LifetimeJitterDuration, transforms.HandoverDuration, transforms.LifetimeMaxData)
+		if err != nil {
+			return errors.Wrapf(err, "error configuring IPsec tunnel %s lifetime", tunnel.String())
+		}
 	}
 
 	// Compare addresses lexicographically to select an initiator
@@ -261,11 +421,6 @@ func (p *IpsecProvider) createIPSECTunnel(tunnel *IpsecTunnel, psk string, stack
 			return errors.Wrapf(err, "error configuring IPsec tunnel %s", tunnel.String())
 		}
 
-		err = p.vpp.SetIKEv2DefaultTransforms(tunnel.Profile())
-		if err != nil {
-			return errors.Wrapf(err, "error configuring IPsec tunnel %s", tunnel.String())
-		}
-
 		err = p.vpp.IKEv2Initiate(tunnel.Profile())
 		if err != nil {
 			return errors.Wrapf(err, "error configuring IPsec tunnel %s", tunnel.String())
@@ -325,49 +480,72 @@ func getIPSecRoutePaths(tunnels []IpsecTunnel) []types.RoutePath {
 	return paths
 }
 
-func (p *IpsecProvider) forceOtherNodeIp4(addr net.IP) (ip4 net.IP, err error) {
-	/* If only IP6 (e.g. ipsec) is supported, find nodeip4 out of nodeip6 */
-	if !vpplink.IsIP6(addr) {
-		return addr, nil
-	}
+/**
+ * getTunnelAddresses returns the local and remote addresses of the tunnels
+ * towards the node with address addr. A dual-stack peer is a single logical
+ * peer: the same tunnels carry both families, their outer addresses are IPv4
+ * when both nodes have one, IPv6 otherwise.
+ */
+func (p *IpsecProvider) getTunnelAddresses(addr net.IP) (local net.IP, remote net.IP, err error) {
+	var peerIP4, peerIP6 *net.IP
 	otherNode := p.GetNodeByIp(addr)
-	if otherNode == nil {
-		return nil, fmt.Errorf("Didnt find an ip4 for ip %s", addr.String())
+	if otherNode != nil && otherNode.Spec.BGP != nil {
+		peerIP4, peerIP6 = common.GetBGPSpecAddresses(otherNode.Spec.BGP)
+	} else if vpplink.IsIP6(addr) {
+		peerIP6 = &addr
+	} else {
+		peerIP4 = &addr
 	}
-	nodeIP, _, err := net.ParseCIDR(otherNode.Spec.BGP.IPv4Address)
-	if err != nil {
-		return nil, errors.Wrapf(err, "Didnt find an ip4 for ip %s", addr.String())
+	nodeIP4, nodeIP6 := p.server.GetNodeIPs()
+	if nodeIP4 != nil && peerIP4 != nil {
+		return *nodeIP4, *peerIP4, nil
+	}
+	if nodeIP6 != nil && peerIP6 != nil {
+		return *nodeIP6, *peerIP6, nil
 	}
-	return nodeIP, nil
+	return nil, nil, fmt.Errorf("no common address family with %s", addr.String())
 }
 
 func (p *IpsecProvider) AddConnectivity(cn *common.NodeConnectivity) (err error) {
 	var route *types.Route
 	var tunnels []IpsecTunnel
+	var peerName string
 
-	cn.NextHop, err = p.forceOtherNodeIp4(cn.NextHop)
+	nodeAddr, nextHop, err := p.getTunnelAddresses(cn.NextHop)
 	if err != nil {
-		return errors.Wrap(err, "Ipsec v6 config failed")
-	}
-	/* IP6 is not yet supported by ikev2 */
-	nodeIP4, _ := p.server.GetNodeIPs()
-	if nodeIP4 == nil {
-		return fmt.Errorf("no ip4 node address found")
+		return errors.Wrap(err, "Ipsec config failed")
 	}
+	cn.NextHop = nextHop
 
 	stack := p.vpp.NewCleanupStack()
 
 	_, found := p.ipsecIfs[cn.NextHop.String()]
 	if !found {
-		tunnelSpecs := p.getIPSECTunnelSpecs(nodeIP4, &cn.NextHop)
+		if useIKEv2Certificates() {
+			/* IKEv2 identities are node names */
+			peerNode := p.GetNodeByIp(cn.NextHop)
+			if peerNode == nil {
+				return fmt.Errorf("Didnt find the node with address %s", cn.NextHop.String())
+			}
+			peerName = peerNode.Name
+		}
+		psk := ""
+		if !useIKEv2Certificates() {
+			psk, err = p.getPeerPSK(cn.NextHop)
+			if err != nil {
+				return errors.Wrapf(err, "Not configuring IPSEC tunnels to %s", cn.NextHop)
+			}
+		}
+		tunnelSpecs := p.getIPSECTunnelSpecs(&nodeAddr, &cn.NextHop)
 		for _, tunnelSpec := range tunnelSpecs {
-			err = p.createIPSECTunnel(&tunnelSpec, config.IPSecIkev2Psk, stack)
+			err = p.createIPSECTunnel(&tunnelSpec, psk, peerName, stack)
 			if err != nil {
 				err = errors.Wrapf(err, "Error configuring IPSEC tunnels to %s", cn.NextHop)
 				goto err
 			}
 			p.ipsecIfs[cn.NextHop.String()] = append(p.ipsecIfs[cn.NextHop.String()], tunnelSpec)
 		}
+		p.peerPSKs[cn.NextHop.String()] = psk
 	}
 	tunnels = p.ipsecIfs[cn.NextHop.String()]
 	p.log.Infof("connectivity(add) IPSEC cn=%s tunnels=%v", cn.String(), tunnels)
@@ -397,9 +575,9 @@ err:
 }
 
 func (p *IpsecProvider) DelConnectivity(cn *common.NodeConnectivity) (err error) {
-	cn.NextHop, err = p.forceOtherNodeIp4(cn.NextHop)
+	_, cn.NextHop, err = p.getTunnelAddresses(cn.NextHop)
 	if err != nil {
-		return errors.Wrap(err, "Ipsec v6 config failed")
+		return errors.Wrap(err, "Ipsec config failed")
 	}
 
 	tunnels, found := p.ipsecIfs[cn.NextHop.String()]
@@ -424,6 +602,7 @@ func (p *IpsecProvider) DelConnectivity(cn *common.NodeConnectivity) (err error)
 			tunnel.cancel()
 			p.vpp.DelIKEv2Profile(tunnel.Profile())
 			p.log.Infof("connectivity(del) Deleting IPsec tunnel=%s", tunnel)
+			p.server.releaseTunnelMtu(IPSEC, tunnel.SwIfIndex)
 			err := p.vpp.DelIPIPTunnel(tunnel.IPIPTunnel)
 			if err != nil {
 				p.log.Errorf("Error deleting ipip tunnel %s after error: %v", tunnel.String(), err)
@@ -434,6 +613,7 @@ func (p *IpsecProvider) DelConnectivity(cn *common.NodeConnectivity) (err error)
 			})
 		}
 		delete(p.ipsecIfs, cn.NextHop.String())
+		delete(p.peerPSKs, cn.NextHop.String())
 	}
 	return nil
 }
@@ -0,0 +1,270 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/config"
+)
+
+/**
+ * With CALICOVPP_IPSEC_IKEV2_AUTH=cert, IKEv2 peers authenticate with
+ * signatures instead of the cluster-wide PSK. Every node has a certificate
+ * <node name>.crt in config.IPSecIkev2CertDir, either mounted there or copied
+ * from the Secret config.IPSecIkev2CertSecret, which only holds certificates.
+ * Our private key <node name>.key stays on the node in config.IPSecIkev2KeyDir
+ * (e.g. a hostPath), so that a node never has the key of another one. Both
+ * directories must be readable by VPP.
+ *
+ * IKE identities are the node names, and each profile pins the certificate
+ * of the peer node. Whoever can write the certificates can thus impersonate
+ * a node, unless config.IPSecIkev2CAFile is set: the certificate of a peer
+ * must then be signed by this CA for the name of the peer node.
+ *
+ * Keys are RSA or ECDSA. VPP signs and verifies with the key of the
+ * certificates whatever its type, the rsa-sig auth method only tells that
+ * signatures are used.
+ */
+const (
+	ikev2CertSuffix = ".crt"
+	ikev2KeySuffix  = ".key"
+)
+
+func ikev2CertFile(nodeName string) string {
+	return filepath.Join(config.IPSecIkev2CertDir, nodeName+ikev2CertSuffix)
+}
+
+func ikev2KeyFile() string {
+	return filepath.Join(config.IPSecIkev2KeyDir, config.NodeName+ikev2KeySuffix)
+}
+
+func useIKEv2Certificates() bool {
+	return config.IPSecIkev2Auth == config.IPSecIkev2AuthCert
+}
+
+/* The certificates in the data of a Secret, which must not hold private keys */
+func filterIKEv2Certificates(data map[string][]byte) (map[string][]byte, error) {
+	certs := make(map[string][]byte)
+	for name, value := range data {
+		if strings.HasSuffix(name, ikev2KeySuffix) {
+			return nil, fmt.Errorf("found private key %s, keys must stay on their node", name)
+		}
+		if strings.HasSuffix(name, ikev2CertSuffix) {
+			certs[name] = value
+		}
+	}
+	return certs, nil
+}
+
+/* Private keys of other nodes in dir, that we refuse to run with */
+func findForeignIKEv2Keys(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	foreignKeys := make([]string, 0)
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ikev2KeySuffix) && file.Name() != config.NodeName+ikev2KeySuffix {
+			foreignKeys = append(foreignKeys, file.Name())
+		}
+	}
+	return foreignKeys, nil
+}
+
+/* Copy the node certificates from the configured Secret, if any */
+func (p *IpsecProvider) syncIKEv2Certificates() error {
+	if config.IPSecIkev2CertSecret == "" {
+		return nil
+	}
+	parts := strings.Split(config.IPSecIkev2CertSecret, "/")
+	secret, err := p.server.k8sclient.CoreV1().Secrets(parts[0]).Get(context.Background(), parts[1], metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "error getting secret %s", config.IPSecIkev2CertSecret)
+	}
+	certs, err := filterIKEv2Certificates(secret.Data)
+	if err != nil {
+		return errors.Wrapf(err, "invalid secret %s", config.IPSecIkev2CertSecret)
+	}
+	err = os.MkdirAll(config.IPSecIkev2CertDir, 0755)
+	if err != nil {
+		return errors.Wrapf(err, "error creating %s", config.IPSecIkev2CertDir)
+	}
+	for name, data := range certs {
+		err = ioutil.WriteFile(filepath.Join(config.IPSecIkev2CertDir, name), data, 0644)
+		if err != nil {
+			return errors.Wrapf(err, "error writing %s", name)
+		}
+	}
+	return nil
+}
+
+func checkIKEv2PrivateKey(data []byte) error {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return fmt.Errorf("no PEM data found")
+	}
+	if _, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return nil
+	}
+	if _, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return errors.Wrap(err, "cannot parse private key")
+	}
+	switch key.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+		return nil
+	default:
+		return fmt.Errorf("unsupported key type %T, use RSA or ECDSA", key)
+	}
+}
+
+func loadIKEv2Roots(caFile string) (*x509.CertPool, error) {
+	if caFile == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading IKEv2 CA")
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+	return roots, nil
+}
+
+/* Check the certificate of peerName, signed for this name by roots when not nil */
+func checkIKEv2Certificate(data []byte, peerName string, roots *x509.CertPool) error {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return fmt.Errorf("no PEM data found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return errors.Wrap(err, "cannot parse certificate")
+	}
+	if cert.PublicKeyAlgorithm != x509.RSA && cert.PublicKeyAlgorithm != x509.ECDSA {
+		return fmt.Errorf("%s certificates are not supported, use RSA or ECDSA", cert.PublicKeyAlgorithm)
+	}
+	if roots == nil {
+		return nil
+	}
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return errors.Wrap(err, "certificate not signed by the IKEv2 CA")
+	}
+	if cert.Subject.CommonName != peerName && cert.VerifyHostname(peerName) != nil {
+		return fmt.Errorf("certificate issued for %s, not for node %s", cert.Subject.CommonName, peerName)
+	}
+	return nil
+}
+
+/* Give VPP our private key, this is global to all IKEv2 profiles */
+func (p *IpsecProvider) setupIKEv2LocalKey() error {
+	err := p.syncIKEv2Certificates()
+	if err != nil {
+		return err
+	}
+	for _, dir := range []string{config.IPSecIkev2KeyDir, config.IPSecIkev2CertDir} {
+		foreignKeys, err := findForeignIKEv2Keys(dir)
+		if err != nil {
+			return errors.Wrapf(err, "error listing %s", dir)
+		}
+		if len(foreignKeys) > 0 {
+			return fmt.Errorf("found private keys of other nodes %v in %s, keys must stay on their node", foreignKeys, dir)
+		}
+	}
+	p.ikev2Roots, err = loadIKEv2Roots(config.IPSecIkev2CAFile)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(ikev2KeyFile())
+	if err != nil {
+		return errors.Wrap(err, "error reading IKEv2 private key")
+	}
+	err = checkIKEv2PrivateKey(data)
+	if err != nil {
+		return errors.Wrapf(err, "invalid IKEv2 private key %s", ikev2KeyFile())
+	}
+	err = p.vpp.SetIKEv2LocalKey(ikev2KeyFile())
+	if err != nil {
+		return err
+	}
+	p.localKeySet = true
+	return nil
+}
+
+/* Path of the certificate of a peer node, refreshing the Secret if it's not there yet */
+func (p *IpsecProvider) getIKEv2PeerCertFile(peerName string) (string, error) {
+	certFile := ikev2CertFile(peerName)
+	data, err := ioutil.ReadFile(certFile)
+	if os.IsNotExist(err) && config.IPSecIkev2CertSecret != "" {
+		err = p.syncIKEv2Certificates()
+		if err != nil {
+			return "", err
+		}
+		data, err = ioutil.ReadFile(certFile)
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "error reading certificate of node %s", peerName)
+	}
+	err = checkIKEv2Certificate(data, peerName, p.ikev2Roots)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid certificate %s", certFile)
+	}
+	return certFile, nil
+}
+
+func (p *IpsecProvider) setIKEv2CertAuth(tunnel *IpsecTunnel, peerName string) (err error) {
+	if !p.localKeySet {
+		err = p.setupIKEv2LocalKey()
+		if err != nil {
+			return err
+		}
+	}
+	certFile, err := p.getIKEv2PeerCertFile(peerName)
+	if err != nil {
+		return err
+	}
+	err = p.vpp.SetIKEv2CertAuth(tunnel.Profile(), certFile)
+	if err != nil {
+		return err
+	}
+	err = p.vpp.SetIKEv2LocalIDFQDN(tunnel.Profile(), config.NodeName)
+	if err != nil {
+		return err
+	}
+	return p.vpp.SetIKEv2RemoteIDFQDN(tunnel.Profile(), peerName)
+}
@@ -0,0 +1,227 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/common"
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/config"
+)
+
+/**
+ * When the PSK comes from CALICOVPP_IPSEC_IKEV2_PSK_SECRET, it can be rotated
+ * without restarting the agents. The secret holds the current key and
+ * optionally the next one. We remember the key it replaced as previous.
+ *
+ * Each key comes with a random ID stored next to it in the secret (psk-id,
+ * psk-next-id), which reveals nothing about the key. Each node publishes the
+ * IDs of the keys it knows in an annotation on its Kubernetes node. Towards
+ * a peer, we use the first of [next, current, previous] that the peer also
+ * knows, so both ends agree while the secret update propagates. A rotation is thus:
+ *  - add psk-next & psk-next-id to the secret, peers switch once both ends
+ *    have it
+ *  - move psk-next & psk-next-id to psk & psk-id once all peers report being
+ *    rotated
+ * Keys without ID are not published, peers then use the current key.
+ *
+ * Switching keys only changes the IKEv2 profile auth, existing SAs are kept,
+ * the new key is used on the next IKE negotiation.
+ */
+const (
+	IPsecPSKIDsAnnotation = "cni.projectcalico.org/vpp.ipsec.psk-ids"
+)
+
+/* The ID of a key, empty if the secret gives none */
+func (p *IpsecProvider) pskID(psk string) string {
+	return p.pskIDs[psk]
+}
+
+func usePSKRotation() bool {
+	return config.IPSecIkev2PskSecret != "" && !useIKEv2Certificates()
+}
+
+/* Our keys by order of preference */
+func (p *IpsecProvider) localPSKs() []string {
+	psks := make([]string, 0, 3)
+	for _, psk := range []string{p.pskNext, p.pskCurrent, p.pskPrevious} {
+		if psk != "" && (len(psks) == 0 || psks[len(psks)-1] != psk) {
+			psks = append(psks, psk)
+		}
+	}
+	return psks
+}
+
+func (p *IpsecProvider) choosePSK(peerPSKIDs string) string {
+	if peerPSKIDs == "" {
+		/* Peer doesn't advertise its keys, assume it has the current one */
+		return p.pskCurrent
+	}
+	ids := make(map[string]bool)
+	for _, id := range strings.Split(peerPSKIDs, ",") {
+		ids[id] = true
+	}
+	for _, psk := range p.localPSKs() {
+		if id := p.pskID(psk); id != "" && ids[id] {
+			return psk
+		}
+	}
+	return p.pskCurrent
+}
+
+func (p *IpsecProvider) localPSKIDs() []string {
+	ids := make([]string, 0, 3)
+	for _, psk := range p.localPSKs() {
+		if id := p.pskID(psk); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (p *IpsecProvider) publishPSKIDs() error {
+	ids := strings.Join(p.localPSKIDs(), ",")
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, IPsecPSKIDsAnnotation, ids)
+	_, err := p.server.k8sclient.CoreV1().Nodes().Patch(context.Background(), config.NodeName,
+		k8stypes.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "error annotating node %s", config.NodeName)
+	}
+	return nil
+}
+
+/* getPeerPSKIDs returns the key IDs the peer with address nextHop publishes, from the node cache */
+func (p *IpsecProvider) getPeerPSKIDs(nextHop net.IP) string {
+	peerNode := p.GetNodeByIp(nextHop)
+	if peerNode == nil || p.server.nodeLister == nil {
+		return ""
+	}
+	node, err := p.server.nodeLister.Get(peerNode.Name)
+	if err != nil {
+		return ""
+	}
+	return node.Annotations[IPsecPSKIDsAnnotation]
+}
+
+/* getPeerPSK returns the key to use towards the node with address nextHop */
+func (p *IpsecProvider) getPeerPSK(nextHop net.IP) (string, error) {
+	if !usePSKRotation() || p.pskCurrent == "" {
+		/* Until we read the secret, use the PSK from the environment if any */
+		if config.IPSecIkev2Psk == "" {
+			return "", fmt.Errorf("no PSK known yet, waiting for secret %s", config.IPSecIkev2PskSecret)
+		}
+		return config.IPSecIkev2Psk, nil
+	}
+	return p.choosePSK(p.getPeerPSKIDs(nextHop)), nil
+}
+
+func (p *IpsecProvider) setPeerPSK(nextHop string, psk string) error {
+	for _, tunnel := range p.ipsecIfs[nextHop] {
+		err := p.vpp.SetIKEv2PSKAuth(tunnel.Profile(), psk)
+		if err != nil {
+			return errors.Wrapf(err, "error setting PSK on profile %s", tunnel.Profile())
+		}
+	}
+	p.peerPSKs[nextHop] = psk
+	return nil
+}
+
+/* updatePSKs rotates our keys and their IDs, the key we are leaving keeps its ID */
+func (p *IpsecProvider) updatePSKs(psks *common.IPsecPSKs) {
+	if p.pskCurrent != "" && p.pskCurrent != psks.Current {
+		p.pskPrevious = p.pskCurrent
+	}
+	p.pskCurrent = psks.Current
+	p.pskNext = psks.Next
+	if p.pskPrevious == p.pskCurrent || p.pskPrevious == p.pskNext {
+		p.pskPrevious = ""
+	}
+	pskIDs := make(map[string]string)
+	if p.pskPrevious != "" {
+		pskIDs[p.pskPrevious] = p.pskIDs[p.pskPrevious]
+	}
+	pskIDs[psks.Current] = psks.CurrentID
+	if psks.Next != "" {
+		pskIDs[psks.Next] = psks.NextID
+	}
+	p.pskIDs = pskIDs
+}
+
+/* SetPSKs is called when the PSK secret changes */
+func (p *IpsecProvider) SetPSKs(psks *common.IPsecPSKs) {
+	p.updatePSKs(psks)
+	p.log.Infof("psk(upd) keys %v", p.localPSKIDs())
+	err := p.publishPSKIDs()
+	if err != nil {
+		p.log.Errorf("Error publishing PSK IDs: %v", err)
+	}
+	p.pskRotationPending = true
+	p.ReconcilePSKs()
+}
+
+/**
+ * ReconcilePSKs switches each peer to the preferred key both ends know.
+ * It runs on PSK changes and whenever a peer publishes new key IDs, as
+ * peers get the secret update at different times.
+ */
+func (p *IpsecProvider) ReconcilePSKs() {
+	if !usePSKRotation() || !p.pskRotationPending || p.pskCurrent == "" {
+		return
+	}
+	preferred := p.localPSKs()[0]
+	pending := false
+	for nextHop := range p.ipsecIfs {
+		psk := p.choosePSK(p.getPeerPSKIDs(net.ParseIP(nextHop)))
+		if psk != p.peerPSKs[nextHop] {
+			p.log.Infof("psk(rotate) peer %s now using key %s", nextHop, p.pskID(psk))
+			err := p.setPeerPSK(nextHop, psk)
+			if err != nil {
+				p.log.Errorf("Error rotating PSK towards %s: %v", nextHop, err)
+				pending = true
+				continue
+			}
+		}
+		if psk != preferred {
+			pending = true
+		}
+	}
+	p.pskRotationPending = pending
+	if !pending {
+		p.log.Infof("psk(rotate) all peers use key %s", p.pskID(preferred))
+	}
+}
+
+/* Rotation progress towards a peer, reported in its connectivity status */
+func (p *IpsecProvider) getPSKStatus(nextHop string) string {
+	if !usePSKRotation() {
+		return ""
+	}
+	psk, found := p.peerPSKs[nextHop]
+	if !found || len(p.localPSKs()) == 0 {
+		return ""
+	}
+	if psk != p.localPSKs()[0] {
+		return fmt.Sprintf("psk %s (rotation to %s pending)", p.pskID(psk), p.pskID(p.localPSKs()[0]))
+	}
+	return fmt.Sprintf("psk %s", p.pskID(psk))
+}
@@ -0,0 +1,180 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"net"
+	"sort"
+	"time"
+
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/common"
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/config"
+	"github.com/projectcalico/vpp-dataplane/vpplink/types"
+)
+
+/**
+ * We periodically look at the SAs protecting each IPsec tunnel, to log
+ * when IKEv2 establishes new ones (initial negotiation or rekey) and when
+ * they go away. The last snapshot is what the prometheus server exports,
+ * together with the SA counters it reads from the stats segment. The
+ * protections of all the tunnels are dumped at once, as there are
+ * IpsecTunsPerNode tunnels per remote node.
+ */
+const ipsecSAPollInterval = 5 * time.Second
+
+/* IKE SAs are not tied to the tunnel, match them with the tunnel endpoints */
+func findIKEv2SA(tunnel *IpsecTunnel, ikeSAs []types.IKEv2SA) *types.IKEv2SA {
+	var found *types.IKEv2SA
+	for i := range ikeSAs {
+		sa := &ikeSAs[i]
+		if (sa.Iaddr.Equal(tunnel.Src) && sa.Raddr.Equal(tunnel.Dst)) ||
+			(sa.Iaddr.Equal(tunnel.Dst) && sa.Raddr.Equal(tunnel.Src)) {
+			/* While rekeying there may be two, prefer the latest */
+			if found == nil || sa.SaIndex > found.SaIndex {
+				found = sa
+			}
+		}
+	}
+	return found
+}
+
+/* indexTunnelProtections groups the protections by tunnel interface */
+func indexTunnelProtections(protections []types.IPsecTunnelProtection) map[uint32][]types.IPsecTunnelProtection {
+	bySwIfIndex := make(map[uint32][]types.IPsecTunnelProtection)
+	for _, protection := range protections {
+		bySwIfIndex[protection.SwIfIndex] = append(bySwIfIndex[protection.SwIfIndex], protection)
+	}
+	return bySwIfIndex
+}
+
+func getTunnelStatus(nextHop string, tunnel *IpsecTunnel, protections []types.IPsecTunnelProtection, sas map[uint32]types.IPsecSA, ikeSAs []types.IKEv2SA) common.IPsecTunnelStatus {
+	status := common.IPsecTunnelStatus{
+		NextHop:    net.ParseIP(nextHop),
+		Tunnel:     tunnel.Profile(),
+		SwIfIndex:  tunnel.SwIfIndex,
+		InSAs:      make([]types.IPsecSA, 0),
+		IKESA:      findIKEv2SA(tunnel, ikeSAs),
+		SALifetime: config.IPSecIkev2Transforms.LifetimeDuration,
+	}
+	for _, protection := range protections {
+		if sa, found := sas[protection.OutSAIndex]; found {
+			sa := sa
+			status.OutSA = &sa
+		}
+		for _, saID := range protection.InSAIndices {
+			if sa, found := sas[saID]; found {
+				status.InSAs = append(status.InSAs, sa)
+			}
+		}
+	}
+	return status
+}
+
+/* VPP doesn't tell how old SAs are, we date them from the first poll that sees them */
+func setOutSAEstablished(old *common.IPsecTunnelStatus, new *common.IPsecTunnelStatus, now time.Time) {
+	if new.OutSA == nil {
+		return
+	}
+	if old != nil && old.OutSA != nil && old.OutSA.Spi == new.OutSA.Spi {
+		new.OutSAEstablished = old.OutSAEstablished
+	} else {
+		new.OutSAEstablished = now
+	}
+}
+
+func ipsecSPIs(status *common.IPsecTunnelStatus) map[uint32]string {
+	spis := make(map[uint32]string)
+	if status.OutSA != nil {
+		spis[status.OutSA.Spi] = "out"
+	}
+	for _, sa := range status.InSAs {
+		spis[sa.Spi] = "in"
+	}
+	return spis
+}
+
+func (p *IpsecProvider) logSAChanges(old *common.IPsecTunnelStatus, new *common.IPsecTunnelStatus) {
+	oldSPIs := make(map[uint32]string)
+	if old != nil {
+		oldSPIs = ipsecSPIs(old)
+	}
+	newSPIs := ipsecSPIs(new)
+	for spi, direction := range newSPIs {
+		if _, found := oldSPIs[spi]; !found {
+			p.log.Infof("ipsec(sa) established tunnel=%s peer=%s spi=0x%08x dir=%s", new.Tunnel, new.NextHop, spi, direction)
+		}
+	}
+	for spi, direction := range oldSPIs {
+		if _, found := newSPIs[spi]; !found {
+			p.log.Infof("ipsec(sa) expired tunnel=%s peer=%s spi=0x%08x dir=%s", new.Tunnel, new.NextHop, spi, direction)
+		}
+	}
+	if old != nil && old.IKESA != nil && new.IKESA != nil && new.IKESA.NRekeyReq > old.IKESA.NRekeyReq {
+		p.log.Infof("ipsec(ike) rekeyed tunnel=%s peer=%s rekeys=%d", new.Tunnel, new.NextHop, new.IKESA.NRekeyReq)
+	}
+}
+
+/* PollSAs refreshes the SAs snapshot of our tunnels, logging what changed */
+func (p *IpsecProvider) PollSAs() {
+	saList, err := p.vpp.ListIPsecSAs()
+	if err != nil {
+		p.log.Errorf("Error listing IPsec SAs: %v", err)
+		return
+	}
+	sas := make(map[uint32]types.IPsecSA)
+	for _, sa := range saList {
+		sas[sa.SadID] = sa
+	}
+	ikeSAs, err := p.vpp.ListIKEv2SAs()
+	if err != nil {
+		p.log.Errorf("Error listing IKEv2 SAs: %v", err)
+	}
+	protections, err := p.vpp.ListIPsecTunnelProtections()
+	if err != nil {
+		p.log.Errorf("Error listing IPsec tunnel protections: %v", err)
+		return
+	}
+	protectionsBySwIfIndex := indexTunnelProtections(protections)
+
+	now := time.Now()
+	tunnelStatus := make(map[string]common.IPsecTunnelStatus)
+	for nextHop, tunnels := range p.ipsecIfs {
+		for i := range tunnels {
+			tunnel := &tunnels[i]
+			status := getTunnelStatus(nextHop, tunnel, protectionsBySwIfIndex[tunnel.SwIfIndex], sas, ikeSAs)
+			if old, found := p.tunnelStatus[status.Tunnel]; found {
+				setOutSAEstablished(&old, &status, now)
+				p.logSAChanges(&old, &status)
+			} else {
+				setOutSAEstablished(nil, &status, now)
+				p.logSAChanges(nil, &status)
+			}
+			tunnelStatus[status.Tunnel] = status
+		}
+	}
+	p.tunnelStatus = tunnelStatus
+}
+
+func (p *IpsecProvider) GetTunnelStatus() []common.IPsecTunnelStatus {
+	statuses := make([]common.IPsecTunnelStatus, 0, len(p.tunnelStatus))
+	for _, status := range p.tunnelStatus {
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Tunnel < statuses[j].Tunnel
+	})
+	return statuses
+}
@@ -0,0 +1,222 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/common"
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/config"
+	"github.com/projectcalico/vpp-dataplane/vpplink"
+
+	types2 "git.fd.io/govpp.git/api/v0"
+)
+
+/**
+ * Tunnels get the uplink MTU minus their overhead, so that VPP fragments
+ * or answers ICMP too big for packets that would not fit once encapsulated.
+ * Pod interfaces are unnumbered to their loopback, which gives the ICMP
+ * errors a source address. With CALICOVPP_TUNNEL_MSS_CLAMP we also clamp
+ * the MSS of TCP SYNs sent in the tunnels, so that TCP doesn't depend on
+ * ICMP reaching the pods. Providers set both when they create a tunnel
+ * (e.g. also on wireguard key rotations), and we set them again on each
+ * connectivity as the overhead can depend on the peer.
+ */
+func (s *ConnectivityServer) setConnectivityMtu(providerType string, cn *common.NodeConnectivity) {
+	provider := s.providers[providerType]
+	overhead := provider.EncapOverhead(cn)
+	if overhead == 0 || config.HostMtu == 0 {
+		return
+	}
+	tunnelProvider, ok := provider.(TunnelProvider)
+	if !ok {
+		return
+	}
+	for _, swIfIndex := range tunnelProvider.GetTunnelSwIfIndexes(cn.NextHop) {
+		s.setTunnelMtu(providerType, swIfIndex, overhead)
+	}
+}
+
+func (s *ConnectivityServer) setTunnelMtu(providerType string, swIfIndex uint32, overhead int) {
+	if swIfIndex == vpplink.InvalidID || config.HostMtu == 0 {
+		return
+	}
+	mtu := config.HostMtu - overhead
+	err := s.vpp.SetInterfaceMtu(&types2.Interface{SwIfIndex: swIfIndex}, mtu)
+	if err != nil {
+		s.log.Errorf("Error setting MTU %d on %s tunnel %d: %v", mtu, providerType, swIfIndex, err)
+	}
+	if config.EnableTunnelMSSClamp {
+		err = s.vpp.EnableMSSClamp(swIfIndex, uint16(mtu-ip4TCPHeadersSize), uint16(mtu-ip6TCPHeadersSize))
+		if err != nil {
+			s.log.Errorf("Error clamping MSS on %s tunnel %d: %v", providerType, swIfIndex, err)
+		}
+	}
+}
+
+/**
+ * releaseTunnelMtu undoes setTunnelMtu, providers call it before deleting
+ * a tunnel so that VPP doesn't keep clamping a reused interface index.
+ */
+func (s *ConnectivityServer) releaseTunnelMtu(providerType string, swIfIndex uint32) {
+	if swIfIndex == vpplink.InvalidID || !config.EnableTunnelMSSClamp {
+		return
+	}
+	err := s.vpp.DisableMSSClamp(swIfIndex)
+	if err != nil {
+		s.log.Errorf("Error removing MSS clamp on %s tunnel %d: %v", providerType, swIfIndex, err)
+	}
+}
+
+/**
+ * configuredProviderTypes are the providers the configuration will use
+ * whatever the peers, so that pods created before we know them get a
+ * MTU that fits. felix enables IPIP & VXLAN when IPPools use them.
+ */
+func (s *ConnectivityServer) configuredProviderTypes() []string {
+	providerTypes := make([]string, 0)
+	if s.felixConfig != nil {
+		if s.felixConfig.IpInIpEnabled {
+			providerTypes = append(providerTypes, IPIP)
+		}
+		if s.felixConfig.VXLANEnabled {
+			providerTypes = append(providerTypes, VXLAN)
+		}
+		if s.felixConfig.WireguardEnabled {
+			providerTypes = append(providerTypes, WIREGUARD)
+		}
+	}
+	if config.EnableIPSec {
+		providerTypes = append(providerTypes, IPSEC)
+	}
+	if config.EnableSRv6 {
+		providerTypes = append(providerTypes, SRv6)
+	}
+	if providerType := s.getEncapsulationProviderType(config.DefaultEncapsulation); providerType != "" {
+		providerTypes = append(providerTypes, providerType)
+	}
+	return providerTypes
+}
+
+/* Pods get the MTU of the worst case among the connectivity configured or in use */
+func (s *ConnectivityServer) computeEncapOverhead() int {
+	encapOverhead := 0
+	ip4, ip6 := s.GetNodeIPs()
+	for _, providerType := range s.configuredProviderTypes() {
+		provider, found := s.providers[providerType]
+		if !found {
+			continue
+		}
+		/* The overhead depends on the address family of the peers */
+		for _, addr := range []*net.IP{ip4, ip6} {
+			if addr == nil {
+				continue
+			}
+			if overhead := provider.EncapOverhead(&common.NodeConnectivity{NextHop: *addr}); overhead > encapOverhead {
+				encapOverhead = overhead
+			}
+		}
+	}
+	for _, cn := range s.connectivityMap {
+		provider, found := s.providers[cn.ResolvedProvider]
+		if !found {
+			continue
+		}
+		cn := cn
+		if overhead := provider.EncapOverhead(&cn); overhead > encapOverhead {
+			encapOverhead = overhead
+		}
+	}
+	return encapOverhead
+}
+
+func (s *ConnectivityServer) updateEncapOverhead() {
+	encapOverhead := s.computeEncapOverhead()
+	if encapOverhead == s.encapOverhead {
+		return
+	}
+	s.log.Infof("connectivity(upd) encapsulation overhead %d->%d", s.encapOverhead, encapOverhead)
+	s.encapOverhead = encapOverhead
+	common.SendEvent(common.CalicoVppEvent{
+		Type: common.EncapOverheadChanged,
+		New:  encapOverhead,
+	})
+}
+
+/**
+ * vpp-manager routes the IPPools from the host through VPP with the worst
+ * case MTU. We add more specific routes to the pod prefixes of each peer,
+ * with the MTU of the connectivity in use towards it, the smallest one if
+ * several next hops lead there.
+ */
+func (s *ConnectivityServer) getHostRouteMtu(dst net.IPNet) int {
+	mtu := 0
+	for _, cn := range s.connectivityMap {
+		if cn.Dst.String() != dst.String() {
+			continue
+		}
+		provider, found := s.providers[cn.ResolvedProvider]
+		if !found {
+			continue
+		}
+		cn := cn
+		if cnMtu := config.HostMtu - provider.EncapOverhead(&cn); mtu == 0 || cnMtu < mtu {
+			mtu = cnMtu
+		}
+	}
+	return mtu
+}
+
+func (s *ConnectivityServer) syncHostRouteMtu(dst net.IPNet) {
+	if config.HostMtu == 0 {
+		return
+	}
+	mtu := s.getHostRouteMtu(dst)
+	if mtu == s.hostRouteMtus[dst.String()] {
+		return
+	}
+	if mtu == 0 {
+		s.log.Infof("connectivity(del) host route to %s", dst.String())
+		err := netlink.RouteDel(&netlink.Route{Dst: &dst, Protocol: syscall.RTPROT_STATIC})
+		if err != nil {
+			s.log.Warnf("Error deleting host route to %s: %v", dst.String(), err)
+		}
+		delete(s.hostRouteMtus, dst.String())
+		return
+	}
+	/* Go through VPP as the IPPool route does */
+	routes, err := netlink.RouteGet(dst.IP)
+	if err != nil || len(routes) == 0 {
+		s.log.Warnf("Error getting the host route to %s: %v", dst.String(), err)
+		return
+	}
+	s.log.Infof("connectivity(upd) host route to %s mtu %d", dst.String(), mtu)
+	err = netlink.RouteReplace(&netlink.Route{
+		Dst:       &dst,
+		Gw:        routes[0].Gw,
+		LinkIndex: routes[0].LinkIndex,
+		Protocol:  syscall.RTPROT_STATIC,
+		MTU:       mtu,
+	})
+	if err != nil {
+		s.log.Warnf("Error setting the host route to %s: %v", dst.String(), err)
+		return
+	}
+	s.hostRouteMtus[dst.String()] = mtu
+}
@@ -0,0 +1,100 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"net"
+	"testing"
+
+	felixConfig "github.com/projectcalico/calico/felix/config"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/common"
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/config"
+)
+
+/* Server whose providers have the overheads in overheads */
+func newTestMtuServer(overheads map[string]int) *ConnectivityServer {
+	s := newTestProviderServer(nil)
+	s.connectivityMap = make(map[string]common.NodeConnectivity)
+	for name, overhead := range overheads {
+		s.providers[name] = &testProvider{overhead: overhead}
+	}
+	return s
+}
+
+var computeEncapOverheadTests = []struct {
+	name         string
+	felixConfig  *felixConfig.Config
+	enableIPSec  bool
+	defaultEncap string
+	inUse        []string
+	overhead     int
+}{
+	{"nothing configured", nil, false, "", nil, 0},
+	{"ipip", &felixConfig.Config{IpInIpEnabled: true}, false, "", nil, 20},
+	{"vxlan & ipip", &felixConfig.Config{IpInIpEnabled: true, VXLANEnabled: true}, false, "", nil, 50},
+	{"ipsec", &felixConfig.Config{IpInIpEnabled: true}, true, "", nil, 60},
+	{"default geneve", nil, false, EncapGeneve, nil, 36},
+	{"unknown default encapsulation", nil, false, "foo", nil, 0},
+	{"in use", &felixConfig.Config{IpInIpEnabled: true}, false, "", []string{GRE}, 24},
+	{"in use smaller than configured", &felixConfig.Config{VXLANEnabled: true}, false, "", []string{GRE}, 50},
+}
+
+func TestComputeEncapOverhead(t *testing.T) {
+	enableIPSec := config.EnableIPSec
+	defaultEncap := config.DefaultEncapsulation
+	defer func() {
+		config.EnableIPSec = enableIPSec
+		config.DefaultEncapsulation = defaultEncap
+	}()
+	for _, test := range computeEncapOverheadTests {
+		config.EnableIPSec = test.enableIPSec
+		config.DefaultEncapsulation = test.defaultEncap
+		s := newTestMtuServer(map[string]int{IPIP: 20, VXLAN: 50, GENEVE: 36, GRE: 24, IPSEC: 60})
+		s.felixConfig = test.felixConfig
+		for i, providerType := range test.inUse {
+			s.connectivityMap[providerType] = common.NodeConnectivity{
+				NextHop:          net.IPv4(10, 1, 0, byte(i+2)),
+				ResolvedProvider: providerType,
+			}
+		}
+		assert.Equal(t, test.overhead, s.computeEncapOverhead(), test.name)
+	}
+}
+
+func TestGetHostRouteMtu(t *testing.T) {
+	hostMtu := config.HostMtu
+	defer func() { config.HostMtu = hostMtu }()
+	config.HostMtu = 1500
+
+	s := newTestMtuServer(map[string]int{FLAT: 0, VXLAN: 50, IPSEC: 60})
+	_, dst, _ := net.ParseCIDR("10.2.0.0/24")
+	_, otherDst, _ := net.ParseCIDR("10.3.0.0/24")
+	assert.Equal(t, 0, s.getHostRouteMtu(*dst), "no connectivity")
+
+	s.connectivityMap["a"] = common.NodeConnectivity{Dst: *dst, NextHop: net.ParseIP("10.1.0.2"), ResolvedProvider: VXLAN}
+	s.connectivityMap["b"] = common.NodeConnectivity{Dst: *otherDst, NextHop: net.ParseIP("10.1.0.3"), ResolvedProvider: IPSEC}
+	assert.Equal(t, 1450, s.getHostRouteMtu(*dst))
+	assert.Equal(t, 1440, s.getHostRouteMtu(*otherDst))
+
+	/* Several next hops, the smallest MTU wins */
+	s.connectivityMap["c"] = common.NodeConnectivity{Dst: *dst, NextHop: net.ParseIP("10.1.0.4"), ResolvedProvider: IPSEC}
+	assert.Equal(t, 1440, s.getHostRouteMtu(*dst))
+
+	s.connectivityMap["d"] = common.NodeConnectivity{Dst: *otherDst, NextHop: net.ParseIP("10.0.0.2"), ResolvedProvider: FLAT}
+	assert.Equal(t, 1440, s.getHostRouteMtu(*otherDst))
+}
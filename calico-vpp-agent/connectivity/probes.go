@@ -0,0 +1,412 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	tomb "gopkg.in/tomb.v2"
+
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/common"
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/config"
+	"github.com/projectcalico/vpp-dataplane/vpplink"
+	"github.com/projectcalico/vpp-dataplane/vpplink/types"
+
+	types2 "git.fd.io/govpp.git/api/v0"
+)
+
+/**
+ * With CALICOVPP_PROBES_ENABLED, every CALICOVPP_PROBE_INTERVAL we send a
+ * UDP probe to each remote node we have connectivity to, and the agent
+ * there sends it back.
+ * Probes are injected in VPP through a punt socket, and routed in the pod
+ * VRF as if they came from a pod, so that they take the path pods use to
+ * reach the remote node: the tunnel of its provider, or the uplink for
+ * flat connectivity. They are sent to the node address on
+ * CALICOVPP_PROBE_PORT, which VPP punts to the agent, answers come back
+ * the same way. This needs a punt { socket } section in the VPP
+ * configuration, that vpp-manager adds when CALICOVPP_PROBES_ENABLED is
+ * also set on the vpp container, and probes enabled on the remote nodes.
+ * A probe not answered before the next one is sent is lost, a peer is
+ * unreachable when its last probeUnreachableCount probes were lost.
+ */
+const (
+	probeLoopbackTag = "calico-probe"
+
+	probeWindow           = 10
+	probeUnreachableCount = 3
+
+	probeMagic       = 0xca1c0771
+	probeRequest     = 1
+	probeReply       = 2
+	probePayloadSize = 12
+
+	ip4HeaderSize = 20
+	ip6HeaderSize = 40
+	udpHeaderSize = 8
+)
+
+type peerProbe struct {
+	NextHop  net.IP
+	Provider string
+	Rtt      time.Duration
+	Sent     uint64
+	Lost     uint64
+
+	seq     uint32
+	pending bool
+	sentAt  time.Time
+	/* answered or not, for the last probeWindow probes */
+	results []bool
+}
+
+func (p *peerProbe) addResult(answered bool) {
+	p.results = append(p.results, answered)
+	if len(p.results) > probeWindow {
+		p.results = p.results[1:]
+	}
+	if !answered {
+		p.Lost++
+	}
+}
+
+func (p *peerProbe) isReachable() bool {
+	for i := len(p.results) - 1; i >= 0 && i >= len(p.results)-probeUnreachableCount; i-- {
+		if p.results[i] {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *peerProbe) loss() float64 {
+	lost := 0
+	for _, answered := range p.results {
+		if !answered {
+			lost++
+		}
+	}
+	return float64(lost) / float64(len(p.results))
+}
+
+/* The loopback we inject probes from, in the pod VRF */
+func (s *ConnectivityServer) getProbeLoopback() (uint32, error) {
+	swIfIndex, err := s.vpp.SearchInterfaceWithTag(probeLoopbackTag)
+	if err != nil {
+		return vpplink.InvalidID, errors.Wrap(err, "error searching probe loopback")
+	}
+	if swIfIndex != vpplink.InvalidID {
+		return swIfIndex, nil
+	}
+	swIfIndex, err = s.vpp.CreateLoopback(&common.ContainerSideMacAddress)
+	if err != nil {
+		return vpplink.InvalidID, errors.Wrap(err, "error creating probe loopback")
+	}
+	iface := types2.Interface{SwIfIndex: swIfIndex}
+	err = s.vpp.SetInterfaceTag(&iface, probeLoopbackTag)
+	if err != nil {
+		return vpplink.InvalidID, errors.Wrapf(err, "error tagging probe loopback %d", swIfIndex)
+	}
+	for _, ipFamily := range vpplink.IpFamilies {
+		err = s.vpp.SetInterfaceVRF(&iface, common.PodVRFIndex, ipFamily.IsIp6)
+		if err != nil {
+			return vpplink.InvalidID, errors.Wrapf(err, "error setting probe loopback %d in pod vrf", swIfIndex)
+		}
+	}
+	err = s.vpp.InterfaceAdminUp(&iface)
+	if err != nil {
+		return vpplink.InvalidID, errors.Wrapf(err, "error setting probe loopback %d up", swIfIndex)
+	}
+	return swIfIndex, nil
+}
+
+func (s *ConnectivityServer) startProbes(t *tomb.Tomb) error {
+	swIfIndex, err := s.getProbeLoopback()
+	if err != nil {
+		return err
+	}
+	s.probeSwIfIndex = swIfIndex
+
+	err = os.RemoveAll(config.ProbeSocket)
+	if err != nil {
+		return errors.Wrapf(err, "error removing %s", config.ProbeSocket)
+	}
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: config.ProbeSocket, Net: "unixgram"})
+	if err != nil {
+		return errors.Wrapf(err, "error listening on %s", config.ProbeSocket)
+	}
+	ip4, ip6 := s.GetNodeIPs()
+	for _, ipFamily := range vpplink.IpFamilies {
+		if (ipFamily.IsIp6 && ip6 == nil) || (!ipFamily.IsIp6 && ip4 == nil) {
+			continue
+		}
+		vppSocket, err := s.vpp.PuntSocketRegister(types.UDP, uint16(config.ProbePort), ipFamily.IsIp6, config.ProbeSocket)
+		if err != nil {
+			s.deregisterProbePuntSockets()
+			conn.Close()
+			return errors.Wrapf(err, "error registering %s probe punt socket", ipFamily.Str)
+		}
+		s.probePuntFamilies = append(s.probePuntFamilies, ipFamily.IsIp6)
+		s.probeVppSocket = &net.UnixAddr{Name: vppSocket, Net: "unixgram"}
+	}
+	if s.probeVppSocket == nil {
+		conn.Close()
+		return fmt.Errorf("no node address to probe from")
+	}
+	s.probeConn = conn
+	t.Go(s.receiveProbes)
+	return nil
+}
+
+func (s *ConnectivityServer) deregisterProbePuntSockets() {
+	for _, isIp6 := range s.probePuntFamilies {
+		err := s.vpp.PuntSocketDeregister(types.UDP, uint16(config.ProbePort), isIp6)
+		if err != nil {
+			s.log.Errorf("Error deregistering probe punt socket (ip6=%t): %v", isIp6, err)
+		}
+	}
+	s.probePuntFamilies = nil
+	s.probeVppSocket = nil
+}
+
+func (s *ConnectivityServer) stopProbes() {
+	s.deregisterProbePuntSockets()
+	if s.probeConn != nil {
+		s.probeConn.Close()
+		s.probeConn = nil
+	}
+}
+
+/* sendProbes is called with s.lock held */
+func (s *ConnectivityServer) sendProbes() {
+	peers := make(map[string]string)
+	for _, cn := range s.connectivityMap {
+		peers[cn.NextHop.String()] = cn.ResolvedProvider
+	}
+	for nextHop, probe := range s.probes {
+		if provider, found := peers[nextHop]; !found || provider != probe.Provider {
+			delete(s.probes, nextHop)
+		}
+	}
+
+	ip4, ip6 := s.GetNodeIPs()
+	for nextHop, provider := range peers {
+		probe, found := s.probes[nextHop]
+		if !found {
+			probe = &peerProbe{
+				NextHop:  net.ParseIP(nextHop),
+				Provider: provider,
+				results:  make([]bool, 0, probeWindow+1),
+			}
+			s.probes[nextHop] = probe
+		}
+		if probe.pending {
+			probe.addResult(false)
+		}
+		src := ip4
+		if vpplink.IsIP6(probe.NextHop) {
+			src = ip6
+		}
+		if src == nil {
+			continue
+		}
+		probe.seq++
+		err := s.sendProbePacket(*src, probe.NextHop, probeRequest, probe.seq)
+		if err != nil {
+			s.log.Errorf("Error sending probe to %s: %v", nextHop, err)
+			probe.pending = false
+			continue
+		}
+		probe.pending = true
+		probe.sentAt = time.Now()
+		probe.Sent++
+	}
+}
+
+func (s *ConnectivityServer) sendProbePacket(src net.IP, dst net.IP, probeType uint8, seq uint32) error {
+	desc := types.PuntPacketDesc{SwIfIndex: s.probeSwIfIndex, Action: types.PuntIP4Routed}
+	if vpplink.IsIP6(dst) {
+		desc.Action = types.PuntIP6Routed
+	}
+	packet := make([]byte, types.PuntPacketDescSize, types.PuntPacketDescSize+ip6HeaderSize+udpHeaderSize+probePayloadSize)
+	desc.Encode(packet)
+	packet = append(packet, buildProbePacket(src, dst, probeType, seq)...)
+	_, err := s.probeConn.WriteToUnix(packet, s.probeVppSocket)
+	return err
+}
+
+func (s *ConnectivityServer) receiveProbes() error {
+	buf := make([]byte, 2048)
+	for {
+		n, err := s.probeConn.Read(buf)
+		if err != nil {
+			/* The socket is closed when we stop */
+			s.log.Infof("Stopped receiving probes: %v", err)
+			return nil
+		}
+		now := time.Now()
+		if n < types.PuntPacketDescSize {
+			continue
+		}
+		src, dst, probeType, seq, err := parseProbePacket(buf[types.PuntPacketDescSize:n])
+		if err != nil {
+			s.log.Debugf("Ignoring punted packet: %v", err)
+			continue
+		}
+		switch probeType {
+		case probeRequest:
+			err = s.sendProbePacket(dst, src, probeReply, seq)
+			if err != nil {
+				s.log.Errorf("Error answering probe from %s: %v", src, err)
+			}
+		case probeReply:
+			s.lock.Lock()
+			probe, found := s.probes[src.String()]
+			if found && probe.pending && probe.seq == seq {
+				probe.pending = false
+				probe.Rtt = now.Sub(probe.sentAt)
+				probe.addResult(true)
+			}
+			s.lock.Unlock()
+		}
+	}
+}
+
+/* GetProbeStatus returns the probe results for each remote node */
+func (s *ConnectivityServer) GetProbeStatus() []common.PeerProbeStatus {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	statuses := make([]common.PeerProbeStatus, 0, len(s.probes))
+	for _, probe := range s.probes {
+		if len(probe.results) == 0 {
+			/* Wait for the first probe to complete */
+			continue
+		}
+		status := common.PeerProbeStatus{
+			NextHop:   probe.NextHop,
+			Provider:  probe.Provider,
+			Reachable: probe.isReachable(),
+			Rtt:       probe.Rtt,
+			Sent:      probe.Sent,
+			Lost:      probe.Lost,
+			Loss:      probe.loss(),
+		}
+		if node := s.GetNodeByIp(probe.NextHop); node != nil {
+			status.NodeName = node.Name
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].NextHop.String() < statuses[j].NextHop.String()
+	})
+	return statuses
+}
+
+func checksumAdd(sum uint32, b []byte) uint32 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	return sum
+}
+
+func checksumFold(sum uint32) uint16 {
+	for sum>>16 != 0 {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
+
+/* buildProbePacket returns the IP packet of a probe, from port to port CALICOVPP_PROBE_PORT */
+func buildProbePacket(src net.IP, dst net.IP, probeType uint8, seq uint32) []byte {
+	udp := make([]byte, udpHeaderSize+probePayloadSize)
+	binary.BigEndian.PutUint16(udp[0:], uint16(config.ProbePort))
+	binary.BigEndian.PutUint16(udp[2:], uint16(config.ProbePort))
+	binary.BigEndian.PutUint16(udp[4:], uint16(len(udp)))
+	binary.BigEndian.PutUint32(udp[8:], probeMagic)
+	udp[12] = probeType
+	binary.BigEndian.PutUint32(udp[16:], seq)
+
+	var header []byte
+	pseudoHeader := make([]byte, 0, 40)
+	if src4, dst4 := src.To4(), dst.To4(); src4 != nil && dst4 != nil {
+		header = make([]byte, ip4HeaderSize)
+		header[0] = 0x45
+		binary.BigEndian.PutUint16(header[2:], uint16(ip4HeaderSize+len(udp)))
+		header[8] = 64 /* ttl */
+		header[9] = byte(types.UDP)
+		copy(header[12:16], src4)
+		copy(header[16:20], dst4)
+		binary.BigEndian.PutUint16(header[10:], checksumFold(checksumAdd(0, header)))
+		pseudoHeader = append(pseudoHeader, header[12:20]...)
+		pseudoHeader = append(pseudoHeader, 0, byte(types.UDP), udp[4], udp[5])
+	} else {
+		header = make([]byte, ip6HeaderSize)
+		header[0] = 0x60
+		binary.BigEndian.PutUint16(header[4:], uint16(len(udp)))
+		header[6] = byte(types.UDP)
+		header[7] = 64 /* hop limit */
+		copy(header[8:24], src.To16())
+		copy(header[24:40], dst.To16())
+		pseudoHeader = append(pseudoHeader, header[8:40]...)
+		pseudoHeader = append(pseudoHeader, 0, 0, udp[4], udp[5], 0, 0, 0, byte(types.UDP))
+	}
+	checksum := checksumFold(checksumAdd(checksumAdd(0, pseudoHeader), udp))
+	if checksum == 0 {
+		checksum = 0xffff
+	}
+	binary.BigEndian.PutUint16(udp[6:], checksum)
+	return append(header, udp...)
+}
+
+/* parseProbePacket checks that packet is a probe, and returns its addresses, type & sequence number */
+func parseProbePacket(packet []byte) (src net.IP, dst net.IP, probeType uint8, seq uint32, err error) {
+	if len(packet) < ip4HeaderSize {
+		return nil, nil, 0, 0, fmt.Errorf("packet too short (%d)", len(packet))
+	}
+	var udp []byte
+	switch packet[0] >> 4 {
+	case 4:
+		headerSize := int(packet[0]&0x0f) * 4
+		if headerSize < ip4HeaderSize || len(packet) < headerSize || packet[9] != byte(types.UDP) {
+			return nil, nil, 0, 0, fmt.Errorf("not an ip4 udp packet")
+		}
+		src, dst = net.IP(packet[12:16]), net.IP(packet[16:20])
+		udp = packet[headerSize:]
+	case 6:
+		if len(packet) < ip6HeaderSize || packet[6] != byte(types.UDP) {
+			return nil, nil, 0, 0, fmt.Errorf("not an ip6 udp packet")
+		}
+		src, dst = net.IP(packet[8:24]), net.IP(packet[24:40])
+		udp = packet[ip6HeaderSize:]
+	default:
+		return nil, nil, 0, 0, fmt.Errorf("unknown ip version %d", packet[0]>>4)
+	}
+	if len(udp) < udpHeaderSize+probePayloadSize || binary.BigEndian.Uint32(udp[8:]) != probeMagic {
+		return nil, nil, 0, 0, fmt.Errorf("not a probe")
+	}
+	/* The buffer is reused, copy the addresses */
+	return append(net.IP{}, src...), append(net.IP{}, dst...), udp[12], binary.BigEndian.Uint32(udp[16:]), nil
+}
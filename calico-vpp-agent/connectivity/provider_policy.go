@@ -0,0 +1,140 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"reflect"
+
+	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+	oldv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/common"
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/config"
+)
+
+/* Warn early about rules that will never apply */
+func (s *ConnectivityServer) checkConnectivityPolicy() {
+	for i, rule := range config.ConnectivityPolicy {
+		if _, found := s.providers[rule.Provider]; !found {
+			s.log.Errorf("Connectivity policy rule %d uses unknown provider %s, it will be ignored", i, rule.Provider)
+		}
+	}
+}
+
+func (s *ConnectivityServer) getOurNode() *oldv3.Node {
+	for _, node := range s.nodeByAddr {
+		if node.Name == config.NodeName {
+			return &node
+		}
+	}
+	return nil
+}
+
+func ruleMatches(rule *config.ConnectivityRule, cn *common.NodeConnectivity, ipPool *calicov3.IPPool, node *oldv3.Node, ourNode *oldv3.Node) bool {
+	if rule.Selector != nil || len(rule.SameLabels) > 0 || len(rule.DifferentLabels) > 0 {
+		if node == nil {
+			return false
+		}
+	}
+	if rule.Selector != nil && !rule.Selector.Evaluate(node.Labels) {
+		return false
+	}
+	if len(rule.SameLabels) > 0 || len(rule.DifferentLabels) > 0 {
+		if ourNode == nil {
+			return false
+		}
+	}
+	for _, label := range rule.SameLabels {
+		value, found := node.Labels[label]
+		if !found || value != ourNode.Labels[label] {
+			return false
+		}
+	}
+	if len(rule.DifferentLabels) > 0 {
+		differs := false
+		for _, label := range rule.DifferentLabels {
+			if node.Labels[label] != ourNode.Labels[label] {
+				differs = true
+				break
+			}
+		}
+		if !differs {
+			return false
+		}
+	}
+	if len(rule.SubnetNets) > 0 {
+		inSubnet := false
+		for _, subnet := range rule.SubnetNets {
+			if subnet.Contains(cn.NextHop) {
+				inSubnet = true
+				break
+			}
+		}
+		if !inSubnet {
+			return false
+		}
+	}
+	if len(rule.Pools) > 0 {
+		if ipPool == nil {
+			return false
+		}
+		inPool := false
+		for _, pool := range rule.Pools {
+			if pool == ipPool.Name {
+				inPool = true
+				break
+			}
+		}
+		if !inPool {
+			return false
+		}
+	}
+	return true
+}
+
+/**
+ * getPolicyRule returns the first rule of CALICOVPP_CONNECTIVITY_POLICY
+ * matching cn whose provider is enabled, or nil to fall back to the IPPool
+ * based selection.
+ */
+func (s *ConnectivityServer) getPolicyRule(cn *common.NodeConnectivity, ipPool *calicov3.IPPool) *config.ConnectivityRule {
+	if len(config.ConnectivityPolicy) == 0 {
+		return nil
+	}
+	node := s.GetNodeByIp(cn.NextHop)
+	ourNode := s.getOurNode()
+	for i := range config.ConnectivityPolicy {
+		rule := &config.ConnectivityPolicy[i]
+		provider, found := s.providers[rule.Provider]
+		if !found || !ruleMatches(rule, cn, ipPool, node, ourNode) {
+			continue
+		}
+		if !provider.Enabled(cn) {
+			s.log.Debugf("connectivity(policy) rule %d matches cn=%s but %s is not enabled", i, cn.String(), rule.Provider)
+			continue
+		}
+		return rule
+	}
+	return nil
+}
+
+/* Node label changes may change the provider to use when the policy looks at them */
+func connectivityPolicyNodeChanged(old *oldv3.Node, new *oldv3.Node) bool {
+	if len(config.ConnectivityPolicy) == 0 || old == nil || new == nil {
+		return false
+	}
+	return !reflect.DeepEqual(old.Labels, new.Labels)
+}
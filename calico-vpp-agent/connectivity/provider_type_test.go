@@ -0,0 +1,78 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"net"
+	"testing"
+
+	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/common"
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/config"
+)
+
+func testPool(ipipMode calicov3.IPIPMode, vxlanMode calicov3.VXLANMode, encap string) *calicov3.IPPool {
+	pool := &calicov3.IPPool{}
+	pool.Name = "pool"
+	pool.Spec.IPIPMode = ipipMode
+	pool.Spec.VXLANMode = vxlanMode
+	if encap != "" {
+		pool.Annotations = map[string]string{EncapAnnotation: encap}
+	}
+	return pool
+}
+
+/* Our node is 10.0.0.1/24, encryption only replaces IPIP */
+var getProviderTypeTests = []struct {
+	name           string
+	pool           *calicov3.IPPool
+	defaultEncap   string
+	encryption     string
+	encryptionMode string
+	nextHop        string
+	provider       string
+}{
+	{"no pool", nil, "", IPSEC, "", "10.1.0.2", FLAT},
+	{"ipip", testPool(calicov3.IPIPModeAlways, "", ""), "", "", "", "10.1.0.2", IPIP},
+	{"ipip ipsec", testPool(calicov3.IPIPModeAlways, "", ""), "", IPSEC, "", "10.1.0.2", IPSEC},
+	{"ipip wireguard", testPool(calicov3.IPIPModeAlways, "", ""), "", WIREGUARD, "", "10.1.0.2", WIREGUARD},
+	{"ipip cross subnet, same subnet", testPool(calicov3.IPIPModeCrossSubnet, "", ""), "", IPSEC, "", "10.0.0.2", FLAT},
+	{"vxlan is not encrypted", testPool("", calicov3.VXLANModeAlways, ""), "", WIREGUARD, "", "10.1.0.2", VXLAN},
+	{"geneve is not encrypted", testPool(calicov3.IPIPModeAlways, "", EncapGeneve), "", IPSEC, "", "10.1.0.2", GENEVE},
+	{"default gre is not encrypted", testPool(calicov3.IPIPModeAlways, "", ""), EncapGRE, IPSEC, "", "10.1.0.2", GRE},
+	{"default gre, unencapsulated pool", testPool("", "", ""), EncapGRE, "", "", "10.1.0.2", FLAT},
+	{"cross subnet encryption, same subnet", testPool(calicov3.IPIPModeAlways, "", ""), "", IPSEC, config.EncryptionModeCrossSubnet, "10.0.0.2", IPIP},
+	{"cross subnet encryption, other subnet", testPool(calicov3.IPIPModeAlways, "", ""), "", IPSEC, config.EncryptionModeCrossSubnet, "10.1.0.2", IPSEC},
+}
+
+func TestGetProviderType(t *testing.T) {
+	defaultEncap, encryptionMode := config.DefaultEncapsulation, config.EncryptionMode
+	defer func() { config.DefaultEncapsulation, config.EncryptionMode = defaultEncap, encryptionMode }()
+	_, dst, _ := net.ParseCIDR("10.2.0.0/24")
+	for _, test := range getProviderTypeTests {
+		config.DefaultEncapsulation = test.defaultEncap
+		config.EncryptionMode = test.encryptionMode
+		s := newTestProviderServer(test.pool, test.encryption)
+		providerType, err := s.getProviderType(&common.NodeConnectivity{
+			Dst:     *dst,
+			NextHop: net.ParseIP(test.nextHop),
+		})
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, test.provider, providerType, test.name)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/config"
+)
+
+/**
+ * Connectivity providers living outside of this package register themselves
+ * by name with RegisterProvider, from an init() function of their package.
+ * Importing that package in the agent binary (e.g. with a blank import in
+ * cmd/calico_vpp_dataplane.go) is enough to compile them in:
+ *
+ *   func init() {
+ *       connectivity.RegisterProvider("myunderlay", NewMyUnderlayProvider)
+ *   }
+ *
+ * They can then be selected like the built-in ones, in
+ * CALICOVPP_CONNECTIVITY_POLICY rules or with the EncapAnnotation of IPPools.
+ * Their configuration is the entry with their name in the JSON object
+ * CALICOVPP_CONNECTIVITY_PROVIDERS_CONFIG, it is passed as is to their
+ * constructor which parses it.
+ */
+type ProviderConstructor func(d *ConnectivityProviderData, conf json.RawMessage) (ConnectivityProvider, error)
+
+var (
+	builtinProviders = []string{FLAT, IPSEC, VXLAN, IPIP, WIREGUARD, SRv6, GENEVE, GRE}
+	providerRegistry = make(map[string]ProviderConstructor)
+)
+
+/* RegisterProvider makes a provider available under name, it panics on duplicates */
+func RegisterProvider(name string, constructor ProviderConstructor) {
+	if name == "" || constructor == nil {
+		panic("connectivity: invalid provider registration")
+	}
+	for _, builtin := range builtinProviders {
+		if name == builtin {
+			panic(fmt.Sprintf("connectivity: provider %s is built-in", name))
+		}
+	}
+	if _, found := providerRegistry[name]; found {
+		panic(fmt.Sprintf("connectivity: provider %s registered twice", name))
+	}
+	providerRegistry[name] = constructor
+}
+
+func isRegisteredProvider(name string) bool {
+	_, found := providerRegistry[name]
+	return found
+}
+
+/* knownEncapsulations lists the values EncapAnnotation & CALICOVPP_DEFAULT_ENCAPSULATION accept */
+func knownEncapsulations() []string {
+	encaps := []string{EncapGeneve, EncapGRE}
+	for name := range providerRegistry {
+		encaps = append(encaps, name)
+	}
+	sort.Strings(encaps)
+	return encaps
+}
+
+func isKnownEncapsulation(encap string) bool {
+	for _, known := range knownEncapsulations() {
+		if encap == known {
+			return true
+		}
+	}
+	return false
+}
+
+/* validateDefaultEncapsulation runs once the providers registered, from ValidateConfig */
+func validateDefaultEncapsulation() error {
+	if config.DefaultEncapsulation == "" || isKnownEncapsulation(config.DefaultEncapsulation) {
+		return nil
+	}
+	return fmt.Errorf("unknown encapsulation %s, expected one of %s",
+		config.DefaultEncapsulation, strings.Join(knownEncapsulations(), ", "))
+}
+
+/* Instantiate the registered providers, those failing to are left out */
+func (s *ConnectivityServer) addRegisteredProviders(d *ConnectivityProviderData) {
+	names := make([]string, 0, len(providerRegistry))
+	for name := range providerRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		provider, err := providerRegistry[name](d, config.ProvidersConfig[name])
+		if err != nil {
+			s.log.Errorf("Error creating connectivity provider %s, it will be ignored: %v", name, err)
+			continue
+		}
+		s.log.Infof("Using registered connectivity provider %s", name)
+		s.providers[name] = provider
+	}
+	for name := range config.ProvidersConfig {
+		if !isRegisteredProvider(name) {
+			s.log.Warnf("Configuration given for unknown connectivity provider %s", name)
+		}
+	}
+	/* ValidateConfig checked the name, but the provider might have failed to start */
+	if isRegisteredProvider(config.DefaultEncapsulation) {
+		if _, found := s.providers[config.DefaultEncapsulation]; !found {
+			s.log.Errorf("Default encapsulation %s is unavailable, it will be ignored", config.DefaultEncapsulation)
+		}
+	}
+}
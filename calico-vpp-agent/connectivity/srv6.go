@@ -33,10 +33,13 @@ type SRv6Provider struct {
 	nodePolices    map[string]*NodeToPolicies
 	policyIPPool   net.IPNet
 	localSidIPPool net.IPNet
+	tePolicies     map[string]common.SRv6TEPolicy
+	/* names of the tePolicies that failed to be programmed, retried on the next update */
+	teFailed map[string]bool
 }
 
 func NewSRv6Provider(d *ConnectivityProviderData) *SRv6Provider {
-	p := &SRv6Provider{d, make(map[string]*NodeToPrefixes), make(map[string]*NodeToPolicies), net.IPNet{}, net.IPNet{}}
+	p := &SRv6Provider{d, make(map[string]*NodeToPrefixes), make(map[string]*NodeToPolicies), net.IPNet{}, net.IPNet{}, make(map[string]common.SRv6TEPolicy), make(map[string]bool)}
 	if config.EnableSRv6 {
 		p.localSidIPPool = cnet.MustParseNetwork(config.SRv6localSidIPPool).IPNet
 		p.policyIPPool = cnet.MustParseNetwork(config.SRv6policyIPPool).IPNet
@@ -57,6 +60,27 @@ func (p *SRv6Provider) Enabled(cn *common.NodeConnectivity) bool {
 	return config.EnableSRv6
 }
 
+func (p *SRv6Provider) EncapOverhead(cn *common.NodeConnectivity) int {
+	/* Outer IPv6 + SRH with one segment */
+	return 40 + 8 + 16
+}
+
+func (p *SRv6Provider) GetStatus(nextHop net.IP) common.NodeConnectivityStatus {
+	policies, found := p.nodePolices[nextHop.String()]
+	if !found || len(policies.SRv6Tunnel) == 0 {
+		return common.NodeConnectivityStatus{
+			SwIfIndex: vpplink.InvalidID,
+			State:     common.ConnectivityStatePending,
+			Detail:    "no policy",
+		}
+	}
+	return common.NodeConnectivityStatus{
+		SwIfIndex: vpplink.InvalidID,
+		State:     common.ConnectivityStateUp,
+		Detail:    fmt.Sprintf("%d policies", len(policies.SRv6Tunnel)),
+	}
+}
+
 func (p *SRv6Provider) RescanState() {
 	p.log.Infof("SRv6Provider RescanState")
 
@@ -73,7 +97,7 @@ func (p *SRv6Provider) RescanState() {
 	if err != nil {
 		p.log.Errorf("SRv6Provider Error creating SRv6Localsid: %v", err)
 	}
-
+	p.reapplyTEPolicies()
 }
 
 func (p *SRv6Provider) CreateSRv6Tunnnel(dst net.IP, prefixDst ip_types.Prefix, policyTunnel *types.SrPolicy) (err error) {
@@ -224,11 +248,16 @@ func (p *SRv6Provider) setEncapSource() (err error) {
 
 func (p *SRv6Provider) createLocalSidTunnels(currentLocalSids []*types.SrLocalsid) (localSids []*types.SrLocalsid, err error) {
 	p.log.Infof("SRv6Provider createLocalSidTunnels")
+	endExist := false
 	endDt4Exist := false
 	endDt6Exist := false
 	for _, localSid := range currentLocalSids {
 		p.log.Debugf("Found existing SRv6Localsid: %s", localSid.String())
 
+		if localSid.Behavior == types.SrBehaviorEND {
+			endExist = true
+		}
+
 		if localSid.Behavior == types.SrBehaviorDT6 && localSid.FibTable == 0 {
 			endDt6Exist = true
 		}
@@ -252,6 +281,15 @@ func (p *SRv6Provider) createLocalSidTunnels(currentLocalSids []*types.SrLocalsi
 			localSids = append(localSids, localSidDT6)
 		}
 	}
+
+	/* Plain End SIDs make this node usable as a segment of explicit SR policies */
+	if !endExist {
+		if localSidEnd, err := p.setLocalSid(types.SrBehaviorEND); err != nil {
+			p.log.Errorf("SRv6Provider Error setEnd: %v", err)
+		} else {
+			localSids = append(localSids, localSidEnd)
+		}
+	}
 	return localSids, err
 }
 
@@ -266,7 +304,10 @@ func (p *SRv6Provider) setEndDT(typeDT int) (newLocalSid *types.SrLocalsid, err
 	case 6:
 		behavior = types.SrBehaviorDT6
 	}
+	return p.setLocalSid(behavior)
+}
 
+func (p *SRv6Provider) setLocalSid(behavior types.SrBehavior) (newLocalSid *types.SrLocalsid, err error) {
 	poolLocalSIDName := "sr-localsids-pool-" + config.NodeName
 	newLocalSidAddr, err := p.getSidFromPool(poolLocalSIDName)
 
@@ -0,0 +1,203 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"net"
+	"reflect"
+
+	"github.com/pkg/errors"
+
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/common"
+	"github.com/projectcalico/vpp-dataplane/vpplink"
+	"github.com/projectcalico/vpp-dataplane/vpplink/types"
+)
+
+/**
+ * Besides the policies built from the localsids remote nodes advertise,
+ * SRv6Policy resources describe policies with explicit segment lists (see
+ * watchers.SRv6PolicyWatcher). We program them as given, and steer their
+ * prefixes into them. Steered prefixes are usually more specific than the
+ * pod CIDRs, so they take precedence over the advertised policies.
+ * A policy that fails to be programmed is kept, and removed then programmed
+ * again on the next update, which the watcher sends on each resync.
+ */
+
+func teSteer(policy *common.SRv6TEPolicy, prefix net.IPNet) *types.SrSteer {
+	steer := &types.SrSteer{
+		TrafficType: types.SR_STEER_IPV4,
+		Prefix:      types.ToVppPrefix(&prefix),
+		Bsid:        policy.Policy.Bsid,
+	}
+	if vpplink.IsIP6(prefix.IP) {
+		steer.TrafficType = types.SR_STEER_IPV6
+	}
+	return steer
+}
+
+func (p *SRv6Provider) addTEPolicy(policy *common.SRv6TEPolicy) error {
+	p.log.Infof("SRv6Provider(te) add policy %s %s", policy.Name, policy.Policy.String())
+	err := p.vpp.AddModSRv6Policy(policy.Policy)
+	if err != nil {
+		return errors.Wrapf(err, "error adding SRv6 policy %s", policy.Name)
+	}
+	for _, prefix := range policy.Prefixes {
+		err = p.vpp.AddSRv6Steering(teSteer(policy, prefix))
+		if err != nil {
+			return errors.Wrapf(err, "error steering %s into SRv6 policy %s", prefix.String(), policy.Name)
+		}
+	}
+	return nil
+}
+
+func (p *SRv6Provider) delTEPolicy(policy *common.SRv6TEPolicy) {
+	p.log.Infof("SRv6Provider(te) delete policy %s", policy.Name)
+	for _, prefix := range policy.Prefixes {
+		err := p.vpp.DelSRv6Steering(teSteer(policy, prefix))
+		if err != nil {
+			p.log.Errorf("Error removing %s steering from SRv6 policy %s: %v", prefix.String(), policy.Name, err)
+		}
+	}
+	err := p.vpp.DelSRv6Policy(policy.Policy)
+	if err != nil {
+		p.log.Errorf("Error deleting SRv6 policy %s: %v", policy.Name, err)
+	}
+}
+
+/* teSteerKey identifies the steering of prefix into the policy with bsid */
+func teSteerKey(bsid net.IP, prefix string) string {
+	return bsid.String() + "/" + prefix
+}
+
+/* reapplyTEPolicies programs again what is missing from VPP, e.g. after a VPP restart */
+func (p *SRv6Provider) reapplyTEPolicies() {
+	if len(p.tePolicies) == 0 {
+		return
+	}
+	vppPolicies, err := p.vpp.ListSRv6Policies()
+	if err != nil {
+		p.log.Errorf("SRv6Provider(te) Error listing SRv6 policies: %v", err)
+		return
+	}
+	vppSteerings, err := p.vpp.ListSRv6Steering()
+	if err != nil {
+		p.log.Errorf("SRv6Provider(te) Error listing SRv6 steerings: %v", err)
+		return
+	}
+	bsids := make(map[string]bool)
+	for _, policy := range vppPolicies {
+		bsids[policy.Bsid.ToIP().String()] = true
+	}
+	steerings := make(map[string]bool)
+	for _, steer := range vppSteerings {
+		steerings[teSteerKey(steer.Bsid.ToIP(), steer.Prefix.String())] = true
+	}
+	for name, policy := range p.tePolicies {
+		policy := policy
+		bsid := policy.Policy.Bsid.ToIP()
+		if p.teFailed[name] {
+			p.delTEPolicy(&policy)
+		}
+		if p.teFailed[name] || !bsids[bsid.String()] {
+			p.log.Infof("SRv6Provider(te) re-applying policy %s", name)
+			p.setTEPolicyResult(name, p.addTEPolicy(&policy))
+			continue
+		}
+		for _, prefix := range policy.Prefixes {
+			steer := teSteer(&policy, prefix)
+			if steerings[teSteerKey(bsid, steer.Prefix.String())] {
+				continue
+			}
+			err = p.vpp.AddSRv6Steering(steer)
+			if err != nil {
+				p.setTEPolicyResult(name, errors.Wrapf(err, "error steering %s into SRv6 policy %s", prefix.String(), name))
+			}
+		}
+	}
+}
+
+/* setTEPolicyResult records whether programming the policy name succeeded */
+func (p *SRv6Provider) setTEPolicyResult(name string, err error) {
+	if err == nil {
+		delete(p.teFailed, name)
+		return
+	}
+	p.teFailed[name] = true
+	p.log.Errorf("Error programming SRv6 policy %s: %v", name, err)
+	common.NodeWarningEvent(common.EventReasonConnectivityFailed,
+		"Error programming SRv6 policy %s: %v", name, err)
+}
+
+/* updateTESteering only changes the prefixes steered into an unchanged policy */
+func (p *SRv6Provider) updateTESteering(old *common.SRv6TEPolicy, new *common.SRv6TEPolicy) error {
+	newPrefixes := make(map[string]bool)
+	for _, prefix := range new.Prefixes {
+		newPrefixes[prefix.String()] = true
+	}
+	oldPrefixes := make(map[string]bool)
+	for _, prefix := range old.Prefixes {
+		oldPrefixes[prefix.String()] = true
+		if !newPrefixes[prefix.String()] {
+			err := p.vpp.DelSRv6Steering(teSteer(old, prefix))
+			if err != nil {
+				p.log.Errorf("Error removing %s steering from SRv6 policy %s: %v", prefix.String(), old.Name, err)
+			}
+		}
+	}
+	for _, prefix := range new.Prefixes {
+		if !oldPrefixes[prefix.String()] {
+			err := p.vpp.AddSRv6Steering(teSteer(new, prefix))
+			if err != nil {
+				return errors.Wrapf(err, "error steering %s into SRv6 policy %s", prefix.String(), new.Name)
+			}
+		}
+	}
+	return nil
+}
+
+/* SetTEPolicies reconciles the explicit policies programmed in VPP with policies */
+func (p *SRv6Provider) SetTEPolicies(policies []common.SRv6TEPolicy) {
+	desired := make(map[string]common.SRv6TEPolicy)
+	for _, policy := range policies {
+		desired[policy.Name] = policy
+	}
+	for name, old := range p.tePolicies {
+		old := old
+		new, found := desired[name]
+		if found && !p.teFailed[name] && reflect.DeepEqual(old, new) {
+			continue
+		}
+		if found && !p.teFailed[name] && reflect.DeepEqual(old.Policy, new.Policy) {
+			p.log.Infof("SRv6Provider(te) update policy %s steering", name)
+			p.setTEPolicyResult(name, p.updateTESteering(&old, &new))
+			p.tePolicies[name] = new
+			continue
+		}
+		/* Changed or failed, remove what was programmed before adding it again */
+		p.delTEPolicy(&old)
+		delete(p.tePolicies, name)
+		delete(p.teFailed, name)
+	}
+	for name, new := range desired {
+		if _, found := p.tePolicies[name]; found {
+			continue
+		}
+		new := new
+		p.setTEPolicyResult(name, p.addTEPolicy(&new))
+		/* Keep it even partially programmed, so that it gets cleaned up */
+		p.tePolicies[name] = new
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"net"
+	"sort"
+
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/common"
+	"github.com/projectcalico/vpp-dataplane/vpplink"
+	"github.com/projectcalico/vpp-dataplane/vpplink/types"
+)
+
+/**
+ * Tenant VRFs (see cni/tenant_vrf.go) only route their members. The CNI
+ * server routes the local ones, we route the members running on other nodes
+ * through the connectivity reaching their node: each member gets a route in
+ * the tenant VRF looking it up in the VRF its provider routes the node's
+ * pods in. Members whose node we have no connectivity to aren't routed.
+ */
+type tenantRoutes struct {
+	members *common.TenantMembers
+	/* routes programmed in the tenant VRF, by destination */
+	routes map[string]*types.Route
+}
+
+/**
+ * Providers routing the pods of some nodes outside of the main VRF implement
+ * this, the tenant VRFs then look up their members in that VRF.
+ */
+type VrfProvider interface {
+	GetConnectivityVrf(cn *common.NodeConnectivity) uint32
+}
+
+/* The connectivity whose prefix is the longest to contain addr, nil if none */
+func (s *ConnectivityServer) getAddressConnectivity(addr net.IP) *common.NodeConnectivity {
+	var best *common.NodeConnectivity
+	for _, cn := range s.connectivityMap {
+		if !cn.Dst.Contains(addr) {
+			continue
+		}
+		cn := cn
+		if best == nil {
+			best = &cn
+			continue
+		}
+		bestLen, _ := best.Dst.Mask.Size()
+		cnLen, _ := cn.Dst.Mask.Size()
+		if cnLen > bestLen {
+			best = &cn
+		}
+	}
+	return best
+}
+
+/* The VRF the provider of cn routes it in */
+func (s *ConnectivityServer) getConnectivityVrf(cn *common.NodeConnectivity) uint32 {
+	if vrfProvider, ok := s.providers[cn.ResolvedProvider].(VrfProvider); ok {
+		return vrfProvider.GetConnectivityVrf(cn)
+	}
+	return common.DefaultVRFIndex
+}
+
+/**
+ * Routes the tenant VRF should contain for its remote members, by
+ * destination. getVrf returns the VRF routing a member, false if
+ * it isn't reachable.
+ */
+func tenantMemberRoutes(members *common.TenantMembers, getVrf func(member *net.IPNet) (uint32, bool)) map[string]*types.Route {
+	routes := make(map[string]*types.Route)
+	for _, member := range members.Remote {
+		vrf, found := getVrf(member)
+		if !found {
+			continue
+		}
+		routes[member.String()] = &types.Route{
+			Dst:   member,
+			Table: members.GetVrfId(vpplink.IsIP6(member.IP)),
+			Paths: []types.RoutePath{{
+				Table:     vrf,
+				SwIfIndex: types.InvalidID,
+			}},
+		}
+	}
+	return routes
+}
+
+/* Whether a route read back from a tenant VRF is one of ours, and not the CNI server's */
+func isTenantMemberRoute(route *types.Route) bool {
+	if len(route.Paths) != 1 || route.Paths[0].Table == common.PodVRFIndex || !route.Dst.IP.IsGlobalUnicast() {
+		return false
+	}
+	gw := route.Paths[0].Gw
+	ones, bits := route.Dst.Mask.Size()
+	return ones == bits && (gw == nil || gw.IsUnspecified())
+}
+
+/* adoptTenantRoutes reads back the member routes a previous run of the agent programmed */
+func (s *ConnectivityServer) adoptTenantRoutes(tenant *tenantRoutes) {
+	for _, isIP6 := range []bool{false, true} {
+		vrfId := tenant.members.GetVrfId(isIP6)
+		routes, err := s.vpp.GetRoutes(vrfId, isIP6)
+		if err != nil {
+			s.log.Errorf("Error listing routes of tenant %s VRF %d: %v", tenant.members.Name, vrfId, err)
+			continue
+		}
+		for i, route := range routes {
+			if isTenantMemberRoute(&route) {
+				tenant.routes[route.Dst.String()] = &routes[i]
+			}
+		}
+	}
+}
+
+/* syncTenantRoutes reconciles the routes of the remote members of a tenant */
+func (s *ConnectivityServer) syncTenantRoutes(tenant *tenantRoutes) {
+	routes := tenantMemberRoutes(tenant.members, func(member *net.IPNet) (uint32, bool) {
+		cn := s.getAddressConnectivity(member.IP)
+		if cn == nil {
+			return 0, false
+		}
+		return s.getConnectivityVrf(cn), true
+	})
+	for key, route := range tenant.routes {
+		if desired, found := routes[key]; found && desired.Paths[0].Table == route.Paths[0].Table {
+			continue
+		}
+		s.log.Infof("tenant(del) %s route [tenantVRF->providerVRF] %s", tenant.members.Name, route.String())
+		err := s.vpp.RouteDel(route)
+		if err != nil {
+			s.log.Errorf("Error deleting route %s in tenant %s: %v", route.String(), tenant.members.Name, err)
+		}
+		delete(tenant.routes, key)
+	}
+	keys := make([]string, 0, len(routes))
+	for key := range routes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, found := tenant.routes[key]; found {
+			continue
+		}
+		route := routes[key]
+		s.log.Infof("tenant(add) %s route [tenantVRF->providerVRF] %s", tenant.members.Name, route.String())
+		err := s.vpp.RouteAdd(route)
+		if err != nil {
+			s.log.Errorf("Error adding route %s in tenant %s: %v", route.String(), tenant.members.Name, err)
+			continue
+		}
+		tenant.routes[key] = route
+	}
+}
+
+/* syncAllTenantRoutes follows the connectivity changes in the tenant VRFs */
+func (s *ConnectivityServer) syncAllTenantRoutes() {
+	for _, tenant := range s.tenants {
+		s.syncTenantRoutes(tenant)
+	}
+}
+
+func (s *ConnectivityServer) onTenantMembersChanged(members *common.TenantMembers) {
+	tenant, found := s.tenants[members.Name]
+	if !found || tenant.members.V4VrfId != members.V4VrfId || tenant.members.V6VrfId != members.V6VrfId {
+		tenant = &tenantRoutes{
+			members: members,
+			routes:  make(map[string]*types.Route),
+		}
+		s.adoptTenantRoutes(tenant)
+		s.tenants[members.Name] = tenant
+	}
+	tenant.members = members
+	s.syncTenantRoutes(tenant)
+}
+
+/* The CNI server deletes the tenant VRFs, which flushes their routes */
+func (s *ConnectivityServer) onTenantDeleted(members *common.TenantMembers) {
+	delete(s.tenants, members.Name)
+}
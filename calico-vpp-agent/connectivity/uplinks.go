@@ -0,0 +1,281 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/config"
+	"github.com/projectcalico/vpp-dataplane/vpplink/types"
+
+	types2 "git.fd.io/govpp.git/api/v0"
+)
+
+/**
+ * With CALICOVPP_UPLINK_ECMP, traffic towards a peer node is spread over all
+ * the uplinks vpp-manager created (the main one and CALICOVPP_EXTRA_INTERFACES),
+ * hashing flows with CALICOVPP_UPLINK_FLOW_HASH.
+ *
+ * Each node publishes the addresses of its uplinks in an annotation on its
+ * Kubernetes node, peers get it from the node informer. Towards a peer,
+ * every uplink that is up and shares a subnet with one of the peer addresses
+ * gives a path, either a route path (flat) or a tunnel built from the uplink
+ * address (ipip). The other providers only use the main uplink: their
+ * tunnels are keyed by peer, and the encrypted ones negotiate a single SA or
+ * peer per node.
+ * Uplinks are re-read on VPP interface events, paths through a down uplink
+ * are removed until it comes back up. Without any usable uplink we fall back
+ * to the single path towards the node address.
+ */
+const (
+	UplinksAnnotation = "cni.projectcalico.org/vpp.uplinks"
+
+	uplinkTagPrefix = "main-"
+)
+
+type uplink struct {
+	Name      string
+	SwIfIndex uint32
+	Addresses []net.IPNet
+	IsUp      bool
+}
+
+/* A path towards a peer through one of our uplinks */
+type uplinkPath struct {
+	SwIfIndex uint32
+	Src       net.IP
+	Gw        net.IP
+}
+
+func (p uplinkPath) String() string {
+	return fmt.Sprintf("%s->%s [%d]", p.Src, p.Gw, p.SwIfIndex)
+}
+
+func (s *ConnectivityServer) listUplinks() ([]uplink, error) {
+	swIfIndexes, err := s.vpp.SearchInterfacesWithTagPrefix(uplinkTagPrefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing uplinks")
+	}
+	uplinks := make([]uplink, 0, len(swIfIndexes))
+	for tag, swIfIndex := range swIfIndexes {
+		iface := types2.Interface{SwIfIndex: swIfIndex}
+		details, err := s.vpp.GetInterfaceDetails(&iface)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting uplink %d details", swIfIndex)
+		}
+		ip4Addresses, err := s.vpp.GetInterfaceAddressesIP4(&iface)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting uplink %d addresses", swIfIndex)
+		}
+		ip6Addresses, err := s.vpp.GetInterfaceAddressesIP6(&iface)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting uplink %d addresses", swIfIndex)
+		}
+		link := uplink{
+			Name:      strings.TrimPrefix(tag, uplinkTagPrefix),
+			SwIfIndex: swIfIndex,
+			Addresses: make([]net.IPNet, 0),
+			IsUp:      details.IsUp,
+		}
+		for _, addr := range append(ip4Addresses, ip6Addresses...) {
+			if addr.IPNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			link.Addresses = append(link.Addresses, addr.IPNet)
+		}
+		uplinks = append(uplinks, link)
+	}
+	sort.Slice(uplinks, func(i, j int) bool {
+		return uplinks[i].SwIfIndex < uplinks[j].SwIfIndex
+	})
+	return uplinks, nil
+}
+
+func uplinksAddresses(uplinks []uplink) []string {
+	addresses := make([]string, 0)
+	for _, link := range uplinks {
+		for _, addr := range link.Addresses {
+			addresses = append(addresses, addr.IP.String())
+		}
+	}
+	return addresses
+}
+
+func (s *ConnectivityServer) publishUplinks() error {
+	addresses := strings.Join(uplinksAddresses(s.uplinks), ",")
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, UplinksAnnotation, addresses)
+	_, err := s.k8sclient.CoreV1().Nodes().Patch(context.Background(), config.NodeName,
+		k8stypes.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "error annotating node %s", config.NodeName)
+	}
+	return nil
+}
+
+func (s *ConnectivityServer) setUplinkFlowHash() {
+	for _, isIP6 := range []bool{false, true} {
+		err := s.vpp.SetIPFlowHash(config.UplinkFlowHash, 0 /* vrf */, isIP6)
+		if err != nil {
+			s.log.Errorf("Error setting flow hash %s (ip6 %t): %v", config.UplinkFlowHash, isIP6, err)
+		}
+	}
+}
+
+/* isUplinkEvent tells whether an interface event concerns one of our uplinks */
+func (s *ConnectivityServer) isUplinkEvent(event *types.InterfaceEvent) bool {
+	for _, link := range s.uplinks {
+		if link.SwIfIndex == event.SwIfIndex {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * refreshUplinks re-reads the uplinks states & addresses, it returns true
+ * when paths might have changed
+ */
+func (s *ConnectivityServer) refreshUplinks() bool {
+	uplinks, err := s.listUplinks()
+	if err != nil {
+		s.log.Errorf("Error listing uplinks: %v", err)
+		return false
+	}
+	changed := len(uplinks) != len(s.uplinks)
+	addressesChanged := !reflect.DeepEqual(uplinksAddresses(uplinks), uplinksAddresses(s.uplinks))
+	for i, link := range uplinks {
+		if i < len(s.uplinks) && s.uplinks[i].SwIfIndex == link.SwIfIndex && s.uplinks[i].IsUp == link.IsUp {
+			continue
+		}
+		changed = true
+		if link.IsUp {
+			s.log.Infof("connectivity(uplink) %s [%d] up", link.Name, link.SwIfIndex)
+		} else {
+			s.log.Warnf("connectivity(uplink) %s [%d] down", link.Name, link.SwIfIndex)
+		}
+	}
+	s.uplinks = uplinks
+	if addressesChanged {
+		err = s.publishUplinks()
+		if err != nil {
+			s.log.Errorf("Error publishing uplinks: %v", err)
+		}
+	}
+	return changed || addressesChanged
+}
+
+/* refreshPeerUplinks reads the uplinks addresses of the peers, it returns true when they changed */
+func (s *ConnectivityServer) refreshPeerUplinks() bool {
+	if !config.EnableUplinkECMP || s.nodeLister == nil {
+		return false
+	}
+	nodes, err := s.nodeLister.List(labels.Everything())
+	if err != nil {
+		s.log.Errorf("Error listing nodes for uplinks: %v", err)
+		return false
+	}
+	peerUplinks := make(map[string][]net.IP)
+	for _, node := range nodes {
+		if node.Name == config.NodeName || node.Annotations[UplinksAnnotation] == "" {
+			continue
+		}
+		for _, addr := range strings.Split(node.Annotations[UplinksAnnotation], ",") {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				s.log.Warnf("Invalid uplink address %s on node %s", addr, node.Name)
+				continue
+			}
+			peerUplinks[node.Name] = append(peerUplinks[node.Name], ip)
+		}
+	}
+	if reflect.DeepEqual(peerUplinks, s.peerUplinks) {
+		return false
+	}
+	s.peerUplinks = peerUplinks
+	return true
+}
+
+/**
+ * getUplinkPaths returns one path per uplink that can reach the node with
+ * address nextHop, or nil when ECMP is disabled or no uplink can.
+ */
+func (s *ConnectivityServer) getUplinkPaths(nextHop net.IP) []uplinkPath {
+	if !config.EnableUplinkECMP {
+		return nil
+	}
+	peerAddresses := []net.IP{nextHop}
+	if node := s.GetNodeByIp(nextHop); node != nil {
+		peerAddresses = append(peerAddresses, s.peerUplinks[node.Name]...)
+	}
+	paths := make([]uplinkPath, 0)
+	for _, link := range s.uplinks {
+		if !link.IsUp {
+			continue
+		}
+		path := findUplinkPath(&link, peerAddresses, nextHop)
+		if path != nil {
+			paths = append(paths, *path)
+		}
+	}
+	return paths
+}
+
+func findUplinkPath(link *uplink, peerAddresses []net.IP, nextHop net.IP) *uplinkPath {
+	for _, addr := range link.Addresses {
+		if (addr.IP.To4() == nil) != (nextHop.To4() == nil) {
+			continue
+		}
+		for _, peerAddr := range peerAddresses {
+			if addr.Contains(peerAddr) && !addr.IP.Equal(peerAddr) {
+				return &uplinkPath{SwIfIndex: link.SwIfIndex, Src: addr.IP, Gw: peerAddr}
+			}
+		}
+	}
+	return nil
+}
+
+func uplinkRoutePaths(paths []uplinkPath) []types.RoutePath {
+	routePaths := make([]types.RoutePath, 0, len(paths))
+	for _, path := range paths {
+		routePaths = append(routePaths, types.RoutePath{
+			Gw:        path.Gw,
+			SwIfIndex: path.SwIfIndex,
+		})
+	}
+	return routePaths
+}
+
+/* isUplinkAddress tells whether addr belongs to one of our uplinks */
+func (s *ConnectivityServer) isUplinkAddress(addr net.IP) bool {
+	for _, link := range s.uplinks {
+		for _, uplinkAddr := range link.Addresses {
+			if uplinkAddr.IP.Equal(addr) {
+				return true
+			}
+		}
+	}
+	return false
+}
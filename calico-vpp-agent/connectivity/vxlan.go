@@ -18,8 +18,12 @@ package connectivity
 import (
 	"fmt"
 	"net"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
+	calicov3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
 
 	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/common"
 	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/config"
@@ -29,16 +33,35 @@ import (
 	types2 "git.fd.io/govpp.git/api/v0"
 )
 
+/**
+ * IPPools can set their own VXLAN VNI and UDP port with these annotations,
+ * e.g. to interoperate with external VTEPs. Other pools use the VXLANVNI and
+ * VXLANPort of the felix configuration. There is a tunnel per (peer, VNI, port).
+ * The vrf annotation puts the routes to the pool and the decapsulated traffic
+ * in an existing VPP VRF, so a VNI maps to a single VRF.
+ */
+const (
+	VXLANVniAnnotation  = "cni.projectcalico.org/vpp.vxlan.vni"
+	VXLANPortAnnotation = "cni.projectcalico.org/vpp.vxlan.port"
+	VXLANVrfAnnotation  = "cni.projectcalico.org/vpp.vxlan.vrf"
+
+	/* Parameters of the connectivity policy rules picking vxlan */
+	VXLANVniParameter  = "vni"
+	VXLANPortParameter = "port"
+	VXLANVrfParameter  = "vrf"
+)
+
 type VXLanProvider struct {
 	*ConnectivityProviderData
 	vxlanIfs     map[string]types.VXLanTunnel
 	vxlanRoutes  map[uint32]map[string]bool
+	vxlanVrfs    map[uint32]uint32
 	ip4NodeIndex uint32
 	ip6NodeIndex uint32
 }
 
 func NewVXLanProvider(d *ConnectivityProviderData) *VXLanProvider {
-	return &VXLanProvider{d, make(map[string]types.VXLanTunnel), make(map[uint32]map[string]bool), 0, 0}
+	return &VXLanProvider{d, make(map[string]types.VXLanTunnel), make(map[uint32]map[string]bool), make(map[uint32]uint32), 0, 0}
 }
 
 func (p *VXLanProvider) EnableDisable(isEnable bool) () {
@@ -48,6 +71,79 @@ func (p *VXLanProvider) Enabled(cn *common.NodeConnectivity) bool {
 	return true
 }
 
+func (p *VXLanProvider) EncapOverhead(cn *common.NodeConnectivity) int {
+	/* UDP + VXLAN headers + inner ethernet */
+	return outerIPHeaderSize(cn.NextHop) + 8 + 8 + 14
+}
+
+func (p *VXLanProvider) GetStatus(nextHop net.IP) common.NodeConnectivityStatus {
+	tunnels := p.getNextHopTunnels(nextHop)
+	if len(tunnels) == 0 {
+		return common.NodeConnectivityStatus{
+			SwIfIndex: vpplink.InvalidID,
+			State:     common.ConnectivityStateDown,
+			Detail:    "no tunnel",
+		}
+	}
+	details := make([]string, 0, len(tunnels))
+	for _, tunnel := range tunnels {
+		details = append(details, fmt.Sprintf("vni %d port %d", tunnel.Vni, tunnel.DstPort))
+	}
+	return common.NodeConnectivityStatus{
+		SwIfIndex: tunnels[0].SwIfIndex,
+		State:     common.ConnectivityStateUp,
+		Detail:    strings.Join(details, ", "),
+	}
+}
+
+func (p *VXLanProvider) GetTunnelSwIfIndexes(nextHop net.IP) []uint32 {
+	swIfIndexes := make([]uint32, 0)
+	for _, tunnel := range p.getNextHopTunnels(nextHop) {
+		swIfIndexes = append(swIfIndexes, tunnel.SwIfIndex)
+	}
+	return swIfIndexes
+}
+
+/* GetConnectivityVrf returns the VRF the pool of cn is routed in */
+func (p *VXLanProvider) GetConnectivityVrf(cn *common.NodeConnectivity) uint32 {
+	key, found := p.getConnectivityTunnel(cn)
+	if !found {
+		return common.DefaultVRFIndex
+	}
+	return p.vxlanVrfs[p.vxlanIfs[key].SwIfIndex]
+}
+
+func vxlanTunnelKey(nextHop net.IP, vni uint32, port uint16) string {
+	return fmt.Sprintf("%s-%d-%d", nextHop.String(), vni, port)
+}
+
+/* Tunnels towards nextHop, sorted by VNI */
+func (p *VXLanProvider) getNextHopTunnels(nextHop net.IP) []types.VXLanTunnel {
+	tunnels := make([]types.VXLanTunnel, 0)
+	for _, tunnel := range p.vxlanIfs {
+		if tunnel.DstAddress.Equal(nextHop) {
+			tunnels = append(tunnels, tunnel)
+		}
+	}
+	sort.Slice(tunnels, func(i, j int) bool {
+		return tunnels[i].Vni < tunnels[j].Vni
+	})
+	return tunnels
+}
+
+/* The tunnel currently routing cn, if any */
+func (p *VXLanProvider) getConnectivityTunnel(cn *common.NodeConnectivity) (key string, found bool) {
+	for key, tunnel := range p.vxlanIfs {
+		if !tunnel.DstAddress.Equal(cn.NextHop) {
+			continue
+		}
+		if p.vxlanRoutes[tunnel.SwIfIndex][cn.Dst.String()] {
+			return key, true
+		}
+	}
+	return "", false
+}
+
 func (p *VXLanProvider) configureVXLANNodes() error {
 	var err error
 	p.ip4NodeIndex, err = p.vpp.AddNodeNext("vxlan4-input", "ip4-input")
@@ -69,12 +165,19 @@ func (p *VXLanProvider) RescanState() {
 	if err != nil {
 		p.log.Errorf("Error listing VXLan tunnels: %v", err)
 	}
+	configured := p.getConfiguredVXLANParams()
+	vnis := make(map[uint32]bool)
+	vrfs := map[uint32]bool{0: true}
+	for _, params := range configured {
+		vnis[params.vni] = true
+		vrfs[params.vrf] = true
+	}
 	ip4, ip6 := p.server.GetNodeIPs()
 	for _, tunnel := range tunnels {
 		if (ip4 != nil && tunnel.SrcAddress.Equal(*ip4)) || (ip6 != nil && tunnel.SrcAddress.Equal(*ip6)) {
-			if tunnel.Vni == p.getVXLANVNI() && tunnel.DstPort == p.getVXLANPort() && tunnel.SrcPort == p.getVXLANPort() {
+			if tunnel.DstPort == tunnel.SrcPort && vnis[tunnel.Vni] {
 				p.log.Infof("Found existing tunnel: %s", tunnel)
-				p.vxlanIfs[tunnel.DstAddress.String()] = tunnel
+				p.vxlanIfs[vxlanTunnelKey(tunnel.DstAddress, tunnel.Vni, tunnel.DstPort)] = tunnel
 			}
 		}
 	}
@@ -85,19 +188,26 @@ func (p *VXLanProvider) RescanState() {
 	}
 	p.log.Infof("Rescanning existing routes")
 	p.vxlanRoutes = make(map[uint32]map[string]bool)
-	routes, err := p.vpp.GetRoutes(0, false)
-	if err != nil {
-		p.log.Errorf("Error listing routes: %v", err)
-	}
-	for _, route := range routes {
-		for _, routePath := range route.Paths {
-			_, exists := tunnelBySwIfIndex[routePath.SwIfIndex]
-			if exists {
-				_, found := p.vxlanRoutes[routePath.SwIfIndex]
-				if !found {
-					p.vxlanRoutes[routePath.SwIfIndex] = make(map[string]bool)
+	p.vxlanVrfs = make(map[uint32]uint32)
+	for vrf := range vrfs {
+		for _, isIP6 := range []bool{false, true} {
+			routes, err := p.vpp.GetRoutes(vrf, isIP6)
+			if err != nil {
+				p.log.Errorf("Error listing routes in vrf %d: %v", vrf, err)
+				continue
+			}
+			for _, route := range routes {
+				for _, routePath := range route.Paths {
+					_, exists := tunnelBySwIfIndex[routePath.SwIfIndex]
+					if exists {
+						_, found := p.vxlanRoutes[routePath.SwIfIndex]
+						if !found {
+							p.vxlanRoutes[routePath.SwIfIndex] = make(map[string]bool)
+						}
+						p.vxlanRoutes[routePath.SwIfIndex][route.Dst.String()] = true
+						p.vxlanVrfs[routePath.SwIfIndex] = vrf
+					}
 				}
-				p.vxlanRoutes[routePath.SwIfIndex][route.Dst.String()] = true
 			}
 		}
 	}
@@ -119,6 +229,113 @@ func (p *VXLanProvider) getVXLANPort() uint16 {
 	return uint16(felixConfig.VXLANPort)
 }
 
+/* Where the traffic to a pool goes: the tunnel VNI & port, and the VRF of its routes */
+type vxlanParams struct {
+	vni  uint32
+	port uint16
+	vrf  uint32
+}
+
+/* The VXLAN annotations of ipPool, as connectivity policy rule parameters */
+func poolVXLANParameters(ipPool *calicov3.IPPool) map[string]string {
+	parameters := make(map[string]string)
+	for parameter, annotation := range map[string]string{
+		VXLANVniParameter:  VXLANVniAnnotation,
+		VXLANPortParameter: VXLANPortAnnotation,
+		VXLANVrfParameter:  VXLANVrfAnnotation,
+	} {
+		if value, found := ipPool.Annotations[annotation]; found {
+			parameters[parameter] = value
+		}
+	}
+	return parameters
+}
+
+/* Overrides params with the ones set in parameters, source is for error messages */
+func (params *vxlanParams) parse(parameters map[string]string, source string) error {
+	if value, found := parameters[VXLANVniParameter]; found {
+		vni, err := strconv.ParseUint(value, 10, 24)
+		if err != nil || vni == 0 {
+			return fmt.Errorf("invalid %s %s %s", VXLANVniParameter, value, source)
+		}
+		params.vni = uint32(vni)
+	}
+	if value, found := parameters[VXLANPortParameter]; found {
+		port, err := strconv.ParseUint(value, 10, 16)
+		if err != nil || port == 0 {
+			return fmt.Errorf("invalid %s %s %s", VXLANPortParameter, value, source)
+		}
+		params.port = uint16(port)
+	}
+	if value, found := parameters[VXLANVrfParameter]; found {
+		vrf, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || vrf == uint64(vpplink.InvalidID) {
+			return fmt.Errorf("invalid %s %s %s", VXLANVrfParameter, value, source)
+		}
+		params.vrf = uint32(vrf)
+	}
+	return nil
+}
+
+func (p *VXLanProvider) getDefaultVXLANParams() vxlanParams {
+	return vxlanParams{vni: p.getVXLANVNI(), port: p.getVXLANPort()}
+}
+
+/**
+ * VNI, port & VRF to use for cn, from the connectivity policy rule
+ * parameters, otherwise from the annotations of its IPPool if any
+ */
+func (p *VXLanProvider) getVXLANParams(cn *common.NodeConnectivity) (params vxlanParams, err error) {
+	params = p.getDefaultVXLANParams()
+	ipPool := p.server.ipam.GetPrefixIPPool(&cn.Dst)
+	if ipPool != nil {
+		err = params.parse(poolVXLANParameters(ipPool), fmt.Sprintf("annotation on IPPool %s", ipPool.Name))
+		if err != nil {
+			return params, err
+		}
+	}
+	err = params.parse(cn.Parameters, "parameter in the connectivity policy")
+	return params, err
+}
+
+/**
+ * All the VNI/VRF our pools and connectivity policy can use. Tunnels with
+ * other VNIs aren't ours, and our routes live in these VRFs.
+ */
+func (p *VXLanProvider) getConfiguredVXLANParams() []vxlanParams {
+	defaults := p.getDefaultVXLANParams()
+	configured := []vxlanParams{defaults}
+	for _, ipPool := range p.server.ipam.GetIPPools() {
+		params := defaults
+		err := params.parse(poolVXLANParameters(&ipPool), fmt.Sprintf("annotation on IPPool %s", ipPool.Name))
+		if err != nil {
+			p.log.Warn(err)
+			continue
+		}
+		configured = append(configured, params)
+	}
+	for _, rule := range config.ConnectivityPolicy {
+		if rule.Provider != VXLAN {
+			continue
+		}
+		params := defaults
+		err := params.parse(rule.Parameters, "parameter in the connectivity policy")
+		if err != nil {
+			p.log.Warn(err)
+			continue
+		}
+		configured = append(configured, params)
+	}
+	return configured
+}
+
+func (p *VXLanProvider) errorCleanup(tunnel *types.VXLanTunnel) {
+	err := p.vpp.DelVXLanTunnel(tunnel)
+	if err != nil {
+		p.log.Errorf("Error deleting vxlan tunnel %s after error: %v", tunnel.String(), err)
+	}
+}
+
 func (p *VXLanProvider) getNodeIpForConnectivity(cn *common.NodeConnectivity) (nodeIP net.IP, err error) {
 	ip4, ip6 := p.server.GetNodeIPs()
 	if vpplink.IsIP6(cn.NextHop) && ip6 != nil {
@@ -136,16 +353,31 @@ func (p *VXLanProvider) AddConnectivity(cn *common.NodeConnectivity) error {
 	if err != nil {
 		return err
 	}
+	params, err := p.getVXLANParams(cn)
+	if err != nil {
+		return err
+	}
+	vni, port := params.vni, params.port
+	key := vxlanTunnelKey(cn.NextHop, vni, port)
+
+	/* The VNI, port or VRF of the pool changed */
+	if oldKey, found := p.getConnectivityTunnel(cn); found && (oldKey != key || p.vxlanVrfs[p.vxlanIfs[oldKey].SwIfIndex] != params.vrf) {
+		p.log.Infof("connectivity(upd) VXLan cn=%s moves to vni %d port %d vrf %d", cn.String(), vni, port, params.vrf)
+		err = p.DelConnectivity(cn)
+		if err != nil {
+			p.log.Errorf("Error deleting previous VXLan connectivity %s: %v", cn.String(), err)
+		}
+	}
 
-	_, found := p.vxlanIfs[cn.NextHop.String()]
+	_, found := p.vxlanIfs[key]
 	if !found {
-		p.log.Infof("connectivity(add) VXLan %s->%s", nodeIP.String(), cn.NextHop.String())
+		p.log.Infof("connectivity(add) VXLan %s->%s vni %d port %d vrf %d", nodeIP.String(), cn.NextHop.String(), vni, port, params.vrf)
 		tunnel := &types.VXLanTunnel{
 			SrcAddress:     nodeIP,
 			DstAddress:     cn.NextHop,
-			SrcPort:        p.getVXLANPort(),
-			DstPort:        p.getVXLANPort(),
-			Vni:            p.getVXLANVNI(),
+			SrcPort:        port,
+			DstPort:        port,
+			Vni:            vni,
 			DecapNextIndex: p.ip4NodeIndex,
 		}
 		if vpplink.IsIP6(cn.NextHop) {
@@ -158,46 +390,55 @@ func (p *VXLanProvider) AddConnectivity(cn *common.NodeConnectivity) error {
 
 		iface := types2.Interface{SwIfIndex: swIfIndex}
 
+		/* Decapsulated traffic is looked up in the VRF of the pool */
+		if params.vrf != 0 {
+			for _, ipFamily := range vpplink.IpFamilies {
+				err = p.vpp.SetInterfaceVRF(&iface, params.vrf, ipFamily.IsIp6)
+				if err != nil {
+					p.errorCleanup(tunnel)
+					return errors.Wrapf(err, "Error setting vxlan tunnel in vrf %d", params.vrf)
+				}
+			}
+		}
+
 		err = p.vpp.InterfaceSetUnnumbered(iface.SwIfIndex, config.DataInterfaceSwIfIndex)
 		if err != nil {
-			// TODO : delete tunnel
+			p.errorCleanup(tunnel)
 			return errors.Wrapf(err, "Error setting vxlan tunnel unnumbered")
 		}
 
 		// Always enable GSO feature on VXLan tunnel, only a tiny negative effect on perf if GSO is not enabled on the taps
 		err = p.vpp.EnableGSOFeature(&iface)
 		if err != nil {
-			// TODO : delete tunnel
+			p.errorCleanup(tunnel)
 			return errors.Wrapf(err, "Error enabling gso for vxlan interface")
 		}
 
 		err = p.vpp.CnatEnableFeatures(iface.SwIfIndex)
 		if err != nil {
-			// TODO : delete tunnel
+			p.errorCleanup(tunnel)
 			return errors.Wrapf(err, "Error enabling nat for vxlan interface")
 		}
 
 		err = p.vpp.InterfaceAdminUp(&iface)
 		if err != nil {
-			// TODO : delete tunnel
+			p.errorCleanup(tunnel)
 			return errors.Wrapf(err, "Error setting vxlan interface up")
 		}
+		p.server.setTunnelMtu(VXLAN, iface.SwIfIndex, p.EncapOverhead(cn))
 
-		p.log.Debugf("Routing pod->node %s traffic into tunnel (swIfIndex %d)", cn.NextHop.String(), iface.SwIfIndex)
-		err = p.vpp.RouteAdd(&types.Route{
-			Dst: common.ToMaxLenCIDR(cn.NextHop),
-			Paths: []types.RoutePath{{
-				SwIfIndex: iface.SwIfIndex,
-				Gw:        nil,
-			}},
-			Table: common.PodVRFIndex,
-		})
-		if err != nil {
-			// TODO : delete tunnel
-			return errors.Wrapf(err, "Error adding route to %s in ipip tunnel %d for pods", cn.NextHop.String(), iface.SwIfIndex)
+		/* Only the first tunnel to a peer carries pod->node traffic */
+		if len(p.getNextHopTunnels(cn.NextHop)) == 0 {
+			err = p.addPodToNodeRoute(cn.NextHop, iface.SwIfIndex)
+			if err != nil {
+				p.server.releaseTunnelMtu(VXLAN, iface.SwIfIndex)
+				p.errorCleanup(tunnel)
+				return err
+			}
 		}
 
-		p.vxlanIfs[cn.NextHop.String()] = *tunnel
+		p.vxlanIfs[key] = *tunnel
+		p.vxlanVrfs[swIfIndex] = params.vrf
 		p.log.Infof("connectivity(add) VXLan Added tunnel=%s", tunnel)
 		common.SendEvent(common.CalicoVppEvent{
 			Type: common.TunnelAdded,
@@ -205,15 +446,19 @@ func (p *VXLanProvider) AddConnectivity(cn *common.NodeConnectivity) error {
 		})
 
 	}
-	tunnel := p.vxlanIfs[cn.NextHop.String()]
+	tunnel := p.vxlanIfs[key]
+	if vrf := p.vxlanVrfs[tunnel.SwIfIndex]; vrf != params.vrf {
+		return errors.Errorf("vxlan tunnel vni %d to %s is already in vrf %d, not %d", vni, cn.NextHop.String(), vrf, params.vrf)
+	}
 
-	p.log.Infof("connectivity(add) vxlan route dst=%s via swIfIndex=%d", cn.Dst.IP.String(), tunnel.SwIfIndex)
+	p.log.Infof("connectivity(add) vxlan route dst=%s via swIfIndex=%d vrf=%d", cn.Dst.IP.String(), tunnel.SwIfIndex, params.vrf)
 	route := &types.Route{
 		Dst: &cn.Dst,
 		Paths: []types.RoutePath{{
 			SwIfIndex: tunnel.SwIfIndex,
 			Gw:        nodeIP,
 		}},
+		Table: params.vrf,
 	}
 	_, found = p.vxlanRoutes[tunnel.SwIfIndex]
 	if !found {
@@ -223,11 +468,28 @@ func (p *VXLanProvider) AddConnectivity(cn *common.NodeConnectivity) error {
 	return p.vpp.RouteAdd(route)
 }
 
+func (p *VXLanProvider) addPodToNodeRoute(nextHop net.IP, swIfIndex uint32) error {
+	p.log.Debugf("Routing pod->node %s traffic into tunnel (swIfIndex %d)", nextHop.String(), swIfIndex)
+	err := p.vpp.RouteAdd(&types.Route{
+		Dst: common.ToMaxLenCIDR(nextHop),
+		Paths: []types.RoutePath{{
+			SwIfIndex: swIfIndex,
+			Gw:        nil,
+		}},
+		Table: common.PodVRFIndex,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Error adding route to %s in vxlan tunnel %d for pods", nextHop.String(), swIfIndex)
+	}
+	return nil
+}
+
 func (p *VXLanProvider) DelConnectivity(cn *common.NodeConnectivity) error {
-	tunnel, found := p.vxlanIfs[cn.NextHop.String()]
+	key, found := p.getConnectivityTunnel(cn)
 	if !found {
 		return errors.Errorf("Deleting unknown vxlan tunnel cn=%s", cn.String())
 	}
+	tunnel := p.vxlanIfs[key]
 	nodeIP, err := p.getNodeIpForConnectivity(cn)
 	if err != nil {
 		return err
@@ -240,6 +502,7 @@ func (p *VXLanProvider) DelConnectivity(cn *common.NodeConnectivity) error {
 			SwIfIndex: tunnel.SwIfIndex,
 			Gw:        nodeIP,
 		}},
+		Table: p.vxlanVrfs[tunnel.SwIfIndex],
 	}
 	err = p.vpp.RouteDel(routeToDelete)
 	if err != nil {
@@ -251,22 +514,31 @@ func (p *VXLanProvider) DelConnectivity(cn *common.NodeConnectivity) error {
 	remaining_routes, found := p.vxlanRoutes[tunnel.SwIfIndex]
 	if !found || len(remaining_routes) == 0 {
 		p.log.Infof("connectivity(del) all gone. Deleting VXLan tunnel swIfIndex=%d", tunnel.SwIfIndex)
-		err = p.vpp.RouteDel(&types.Route{
-			Dst: common.ToMaxLenCIDR(cn.NextHop),
-			Paths: []types.RoutePath{{
-				SwIfIndex: tunnel.SwIfIndex,
-				Gw:        nil,
-			}},
-			Table: common.PodVRFIndex,
-		})
+		delete(p.vxlanIfs, key)
+		delete(p.vxlanRoutes, tunnel.SwIfIndex)
+		delete(p.vxlanVrfs, tunnel.SwIfIndex)
+		remaining := p.getNextHopTunnels(cn.NextHop)
+		if len(remaining) > 0 {
+			/* Move pod->node traffic to another tunnel to this peer */
+			err = p.addPodToNodeRoute(cn.NextHop, remaining[0].SwIfIndex)
+		} else {
+			err = p.vpp.RouteDel(&types.Route{
+				Dst: common.ToMaxLenCIDR(cn.NextHop),
+				Paths: []types.RoutePath{{
+					SwIfIndex: tunnel.SwIfIndex,
+					Gw:        nil,
+				}},
+				Table: common.PodVRFIndex,
+			})
+		}
 		if err != nil {
-			p.log.Errorf("Error deleting vxlan route dst=%s via tunnel swIfIndex=%d %s", cn.NextHop.String(), tunnel.SwIfIndex, err)
+			p.log.Errorf("Error updating vxlan route dst=%s via tunnel swIfIndex=%d %s", cn.NextHop.String(), tunnel.SwIfIndex, err)
 		}
+		p.server.releaseTunnelMtu(VXLAN, tunnel.SwIfIndex)
 		err = p.vpp.DelVXLanTunnel(&tunnel)
 		if err != nil {
 			p.log.Errorf("Error deleting VXLan tunnel %s after error: %v", tunnel.String(), err)
 		}
-		delete(p.vxlanIfs, cn.NextHop.String())
 		common.SendEvent(common.CalicoVppEvent{
 			Type: common.TunnelDeleted,
 			Old:  tunnel.SwIfIndex,
@@ -20,12 +20,14 @@ import (
 	"encoding/base64"
 	"fmt"
 	"net"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/projectcalico/calico/libcalico-go/lib/options"
 	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/common"
 	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/config"
+	"github.com/projectcalico/vpp-dataplane/vpplink"
 	"github.com/projectcalico/vpp-dataplane/vpplink/types"
 
 	types2 "git.fd.io/govpp.git/api/v0"
@@ -35,6 +37,16 @@ type WireguardProvider struct {
 	*ConnectivityProviderData
 	wireguardTunnel *types.WireguardTunnel
 	wireguardPeers  map[string]types.WireguardPeer
+
+	/* Tunnel replaced by the last key or port change, and its peers, during their grace period */
+	retiringTunnel *types.WireguardTunnel
+	retiringPeers  []types.WireguardPeer
+	retireAt       time.Time
+	retireTimer    *time.Timer
+
+	/* VPP peers as of the last status snapshot, by wireguardPeerKey */
+	statusPeers map[string]*types.WireguardPeer
+	statusErr   error
 }
 
 func NewWireguardProvider(d *ConnectivityProviderData) *WireguardProvider {
@@ -51,9 +63,17 @@ func (p *WireguardProvider) Enabled(cn *common.NodeConnectivity) bool {
 		return false
 	}
 	node := p.GetNodeByIp(cn.NextHop)
+	if node == nil {
+		return false
+	}
 	return node.Status.WireguardPublicKey != ""
 }
 
+func (p *WireguardProvider) EncapOverhead(cn *common.NodeConnectivity) int {
+	/* UDP + wireguard data header & auth tag */
+	return outerIPHeaderSize(cn.NextHop) + 8 + 32
+}
+
 func (p *WireguardProvider) getWireguardPort() uint16 {
 	felixConfig := p.GetFelixConfig()
 	if felixConfig.WireguardListeningPort == 0 {
@@ -77,6 +97,11 @@ func (p *WireguardProvider) getNodePublicKey(cn *common.NodeConnectivity) ([]byt
 }
 
 func (p *WireguardProvider) publishWireguardPublicKey(pubKey string) error {
+	/* Let the policy server report it to felix */
+	common.SendEvent(common.CalicoVppEvent{
+		Type: common.WireguardPublicKeyChanged,
+		New:  pubKey,
+	})
 	// Ref: felix/daemon/daemon.go:1056
 	node, err := p.Clientv3().Nodes().Get(context.Background(), config.NodeName, options.GetOptions{})
 	if err != nil {
@@ -91,9 +116,101 @@ func (p *WireguardProvider) publishWireguardPublicKey(pubKey string) error {
 	return nil
 }
 
+func wireguardPeerKey(publicKey []byte, addr net.IP) string {
+	return base64.StdEncoding.EncodeToString(publicKey) + "-" + addr.String()
+}
+
+/* The handshake state is only known to VPP, so we dump the peers once per status collection */
+func (p *WireguardProvider) SnapshotStatus() {
+	p.statusPeers = make(map[string]*types.WireguardPeer)
+	p.statusErr = nil
+	if p.wireguardTunnel == nil {
+		return
+	}
+	peers, err := p.vpp.ListWireguardPeers()
+	if err != nil {
+		p.statusErr = err
+		return
+	}
+	for _, vppPeer := range peers {
+		p.statusPeers[wireguardPeerKey(vppPeer.PublicKey, vppPeer.Addr)] = vppPeer
+	}
+}
+
+func (p *WireguardProvider) GetTunnelSwIfIndexes(nextHop net.IP) []uint32 {
+	if _, found := p.wireguardPeers[nextHop.String()]; !found || p.wireguardTunnel == nil {
+		return []uint32{}
+	}
+	return []uint32{p.wireguardTunnel.SwIfIndex}
+}
+
+func (p *WireguardProvider) GetStatus(nextHop net.IP) common.NodeConnectivityStatus {
+	status := common.NodeConnectivityStatus{
+		SwIfIndex: vpplink.InvalidID,
+		State:     common.ConnectivityStateDown,
+	}
+	peer, found := p.wireguardPeers[nextHop.String()]
+	if !found || p.wireguardTunnel == nil {
+		status.Detail = "no peer"
+		return status
+	}
+	status.SwIfIndex = p.wireguardTunnel.SwIfIndex
+	if p.statusErr != nil {
+		status.Detail = fmt.Sprintf("error listing peers: %v", p.statusErr)
+		return status
+	}
+	return wireguardPeerStatus(status, p.statusPeers[wireguardPeerKey(peer.PublicKey, peer.Addr)])
+}
+
+func wireguardPeerStatus(status common.NodeConnectivityStatus, vppPeer *types.WireguardPeer) common.NodeConnectivityStatus {
+	switch {
+	case vppPeer == nil:
+		status.Detail = "peer not found in VPP"
+	case vppPeer.IsEstablished:
+		status.State = common.ConnectivityStateUp
+		status.Detail = "handshake established"
+	case vppPeer.IsDead:
+		status.Detail = "peer dead"
+	default:
+		status.State = common.ConnectivityStatePending
+		status.Detail = "handshake pending"
+	}
+	return status
+}
+
+/**
+ * pickWireguardTunnel returns the tunnel on one of our addresses with the
+ * highest swIfIndex, which is the newest if we restarted during a grace
+ * period, and the other ones on our addresses which are to be deleted.
+ */
+func pickWireguardTunnel(tunnels []*types.WireguardTunnel, ip4 *net.IP, ip6 *net.IP) (*types.WireguardTunnel, []*types.WireguardTunnel) {
+	var picked *types.WireguardTunnel
+	stale := make([]*types.WireguardTunnel, 0)
+	for _, tunnel := range tunnels {
+		if !(ip4 != nil && tunnel.Addr.Equal(*ip4)) && !(ip6 != nil && tunnel.Addr.Equal(*ip6)) {
+			continue
+		}
+		if picked == nil {
+			picked = tunnel
+		} else if tunnel.SwIfIndex > picked.SwIfIndex {
+			stale = append(stale, picked)
+			picked = tunnel
+		} else {
+			stale = append(stale, tunnel)
+		}
+	}
+	return picked, stale
+}
+
 func (p *WireguardProvider) RescanState() {
 	p.wireguardPeers = make(map[string]types.WireguardPeer)
 	p.wireguardTunnel = nil
+	p.retiringTunnel = nil
+	p.retiringPeers = nil
+	if p.retireTimer != nil {
+		p.retireTimer.Stop()
+		p.retireTimer = nil
+	}
 
 	p.log.Debugf("Wireguard: Rescanning existing tunnels")
 	tunnels, err := p.vpp.ListWireguardTunnels()
@@ -101,15 +218,10 @@ func (p *WireguardProvider) RescanState() {
 		p.log.Errorf("Error listing wireguard tunnels: %v", err)
 	}
 	ip4, ip6 := p.server.GetNodeIPs()
-	for _, tunnel := range tunnels {
-		if ip4 != nil && tunnel.Addr.Equal(*ip4) {
-			p.log.Infof("Found existing v4 tunnel: %s", tunnel)
-			p.wireguardTunnel = tunnel
-		}
-		if ip6 != nil && tunnel.Addr.Equal(*ip6) {
-			p.log.Infof("Found existing v6 tunnel: %s", tunnel)
-			p.wireguardTunnel = tunnel
-		}
+	tunnel, stale := pickWireguardTunnel(tunnels, ip4, ip6)
+	if tunnel != nil {
+		p.log.Infof("Found existing tunnel: %s", tunnel)
+		p.wireguardTunnel = tunnel
 	}
 
 	p.log.Debugf("Wireguard: Rescanning existing peers")
@@ -119,7 +231,21 @@ func (p *WireguardProvider) RescanState() {
 	}
 
 	for _, peer := range peers {
-		p.wireguardPeers[peer.Addr.String()] = *peer
+		if tunnel != nil && peer.SwIfIndex == tunnel.SwIfIndex {
+			p.wireguardPeers[peer.Addr.String()] = *peer
+			continue
+		}
+		for _, staleTunnel := range stale {
+			if peer.SwIfIndex == staleTunnel.SwIfIndex {
+				p.retiringPeers = append(p.retiringPeers, *peer)
+			}
+		}
+	}
+	/* We restarted during a grace period, the peers were already moved */
+	for _, staleTunnel := range stale {
+		p.retiringTunnel = staleTunnel
+		p.RetireOldTunnel()
+		p.retiringPeers = nil
 	}
 }
 
@@ -133,9 +259,11 @@ func (p *WireguardProvider) errorCleanup(tunnel *types.WireguardTunnel) {
 func (p *WireguardProvider) EnableDisable(isEnable bool) {
 	if isEnable {
 		if p.wireguardTunnel == nil {
-			err := p.createWireguardTunnel(false /* isv6 */)
+			/* Peers are reached over v4 when we have a v4 address */
+			ip4, _ := p.server.GetNodeIPs()
+			err := p.createWireguardTunnel(ip4 == nil /* isv6 */)
 			if err != nil {
-				p.log.Errorf("Wireguard: Error creating v4 tunnel %s", err)
+				p.log.Errorf("Wireguard: Error creating tunnel %s", err)
 				return
 			}
 		}
@@ -154,16 +282,201 @@ func (p *WireguardProvider) EnableDisable(isEnable bool) {
 	}
 }
 
+/**
+ * VPP cannot change the port nor the key of an existing wireguard interface,
+ * so both are applied by creating a new tunnel next to the current one and
+ * moving the peers and their routes to it. The old tunnel, with a copy of the
+ * peers, keeps accepting handshakes with the old key & port for a grace
+ * period, while peers pick up our new public key from the node status.
+ */
+const (
+	WireguardRotateKeyAnnotation = "cni.projectcalico.org/vpp.wireguard.rotate-key"
+
+	wireguardRetireGracePeriod = 2 * time.Minute
+)
+
+/* The routes AddConnectivity creates towards a peer */
+func wireguardPeerRoutes(peer *types.WireguardPeer) []types.Route {
+	routes := make([]types.Route, 0, len(peer.AllowedIps))
+	for _, allowedIp := range peer.AllowedIps {
+		dst := allowedIp
+		if allowedIp.IP.Equal(peer.Addr) {
+			routes = append(routes, types.Route{
+				Dst: common.ToMaxLenCIDR(peer.Addr),
+				Paths: []types.RoutePath{{
+					SwIfIndex: peer.SwIfIndex,
+					Gw:        nil,
+				}},
+				Table: common.PodVRFIndex,
+			})
+		} else {
+			routes = append(routes, types.Route{
+				Dst: &dst,
+				Paths: []types.RoutePath{{
+					SwIfIndex: peer.SwIfIndex,
+					Gw:        dst.IP,
+				}},
+			})
+		}
+	}
+	return routes
+}
+
+func (p *WireguardProvider) delWireguardPeerAndRoutes(peer *types.WireguardPeer) error {
+	err := p.vpp.DelWireguardPeer(peer)
+	if err != nil {
+		return errors.Wrapf(err, "Error deleting wireguard peer %s", peer)
+	}
+	for _, route := range wireguardPeerRoutes(peer) {
+		err = p.vpp.RouteDel(&route)
+		if err != nil {
+			return errors.Wrapf(err, "Error deleting route to %s in wg tunnel %d", route.Dst.String(), peer.SwIfIndex)
+		}
+	}
+	return nil
+}
+
+/* movedWireguardPeer is peer on the tunnel swIfIndex, towards port */
+func movedWireguardPeer(peer types.WireguardPeer, swIfIndex uint32, port uint16) types.WireguardPeer {
+	moved := peer
+	moved.SwIfIndex = swIfIndex
+	moved.Port = port
+	moved.AllowedIps = append([]net.IPNet(nil), peer.AllowedIps...)
+	return moved
+}
+
+/* moveWireguardPeer adds peer on the current tunnel and points its routes there */
+func (p *WireguardProvider) moveWireguardPeer(peer *types.WireguardPeer) (*types.WireguardPeer, error) {
+	moved := movedWireguardPeer(*peer, p.wireguardTunnel.SwIfIndex, p.getWireguardPort())
+	var err error
+	moved.Index, err = p.vpp.AddWireguardPeer(&moved)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error adding wireguard peer %s", moved.String())
+	}
+	for _, route := range wireguardPeerRoutes(&moved) {
+		err = p.vpp.RouteAdd(&route)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error adding route to %s in wg tunnel %d", route.Dst.String(), moved.SwIfIndex)
+		}
+	}
+	for _, route := range wireguardPeerRoutes(peer) {
+		err = p.vpp.RouteDel(&route)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error deleting route to %s in wg tunnel %d", route.Dst.String(), peer.SwIfIndex)
+		}
+	}
+	return &moved, nil
+}
+
+func (p *WireguardProvider) replaceWireguardTunnel() error {
+	if p.wireguardTunnel == nil {
+		/* Nothing to do, the tunnel will be created with the current settings */
+		return nil
+	}
+	/* A previous tunnel still in its grace period goes away now */
+	p.RetireOldTunnel()
+
+	oldTunnel := p.wireguardTunnel
+	err := p.createWireguardTunnel(oldTunnel.Addr.To4() == nil)
+	if err != nil {
+		p.wireguardTunnel = oldTunnel
+		return errors.Wrapf(err, "Error creating the new wireguard tunnel")
+	}
+	p.retiringTunnel = oldTunnel
+	p.retiringPeers = make([]types.WireguardPeer, 0, len(p.wireguardPeers))
+	failed := 0
+	for nextHop, peer := range p.wireguardPeers {
+		p.retiringPeers = append(p.retiringPeers, peer)
+		moved, err := p.moveWireguardPeer(&peer)
+		if err != nil {
+			/* AddConnectivity will add it back */
+			p.log.Errorf("Wireguard: error moving peer %s to the new tunnel: %v", nextHop, err)
+			delete(p.wireguardPeers, nextHop)
+			failed++
+			continue
+		}
+		p.wireguardPeers[nextHop] = *moved
+	}
+	p.retireAt = time.Now().Add(wireguardRetireGracePeriod)
+	p.retireTimer = time.AfterFunc(wireguardRetireGracePeriod, p.server.signalWireguardRetire)
+	p.log.Infof("connectivity(upd) Wireguard tunnel=%s replaces tunnel=%s, retiring it in %s",
+		p.wireguardTunnel, oldTunnel, wireguardRetireGracePeriod)
+
+	key := base64.StdEncoding.EncodeToString(p.wireguardTunnel.PublicKey)
+	err = p.publishWireguardPublicKey(key)
+	if err != nil {
+		return errors.Wrapf(err, "Error publishing wireguard public key")
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d wireguard peers could not be moved to the new tunnel", failed)
+	}
+	return nil
+}
+
+/* RetireOldTunnelIfDue deletes the tunnel replaced by the last rotation once its grace period is over */
+func (p *WireguardProvider) RetireOldTunnelIfDue(now time.Time) {
+	if p.retiringTunnel == nil || now.Before(p.retireAt) {
+		/* The timer of an earlier rotation */
+		return
+	}
+	p.RetireOldTunnel()
+}
+
+/* RetireOldTunnel deletes the tunnel replaced by the last rotation and its peers */
+func (p *WireguardProvider) RetireOldTunnel() {
+	if p.retireTimer != nil {
+		p.retireTimer.Stop()
+		p.retireTimer = nil
+	}
+	if p.retiringTunnel == nil {
+		return
+	}
+	for _, peer := range p.retiringPeers {
+		/* Its routes were moved to the new tunnel */
+		err := p.vpp.DelWireguardPeer(&peer)
+		if err != nil {
+			p.log.Errorf("Wireguard: error deleting retired peer %s: %v", peer.String(), err)
+		}
+	}
+	p.log.Infof("connectivity(upd) Wireguard deleting retired tunnel=%s", p.retiringTunnel)
+	p.server.releaseTunnelMtu(WIREGUARD, p.retiringTunnel.SwIfIndex)
+	err := p.vpp.DelWireguardTunnel(p.retiringTunnel)
+	if err != nil {
+		p.log.Errorf("Wireguard: error deleting retired tunnel %s: %v", p.retiringTunnel, err)
+	}
+	common.SendEvent(common.CalicoVppEvent{
+		Type: common.TunnelDeleted,
+		Old:  p.retiringTunnel.SwIfIndex,
+	})
+	p.retiringTunnel = nil
+	p.retiringPeers = nil
+}
+
+/* UpdatePort is called when the felix WireguardListeningPort changes */
+func (p *WireguardProvider) UpdatePort() error {
+	if p.wireguardTunnel != nil && p.wireguardTunnel.Port == p.getWireguardPort() {
+		return nil
+	}
+	p.log.Infof("connectivity(upd) Wireguard port changed to %d", p.getWireguardPort())
+	return p.replaceWireguardTunnel()
+}
+
+/* RotateKey generates a new private key and publishes the matching public key */
+func (p *WireguardProvider) RotateKey() error {
+	p.log.Infof("connectivity(upd) Wireguard rotating private key")
+	return p.replaceWireguardTunnel()
+}
+
 func (p *WireguardProvider) createWireguardTunnel(isIP6 bool) error {
 
 	var nodeIp net.IP
 	ip4, ip6 := p.server.GetNodeIPs()
-	if ip4 != nil {
-		nodeIp = *ip4
-	} else if ip6 != nil {
+	if isIP6 && ip6 != nil {
 		nodeIp = *ip6
+	} else if !isIP6 && ip4 != nil {
+		nodeIp = *ip4
 	} else {
-		return fmt.Errorf("Missing node address")
+		return fmt.Errorf("Missing node address for the wireguard tunnel (v6=%t)", isIP6)
 	}
 
 	p.log.Debugf("Adding wireguard Tunnel to VPP")
@@ -210,6 +523,8 @@ func (p *WireguardProvider) createWireguardTunnel(isIP6 bool) error {
 		p.errorCleanup(tunnel)
 		return errors.Wrapf(err, "Error setting wireguard interface up")
 	}
+	/* Also covers the tunnels replacing this one on key rotations */
+	p.server.setTunnelMtu(WIREGUARD, iface.SwIfIndex, p.EncapOverhead(&common.NodeConnectivity{NextHop: nodeIp}))
 
 	common.SendEvent(common.CalicoVppEvent{
 		Type: common.TunnelAdded,
@@ -65,6 +65,12 @@ func fromProtoHostEndpointID(ep *proto.HostEndpointID) *HostEndpointID {
 	}
 }
 
+func toProtoHostEndpointID(id *HostEndpointID) *proto.HostEndpointID {
+	return &proto.HostEndpointID{
+		EndpointId: id.EndpointID,
+	}
+}
+
 func fromProtoHostEndpoint(hep *proto.HostEndpoint, server *Server) *HostEndpoint {
 	r := &HostEndpoint{
 		Profiles:          hep.ProfileIds,
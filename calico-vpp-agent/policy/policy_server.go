@@ -25,6 +25,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	felixConfig "github.com/projectcalico/calico/felix/config"
@@ -66,6 +67,11 @@ type Server struct {
 
 	state         SyncState
 	nextSeqNumber uint64
+	/* current connection to felix, nil when disconnected */
+	felixConn net.Conn
+	startTime time.Time
+
+	wireguardPublicKey string
 
 	endpointsLock       sync.Mutex
 	endpointsInterfaces map[WorkloadEndpointID]uint32
@@ -112,6 +118,7 @@ func NewPolicyServer(vpp *vpplink.VppLink, log *logrus.Entry) (*Server, error) {
 
 		state:         StateDisconnected,
 		nextSeqNumber: 0,
+		startTime:     time.Now(),
 
 		endpointsInterfaces: make(map[WorkloadEndpointID]uint32),
 
@@ -132,6 +139,7 @@ func NewPolicyServer(vpp *vpplink.VppLink, log *logrus.Entry) (*Server, error) {
 		common.PodDeleted,
 		common.TunnelAdded,
 		common.TunnelDeleted,
+		common.WireguardPublicKeyChanged,
 	)
 
 	server.interfacesMap, err = server.mapTagToInterfaceDetails()
@@ -233,7 +241,6 @@ func (s *Server) getEndpointToHostAction() string {
 // workloadAdded is called by the CNI server when a container interface is created,
 // either during startup when reconnecting the interfaces, or when a new pod is created
 func (s *Server) workloadAdded(id *WorkloadEndpointID, swIfIndex uint32, containerIPs []*net.IPNet) {
-	// TODO: Send WorkloadEndpointStatusUpdate to felix
 	s.endpointsLock.Lock()
 	defer s.endpointsLock.Unlock()
 
@@ -259,6 +266,8 @@ func (s *Server) workloadAdded(id *WorkloadEndpointID, swIfIndex uint32, contain
 			err := wep.Create(s.vpp, swIfIndex, s.configuredState)
 			if err != nil {
 				s.log.Errorf("Error processing workload addition: %s", err)
+			} else {
+				s.sendWorkloadEndpointStatus(id, EndpointStatusUp)
 			}
 		}
 	}
@@ -274,7 +283,6 @@ func (s *Server) workloadAdded(id *WorkloadEndpointID, swIfIndex uint32, contain
 
 // WorkloadRemoved is called by the CNI server when the interface of a pod is deleted
 func (s *Server) WorkloadRemoved(id *WorkloadEndpointID, containerIPs []*net.IPNet) {
-	// TODO: Send WorkloadEndpointStatusRemove to felix
 	s.endpointsLock.Lock()
 	defer s.endpointsLock.Unlock()
 
@@ -294,6 +302,8 @@ func (s *Server) WorkloadRemoved(id *WorkloadEndpointID, containerIPs []*net.IPN
 			if err != nil {
 				s.log.Errorf("Error processing workload removal: %s", err)
 			}
+			/* felix still knows the endpoint, until it removes it */
+			s.sendWorkloadEndpointStatus(id, EndpointStatusDown)
 		}
 	}
 	delete(s.endpointsInterfaces, *id)
@@ -365,6 +375,9 @@ func (s *Server) handlePolicyServerEvents(evt common.CalicoVppEvent) error {
 		for _, h := range state.HostEndpoints {
 			h.handleTunnelChange(swIfIndex, false /* isAdd */, pending)
 		}
+	case common.WireguardPublicKeyChanged:
+		s.wireguardPublicKey = evt.New.(string)
+		s.sendWireguardStatus()
 	}
 	return nil
 }
@@ -412,6 +425,15 @@ func (s *Server) ServePolicy(t *tomb.Tomb) error {
 		}
 		s.log.Infof("Accepted connection from felix")
 		s.state = StateConnected
+		s.felixConn = conn
+
+		if s.statusReportEnabled() {
+			s.sendProcessStatus()
+		}
+		if s.wireguardPublicKey != "" {
+			s.sendWireguardStatus()
+		}
+		statusTimer := s.newStatusReportTimer()
 
 		felixUpdates := s.MessageReader(conn)
 	innerLoop:
@@ -419,6 +441,7 @@ func (s *Server) ServePolicy(t *tomb.Tomb) error {
 			select {
 			case <-t.Dying():
 				s.log.Infof("Policy server exiting")
+				stopStatusReportTimer(statusTimer)
 				err = conn.Close()
 				if err != nil {
 					s.log.WithError(err).Warn("Error closing unix connection to felix API proxy")
@@ -430,6 +453,9 @@ func (s *Server) ServePolicy(t *tomb.Tomb) error {
 				if err != nil {
 					s.log.WithError(err).Warn("Error handling PolicyServerEvents")
 				}
+			case <-statusReportChan(statusTimer):
+				s.sendProcessStatus()
+				statusTimer = s.newStatusReportTimer()
 			// <-felixUpdates & handleFelixUpdate does the bulk of the policy sync job. It starts by reconciling the current
 			// configured state in VPP (empty at first) with what is sent by felix, and once both are in
 			// sync, it keeps processing felix updates. It also sends endpoint updates to felix when the
@@ -445,8 +471,15 @@ func (s *Server) ServePolicy(t *tomb.Tomb) error {
 					// TODO: Restart VPP as well? State is left over there...
 					break innerLoop
 				}
+				if _, ok := msg.(*proto.ConfigUpdate); ok {
+					/* The reporting interval may have changed */
+					stopStatusReportTimer(statusTimer)
+					statusTimer = s.newStatusReportTimer()
+				}
 			}
 		}
+		stopStatusReportTimer(statusTimer)
+		s.felixConn = nil
 		err = conn.Close()
 		if err != nil {
 			s.log.WithError(err).Warn("Error closing unix connection to felix API proxy")
@@ -659,7 +692,12 @@ func (s *Server) handleInSync(msg *proto.InSync) (err error) {
 
 	s.state = StateInSync
 	s.log.Infof("Policies now in sync")
-	return s.applyPendingState()
+	err = s.applyPendingState()
+	if err != nil {
+		return err
+	}
+	s.sendAllEndpointStatuses()
+	return nil
 }
 
 func (s *Server) handleIpsetUpdate(msg *proto.IPSetUpdate, pending bool) (err error) {
@@ -874,6 +912,9 @@ func (s *Server) handleHostEndpointUpdate(msg *proto.HostEndpointUpdate, pending
 	hep.TunnelSwIfIndexes = s.getAllTunnelSwIfIndexes()
 	if len(hep.UplinkSwIfIndexes) == 0 || len(hep.TapSwIfIndexes) == 0 {
 		s.log.Errorf("No interface for host endpoint id=%s hep=%s", id.EndpointID, hep.String())
+		if !pending {
+			s.sendHostEndpointStatus(id, EndpointStatusDown)
+		}
 		return nil
 	}
 
@@ -898,6 +939,9 @@ func (s *Server) handleHostEndpointUpdate(msg *proto.HostEndpointUpdate, pending
 		}
 		s.log.Infof("policy(add) Updating host endpoint id=%s found=%t new=%s", *id, found, hep)
 	}
+	if !pending {
+		s.sendHostEndpointStatus(id, EndpointStatusUp)
+	}
 	return nil
 }
 
@@ -917,6 +961,9 @@ func (s *Server) handleHostEndpointRemove(msg *proto.HostEndpointRemove, pending
 	}
 	log.Infof("policy(del) Handled Host Endpoint Remove pending=%t id=%s %s", pending, id, existing)
 	delete(state.HostEndpoints, *id)
+	if !pending {
+		s.sendHostEndpointStatusRemove(id)
+	}
 	return nil
 }
 
@@ -934,12 +981,25 @@ func (s *Server) handleWorkloadEndpointUpdate(msg *proto.WorkloadEndpointUpdate,
 	if found {
 		if pending || !swIfIndexFound {
 			state.WorkloadEndpoints[*id] = wep
+			if !pending {
+				s.sendWorkloadEndpointStatus(id, EndpointStatusDown)
+			}
 			log.Infof("policy(upd) Workload Endpoint Update pending=%t id=%s existing=%s new=%s swIf=??", pending, *id, existing, wep)
+		} else if existing.SwIfIndex == types.InvalidID {
+			/* The interface came up but the policies could not be configured yet */
+			err := wep.Create(s.vpp, swIfIndex, state)
+			if err != nil {
+				return errors.Wrap(err, "cannot create workload endpoint")
+			}
+			state.WorkloadEndpoints[*id] = wep
+			s.sendWorkloadEndpointStatus(id, workloadEndpointStatus(wep))
+			log.Infof("policy(upd) Workload Endpoint Update (create) pending=%t id=%s new=%s swIf=%d", pending, *id, wep, swIfIndex)
 		} else {
 			err := existing.Update(s.vpp, wep, state)
 			if err != nil {
 				return errors.Wrap(err, "cannot update workload endpoint")
 			}
+			s.sendWorkloadEndpointStatus(id, workloadEndpointStatus(existing))
 			log.Infof("policy(upd) Workload Endpoint Update pending=%t id=%s existing=%s new=%s swIf=%d", pending, *id, existing, wep, swIfIndex)
 		}
 	} else {
@@ -949,8 +1009,12 @@ func (s *Server) handleWorkloadEndpointUpdate(msg *proto.WorkloadEndpointUpdate,
 			if err != nil {
 				return errors.Wrap(err, "cannot create workload endpoint")
 			}
+			s.sendWorkloadEndpointStatus(id, workloadEndpointStatus(wep))
 			log.Infof("policy(add) Workload Endpoint add pending=%t id=%s new=%s swIf=%d", pending, *id, wep, swIfIndex)
 		} else {
+			if !pending {
+				s.sendWorkloadEndpointStatus(id, EndpointStatusDown)
+			}
 			log.Infof("policy(add) Workload Endpoint add pending=%t id=%s new=%s swIf=??", pending, *id, wep)
 		}
 	}
@@ -976,6 +1040,9 @@ func (s *Server) handleWorkloadEndpointRemove(msg *proto.WorkloadEndpointRemove,
 	}
 	log.Infof("policy(del) Handled Workload Endpoint Remove pending=%t id=%s existing=%s", pending, *id, existing)
 	delete(state.WorkloadEndpoints, *id)
+	if !pending {
+		s.sendWorkloadEndpointStatusRemove(id)
+	}
 	return nil
 }
 
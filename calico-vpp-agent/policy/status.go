@@ -0,0 +1,133 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"time"
+
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/proto"
+	"github.com/projectcalico/vpp-dataplane/vpplink/types"
+)
+
+/**
+ * Status reported to felix, as the Linux dataplane does:
+ *  - process status heartbeats every ReportingIntervalSecs, 0 disables them
+ *  - endpoints are "up" once their policies are configured in VPP,
+ *    "down" while felix knows them but we don't have the interface
+ *    or their policies are not configured yet
+ *  - the public key of the VPP wireguard interface
+ */
+const (
+	EndpointStatusUp   = "up"
+	EndpointStatusDown = "down"
+)
+
+func (s *Server) statusReportEnabled() bool {
+	return s.felixConfig.ReportingIntervalSecs > 0
+}
+
+/* newStatusReportTimer arms the next process status report, nil when reporting is disabled */
+func (s *Server) newStatusReportTimer() *time.Timer {
+	if !s.statusReportEnabled() {
+		return nil
+	}
+	return time.NewTimer(s.felixConfig.ReportingIntervalSecs)
+}
+
+func statusReportChan(timer *time.Timer) <-chan time.Time {
+	if timer == nil {
+		return nil
+	}
+	return timer.C
+}
+
+func stopStatusReportTimer(timer *time.Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+/* The policies of an endpoint are configured once it is created on its interface */
+func workloadEndpointStatus(wep *WorkloadEndpoint) string {
+	if wep.SwIfIndex == types.InvalidID {
+		return EndpointStatusDown
+	}
+	return EndpointStatusUp
+}
+
+func (s *Server) sendToFelix(msg interface{}) {
+	if s.felixConn == nil {
+		/* Felix will get the status when it (re)connects */
+		return
+	}
+	err := s.SendMessage(s.felixConn, msg)
+	if err != nil {
+		s.log.WithError(err).Warn("Error sending status to felix")
+	}
+}
+
+func (s *Server) sendProcessStatus() {
+	s.sendToFelix(&proto.ProcessStatusUpdate{
+		IsoTimestamp: time.Now().UTC().Format(time.RFC3339),
+		Uptime:       time.Since(s.startTime).Seconds(),
+	})
+}
+
+func (s *Server) sendWorkloadEndpointStatus(id *WorkloadEndpointID, status string) {
+	s.log.Debugf("policy(status) Workload Endpoint id=%s status=%s", id, status)
+	s.sendToFelix(&proto.WorkloadEndpointStatusUpdate{
+		Id:     toProtoEndpointID(id),
+		Status: &proto.EndpointStatus{Status: status},
+	})
+}
+
+func (s *Server) sendWorkloadEndpointStatusRemove(id *WorkloadEndpointID) {
+	s.sendToFelix(&proto.WorkloadEndpointStatusRemove{
+		Id: toProtoEndpointID(id),
+	})
+}
+
+func (s *Server) sendHostEndpointStatus(id *HostEndpointID, status string) {
+	s.log.Debugf("policy(status) Host Endpoint id=%s status=%s", id, status)
+	s.sendToFelix(&proto.HostEndpointStatusUpdate{
+		Id:     toProtoHostEndpointID(id),
+		Status: &proto.EndpointStatus{Status: status},
+	})
+}
+
+func (s *Server) sendHostEndpointStatusRemove(id *HostEndpointID) {
+	s.sendToFelix(&proto.HostEndpointStatusRemove{
+		Id: toProtoHostEndpointID(id),
+	})
+}
+
+func (s *Server) sendWireguardStatus() {
+	s.sendToFelix(&proto.WireguardStatusUpdate{
+		PublicKey: s.wireguardPublicKey,
+	})
+}
+
+/* sendAllEndpointStatuses is called with endpointsLock held once we are in sync */
+func (s *Server) sendAllEndpointStatuses() {
+	for id, wep := range s.configuredState.WorkloadEndpoints {
+		id := id
+		s.sendWorkloadEndpointStatus(&id, workloadEndpointStatus(wep))
+	}
+	for id := range s.configuredState.HostEndpoints {
+		id := id
+		s.sendHostEndpointStatus(&id, EndpointStatusUp)
+	}
+}
@@ -69,6 +69,14 @@ func fromProtoEndpointID(ep *proto.WorkloadEndpointID) *WorkloadEndpointID {
 	}
 }
 
+func toProtoEndpointID(id *WorkloadEndpointID) *proto.WorkloadEndpointID {
+	return &proto.WorkloadEndpointID{
+		OrchestratorId: id.OrchestratorID,
+		WorkloadId:     id.WorkloadID,
+		EndpointId:     id.EndpointID,
+	}
+}
+
 func fromProtoWorkload(wep *proto.WorkloadEndpoint, server *Server) *WorkloadEndpoint {
 	r := &WorkloadEndpoint{
 		SwIfIndex: types.InvalidID,
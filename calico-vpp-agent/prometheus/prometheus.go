@@ -17,6 +17,7 @@ package prometheus
 
 import (
 	"context"
+	"encoding/json"
 	"log"
 	"net/http"
 	"time"
@@ -33,6 +34,7 @@ import (
 	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/cni/storage"
 	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/common"
 	"github.com/projectcalico/vpp-dataplane/vpplink"
+	"github.com/projectcalico/vpp-dataplane/vpplink/types"
 	"github.com/sirupsen/logrus"
 	tomb "gopkg.in/tomb.v2"
 )
@@ -54,6 +56,21 @@ type Server struct {
 	sc                       *statsclient.StatsClient
 	channel                  chan common.CalicoVppEvent
 	lock                     sync.Mutex
+	connectivityStatus       common.ConnectivityStatusSource
+	ipsecStatus              common.IPsecStatusSource
+	probeStatus              common.ProbeStatusSource
+}
+
+func (s *Server) SetConnectivityStatusSource(source common.ConnectivityStatusSource) {
+	s.connectivityStatus = source
+}
+
+func (s *Server) SetIPsecStatusSource(source common.IPsecStatusSource) {
+	s.ipsecStatus = source
+}
+
+func (s *Server) SetProbeStatusSource(source common.ProbeStatusSource) {
+	s.probeStatus = source
 }
 
 func (s *Server) recordMetrics(t *tomb.Tomb) {
@@ -63,6 +80,8 @@ func (s *Server) recordMetrics(t *tomb.Tomb) {
 	}
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", pe)
+	mux.HandleFunc("/state/connectivity", s.handleConnectivityState)
+	mux.HandleFunc("/state/probes", s.handleProbeState)
 	go func() {
 		http.ListenAndServe(":8888", mux)
 	}()
@@ -78,7 +97,350 @@ func (s *Server) recordMetrics(t *tomb.Tomb) {
 				s.exportMetricsForStat(names, sta, ifNames, pe)
 			}
 		}
+		s.exportConnectivityMetrics(pe)
+		s.exportIPsecMetrics(pe)
+		s.exportMTUMetrics(pe, dumpStats)
+		s.exportProbeMetrics(pe)
+	}
+}
+
+func (s *Server) getConnectivityStatus() []common.NodeConnectivityStatus {
+	if s.connectivityStatus == nil {
+		return []common.NodeConnectivityStatus{}
+	}
+	return s.connectivityStatus.GetConnectivityStatus()
+}
+
+func (s *Server) handleConnectivityState(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(s.getConnectivityStatus())
+	if err != nil {
+		s.log.Errorf("Error encoding connectivity state: %v", err)
+	}
+}
+
+func (s *Server) getProbeStatus() []common.PeerProbeStatus {
+	if s.probeStatus == nil {
+		return []common.PeerProbeStatus{}
+	}
+	return s.probeStatus.GetProbeStatus()
+}
+
+func (s *Server) handleProbeState(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(s.getProbeStatus())
+	if err != nil {
+		s.log.Errorf("Error encoding probe state: %v", err)
+	}
+}
+
+/* connectivity_peer_up is 1 when traffic to the remote node should flow, 0 otherwise */
+func (s *Server) exportConnectivityMetrics(pe *prometheusExporter.Exporter) {
+	metric := &metricspb.Metric{
+		MetricDescriptor: &metricspb.MetricDescriptor{
+			Name:        "connectivity_peer_up",
+			Unit:        "",
+			Description: "whether the connectivity to a remote node is up",
+			Type:        metricspb.MetricDescriptor_GAUGE_DOUBLE,
+			LabelKeys: []*metricspb.LabelKey{
+				{Key: "node", Description: "Name of the remote node"},
+				{Key: "nextHop", Description: "Address of the remote node"},
+				{Key: "provider", Description: "Connectivity provider used"},
+				{Key: "state", Description: "State reported by the provider"},
+			},
+		},
+		Timeseries: []*metricspb.TimeSeries{},
+	}
+	for _, status := range s.getConnectivityStatus() {
+		value := 0.0
+		if status.State == common.ConnectivityStateUp {
+			value = 1.0
+		}
+		metric.Timeseries = append(metric.Timeseries, &metricspb.TimeSeries{
+			LabelValues: []*metricspb.LabelValue{
+				{Value: status.NodeName},
+				{Value: status.NextHop.String()},
+				{Value: status.Provider},
+				{Value: status.State},
+			},
+			Points: []*metricspb.Point{
+				{
+					Value: &metricspb.Point_DoubleValue{
+						DoubleValue: value,
+					},
+				},
+			},
+		})
+	}
+	// empty timeseries prevents exporter from updating
+	if len(metric.Timeseries) == 0 {
+		metric.Timeseries = []*metricspb.TimeSeries{{}}
+	}
+	pe.ExportMetric(context.Background(), nil, nil, metric)
+}
+
+func newTimeSeries(value float64, labelValues ...string) *metricspb.TimeSeries {
+	ts := &metricspb.TimeSeries{
+		Points: []*metricspb.Point{
+			{
+				Value: &metricspb.Point_DoubleValue{
+					DoubleValue: value,
+				},
+			},
+		},
+	}
+	for _, labelValue := range labelValues {
+		ts.LabelValues = append(ts.LabelValues, &metricspb.LabelValue{Value: labelValue})
+	}
+	return ts
+}
+
+/**
+ * exportMTUMetrics exports the MTU of the tunnels to each remote node, and
+ * how many packets VPP dropped on them since it started, mostly the ones
+ * too big for the tunnel MTU that it answered with ICMP too big.
+ */
+func (s *Server) exportMTUMetrics(pe *prometheusExporter.Exporter, dumpStats []adapter.StatEntry) {
+	peerMtu := &metricspb.Metric{
+		MetricDescriptor: &metricspb.MetricDescriptor{
+			Name:        "connectivity_peer_mtu",
+			Unit:        "bytes",
+			Description: "MTU of the tunnel to a remote node",
+			Type:        metricspb.MetricDescriptor_GAUGE_DOUBLE,
+			LabelKeys: []*metricspb.LabelKey{
+				{Key: "node", Description: "Name of the remote node"},
+				{Key: "nextHop", Description: "Address of the remote node"},
+				{Key: "provider", Description: "Connectivity provider used"},
+			},
+		},
+		Timeseries: []*metricspb.TimeSeries{},
+	}
+	mtuExceeded := &metricspb.Metric{
+		MetricDescriptor: &metricspb.MetricDescriptor{
+			Name:        "tunnel_mtu_exceeded",
+			Unit:        "packets",
+			Description: "packets dropped on the tunnel to a remote node, as too big for its MTU",
+			Type:        metricspb.MetricDescriptor_CUMULATIVE_DOUBLE,
+			LabelKeys: []*metricspb.LabelKey{
+				{Key: "node", Description: "Name of the remote node"},
+				{Key: "nextHop", Description: "Address of the remote node"},
+				{Key: "provider", Description: "Connectivity provider used"},
+			},
+		},
+		Timeseries: []*metricspb.TimeSeries{},
+	}
+	statuses := s.getConnectivityStatus()
+	swIfIndexes := make([]uint32, 0, len(statuses))
+	for _, status := range statuses {
+		if status.SwIfIndex != 0 && status.SwIfIndex != types.InvalidID {
+			swIfIndexes = append(swIfIndexes, status.SwIfIndex)
+		}
+	}
+	errorStats := vpplink.GetTunnelErrorStats(dumpStats, swIfIndexes)
+	for _, status := range statuses {
+		labelValues := []string{status.NodeName, status.NextHop.String(), status.Provider}
+		if status.Mtu != 0 {
+			peerMtu.Timeseries = append(peerMtu.Timeseries, newTimeSeries(float64(status.Mtu), labelValues...))
+		}
+		if dropped, found := errorStats[status.SwIfIndex]; found {
+			mtuExceeded.Timeseries = append(mtuExceeded.Timeseries, newTimeSeries(float64(dropped), labelValues...))
+		}
+	}
+	for _, metric := range []*metricspb.Metric{peerMtu, mtuExceeded} {
+		// empty timeseries prevents exporter from updating
+		if len(metric.Timeseries) == 0 {
+			metric.Timeseries = []*metricspb.TimeSeries{{}}
+		}
+		pe.ExportMetric(context.Background(), nil, nil, metric)
+	}
+}
+
+func newProbeMetric(name string, unit string, description string) *metricspb.Metric {
+	return &metricspb.Metric{
+		MetricDescriptor: &metricspb.MetricDescriptor{
+			Name:        name,
+			Unit:        unit,
+			Description: description,
+			Type:        metricspb.MetricDescriptor_GAUGE_DOUBLE,
+			LabelKeys: []*metricspb.LabelKey{
+				{Key: "node", Description: "Name of the remote node"},
+				{Key: "nextHop", Description: "Address of the remote node"},
+				{Key: "provider", Description: "Connectivity provider used"},
+			},
+		},
+		Timeseries: []*metricspb.TimeSeries{},
+	}
+}
+
+/* exportProbeMetrics exports the results of the probes sent to each remote node */
+func (s *Server) exportProbeMetrics(pe *prometheusExporter.Exporter) {
+	reachable := newProbeMetric("connectivity_probe_reachable", "", "whether a remote node answers probes")
+	rtt := newProbeMetric("connectivity_probe_rtt", "seconds", "round trip time of the last probe answered by a remote node")
+	loss := newProbeMetric("connectivity_probe_loss", "", "ratio of the last probes a remote node did not answer")
+	lost := newProbeMetric("connectivity_probe_lost", "probes", "probes a remote node did not answer")
+	for _, status := range s.getProbeStatus() {
+		labelValues := []string{status.NodeName, status.NextHop.String(), status.Provider}
+		value := 0.0
+		if status.Reachable {
+			value = 1.0
+		}
+		reachable.Timeseries = append(reachable.Timeseries, newTimeSeries(value, labelValues...))
+		rtt.Timeseries = append(rtt.Timeseries, newTimeSeries(status.Rtt.Seconds(), labelValues...))
+		loss.Timeseries = append(loss.Timeseries, newTimeSeries(status.Loss, labelValues...))
+		lost.Timeseries = append(lost.Timeseries, newTimeSeries(float64(status.Lost), labelValues...))
+	}
+	for _, metric := range []*metricspb.Metric{reachable, rtt, loss, lost} {
+		// empty timeseries prevents exporter from updating
+		if len(metric.Timeseries) == 0 {
+			metric.Timeseries = []*metricspb.TimeSeries{{}}
+		}
+		pe.ExportMetric(context.Background(), nil, nil, metric)
+	}
+}
+
+func newIPsecMetric(name string, unit string, description string, labelKeys []*metricspb.LabelKey) *metricspb.Metric {
+	return &metricspb.Metric{
+		MetricDescriptor: &metricspb.MetricDescriptor{
+			Name:        name,
+			Unit:        unit,
+			Description: description,
+			LabelKeys: append([]*metricspb.LabelKey{
+				{Key: "node", Description: "Name of the remote node"},
+				{Key: "nextHop", Description: "Address of the remote node"},
+				{Key: "tunnel", Description: "IKEv2 profile of the tunnel"},
+			}, labelKeys...),
+		},
+		Timeseries: []*metricspb.TimeSeries{},
+	}
+}
+
+func addIPsecTimeSeries(metric *metricspb.Metric, status *common.IPsecTunnelStatus, value float64, labelValues ...string) {
+	ts := &metricspb.TimeSeries{
+		LabelValues: []*metricspb.LabelValue{
+			{Value: status.NodeName},
+			{Value: status.NextHop.String()},
+			{Value: status.Tunnel},
+		},
+		Points: []*metricspb.Point{
+			{
+				Value: &metricspb.Point_DoubleValue{
+					DoubleValue: value,
+				},
+			},
+		},
+	}
+	for _, labelValue := range labelValues {
+		ts.LabelValues = append(ts.LabelValues, &metricspb.LabelValue{Value: labelValue})
+	}
+	metric.Timeseries = append(metric.Timeseries, ts)
+}
+
+/**
+ * exportIPsecMetrics exports per tunnel metrics, there are several tunnels
+ * per remote node (IpsecTunsPerNode) so this also shows how traffic spreads
+ * across them. Packet & byte counters are those of the SAs currently
+ * protecting the tunnel, so they restart from zero on rekeys. Replay drops
+ * are only counted per node by VPP, the anti-replay window shows per tunnel
+ * the sequence numbers not received yet, dropped if they arrive after
+ * leaving it.
+ */
+func (s *Server) exportIPsecMetrics(pe *prometheusExporter.Exporter) {
+	if s.ipsecStatus == nil {
+		return
+	}
+	counters, err := vpplink.GetIPsecSAStats(s.sc)
+	if err != nil {
+		s.log.Errorf("Error getting IPsec SA stats: %v", err)
+		return
+	}
+	directionKey := &metricspb.LabelKey{Key: "direction", Description: "in or out"}
+	saPackets := newIPsecMetric("ipsec_sa_packets", "packets", "packets processed by the tunnel SAs", []*metricspb.LabelKey{directionKey})
+	saBytes := newIPsecMetric("ipsec_sa_bytes", "bytes", "bytes processed by the tunnel SAs", []*metricspb.LabelKey{directionKey})
+	saLost := newIPsecMetric("ipsec_sa_lost_packets", "packets", "packets detected as lost by the inbound SAs sequence numbers", nil)
+	saCount := newIPsecMetric("ipsec_sa_established", "", "number of SAs protecting the tunnel", []*metricspb.LabelKey{directionKey})
+	ikeRekeys := newIPsecMetric("ipsec_ike_rekeys", "", "rekey requests of the tunnel IKE SA", nil)
+	ikeRetransmits := newIPsecMetric("ipsec_ike_retransmits", "", "retransmissions of the tunnel IKE SA", nil)
+	ikeKeepalives := newIPsecMetric("ipsec_ike_keepalives", "", "keepalives of the tunnel IKE SA", nil)
+	ikeUp := newIPsecMetric("ipsec_ike_sa_up", "", "whether the tunnel has an IKE SA", nil)
+	saAge := newIPsecMetric("ipsec_sa_age", "seconds", "time since the outbound SA of the tunnel was established", nil)
+	saLifetime := newIPsecMetric("ipsec_sa_lifetime", "seconds", "configured lifetime of the tunnel SAs, after which they are rekeyed", nil)
+	saSeq := newIPsecMetric("ipsec_sa_sequence_number", "", "last sequence number sent (out) or received (in) by the tunnel SAs", []*metricspb.LabelKey{directionKey})
+	saReplayMissed := newIPsecMetric("ipsec_sa_replay_window_missed", "packets", "sequence numbers not received in the anti-replay window of the inbound SAs", nil)
+	now := time.Now()
+	for _, status := range s.ipsecStatus.GetIPsecTunnelStatus() {
+		status := status
+		var in, out types.IPsecSACounters
+		var inSeq, outSeq uint64
+		nOut := 0
+		inReplayMissed := 0
+		if status.OutSA != nil {
+			out = counters[status.OutSA.StatIndex]
+			outSeq = status.OutSA.SeqOutbound
+			nOut = 1
+			addIPsecTimeSeries(saAge, &status, now.Sub(status.OutSAEstablished).Seconds())
+		}
+		for _, sa := range status.InSAs {
+			sa := sa
+			c := counters[sa.StatIndex]
+			in.Packets += c.Packets
+			in.Bytes += c.Bytes
+			in.LostPackets += c.LostPackets
+			inReplayMissed += sa.ReplayWindowMissed()
+			if sa.LastSeqInbound > inSeq {
+				inSeq = sa.LastSeqInbound
+			}
+		}
+		if status.SALifetime > 0 {
+			addIPsecTimeSeries(saLifetime, &status, status.SALifetime.Seconds())
+		}
+		addIPsecTimeSeries(saSeq, &status, float64(inSeq), "in")
+		addIPsecTimeSeries(saSeq, &status, float64(outSeq), "out")
+		addIPsecTimeSeries(saReplayMissed, &status, float64(inReplayMissed))
+		addIPsecTimeSeries(saPackets, &status, float64(in.Packets), "in")
+		addIPsecTimeSeries(saPackets, &status, float64(out.Packets), "out")
+		addIPsecTimeSeries(saBytes, &status, float64(in.Bytes), "in")
+		addIPsecTimeSeries(saBytes, &status, float64(out.Bytes), "out")
+		addIPsecTimeSeries(saLost, &status, float64(in.LostPackets))
+		addIPsecTimeSeries(saCount, &status, float64(len(status.InSAs)), "in")
+		addIPsecTimeSeries(saCount, &status, float64(nOut), "out")
+		if status.IKESA != nil {
+			addIPsecTimeSeries(ikeRekeys, &status, float64(status.IKESA.NRekeyReq))
+			addIPsecTimeSeries(ikeRetransmits, &status, float64(status.IKESA.NRetransmit))
+			addIPsecTimeSeries(ikeKeepalives, &status, float64(status.IKESA.NKeepalives))
+			addIPsecTimeSeries(ikeUp, &status, 1.0)
+		} else {
+			addIPsecTimeSeries(ikeUp, &status, 0.0)
+		}
+	}
+	for _, metric := range []*metricspb.Metric{saPackets, saBytes, saLost, saCount, ikeRekeys, ikeRetransmits, ikeKeepalives, ikeUp,
+		saAge, saLifetime, saSeq, saReplayMissed} {
+		// empty timeseries prevents exporter from updating
+		if len(metric.Timeseries) == 0 {
+			metric.Timeseries = []*metricspb.TimeSeries{{}}
+		}
+		pe.ExportMetric(context.Background(), nil, nil, metric)
+	}
+
+	ip4, ip6, err := vpplink.GetIPsecReplayDropStats(s.sc)
+	if err != nil {
+		s.log.Errorf("Error getting IPsec replay drop stats: %v", err)
+		return
 	}
+	pe.ExportMetric(context.Background(), nil, nil, &metricspb.Metric{
+		MetricDescriptor: &metricspb.MetricDescriptor{
+			Name:        "ipsec_replay_dropped",
+			Unit:        "packets",
+			Description: "packets dropped as replayed by the inbound SAs",
+			Type:        metricspb.MetricDescriptor_CUMULATIVE_DOUBLE,
+			LabelKeys: []*metricspb.LabelKey{
+				{Key: "af", Description: "ip4 or ip6"},
+			},
+		},
+		Timeseries: []*metricspb.TimeSeries{
+			newTimeSeries(float64(ip4), "ip4"),
+			newTimeSeries(float64(ip6), "ip6"),
+		},
+	})
 }
 
 var units = map[int]string{0: "packets", 1: "bytes"}
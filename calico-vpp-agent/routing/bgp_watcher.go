@@ -372,6 +372,18 @@ func (w *Server) WatchBGPPath(t *tomb.Tomb) error {
 				if err != nil {
 					return err
 				}
+			case common.BFDSessionDown:
+				/* The BFD session might not be towards a BGP peer */
+				addr := evt.New.(string)
+				err := w.BGPServer.ResetPeer(
+					context.Background(),
+					&bgpapi.ResetPeerRequest{Address: addr, Communication: "BFD session down"},
+				)
+				if err != nil {
+					w.log.Debugf("bgp(bfd) not resetting neighbor=%s: %v", addr, err)
+				} else {
+					w.log.Warnf("bgp(bfd) reset neighbor=%s", addr)
+				}
 			}
 		}
 	}
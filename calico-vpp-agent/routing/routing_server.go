@@ -91,6 +91,7 @@ func NewRoutingServer(vpp *vpplink.VppLink, bgpServer *bgpserver.BgpServer, log
 		common.BGPPeerAdded,
 		common.BGPPeerDeleted,
 		common.BGPPeerUpdated,
+		common.BFDSessionDown,
 	)
 
 	return &server
@@ -59,6 +59,7 @@ func equalPools(a *calicov3.IPPool, b *calicov3.IPPool) bool {
 
 type IpamCache interface {
 	GetPrefixIPPool(*net.IPNet) *calicov3.IPPool
+	GetIPPools() []calicov3.IPPool
 	SyncIPAM(t *tomb.Tomb) error
 	WaitReady()
 	IPNetNeedsSNAT(prefix *net.IPNet) bool
@@ -106,6 +107,18 @@ func (c *ipamCache) GetPrefixIPPool(prefix *net.IPNet) *calicov3.IPPool {
 	return nil
 }
 
+// GetIPPools returns a copy of all the known IP pools
+func (c *ipamCache) GetIPPools() []calicov3.IPPool {
+	c.WaitReady()
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	pools := make([]calicov3.IPPool, 0, len(c.ippoolmap))
+	for _, pool := range c.ippoolmap {
+		pools = append(pools, pool)
+	}
+	return pools
+}
+
 func (c *ipamCache) IPNetNeedsSNAT(prefix *net.IPNet) bool {
 	pool := c.GetPrefixIPPool(prefix)
 	if pool == nil {
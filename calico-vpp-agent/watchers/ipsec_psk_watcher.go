@@ -0,0 +1,131 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watchers
+
+import (
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	tomb "gopkg.in/tomb.v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/common"
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/config"
+)
+
+/**
+ * IPsecPSKWatcher watches the secret holding the IKEv2 PSK. Besides the
+ * current key (psk), it may contain the key we are rotating to (psk-next),
+ * see IpsecProvider for how both are used. Each key has a random ID next to
+ * it (psk-id, psk-next-id), that nodes publish instead of the key.
+ */
+const (
+	IPsecPSKSecretKey       = "psk"
+	IPsecPSKSecretNextKey   = "psk-next"
+	IPsecPSKSecretIDKey     = "psk-id"
+	IPsecPSKSecretNextIDKey = "psk-next-id"
+)
+
+type IPsecPSKWatcher struct {
+	log       *logrus.Entry
+	k8sclient *kubernetes.Clientset
+	psks      common.IPsecPSKs
+}
+
+func NewIPsecPSKWatcher(k8sclient *kubernetes.Clientset, log *logrus.Entry) *IPsecPSKWatcher {
+	return &IPsecPSKWatcher{
+		log:       log,
+		k8sclient: k8sclient,
+	}
+}
+
+func (w *IPsecPSKWatcher) handleSecret(secret *corev1.Secret) {
+	psks := common.IPsecPSKs{
+		Current:   string(secret.Data[IPsecPSKSecretKey]),
+		CurrentID: string(secret.Data[IPsecPSKSecretIDKey]),
+		Next:      string(secret.Data[IPsecPSKSecretNextKey]),
+		NextID:    string(secret.Data[IPsecPSKSecretNextIDKey]),
+	}
+	if psks.Current == "" {
+		w.log.Errorf("No %s in secret %s, ignoring", IPsecPSKSecretKey, config.IPSecIkev2PskSecret)
+		return
+	}
+	if psks.CurrentID == "" {
+		w.log.Warnf("No %s in secret %s, peers won't be able to rotate from this key", IPsecPSKSecretIDKey, config.IPSecIkev2PskSecret)
+	}
+	if psks.Next != "" && psks.NextID == "" {
+		w.log.Errorf("No %s in secret %s, ignoring %s", IPsecPSKSecretNextIDKey, config.IPSecIkev2PskSecret, IPsecPSKSecretNextKey)
+		psks.Next = ""
+	}
+	if psks == w.psks {
+		return
+	}
+	w.log.Infof("IPsec PSK updated, next key present: %t", psks.Next != "")
+	w.psks = psks
+	common.SendEvent(common.CalicoVppEvent{
+		Type: common.IPsecPSKChanged,
+		New:  &psks,
+	})
+}
+
+func (w *IPsecPSKWatcher) WatchIPsecPSK(t *tomb.Tomb) error {
+	parts := strings.Split(config.IPSecIkev2PskSecret, "/")
+	namespace, name := parts[0], parts[1]
+	w.log.Infof("IPsec PSK watcher starts on %s", config.IPSecIkev2PskSecret)
+	for t.Alive() {
+		watcher, err := w.k8sclient.CoreV1().Secrets(namespace).Watch(context.Background(), metav1.ListOptions{
+			FieldSelector: "metadata.name=" + name,
+		})
+		if err != nil {
+			w.log.Errorf("Error watching secret %s: %v", config.IPSecIkev2PskSecret, err)
+			goto restart
+		}
+	events:
+		for {
+			select {
+			case <-t.Dying():
+				w.log.Infof("IPsec PSK watcher asked to stop")
+				watcher.Stop()
+				return nil
+			case event, ok := <-watcher.ResultChan():
+				if !ok {
+					break events
+				}
+				switch event.Type {
+				case watch.Added, watch.Modified:
+					secret, ok := event.Object.(*corev1.Secret)
+					if ok {
+						w.handleSecret(secret)
+					}
+				case watch.Deleted:
+					w.log.Warnf("Secret %s deleted, keeping the current keys", config.IPSecIkev2PskSecret)
+				case watch.Error:
+					w.log.Debug("IPsec PSK watch returned, restarting...")
+					break events
+				}
+			}
+		}
+		watcher.Stop()
+	restart:
+		time.Sleep(2 * time.Second)
+	}
+	return nil
+}
@@ -2,7 +2,9 @@ package watchers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"reflect"
 	"time"
 
 	"github.com/pkg/errors"
@@ -12,6 +14,9 @@ import (
 	cnet "github.com/projectcalico/calico/libcalico-go/lib/net"
 	"github.com/sirupsen/logrus"
 	tomb "gopkg.in/tomb.v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
 
 	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/common"
 	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/config"
@@ -24,13 +29,63 @@ type LocalSIDWatcher struct {
 	log         *logrus.Entry
 	vpp         *vpplink.VppLink
 	clientv3    calicov3cli.Interface
+	k8sclient   *kubernetes.Clientset
 	nodeBGPSpec *oldv3.NodeBGPSpec
+	nodeSIDs    map[string]string
 }
 
+/**
+ * The localsids of each node are published in an annotation on its
+ * Kubernetes node, as a JSON object mapping their behavior (end, dt4, dt6)
+ * to the SID, so that SRv6Policy resources can designate segments by node.
+ * End SIDs are not advertised in BGP, as they don't terminate policies.
+ */
 const (
+	SRv6SIDsAnnotation = "cni.projectcalico.org/vpp.srv6.sids"
+
+	SRv6BehaviorEnd = "end"
+	SRv6BehaviorDT4 = "dt4"
+	SRv6BehaviorDT6 = "dt6"
+
 	localSIDWatchInterval = 10 * time.Second
 )
 
+func localSIDBehaviorName(behavior types.SrBehavior) string {
+	switch behavior {
+	case types.SrBehaviorEND:
+		return SRv6BehaviorEnd
+	case types.SrBehaviorDT4:
+		return SRv6BehaviorDT4
+	case types.SrBehaviorDT6:
+		return SRv6BehaviorDT6
+	}
+	return ""
+}
+
+func (w *LocalSIDWatcher) publishNodeSIDs(localsids []*types.SrLocalsid) error {
+	nodeSIDs := make(map[string]string)
+	for _, localsid := range localsids {
+		if name := localSIDBehaviorName(localsid.Behavior); name != "" && localsid.FibTable == 0 {
+			nodeSIDs[name] = localsid.Localsid.ToIP().String()
+		}
+	}
+	if reflect.DeepEqual(nodeSIDs, w.nodeSIDs) {
+		return nil
+	}
+	value, err := json.Marshal(nodeSIDs)
+	if err != nil {
+		return errors.Wrap(err, "error encoding node SIDs")
+	}
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, SRv6SIDsAnnotation, string(value))
+	_, err = w.k8sclient.CoreV1().Nodes().Patch(context.Background(), config.NodeName,
+		k8stypes.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "error annotating node %s", config.NodeName)
+	}
+	w.nodeSIDs = nodeSIDs
+	return nil
+}
+
 func (w *LocalSIDWatcher) WatchLocalSID(t *tomb.Tomb) error {
 	w.log.Infof("WatchLocalSID")
 	time.Sleep(localSIDWatchInterval)
@@ -41,10 +96,16 @@ func (w *LocalSIDWatcher) WatchLocalSID(t *tomb.Tomb) error {
 		if err != nil {
 			return errors.Wrap(err, "error getting assigned SRv6 LocalSIDs")
 		}
+		err = w.publishNodeSIDs(list)
+		if err != nil {
+			w.log.Errorf("Error publishing SRv6 LocalSIDs: %v", err)
+		}
 		for _, localsid := range list {
 			w.log.Debugf("LocalSID: %s", localsid.String())
 			if _, found := assignedLocalSIDs[localsid.Localsid.String()]; found {
 				w.log.Debugf("Old assigned LocalSID: %s", localsid.Localsid.String())
+			} else if localsid.Behavior == types.SrBehaviorEND {
+				assignedLocalSIDs[localsid.Localsid.String()] = true
 			} else {
 				w.log.Debugf("New assigned LocalSID: %s", localsid.Localsid.String())
 				err := w.AdvertiseSRv6Policy(localsid)
@@ -110,11 +171,12 @@ func (w *LocalSIDWatcher) SetOurBGPSpec(nodeBGPSpec *oldv3.NodeBGPSpec) {
 	w.nodeBGPSpec = nodeBGPSpec
 }
 
-func NewLocalSIDWatcher(vpp *vpplink.VppLink, clientv3 calicov3cli.Interface, log *logrus.Entry) *LocalSIDWatcher {
+func NewLocalSIDWatcher(vpp *vpplink.VppLink, clientv3 calicov3cli.Interface, k8sclient *kubernetes.Clientset, log *logrus.Entry) *LocalSIDWatcher {
 	w := &LocalSIDWatcher{
-		vpp:      vpp,
-		log:      log,
-		clientv3: clientv3,
+		vpp:       vpp,
+		log:       log,
+		clientv3:  clientv3,
+		k8sclient: k8sclient,
 	}
 	return w
 }
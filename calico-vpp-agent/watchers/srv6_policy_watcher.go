@@ -0,0 +1,508 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watchers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	tomb "gopkg.in/tomb.v2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/common"
+	"github.com/projectcalico/vpp-dataplane/calico-vpp-agent/config"
+	"github.com/projectcalico/vpp-dataplane/vpplink/binapi/vppapi/ip_types"
+	"github.com/projectcalico/vpp-dataplane/vpplink/types"
+)
+
+/**
+ * SRv6PolicyWatcher watches the SRv6Policy resources (CRD defined in
+ * yaml/base/calico-vpp-daemonset.yaml). Each describes an SR policy with
+ * explicit segment lists, e.g.
+ *
+ *   apiVersion: vpp.projectcalico.org/v1alpha1
+ *   kind: SRv6Policy
+ *   metadata:
+ *     name: via-node-b
+ *   spec:
+ *     bsid: fcff::100
+ *     nodeSelector: {matchLabels: {zone: a}}
+ *     segmentLists:
+ *     - weight: 2
+ *       segments: [{node: node-b}, {node: node-c, behavior: dt4}]
+ *     - weight: 1
+ *       segments: [{sid: "fc00::c:2"}]
+ *     prefixes: [10.0.5.0/24]
+ *     podSelector: {matchLabels: {app: db}}
+ *
+ * Segments are either SIDs or nodes, using the localsid of the node with
+ * the given behavior (end by default) from the SRv6SIDsAnnotation.
+ * Traffic to the prefixes and to the remote pods matching podSelector is
+ * steered into the policy, on the nodes matching nodeSelector (all by
+ * default). Local pods are never steered, we deliver to them directly.
+ * Pod addresses and node SIDs come from the shared informers, policies are
+ * re-resolved when a relevant pod or node changes and sent to the SRv6
+ * provider when they change. They are also sent on each resync, so that the
+ * provider retries the ones it failed to program.
+ */
+const (
+	srv6PolicyResyncInterval = 30 * time.Second
+	srv6MaxSegments          = 16
+	/* Retry delays of the policy watch, backing off while the CRD isn't installed */
+	srv6PolicyRetryDelay    = 2 * time.Second
+	srv6PolicyMaxRetryDelay = 5 * time.Minute
+)
+
+var SRv6PolicyResource = schema.GroupVersionResource{
+	Group:    "vpp.projectcalico.org",
+	Version:  "v1alpha1",
+	Resource: "srv6policies",
+}
+
+type SRv6Segment struct {
+	SID      string `json:"sid,omitempty"`
+	Node     string `json:"node,omitempty"`
+	Behavior string `json:"behavior,omitempty"`
+}
+
+type SRv6SegmentList struct {
+	Weight   uint32        `json:"weight,omitempty"`
+	Segments []SRv6Segment `json:"segments"`
+}
+
+type SRv6PolicySpec struct {
+	BSID         string                `json:"bsid"`
+	SegmentLists []SRv6SegmentList     `json:"segmentLists"`
+	Prefixes     []string              `json:"prefixes,omitempty"`
+	PodSelector  *metav1.LabelSelector `json:"podSelector,omitempty"`
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+}
+
+type SRv6Policy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec SRv6PolicySpec `json:"spec"`
+}
+
+type SRv6PolicyWatcher struct {
+	log       *logrus.Entry
+	dynclient dynamic.Interface
+	policies  map[string]*SRv6Policy
+	resolved  []common.SRv6TEPolicy
+
+	nodeLister      listersv1.NodeLister
+	podLister       listersv1.PodLister
+	informersSynced []cache.InformerSynced
+	/* signaled by the informers when a pod or node we resolve from changes */
+	resolveChanged chan struct{}
+}
+
+func NewSRv6PolicyWatcher(dynclient dynamic.Interface, informerFactory informers.SharedInformerFactory, log *logrus.Entry) *SRv6PolicyWatcher {
+	w := &SRv6PolicyWatcher{
+		log:            log,
+		dynclient:      dynclient,
+		policies:       make(map[string]*SRv6Policy),
+		resolveChanged: make(chan struct{}, 1),
+	}
+	if config.EnableSRv6 {
+		w.initInformers(informerFactory)
+	}
+	return w
+}
+
+/* initInformers caches the nodes & pods policies are resolved from, instead of listing them on each resync */
+func (w *SRv6PolicyWatcher) initInformers(informerFactory informers.SharedInformerFactory) {
+	nodeInformer := informerFactory.Core().V1().Nodes()
+	podInformer := informerFactory.Core().V1().Pods()
+
+	nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { w.signalResolve() },
+		UpdateFunc: func(old interface{}, obj interface{}) {
+			if srv6NodeChanged(old, obj) {
+				w.signalResolve()
+			}
+		},
+		DeleteFunc: func(obj interface{}) { w.signalResolve() },
+	})
+	podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if pod, ok := obj.(*corev1.Pod); ok && isRemotePod(pod) {
+				w.signalResolve()
+			}
+		},
+		UpdateFunc: func(old interface{}, obj interface{}) {
+			if srv6PodChanged(old, obj) {
+				w.signalResolve()
+			}
+		},
+		DeleteFunc: func(obj interface{}) { w.signalResolve() },
+	})
+
+	w.nodeLister = nodeInformer.Lister()
+	w.podLister = podInformer.Lister()
+	w.informersSynced = []cache.InformerSynced{
+		nodeInformer.Informer().HasSynced,
+		podInformer.Informer().HasSynced,
+	}
+}
+
+/* signalResolve runs in the informer goroutines, signals coalesce while the watcher is busy */
+func (w *SRv6PolicyWatcher) signalResolve() {
+	select {
+	case w.resolveChanged <- struct{}{}:
+	default:
+	}
+}
+
+func srv6NodeChanged(old interface{}, obj interface{}) bool {
+	oldNode, ok1 := old.(*corev1.Node)
+	node, ok2 := obj.(*corev1.Node)
+	if !ok1 || !ok2 {
+		return true
+	}
+	return oldNode.Annotations[SRv6SIDsAnnotation] != node.Annotations[SRv6SIDsAnnotation] ||
+		!reflect.DeepEqual(oldNode.Labels, node.Labels)
+}
+
+/* isRemotePod returns whether the addresses of pod may be steered into a policy */
+func isRemotePod(pod *corev1.Pod) bool {
+	return pod.Spec.NodeName != config.NodeName && !pod.Spec.HostNetwork
+}
+
+func srv6PodChanged(old interface{}, obj interface{}) bool {
+	oldPod, ok1 := old.(*corev1.Pod)
+	pod, ok2 := obj.(*corev1.Pod)
+	if !ok1 || !ok2 {
+		return true
+	}
+	if !isRemotePod(oldPod) && !isRemotePod(pod) {
+		return false
+	}
+	return oldPod.Spec.NodeName != pod.Spec.NodeName ||
+		oldPod.Status.Phase != pod.Status.Phase ||
+		!reflect.DeepEqual(oldPod.Labels, pod.Labels) ||
+		!reflect.DeepEqual(oldPod.Status.PodIPs, pod.Status.PodIPs)
+}
+
+func (w *SRv6PolicyWatcher) addPolicy(obj *unstructured.Unstructured) {
+	policy := &SRv6Policy{}
+	err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), policy)
+	if err != nil {
+		w.log.Errorf("Error parsing SRv6Policy %s, ignoring it: %v", obj.GetName(), err)
+		delete(w.policies, obj.GetName())
+		return
+	}
+	w.policies[policy.Name] = policy
+}
+
+/* getNodeSIDs returns the localsids each node publishes, by behavior */
+func (w *SRv6PolicyWatcher) getNodeSIDs() (map[string]map[string]net.IP, error) {
+	nodes, err := w.nodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing nodes")
+	}
+	nodeSIDs := make(map[string]map[string]net.IP)
+	for _, node := range nodes {
+		value := node.Annotations[SRv6SIDsAnnotation]
+		if value == "" {
+			continue
+		}
+		sids := make(map[string]string)
+		err = json.Unmarshal([]byte(value), &sids)
+		if err != nil {
+			w.log.Warnf("Invalid %s annotation on node %s: %v", SRv6SIDsAnnotation, node.Name, err)
+			continue
+		}
+		nodeSIDs[node.Name] = make(map[string]net.IP)
+		for behavior, sid := range sids {
+			nodeSIDs[node.Name][behavior] = net.ParseIP(sid)
+		}
+	}
+	return nodeSIDs, nil
+}
+
+func resolveSegment(segment *SRv6Segment, nodeSIDs map[string]map[string]net.IP) (net.IP, error) {
+	if segment.SID != "" {
+		sid := net.ParseIP(segment.SID)
+		if sid == nil || sid.To4() != nil {
+			return nil, fmt.Errorf("invalid sid %s", segment.SID)
+		}
+		return sid, nil
+	}
+	if segment.Node == "" {
+		return nil, fmt.Errorf("segment without sid nor node")
+	}
+	behavior := segment.Behavior
+	if behavior == "" {
+		behavior = SRv6BehaviorEnd
+	}
+	sid := nodeSIDs[segment.Node][behavior]
+	if sid == nil {
+		return nil, fmt.Errorf("no %s sid known for node %s", behavior, segment.Node)
+	}
+	return sid, nil
+}
+
+func (w *SRv6PolicyWatcher) getPodPrefixes(podSelector *metav1.LabelSelector) ([]net.IPNet, error) {
+	selector, err := metav1.LabelSelectorAsSelector(podSelector)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid podSelector")
+	}
+	pods, err := w.podLister.List(selector)
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing pods")
+	}
+	prefixes := make([]net.IPNet, 0)
+	for _, pod := range pods {
+		if !isRemotePod(pod) || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		for _, podIP := range pod.Status.PodIPs {
+			if ip := net.ParseIP(podIP.IP); ip != nil {
+				prefixes = append(prefixes, *common.FullyQualified(ip))
+			}
+		}
+	}
+	return prefixes, nil
+}
+
+/* resolvePolicy returns the policy to program on our node, nil if it doesn't apply to it */
+func (w *SRv6PolicyWatcher) resolvePolicy(policy *SRv6Policy, node *corev1.Node, nodeSIDs map[string]map[string]net.IP) (*common.SRv6TEPolicy, error) {
+	if policy.Spec.NodeSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(policy.Spec.NodeSelector)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid nodeSelector")
+		}
+		if !selector.Matches(labels.Set(node.Labels)) {
+			return nil, nil
+		}
+	}
+	bsid := net.ParseIP(policy.Spec.BSID)
+	if bsid == nil || bsid.To4() != nil {
+		return nil, fmt.Errorf("invalid bsid %s", policy.Spec.BSID)
+	}
+	if len(policy.Spec.SegmentLists) == 0 {
+		return nil, fmt.Errorf("no segment list")
+	}
+	srPolicy := &types.SrPolicy{
+		Bsid:     types.ToVppIP6Address(bsid),
+		IsSpray:  false,
+		IsEncap:  true,
+		FibTable: 0,
+		SidLists: make([]types.Srv6SidList, 0, len(policy.Spec.SegmentLists)),
+	}
+	for i, segmentList := range policy.Spec.SegmentLists {
+		if len(segmentList.Segments) == 0 || len(segmentList.Segments) > srv6MaxSegments {
+			return nil, fmt.Errorf("segment list %d must have 1 to %d segments", i, srv6MaxSegments)
+		}
+		sids := [srv6MaxSegments]ip_types.IP6Address{}
+		for j := range segmentList.Segments {
+			sid, err := resolveSegment(&segmentList.Segments[j], nodeSIDs)
+			if err != nil {
+				return nil, errors.Wrapf(err, "segment list %d", i)
+			}
+			sids[j] = types.ToVppIP6Address(sid)
+		}
+		weight := segmentList.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		srPolicy.SidLists = append(srPolicy.SidLists, types.Srv6SidList{
+			NumSids: uint8(len(segmentList.Segments)),
+			Weight:  weight,
+			Sids:    sids,
+		})
+	}
+	resolved := &common.SRv6TEPolicy{
+		Name:     policy.Name,
+		Policy:   srPolicy,
+		Prefixes: make([]net.IPNet, 0),
+	}
+	for _, prefix := range policy.Spec.Prefixes {
+		_, ipNet, err := net.ParseCIDR(prefix)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid prefix %s", prefix)
+		}
+		resolved.Prefixes = append(resolved.Prefixes, *ipNet)
+	}
+	if policy.Spec.PodSelector != nil {
+		podPrefixes, err := w.getPodPrefixes(policy.Spec.PodSelector)
+		if err != nil {
+			return nil, err
+		}
+		resolved.Prefixes = append(resolved.Prefixes, podPrefixes...)
+	}
+	sort.Slice(resolved.Prefixes, func(i, j int) bool {
+		return resolved.Prefixes[i].String() < resolved.Prefixes[j].String()
+	})
+	return resolved, nil
+}
+
+/**
+ * reconcile resolves the policies, and sends them to the SRv6 provider if
+ * they changed, or if resync is set
+ */
+func (w *SRv6PolicyWatcher) reconcile(resync bool) {
+	node, err := w.nodeLister.Get(config.NodeName)
+	if err != nil {
+		w.log.Errorf("Error getting node %s, not updating SRv6 policies: %v", config.NodeName, err)
+		return
+	}
+	nodeSIDs, err := w.getNodeSIDs()
+	if err != nil {
+		w.log.Errorf("Error getting node SIDs, not updating SRv6 policies: %v", err)
+		return
+	}
+	names := make([]string, 0, len(w.policies))
+	for name := range w.policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	resolved := make([]common.SRv6TEPolicy, 0, len(names))
+	for _, name := range names {
+		policy, err := w.resolvePolicy(w.policies[name], node, nodeSIDs)
+		if err != nil {
+			w.log.Warnf("Invalid SRv6Policy %s, ignoring it: %v", name, err)
+			continue
+		}
+		if policy != nil {
+			resolved = append(resolved, *policy)
+		}
+	}
+	if w.resolved != nil && reflect.DeepEqual(resolved, w.resolved) {
+		if resync {
+			common.SendEvent(common.CalicoVppEvent{
+				Type: common.SRv6TEPoliciesChanged,
+				New:  resolved,
+			})
+		}
+		return
+	}
+	w.log.Infof("SRv6 policies updated, %d apply to this node", len(resolved))
+	w.resolved = resolved
+	common.SendEvent(common.CalicoVppEvent{
+		Type: common.SRv6TEPoliciesChanged,
+		New:  resolved,
+	})
+}
+
+func (w *SRv6PolicyWatcher) watchPolicies(t *tomb.Tomb, resync <-chan time.Time) error {
+	list, err := w.dynclient.Resource(SRv6PolicyResource).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "error listing SRv6 policies")
+	}
+	w.policies = make(map[string]*SRv6Policy)
+	for i := range list.Items {
+		w.addPolicy(&list.Items[i])
+	}
+	w.reconcile(false)
+
+	watcher, err := w.dynclient.Resource(SRv6PolicyResource).Watch(context.Background(), metav1.ListOptions{
+		ResourceVersion: list.GetResourceVersion(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "error watching SRv6 policies")
+	}
+	defer watcher.Stop()
+	for {
+		select {
+		case <-t.Dying():
+			w.log.Infof("SRv6 policy watcher asked to stop")
+			return nil
+		case <-resync:
+			w.reconcile(true)
+		case <-w.resolveChanged:
+			w.reconcile(false)
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return nil
+			}
+			obj, ok := event.Object.(*unstructured.Unstructured)
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				if ok {
+					w.addPolicy(obj)
+					w.reconcile(false)
+				}
+			case watch.Deleted:
+				if ok {
+					delete(w.policies, obj.GetName())
+					w.reconcile(false)
+				}
+			case watch.Error:
+				w.log.Debug("SRv6 policy watch returned, restarting...")
+				return nil
+			}
+		}
+	}
+}
+
+func (w *SRv6PolicyWatcher) WatchSRv6Policies(t *tomb.Tomb) error {
+	w.log.Infof("SRv6 policy watcher starts")
+	if !cache.WaitForCacheSync(t.Dying(), w.informersSynced...) {
+		return errors.Errorf("SRv6 policy watcher informers did not sync")
+	}
+	resyncTicker := time.NewTicker(srv6PolicyResyncInterval)
+	defer resyncTicker.Stop()
+	delay := srv6PolicyRetryDelay
+	for t.Alive() {
+		err := w.watchPolicies(t, resyncTicker.C)
+		if err != nil && apierrors.IsNotFound(errors.Cause(err)) {
+			/* SRv6 policies are optional, wait for the CRD to be installed */
+			if delay == srv6PolicyRetryDelay {
+				w.log.Debugf("SRv6Policy CRD not installed, retrying up to every %s", srv6PolicyMaxRetryDelay)
+			}
+			delay = nextSRv6PolicyRetryDelay(delay)
+		} else {
+			if err != nil {
+				w.log.Errorf("Error watching SRv6 policies: %v", err)
+			}
+			delay = srv6PolicyRetryDelay
+		}
+		select {
+		case <-t.Dying():
+		case <-time.After(delay):
+		}
+	}
+	return nil
+}
+
+func nextSRv6PolicyRetryDelay(delay time.Duration) time.Duration {
+	delay *= 2
+	if delay > srv6PolicyMaxRetryDelay {
+		return srv6PolicyMaxRetryDelay
+	}
+	return delay
+}
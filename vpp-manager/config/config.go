@@ -19,6 +19,7 @@ import (
 	"fmt"
 	types2 "git.fd.io/govpp.git/api/v0"
 	"net"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -34,11 +35,15 @@ const (
 	VppManagerTapIdxFile   = "/var/run/vpp/vppmanagertap0"
 	VppManagerLinuxMtu     = "/var/run/vpp/vppmanagerlinuxmtu"
 	VppApiSocket           = "/var/run/vpp/vpp-api.sock"
+	VppPuntSocket          = "/var/run/vpp/punt.sock"
 	CalicoVppPidFile       = "/var/run/vpp/calico_vpp.pid"
 	VppPath                = "/usr/bin/vpp"
 	VppNetnsName           = "calico-vpp-ns"
 	VppSigKillTimeout      = 2
-	DefaultEncapSize       = 60 // Used to lower the MTU of the routes to the cluster
+	// Used to lower the MTU of the routes to the cluster. This is the worst
+	// case (IPsec or wireguard over IPv6), the agent installs more specific
+	// routes with the MTU of the encapsulation actually used per destination
+	MaxEncapSize = 80
 )
 
 const (
@@ -83,6 +88,7 @@ type VppManagerParams struct {
 	DefaultGWs               []net.IP
 	IfConfigSavePath         string
 	EnableGSO                bool
+	EnableProbes             bool
 	IpsecNbAsyncCryptoThread int
 	/* Capabilities */
 	LoadedDrivers      map[string]bool
@@ -124,7 +130,7 @@ type KernelVersion struct {
 func GetUplinkMtu(params *VppManagerParams, conf *LinuxInterfaceState, includeEncap bool) int {
 	encapSize := 0
 	if includeEncap {
-		encapSize = DefaultEncapSize
+		encapSize = MaxEncapSize
 	}
 	// Use the linux interface MTU as default value if nothing is configured from env
 	if params.UserSpecifiedMtu == 0 {
@@ -203,6 +209,19 @@ func (c *LinuxInterfaceState) SortRoutes() {
 	})
 }
 
+var puntSectionRegexp = regexp.MustCompile(`(?m)^\s*punt\s*\{`)
+
+/**
+ * AddPuntSocketConfig adds the punt socket the agent probes send and
+ * receive through to the VPP configuration, unless the template has one
+ */
+func AddPuntSocketConfig(template string) string {
+	if puntSectionRegexp.MatchString(template) {
+		return template
+	}
+	return template + fmt.Sprintf("\npunt {\n    socket %s\n}", VppPuntSocket)
+}
+
 func TemplateScriptReplace(input string, params *VppManagerParams, conf []*LinuxInterfaceState) (template string) {
 	template = input
 	if conf != nil {
@@ -17,6 +17,7 @@ package config
 
 import (
 	"net"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -56,3 +57,25 @@ func TestRouteSort(t *testing.T) {
 		}
 	}
 }
+
+var puntSocketConfigTests = []struct {
+	template string
+	added    bool
+}{
+	{"unix { nodaemon }", true},
+	{"unix { nodaemon }\nplugins {\n    plugin default { enable }\n}", true},
+	{"unix { nodaemon }\npunt {\n    socket /run/punt.sock\n}", false},
+	{"unix { nodaemon }\n  punt{ socket /run/punt.sock }", false},
+}
+
+func TestAddPuntSocketConfig(t *testing.T) {
+	for _, tt := range puntSocketConfigTests {
+		conf := AddPuntSocketConfig(tt.template)
+		if tt.added {
+			assert.True(t, strings.HasPrefix(conf, tt.template))
+			assert.Contains(t, conf, "punt {\n    socket "+VppPuntSocket+"\n}")
+		} else {
+			assert.Equal(t, tt.template, conf)
+		}
+	}
+}
@@ -96,6 +96,8 @@ const (
 	SwapDriverEnvVar      = "CALICOVPP_SWAP_DRIVER"
 	ExtraInterfacesEnvVar = "CALICOVPP_EXTRA_INTERFACES"
 	EnableGSOEnvVar       = "CALICOVPP_DEBUG_ENABLE_GSO"
+	/* The agent probes peers through a VPP punt socket, set it on the vpp container too */
+	EnableProbesEnvVar = "CALICOVPP_PROBES_ENABLED"
 )
 
 const (
@@ -343,6 +345,15 @@ func parseEnvVariables(params *config.VppManagerParams, mainInterfaceSpec config
 		params.EnableGSO = enableGSO
 	}
 
+	params.EnableProbes = false
+	if conf := getEnvValue(EnableProbesEnvVar); conf != "" {
+		enableProbes, err := strconv.ParseBool(conf)
+		if err != nil {
+			return fmt.Errorf("Invalid %s configuration: %s parses to %v err %v", EnableProbesEnvVar, conf, enableProbes, err)
+		}
+		params.EnableProbes = enableProbes
+	}
+
 	for _, e := range os.Environ() {
 		pair := strings.SplitN(e, "=", 2)
 		if strings.Contains(pair[0], "CALICOVPP_") {
@@ -389,6 +400,7 @@ func PrintVppManagerConfig(params *config.VppManagerParams, confs []*config.Linu
 	log.Infof("-- Environment --")
 	log.Infof("CorePattern:         %s", params.CorePattern)
 	log.Infof("ExtraAddrCount:      %d", params.ExtraAddrCount)
+	log.Infof("EnableProbes:        %t", params.EnableProbes)
 	log.Infof("RxMode:              %s", params.RxMode)
 	log.Infof("TapRxMode:           %s", params.TapRxMode)
 	log.Infof("Tap MTU override:    %d", params.UserSpecifiedMtu)
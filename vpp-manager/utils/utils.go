@@ -667,6 +667,42 @@ func DecrementIP(ip net.IP) (result net.IP) {
 	return
 }
 
+/**
+ * ExtraAddresses returns the count addresses following the one address of
+ * the given family in addrList, link-local ones aside. They increment the
+ * second to last byte of the address (byte 2 in IPv4, 14 in IPv6), as the
+ * agent does for the IPsec tunnels endpoints.
+ */
+func ExtraAddresses(addrList []netlink.Addr, count int, isIP6 bool) ([]net.IPNet, error) {
+	found := 0
+	var addr net.IPNet
+	for _, a := range addrList {
+		if a.IPNet == nil || a.IP.IsLinkLocalUnicast() || (a.IP.To4() == nil) != isIP6 {
+			continue
+		}
+		found++
+		addr = *a.IPNet
+	}
+	if found != 1 {
+		family := "IPv4"
+		if isIP6 {
+			family = "IPv6"
+		}
+		return nil, fmt.Errorf("%d %s addresses found (need exactly 1)", found, family)
+	}
+	base := NormalizeIP(addr.IP)
+	extraAddrs := make([]net.IPNet, 0, count)
+	for i := 1; i <= count; i++ {
+		a := net.IPNet{
+			IP:   net.IP(append([]byte(nil), base...)),
+			Mask: addr.Mask,
+		}
+		a.IP[len(a.IP)-2] += byte(i)
+		extraAddrs = append(extraAddrs, a)
+	}
+	return extraAddrs, nil
+}
+
 // NetworkAddr returns the first address in the given network, or the network address.
 func NetworkAddr(n *net.IPNet) net.IP {
 	network := make([]byte, len(n.IP))
@@ -20,6 +20,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
 )
 
 var incDecPairs = []struct{ low, high net.IP }{
@@ -67,3 +68,36 @@ func TestNetworkBroadcastAddr(t *testing.T) {
 		assert.True(t, BroadcastAddr(network(t, d.network)).Equal(d.brdAddr))
 	}
 }
+
+func testAddr(t *testing.T, address string) netlink.Addr {
+	ip, ipNet, err := net.ParseCIDR(address)
+	assert.NoError(t, err)
+	ipNet.IP = ip
+	return netlink.Addr{IPNet: ipNet}
+}
+
+func TestExtraAddresses(t *testing.T) {
+	addrList := []netlink.Addr{
+		testAddr(t, "10.0.0.1/16"),
+		testAddr(t, "fd00::1:2/64"),
+		testAddr(t, "fe80::1/64"),
+	}
+	addrs, err := ExtraAddresses(addrList, 2, false)
+	assert.NoError(t, err)
+	if assert.Len(t, addrs, 2) {
+		assert.Equal(t, "10.0.1.1/16", addrs[0].String())
+		assert.Equal(t, "10.0.2.1/16", addrs[1].String())
+	}
+
+	addrs, err = ExtraAddresses(addrList, 2, true)
+	assert.NoError(t, err)
+	if assert.Len(t, addrs, 2) {
+		assert.Equal(t, "fd00::1:102/64", addrs[0].String())
+		assert.Equal(t, "fd00::1:202/64", addrs[1].String())
+	}
+
+	_, err = ExtraAddresses(addrList[2:], 2, true)
+	assert.Error(t, err, "link-local only")
+	_, err = ExtraAddresses(append(addrList, testAddr(t, "10.1.0.1/16")), 2, false)
+	assert.Error(t, err, "two IPv4 addresses")
+}
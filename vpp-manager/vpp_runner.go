@@ -86,6 +86,9 @@ func (v *VppRunner) GenerateVppConfigFile(drivers []uplink.UplinkDriver) error {
 	for _, driver := range drivers {
 		template = driver.UpdateVppConfigFile(template)
 	}
+	if v.params.EnableProbes {
+		template = config.AddPuntSocketConfig(template)
+	}
 	err := errors.Wrapf(
 		ioutil.WriteFile(config.VppConfigFile, []byte(template+"\n"), 0644),
 		"Error writing VPP configuration to %s",
@@ -323,27 +326,23 @@ func (v *VppRunner) addExtraAddresses(addrList []netlink.Addr, extraAddrCount in
 	/* No v6 as extraAddrCount doesnt support it & flow hash breaks in vpp */
 
 	log.Infof("Adding %d extra addresses", extraAddrCount)
-	v4Count := 0
-	var addr net.IPNet
-	for _, a := range addrList {
-		if a.IP.To4() != nil {
-			v4Count++
-			addr = *a.IPNet
-		}
-	}
-	if v4Count != 1 {
-		return fmt.Errorf("%d IPv4 addresses found, not configuring extra addresses (need exactly 1)", v4Count)
-	}
-	for i := 1; i <= extraAddrCount; i++ {
-		a := &net.IPNet{
-			IP:   net.IP(append([]byte(nil), addr.IP.To4()...)),
-			Mask: addr.Mask,
-		}
-		a.IP[2] += byte(i)
-		err = v.vpp.AddInterfaceAddress(&iface, a)
+	nFamilies := 0
+	for _, isIP6 := range []bool{false, true} {
+		extraAddrs, err := utils.ExtraAddresses(addrList, extraAddrCount, isIP6)
 		if err != nil {
-			log.Errorf("Error adding address to data interface: %v", err)
+			log.Infof("Not configuring extra addresses: %v", err)
+			continue
 		}
+		nFamilies++
+		for i := range extraAddrs {
+			err = v.vpp.AddInterfaceAddress(&iface, &extraAddrs[i])
+			if err != nil {
+				log.Errorf("Error adding address to data interface: %v", err)
+			}
+		}
+	}
+	if nFamilies == 0 {
+		return fmt.Errorf("no address to derive extra addresses from")
 	}
 	return nil
 }
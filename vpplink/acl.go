@@ -21,10 +21,11 @@ import (
 	"github.com/pkg/errors"
 	vppacl "github.com/projectcalico/vpp-dataplane/vpplink/binapi/vppapi/acl"
 	"github.com/projectcalico/vpp-dataplane/vpplink/binapi/vppapi/acl_types"
+	"github.com/projectcalico/vpp-dataplane/vpplink/binapi/vppapi/interface_types"
 	"github.com/projectcalico/vpp-dataplane/vpplink/types"
 )
 
-func (v *VppLink) AddACL(acl *types.ACL) (err error) {
+func (v *VppLink) addReplaceACL(acl *types.ACL, aclIndex uint32) (err error) {
 	v.Lock()
 	defer v.Unlock()
 
@@ -33,23 +34,84 @@ func (v *VppLink) AddACL(acl *types.ACL) (err error) {
 		rules = append(rules, aclRule.ToVppACLRule())
 	}
 
+	opStr := "Add"
+	if aclIndex != ^uint32(0) {
+		opStr = "Replace"
+	}
 	response := &vppacl.ACLAddReplaceReply{}
 	request := &vppacl.ACLAddReplace{
-		ACLIndex: ^uint32(0),
+		ACLIndex: aclIndex,
 		Tag:      acl.Tag,
 		R:        rules,
 		Count:    uint32(len(rules)),
 	}
 	err = v.GetChannel().SendRequest(request).ReceiveReply(response)
 	if err != nil {
-		return errors.Wrapf(err, "Add ACL failed")
+		return errors.Wrapf(err, "%s ACL failed", opStr)
 	} else if response.Retval != 0 {
-		return fmt.Errorf("Add ACL failed with retval %d", response.Retval)
+		return fmt.Errorf("%s ACL failed with retval %d", opStr, response.Retval)
 	}
 	acl.ACLIndex = response.ACLIndex
 	return nil
 }
 
+func (v *VppLink) AddACL(acl *types.ACL) (err error) {
+	return v.addReplaceACL(acl, ^uint32(0))
+}
+
+/* UpdateACL replaces the rules of the existing ACL acl.ACLIndex */
+func (v *VppLink) UpdateACL(acl *types.ACL) (err error) {
+	return v.addReplaceACL(acl, acl.ACLIndex)
+}
+
+/* ListACLs returns the index & tag of the ACLs in VPP, without their rules */
+func (v *VppLink) ListACLs() (acls []types.ACL, err error) {
+	v.Lock()
+	defer v.Unlock()
+
+	acls = make([]types.ACL, 0)
+	request := &vppacl.ACLDump{
+		ACLIndex: ^uint32(0),
+	}
+	stream := v.GetChannel().SendMultiRequest(request)
+	for {
+		response := &vppacl.ACLDetails{}
+		stop, err := stream.ReceiveReply(response)
+		if err != nil {
+			return acls, errors.Wrap(err, "error listing ACLs")
+		}
+		if stop {
+			return acls, nil
+		}
+		acls = append(acls, types.ACL{
+			ACLIndex: response.ACLIndex,
+			Tag:      response.Tag,
+		})
+	}
+}
+
+/* SetInterfaceACLs replaces the input & output ACLs applied on swIfIndex */
+func (v *VppLink) SetInterfaceACLs(swIfIndex uint32, inputACLs []uint32, outputACLs []uint32) (err error) {
+	v.Lock()
+	defer v.Unlock()
+
+	acls := append(append([]uint32{}, inputACLs...), outputACLs...)
+	response := &vppacl.ACLInterfaceSetACLListReply{}
+	request := &vppacl.ACLInterfaceSetACLList{
+		SwIfIndex: interface_types.InterfaceIndex(swIfIndex),
+		Count:     uint8(len(acls)),
+		NInput:    uint8(len(inputACLs)),
+		Acls:      acls,
+	}
+	err = v.GetChannel().SendRequest(request).ReceiveReply(response)
+	if err != nil {
+		return errors.Wrapf(err, "Set ACLs on interface %d failed", swIfIndex)
+	} else if response.Retval != 0 {
+		return fmt.Errorf("Set ACLs on interface %d failed with retval %d", swIfIndex, response.Retval)
+	}
+	return nil
+}
+
 func (v *VppLink) DelACL(aclIndex uint32) (err error) {
 	v.Lock()
 	defer v.Unlock()
@@ -0,0 +1,174 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vpplink
+
+import (
+	"fmt"
+
+	"git.fd.io/govpp.git/api"
+	"github.com/pkg/errors"
+	"github.com/projectcalico/vpp-dataplane/vpplink/binapi/vppapi/bfd"
+	"github.com/projectcalico/vpp-dataplane/vpplink/binapi/vppapi/interface_types"
+	"github.com/projectcalico/vpp-dataplane/vpplink/types"
+)
+
+func (v *VppLink) AddBFDSession(session *types.BFDSession) error {
+	v.Lock()
+	defer v.Unlock()
+
+	response := &bfd.BfdUDPAddReply{}
+	request := &bfd.BfdUDPAdd{
+		SwIfIndex:     interface_types.InterfaceIndex(session.SwIfIndex),
+		DesiredMinTx:  session.DesiredMinTx,
+		RequiredMinRx: session.RequiredMinRx,
+		LocalAddr:     types.ToVppAddress(session.LocalAddr),
+		PeerAddr:      types.ToVppAddress(session.PeerAddr),
+		DetectMult:    session.DetectMult,
+	}
+	err := v.GetChannel().SendRequest(request).ReceiveReply(response)
+	if err != nil {
+		return errors.Wrapf(err, "Add BFD session failed")
+	} else if response.Retval != 0 {
+		return fmt.Errorf("Add BFD session failed with retval %d", response.Retval)
+	}
+	return nil
+}
+
+func (v *VppLink) DelBFDSession(session *types.BFDSession) error {
+	v.Lock()
+	defer v.Unlock()
+
+	response := &bfd.BfdUDPDelReply{}
+	request := &bfd.BfdUDPDel{
+		SwIfIndex: interface_types.InterfaceIndex(session.SwIfIndex),
+		LocalAddr: types.ToVppAddress(session.LocalAddr),
+		PeerAddr:  types.ToVppAddress(session.PeerAddr),
+	}
+	err := v.GetChannel().SendRequest(request).ReceiveReply(response)
+	if err != nil {
+		return errors.Wrapf(err, "Del BFD session failed")
+	} else if response.Retval != 0 {
+		return fmt.Errorf("Del BFD session failed with retval %d", response.Retval)
+	}
+	return nil
+}
+
+func (v *VppLink) ListBFDSessions() ([]*types.BFDSession, error) {
+	v.Lock()
+	defer v.Unlock()
+
+	sessions := make([]*types.BFDSession, 0)
+	request := &bfd.BfdUDPSessionDump{}
+	stream := v.GetChannel().SendMultiRequest(request)
+	for {
+		response := &bfd.BfdUDPSessionDetails{}
+		stop, err := stream.ReceiveReply(response)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error listing BFD sessions")
+		}
+		if stop {
+			break
+		}
+		sessions = append(sessions, &types.BFDSession{
+			SwIfIndex:     uint32(response.SwIfIndex),
+			LocalAddr:     types.FromVppAddress(response.LocalAddr),
+			PeerAddr:      types.FromVppAddress(response.PeerAddr),
+			DesiredMinTx:  response.DesiredMinTx,
+			RequiredMinRx: response.RequiredMinRx,
+			DetectMult:    response.DetectMult,
+			State:         types.BFDState(response.State),
+		})
+	}
+	return sessions, nil
+}
+
+func (v *VppLink) wantBFDEvents(isEnable bool) error {
+	response := &bfd.WantBfdEventsReply{}
+	request := &bfd.WantBfdEvents{
+		EnableDisable: isEnable,
+	}
+	err := v.GetChannel().SendRequest(request).ReceiveReply(response)
+	if err != nil {
+		return errors.Wrapf(err, "%s BFD events failed", IsEnableToStr(isEnable))
+	} else if response.Retval != 0 {
+		return fmt.Errorf("%s BFD events failed with retval %d", IsEnableToStr(isEnable), response.Retval)
+	}
+	return nil
+}
+
+/**
+ * WatchBFDSessions sends the BFD sessions whose state changed on events,
+ * until the returned function is called.
+ */
+func (v *VppLink) WatchBFDSessions(events chan<- *types.BFDSession) (func(), error) {
+	v.Lock()
+	defer v.Unlock()
+
+	notifChan := make(chan api.Message, 64)
+	sub, err := v.GetChannel().SubscribeNotification(notifChan, &bfd.BfdUDPSessionEvent{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error subscribing to BFD events")
+	}
+	err = v.wantBFDEvents(true)
+	if err != nil {
+		_ = sub.Unsubscribe()
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case msg := <-notifChan:
+				event, ok := msg.(*bfd.BfdUDPSessionEvent)
+				if !ok {
+					continue
+				}
+				session := &types.BFDSession{
+					SwIfIndex:     uint32(event.SwIfIndex),
+					LocalAddr:     types.FromVppAddress(event.LocalAddr),
+					PeerAddr:      types.FromVppAddress(event.PeerAddr),
+					DesiredMinTx:  event.DesiredMinTx,
+					RequiredMinRx: event.RequiredMinRx,
+					DetectMult:    event.DetectMult,
+					State:         types.BFDState(event.State),
+				}
+				select {
+				case events <- session:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+
+	stop := func() {
+		close(done)
+		v.Lock()
+		defer v.Unlock()
+		err := v.wantBFDEvents(false)
+		if err != nil {
+			v.GetLog().Warnf("%v", err)
+		}
+		err = sub.Unsubscribe()
+		if err != nil {
+			v.GetLog().Warnf("error unsubscribing from BFD events: %v", err)
+		}
+	}
+	return stop, nil
+}
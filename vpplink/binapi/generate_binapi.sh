@@ -95,6 +95,10 @@ function generate_govpp_apis ()
 	  rdma \
 	  vmxnet3 \
 	  pbl \
+	  bfd \
+	  geneve \
+	  gre \
+	  mss_clamp \
 	  memclnt \
 	  session \
 	  vpe
@@ -104,14 +104,14 @@ func (v *VppLink) CnatTranslateDel(id uint32) (err error) {
 	return nil
 }
 
-func (v *VppLink) CnatSetSnatAddresses(v4, v6 net.IP) (err error) {
+func (v *VppLink) cnatSetSnatAddresses(v4, v6 net.IP, swIfIndex interface_types.InterfaceIndex) (err error) {
 	v.Lock()
 	defer v.Unlock()
 
 	request := &cnat.CnatSetSnatAddresses{
 		SnatIP4:   types.ToVppIP4Address(v4),
 		SnatIP6:   types.ToVppIP6Address(v6),
-		SwIfIndex: vppapi.InvalidInterface,
+		SwIfIndex: swIfIndex,
 	}
 	response := &cnat.CnatSetSnatAddressesReply{}
 	err = v.GetChannel().SendRequest(request).ReceiveReply(response)
@@ -123,6 +123,23 @@ func (v *VppLink) CnatSetSnatAddresses(v4, v6 net.IP) (err error) {
 	return nil
 }
 
+func (v *VppLink) CnatSetSnatAddresses(v4, v6 net.IP) (err error) {
+	return v.cnatSetSnatAddresses(v4, v6, vppapi.InvalidInterface)
+}
+
+/**
+ * CnatSetInterfaceSnatAddresses makes the SNAT of the traffic coming from
+ * swIfIndex use v4 & v6 instead of the node-wide addresses, nil addresses
+ * keep using the node-wide ones.
+ */
+func (v *VppLink) CnatSetInterfaceSnatAddresses(swIfIndex uint32, v4, v6 net.IP) (err error) {
+	return v.cnatSetSnatAddresses(v4, v6, interface_types.InterfaceIndex(swIfIndex))
+}
+
+func (v *VppLink) CnatClearInterfaceSnatAddresses(swIfIndex uint32) (err error) {
+	return v.cnatSetSnatAddresses(nil, nil, interface_types.InterfaceIndex(swIfIndex))
+}
+
 func (v *VppLink) CnatAddDelSnatPrefix(prefix *net.IPNet, isAdd bool) (err error) {
 	v.Lock()
 	defer v.Unlock()
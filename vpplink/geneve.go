@@ -0,0 +1,91 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vpplink
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/projectcalico/vpp-dataplane/vpplink/binapi/vppapi"
+	"github.com/projectcalico/vpp-dataplane/vpplink/binapi/vppapi/geneve"
+	"github.com/projectcalico/vpp-dataplane/vpplink/types"
+)
+
+func (v *VppLink) ListGeneveTunnels() ([]types.GeneveTunnel, error) {
+	v.Lock()
+	defer v.Unlock()
+
+	tunnels := make([]types.GeneveTunnel, 0)
+	request := &geneve.GeneveTunnelDump{
+		SwIfIndex: vppapi.InvalidInterface,
+	}
+	stream := v.GetChannel().SendMultiRequest(request)
+	for {
+		response := &geneve.GeneveTunnelDetails{}
+		stop, err := stream.ReceiveReply(response)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error listing Geneve tunnels")
+		}
+		if stop {
+			break
+		}
+		tunnels = append(tunnels, types.GeneveTunnel{
+			SrcAddress:     types.FromVppAddress(response.SrcAddress),
+			DstAddress:     types.FromVppAddress(response.DstAddress),
+			Vni:            response.Vni,
+			DecapNextIndex: response.DecapNextIndex,
+			SwIfIndex:      uint32(response.SwIfIndex),
+		})
+	}
+	return tunnels, nil
+}
+
+func (v *VppLink) addDelGeneveTunnel(tunnel *types.GeneveTunnel, isAdd bool) (swIfIndex uint32, err error) {
+	v.Lock()
+	defer v.Unlock()
+
+	response := &geneve.GeneveAddDelTunnel2Reply{}
+	request := &geneve.GeneveAddDelTunnel2{
+		IsAdd:          isAdd,
+		LocalAddress:   types.ToVppAddress(tunnel.SrcAddress),
+		RemoteAddress:  types.ToVppAddress(tunnel.DstAddress),
+		McastSwIfIndex: vppapi.InvalidInterface,
+		Vni:            tunnel.Vni,
+		DecapNextIndex: tunnel.DecapNextIndex,
+		L3Mode:         true,
+	}
+	err = v.GetChannel().SendRequest(request).ReceiveReply(response)
+	opStr := "Del"
+	if isAdd {
+		opStr = "Add"
+	}
+	if err != nil {
+		return ^uint32(1), errors.Wrapf(err, "%s geneve Tunnel failed", opStr)
+	} else if response.Retval != 0 {
+		return ^uint32(1), fmt.Errorf("%s geneve Tunnel failed with retval %d", opStr, response.Retval)
+	}
+	tunnel.SwIfIndex = uint32(response.SwIfIndex)
+	return uint32(response.SwIfIndex), nil
+}
+
+func (v *VppLink) AddGeneveTunnel(tunnel *types.GeneveTunnel) (swIfIndex uint32, err error) {
+	return v.addDelGeneveTunnel(tunnel, true)
+}
+
+func (v *VppLink) DelGeneveTunnel(tunnel *types.GeneveTunnel) (err error) {
+	_, err = v.addDelGeneveTunnel(tunnel, false)
+	return err
+}
@@ -0,0 +1,100 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vpplink
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/projectcalico/vpp-dataplane/vpplink/binapi/vppapi"
+	"github.com/projectcalico/vpp-dataplane/vpplink/binapi/vppapi/gre"
+	"github.com/projectcalico/vpp-dataplane/vpplink/binapi/vppapi/interface_types"
+	"github.com/projectcalico/vpp-dataplane/vpplink/binapi/vppapi/tunnel_types"
+	"github.com/projectcalico/vpp-dataplane/vpplink/types"
+)
+
+func (v *VppLink) ListGRETunnels() ([]*types.GRETunnel, error) {
+	v.Lock()
+	defer v.Unlock()
+
+	tunnels := make([]*types.GRETunnel, 0)
+	request := &gre.GreTunnelDump{
+		SwIfIndex: vppapi.InvalidInterface,
+	}
+	stream := v.GetChannel().SendMultiRequest(request)
+	for {
+		response := &gre.GreTunnelDetails{}
+		stop, err := stream.ReceiveReply(response)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error listing GRE tunnels")
+		}
+		if stop {
+			break
+		}
+		if response.Tunnel.Type != gre.GRE_API_TUNNEL_TYPE_L3 {
+			continue
+		}
+		tunnels = append(tunnels, &types.GRETunnel{
+			Src:       types.FromVppAddress(response.Tunnel.Src),
+			Dst:       types.FromVppAddress(response.Tunnel.Dst),
+			TableID:   response.Tunnel.OuterTableID,
+			SwIfIndex: uint32(response.Tunnel.SwIfIndex),
+		})
+	}
+	return tunnels, nil
+}
+
+func (v *VppLink) addDelGRETunnel(tunnel *types.GRETunnel, isAdd bool) (swIfIndex uint32, err error) {
+	v.Lock()
+	defer v.Unlock()
+
+	response := &gre.GreTunnelAddDelReply{}
+	request := &gre.GreTunnelAddDel{
+		IsAdd: isAdd,
+		Tunnel: gre.GreTunnel{
+			Type:         gre.GRE_API_TUNNEL_TYPE_L3,
+			Mode:         tunnel_types.TUNNEL_API_MODE_P2P,
+			Instance:     ^uint32(0),
+			OuterTableID: tunnel.TableID,
+			SwIfIndex:    interface_types.InterfaceIndex(tunnel.SwIfIndex),
+			Src:          types.ToVppAddress(tunnel.Src),
+			Dst:          types.ToVppAddress(tunnel.Dst),
+		},
+	}
+	err = v.GetChannel().SendRequest(request).ReceiveReply(response)
+	opStr := "Del"
+	if isAdd {
+		opStr = "Add"
+	}
+	if err != nil {
+		return ^uint32(1), errors.Wrapf(err, "%s GRE Tunnel failed", opStr)
+	} else if response.Retval != 0 {
+		return ^uint32(1), fmt.Errorf("%s GRE Tunnel failed with retval %d", opStr, response.Retval)
+	}
+	if isAdd {
+		tunnel.SwIfIndex = uint32(response.SwIfIndex)
+	}
+	return tunnel.SwIfIndex, nil
+}
+
+func (v *VppLink) AddGRETunnel(tunnel *types.GRETunnel) (swIfIndex uint32, err error) {
+	return v.addDelGRETunnel(tunnel, true)
+}
+
+func (v *VppLink) DelGRETunnel(tunnel *types.GRETunnel) (err error) {
+	_, err = v.addDelGRETunnel(tunnel, false)
+	return err
+}
@@ -126,6 +126,14 @@ func IsAddToStr(isAdd bool) string {
 	}
 }
 
+func IsEnableToStr(isEnable bool) string {
+	if isEnable {
+		return "enable"
+	} else {
+		return "disable"
+	}
+}
+
 func DefaultIntTo(value, defaultValue int) int {
 	if value == 0 {
 		return defaultValue
@@ -18,11 +18,14 @@ package vpplink
 import (
 	"fmt"
 	"net"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/projectcalico/vpp-dataplane/vpplink/binapi/vppapi/ikev2"
 	"github.com/projectcalico/vpp-dataplane/vpplink/binapi/vppapi/ikev2_types"
 	"github.com/projectcalico/vpp-dataplane/vpplink/binapi/vppapi/interface_types"
+	"github.com/projectcalico/vpp-dataplane/vpplink/binapi/vppapi/ipsec_types"
 	"github.com/projectcalico/vpp-dataplane/vpplink/types"
 )
 
@@ -109,6 +112,150 @@ const (
 	IKEv2DHGroupBRAINPOOL_512 IKEv2DHGroup = 30
 )
 
+/**
+ * The IKEv2 transform IDs of the VPP IPsec algorithms the ikev2 plugin can
+ * negotiate. Algorithms are named in the agent config after the binapi
+ * enums, e.g. aes-cbc with a 256 bits key is IPSEC_API_CRYPTO_ALG_AES_CBC_256
+ * and sha2-256-128 is IPSEC_API_INTEG_ALG_SHA_256_128.
+ */
+var (
+	ikev2EncryptionAlgorithms = map[ipsec_types.IpsecCryptoAlg]IKEv2EncryptionAlgorithm{
+		ipsec_types.IPSEC_API_CRYPTO_ALG_AES_CBC_128: IKEv2EncryptionAlgorithmAES_CBC,
+		ipsec_types.IPSEC_API_CRYPTO_ALG_AES_CBC_192: IKEv2EncryptionAlgorithmAES_CBC,
+		ipsec_types.IPSEC_API_CRYPTO_ALG_AES_CBC_256: IKEv2EncryptionAlgorithmAES_CBC,
+		ipsec_types.IPSEC_API_CRYPTO_ALG_AES_GCM_128: IKEv2EncryptionAlgorithmAES_GCM_16,
+		ipsec_types.IPSEC_API_CRYPTO_ALG_AES_GCM_192: IKEv2EncryptionAlgorithmAES_GCM_16,
+		ipsec_types.IPSEC_API_CRYPTO_ALG_AES_GCM_256: IKEv2EncryptionAlgorithmAES_GCM_16,
+	}
+	ikev2IntegrityAlgorithms = map[ipsec_types.IpsecIntegAlg]IKEv2IntegrityAlgorithm{
+		ipsec_types.IPSEC_API_INTEG_ALG_NONE:        IKEv2IntegrityAlgorithmNone,
+		ipsec_types.IPSEC_API_INTEG_ALG_SHA1_96:     IKEv2IntegrityAlgorithmAUTH_HMAC_SHA1_96,
+		ipsec_types.IPSEC_API_INTEG_ALG_SHA_256_128: IKEv2IntegrityAlgorithmAUTH_HMAC_SHA2_256_128,
+		ipsec_types.IPSEC_API_INTEG_ALG_SHA_384_192: IKEv2IntegrityAlgorithmAUTH_HMAC_SHA2_384_192,
+		ipsec_types.IPSEC_API_INTEG_ALG_SHA_512_256: IKEv2IntegrityAlgorithmAUTH_HMAC_SHA2_512_256,
+	}
+	/* DH groups have no binapi enum, they are only known to the ikev2 plugin */
+	IKEv2DHGroups = map[string]IKEv2DHGroup{
+		"modp-768":      IKEv2DHGroupMODP_768,
+		"modp-1024":     IKEv2DHGroupMODP_1024,
+		"modp-1536":     IKEv2DHGroupMODP_1536,
+		"modp-2048":     IKEv2DHGroupMODP_2048,
+		"modp-3072":     IKEv2DHGroupMODP_3072,
+		"modp-4096":     IKEv2DHGroupMODP_4096,
+		"modp-6144":     IKEv2DHGroupMODP_6144,
+		"modp-8192":     IKEv2DHGroupMODP_8192,
+		"modp-1024-160": IKEv2DHGroupMODP_1024_160,
+		"modp-2048-224": IKEv2DHGroupMODP_2048_224,
+		"modp-2048-256": IKEv2DHGroupMODP_2048_256,
+		"ecp-192":       IKEv2DHGroupECP_192,
+		"ecp-224":       IKEv2DHGroupECP_224,
+		"ecp-256":       IKEv2DHGroupECP_256,
+		"ecp-384":       IKEv2DHGroupECP_384,
+		"ecp-521":       IKEv2DHGroupECP_521,
+		"brainpool-224": IKEv2DHGroupBRAINPOOL_224,
+		"brainpool-256": IKEv2DHGroupBRAINPOOL_256,
+		"brainpool-384": IKEv2DHGroupBRAINPOOL_384,
+		"brainpool-512": IKEv2DHGroupBRAINPOOL_512,
+	}
+)
+
+/* IKEv2Transforms is the transform suite of the IKE SA, or of the child (ESP) SAs without DHGroup */
+type IKEv2Transforms struct {
+	CryptoAlg     IKEv2EncryptionAlgorithm
+	CryptoKeySize uint32
+	IntegAlg      IKEv2IntegrityAlgorithm
+	DHGroup       IKEv2DHGroup
+}
+
+var (
+	DefaultIKEv2IKETransforms = IKEv2Transforms{
+		CryptoAlg:     IKEv2EncryptionAlgorithmAES_CBC,
+		CryptoKeySize: 256,
+		IntegAlg:      IKEv2IntegrityAlgorithmAUTH_HMAC_SHA1_96,
+		DHGroup:       IKEv2DHGroupMODP_2048,
+	}
+	DefaultIKEv2ESPTransforms = IKEv2Transforms{
+		CryptoAlg:     IKEv2EncryptionAlgorithmAES_GCM_16,
+		CryptoKeySize: 256,
+		IntegAlg:      IKEv2IntegrityAlgorithmNone,
+	}
+)
+
+/**
+ * ParseIKEv2Transforms checks a transform suite given by names is one VPP
+ * can negotiate. AES-GCM provides integrity and must not have another
+ * integrity algorithm, AES-CBC needs one. The ESP DH group cannot be
+ * configured (no PFS), the child SAs use the keys of the IKE SA.
+ */
+func ParseIKEv2Transforms(encryption string, keySize uint32, integrity string, dhGroup string, isESP bool) (*IKEv2Transforms, error) {
+	cryptoAlg, err := parseIKEv2EncryptionAlgorithm(encryption, keySize)
+	if err != nil {
+		return nil, err
+	}
+	if integrity == "" {
+		integrity = "none"
+	}
+	integAlg, err := parseIKEv2IntegrityAlgorithm(integrity)
+	if err != nil {
+		return nil, err
+	}
+	if cryptoAlg == IKEv2EncryptionAlgorithmAES_GCM_16 && integAlg != IKEv2IntegrityAlgorithmNone {
+		return nil, fmt.Errorf("%s provides integrity, expected integrity none", encryption)
+	}
+	if cryptoAlg != IKEv2EncryptionAlgorithmAES_GCM_16 && integAlg == IKEv2IntegrityAlgorithmNone {
+		return nil, fmt.Errorf("%s requires an integrity algorithm", encryption)
+	}
+	transforms := &IKEv2Transforms{
+		CryptoAlg:     cryptoAlg,
+		CryptoKeySize: keySize,
+		IntegAlg:      integAlg,
+	}
+	if isESP {
+		if dhGroup != "" {
+			return nil, fmt.Errorf("DH group cannot be set for ESP")
+		}
+		return transforms, nil
+	}
+	dh, found := IKEv2DHGroups[dhGroup]
+	if !found {
+		return nil, fmt.Errorf("unsupported DH group %s", dhGroup)
+	}
+	transforms.DHGroup = dh
+	return transforms, nil
+}
+
+/* ipsecAPIName is the binapi enum name of an algorithm named in the agent config */
+func ipsecAPIName(prefix string, name string) string {
+	return prefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+func parseIKEv2EncryptionAlgorithm(encryption string, keySize uint32) (IKEv2EncryptionAlgorithm, error) {
+	/* GCM always has a 16 bytes ICV in VPP, IKEv2 names it */
+	name := ipsecAPIName("IPSEC_API_CRYPTO_ALG_", fmt.Sprintf("%s-%d", strings.TrimSuffix(encryption, "-16"), keySize))
+	value, found := ipsec_types.IpsecCryptoAlg_value[name]
+	if !found {
+		return 0, fmt.Errorf("unsupported encryption %s with key size %d", encryption, keySize)
+	}
+	cryptoAlg, found := ikev2EncryptionAlgorithms[ipsec_types.IpsecCryptoAlg(value)]
+	if !found {
+		return 0, fmt.Errorf("encryption %s cannot be negotiated with IKEv2", encryption)
+	}
+	return cryptoAlg, nil
+}
+
+func parseIKEv2IntegrityAlgorithm(integrity string) (IKEv2IntegrityAlgorithm, error) {
+	name := ipsecAPIName("IPSEC_API_INTEG_ALG_", strings.Replace(integrity, "sha2-", "sha-", 1))
+	value, found := ipsec_types.IpsecIntegAlg_value[name]
+	if !found {
+		return 0, fmt.Errorf("unsupported integrity %s", integrity)
+	}
+	integAlg, found := ikev2IntegrityAlgorithms[ipsec_types.IpsecIntegAlg(value)]
+	if !found {
+		return 0, fmt.Errorf("integrity %s cannot be negotiated with IKEv2", integrity)
+	}
+	return integAlg, nil
+}
+
 func (v *VppLink) AddIKEv2Profile(name string) error {
 	return v.addDelIKEv2Profile(name, true)
 }
@@ -160,6 +307,41 @@ func (v *VppLink) ListIKEv2Profiles() ([]string, error) {
 	return profiles, nil
 }
 
+func (v *VppLink) ListIKEv2SAs() ([]types.IKEv2SA, error) {
+	v.Lock()
+	defer v.Unlock()
+
+	sas := make([]types.IKEv2SA, 0)
+	request := &ikev2.Ikev2SaDump{}
+	stream := v.GetChannel().SendMultiRequest(request)
+	for {
+		response := &ikev2.Ikev2SaDetails{}
+		stop, err := stream.ReceiveReply(response)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error listing Ikev2 SAs")
+		}
+		if stop {
+			break
+		}
+		sa := response.Sa
+		sas = append(sas, types.IKEv2SA{
+			SaIndex:           sa.SaIndex,
+			ProfileIndex:      sa.ProfileIndex,
+			Ispi:              sa.Ispi,
+			Rspi:              sa.Rspi,
+			Iaddr:             types.FromVppAddress(sa.Iaddr),
+			Raddr:             types.FromVppAddress(sa.Raddr),
+			NKeepalives:       sa.Stats.NKeepalives,
+			NRekeyReq:         sa.Stats.NRekeyReq,
+			NSaInitReq:        sa.Stats.NSaInitReq,
+			NSaAuthReq:        sa.Stats.NSaAuthReq,
+			NRetransmit:       sa.Stats.NRetransmit,
+			NInitSaRetransmit: sa.Stats.NInitSaRetransmit,
+		})
+	}
+	return sas, nil
+}
+
 func (v *VppLink) setIKEv2Auth(profile string, authMethod IKEv2AuthMethod, authData []byte) (err error) {
 	v.Lock()
 	defer v.Unlock()
@@ -188,6 +370,33 @@ func (v *VppLink) SetIKEv2PSKAuth(profile, psk string) (err error) {
 	return v.setIKEv2Auth(profile, IKEv2AuthMethodSharedKeyMic, []byte(psk))
 }
 
+/* peerCertFile is the path to the PEM certificate of the peer, as seen by VPP */
+func (v *VppLink) SetIKEv2CertAuth(profile, peerCertFile string) (err error) {
+	return v.setIKEv2Auth(profile, IKEv2AuthMethodRSASig, []byte(peerCertFile))
+}
+
+/* keyFile is the path to our PEM private key, as seen by VPP. It is shared by all profiles */
+func (v *VppLink) SetIKEv2LocalKey(keyFile string) (err error) {
+	v.Lock()
+	defer v.Unlock()
+
+	if len(keyFile) >= 256 {
+		return errors.New("IKEv2 key file path too long (max 256)")
+	}
+	request := &ikev2.Ikev2SetLocalKey{
+		KeyFile: keyFile,
+	}
+	response := &ikev2.Ikev2SetLocalKeyReply{}
+	err = v.GetChannel().SendRequest(request).ReceiveReply(response)
+	if err != nil {
+		return errors.Wrapf(err, "failed to set IKEv2 local key %s", keyFile)
+	} else if response.Retval != 0 {
+		return fmt.Errorf("failed to set IKEv2 local key %s (retval %d)", keyFile, response.Retval)
+	}
+	v.GetLog().Debugf("set IKEv2 local key %s", keyFile)
+	return nil
+}
+
 func (v *VppLink) setIKEv2ID(profile string, isLocal bool, idType IKEv2IDType, id []byte) (err error) {
 	v.Lock()
 	defer v.Unlock()
@@ -212,18 +421,27 @@ func (v *VppLink) setIKEv2ID(profile string, isLocal bool, idType IKEv2IDType, i
 	return nil
 }
 
-func (v *VppLink) SetIKEv2LocalIDAddress(profile string, localAddr net.IP) (err error) {
-	if localAddr.To4() == nil {
-		return errors.New("IPv6 unsupported in IKEv2 at this time")
+func (v *VppLink) setIKEv2IDAddress(profile string, isLocal bool, addr net.IP) (err error) {
+	if IsIP6(addr) {
+		return v.setIKEv2ID(profile, isLocal, IKEv2IDTypeIPv6Addr, addr.To16())
 	}
-	return v.setIKEv2ID(profile, true, IKEv2IDTypeIPv4Addr, localAddr.To4())
+	return v.setIKEv2ID(profile, isLocal, IKEv2IDTypeIPv4Addr, addr.To4())
+}
+
+func (v *VppLink) SetIKEv2LocalIDAddress(profile string, localAddr net.IP) (err error) {
+	return v.setIKEv2IDAddress(profile, true, localAddr)
 }
 
 func (v *VppLink) SetIKEv2RemoteIDAddress(profile string, rmtAddr net.IP) (err error) {
-	if rmtAddr.To4() == nil {
-		return errors.New("IPv6 unsupported in IKEv2 at this time")
-	}
-	return v.setIKEv2ID(profile, false, IKEv2IDTypeIPv4Addr, rmtAddr.To4())
+	return v.setIKEv2IDAddress(profile, false, rmtAddr)
+}
+
+func (v *VppLink) SetIKEv2LocalIDFQDN(profile string, fqdn string) (err error) {
+	return v.setIKEv2ID(profile, true, IKEv2IDTypeFQDN, []byte(fqdn))
+}
+
+func (v *VppLink) SetIKEv2RemoteIDFQDN(profile string, fqdn string) (err error) {
+	return v.setIKEv2ID(profile, false, IKEv2IDTypeFQDN, []byte(fqdn))
 }
 
 func (v *VppLink) SetIKEv2TrafficSelector(
@@ -241,8 +459,8 @@ func (v *VppLink) SetIKEv2TrafficSelector(
 	if len(profile) >= 64 {
 		return errors.New("IKEv2 profile name too long (max 64)")
 	}
-	if startAddr.To4() == nil || endAddr.To4() == nil {
-		return errors.New("IPv6 unsupported in IKEv2 at this time")
+	if IsIP6(startAddr) != IsIP6(endAddr) {
+		return errors.New("IKEv2 traffic selector addresses must be of the same family")
 	}
 	request := &ikev2.Ikev2ProfileSetTs{
 		Name: profile,
@@ -266,12 +484,30 @@ func (v *VppLink) SetIKEv2TrafficSelector(
 	return nil
 }
 
+/* ikev2PermissiveRange is the address range covering a whole family */
+func ikev2PermissiveRange(isIP6 bool) (startAddr net.IP, endAddr net.IP) {
+	if isIP6 {
+		return net.ParseIP("::"), net.ParseIP("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff")
+	}
+	return net.ParseIP("0.0.0.0"), net.ParseIP("255.255.255.255")
+}
+
+/**
+ * SetIKEv2PermissiveTrafficSelectors sets local & remote selectors for both
+ * families, tunnels carry the pods traffic of either family whatever the
+ * family of their endpoints.
+ */
 func (v *VppLink) SetIKEv2PermissiveTrafficSelectors(profile string) (err error) {
-	err = v.SetIKEv2TrafficSelector(profile, true, 0, 0, 0xffff, net.ParseIP("0.0.0.0"), net.ParseIP("255.255.255.255"))
-	if err != nil {
-		return err
+	for _, isIP6 := range []bool{false, true} {
+		startAddr, endAddr := ikev2PermissiveRange(isIP6)
+		for _, isLocal := range []bool{true, false} {
+			err = v.SetIKEv2TrafficSelector(profile, isLocal, 0, 0, 0xffff, startAddr, endAddr)
+			if err != nil {
+				return err
+			}
+		}
 	}
-	return v.SetIKEv2TrafficSelector(profile, false, 0, 0, 0xffff, net.ParseIP("0.0.0.0"), net.ParseIP("255.255.255.255"))
+	return nil
 }
 
 func (v *VppLink) SetIKEv2ESPTransforms(
@@ -338,34 +574,61 @@ func (v *VppLink) SetIKEv2IKETransforms(
 	return nil
 }
 
-func (v *VppLink) SetIKEv2DefaultTransforms(profile string) (err error) {
+func (v *VppLink) SetIKEv2Transforms(profile string, ike *IKEv2Transforms, esp *IKEv2Transforms) (err error) {
 	err = v.SetIKEv2IKETransforms(
 		profile,
-		IKEv2EncryptionAlgorithmAES_CBC,
-		256,
-		IKEv2IntegrityAlgorithmAUTH_HMAC_SHA1_96,
-		IKEv2DHGroupMODP_2048,
+		ike.CryptoAlg,
+		ike.CryptoKeySize,
+		ike.IntegAlg,
+		ike.DHGroup,
 	)
 	if err != nil {
 		return err
 	}
 	return v.SetIKEv2ESPTransforms(
 		profile,
-		IKEv2EncryptionAlgorithmAES_GCM_16,
-		256,
-		IKEv2IntegrityAlgorithmNone,
+		esp.CryptoAlg,
+		esp.CryptoKeySize,
+		esp.IntegAlg,
 	)
 }
 
-func (v *VppLink) SetIKEv2Responder(profile string, swIfIndex uint32, address net.IP) (err error) {
+/**
+ * SetIKEv2SALifetime sets when the child SAs of the profile are rekeyed:
+ * after lifetime plus a random jitter, or after maxData bytes if not zero.
+ * The old SAs are kept for handover after a rekey.
+ */
+func (v *VppLink) SetIKEv2SALifetime(profile string, lifetime time.Duration, jitter time.Duration, handover time.Duration, maxData uint64) (err error) {
 	v.Lock()
 	defer v.Unlock()
 
 	if len(profile) >= 64 {
 		return errors.New("IKEv2 profile name too long (max 64)")
 	}
-	if address.To4() == nil {
-		return errors.New("IPv6 unsupported in IKEv2 at this time")
+	request := &ikev2.Ikev2SetSaLifetime{
+		Name:            profile,
+		Lifetime:        uint64(lifetime.Seconds()),
+		LifetimeJitter:  uint32(jitter.Seconds()),
+		Handover:        uint32(handover.Seconds()),
+		LifetimeMaxdata: maxData,
+	}
+	response := &ikev2.Ikev2SetSaLifetimeReply{}
+	err = v.GetChannel().SendRequest(request).ReceiveReply(response)
+	if err != nil {
+		return errors.Wrapf(err, "failed to set SA lifetime for profile %s", profile)
+	} else if response.Retval != 0 {
+		return fmt.Errorf("failed to set SA lifetime for profile %s (retval %d)", profile, response.Retval)
+	}
+	v.GetLog().Debugf("set SA lifetime for profile %s", profile)
+	return nil
+}
+
+func (v *VppLink) SetIKEv2Responder(profile string, swIfIndex uint32, address net.IP) (err error) {
+	v.Lock()
+	defer v.Unlock()
+
+	if len(profile) >= 64 {
+		return errors.New("IKEv2 profile name too long (max 64)")
 	}
 	vppAddr := types.ToVppAddress(address)
 	request := &ikev2.Ikev2SetResponder{
@@ -0,0 +1,101 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vpplink
+
+import (
+	"fmt"
+
+	"git.fd.io/govpp.git/api"
+	"github.com/pkg/errors"
+	interfaces "github.com/projectcalico/vpp-dataplane/vpplink/binapi/vppapi/interface"
+	"github.com/projectcalico/vpp-dataplane/vpplink/binapi/vppapi/interface_types"
+	"github.com/projectcalico/vpp-dataplane/vpplink/types"
+)
+
+func (v *VppLink) wantInterfaceEvents(isEnable bool) error {
+	response := &interfaces.WantInterfaceEventsReply{}
+	request := &interfaces.WantInterfaceEvents{
+		EnableDisable: uint32(BoolToU8(isEnable)),
+	}
+	err := v.GetChannel().SendRequest(request).ReceiveReply(response)
+	if err != nil {
+		return errors.Wrapf(err, "%s interface events failed", IsEnableToStr(isEnable))
+	} else if response.Retval != 0 {
+		return fmt.Errorf("%s interface events failed with retval %d", IsEnableToStr(isEnable), response.Retval)
+	}
+	return nil
+}
+
+/**
+ * WatchInterfaceEvents sends the admin & link state changes of all the
+ * interfaces on events, until the returned function is called.
+ */
+func (v *VppLink) WatchInterfaceEvents(events chan<- *types.InterfaceEvent) (func(), error) {
+	v.Lock()
+	defer v.Unlock()
+
+	notifChan := make(chan api.Message, 64)
+	sub, err := v.GetChannel().SubscribeNotification(notifChan, &interfaces.SwInterfaceEvent{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error subscribing to interface events")
+	}
+	err = v.wantInterfaceEvents(true)
+	if err != nil {
+		_ = sub.Unsubscribe()
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case msg := <-notifChan:
+				event, ok := msg.(*interfaces.SwInterfaceEvent)
+				if !ok {
+					continue
+				}
+				ifEvent := &types.InterfaceEvent{
+					SwIfIndex: uint32(event.SwIfIndex),
+					IsAdminUp: event.Flags&interface_types.IF_STATUS_API_FLAG_ADMIN_UP != 0,
+					IsLinkUp:  event.Flags&interface_types.IF_STATUS_API_FLAG_LINK_UP != 0,
+					Deleted:   event.Deleted,
+				}
+				select {
+				case events <- ifEvent:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+
+	stop := func() {
+		close(done)
+		v.Lock()
+		defer v.Unlock()
+		err := v.wantInterfaceEvents(false)
+		if err != nil {
+			v.GetLog().Warnf("%v", err)
+		}
+		err = sub.Unsubscribe()
+		if err != nil {
+			v.GetLog().Warnf("error unsubscribing from interface events: %v", err)
+		}
+	}
+	return stop, nil
+}
@@ -172,6 +172,54 @@ func (v *VppLink) PuntL4(proto types.IPProto, port uint16, isIPv6 bool) error {
 	return nil
 }
 
+func puntL4Socket(proto types.IPProto, port uint16, isIPv6 bool) punt.Punt {
+	return punt.Punt{
+		Type: punt.PUNT_API_TYPE_L4,
+		Punt: punt.PuntUnionL4(punt.PuntL4{
+			Af:       types.ToVppAddressFamily(isIPv6),
+			Protocol: types.ToVppIPProto(proto),
+			Port:     port,
+		}),
+	}
+}
+
+// PuntSocketRegister sends the packets received for a local L4 port to the unix socket clientPath.
+// It returns the path of the VPP socket on which packets can be injected, this needs a punt { socket }
+// section in the VPP configuration
+func (v *VppLink) PuntSocketRegister(proto types.IPProto, port uint16, isIPv6 bool, clientPath string) (string, error) {
+	v.Lock()
+	defer v.Unlock()
+	request := &punt.PuntSocketRegister{
+		HeaderVersion: types.PuntSocketHeaderVersion,
+		Punt:          puntL4Socket(proto, port, isIPv6),
+		Pathname:      clientPath,
+	}
+	response := &punt.PuntSocketRegisterReply{}
+	err := v.GetChannel().SendRequest(request).ReceiveReply(response)
+	if err != nil {
+		return "", errors.Wrapf(err, "PuntSocketRegister failed: req %+v reply %+v", request, response)
+	} else if response.Retval != 0 {
+		return "", fmt.Errorf("PuntSocketRegister failed (retval %d). Request: %+v", response.Retval, request)
+	}
+	return response.Pathname, nil
+}
+
+func (v *VppLink) PuntSocketDeregister(proto types.IPProto, port uint16, isIPv6 bool) error {
+	v.Lock()
+	defer v.Unlock()
+	request := &punt.PuntSocketDeregister{
+		Punt: puntL4Socket(proto, port, isIPv6),
+	}
+	response := &punt.PuntSocketDeregisterReply{}
+	err := v.GetChannel().SendRequest(request).ReceiveReply(response)
+	if err != nil {
+		return errors.Wrapf(err, "PuntSocketDeregister failed: req %+v reply %+v", request, response)
+	} else if response.Retval != 0 {
+		return fmt.Errorf("PuntSocketDeregister failed (retval %d). Request: %+v", response.Retval, request)
+	}
+	return nil
+}
+
 func (v *VppLink) PuntAllL4(isIPv6 bool) (err error) {
 	err = v.PuntL4(types.TCP, 0xffff, isIPv6)
 	if err != nil {
@@ -25,6 +25,15 @@ import (
 )
 
 func (v *VppLink) GetIPsecTunnelProtection(tunnelInterface uint32) (protections []types.IPsecTunnelProtection, err error) {
+	return v.dumpIPsecTunnelProtections(tunnelInterface)
+}
+
+/* ListIPsecTunnelProtections returns the protections of all the tunnel interfaces in a single dump */
+func (v *VppLink) ListIPsecTunnelProtections() (protections []types.IPsecTunnelProtection, err error) {
+	return v.dumpIPsecTunnelProtections(InvalidID)
+}
+
+func (v *VppLink) dumpIPsecTunnelProtections(tunnelInterface uint32) (protections []types.IPsecTunnelProtection, err error) {
 	v.Lock()
 	defer v.Unlock()
 
@@ -36,7 +45,7 @@ func (v *VppLink) GetIPsecTunnelProtection(tunnelInterface uint32) (protections
 	for {
 		stop, err := stream.ReceiveReply(response)
 		if err != nil {
-			return nil, errors.Wrapf(err, "error listing tunnel interface %u protections", tunnelInterface)
+			return nil, errors.Wrapf(err, "error listing tunnel interface %d protections", tunnelInterface)
 		}
 		if stop {
 			return protections, nil
@@ -51,6 +60,35 @@ func (v *VppLink) GetIPsecTunnelProtection(tunnelInterface uint32) (protections
 	}
 }
 
+func (v *VppLink) ListIPsecSAs() (sas []types.IPsecSA, err error) {
+	v.Lock()
+	defer v.Unlock()
+
+	request := &ipsec.IpsecSaV3Dump{
+		SaID: ^uint32(0),
+	}
+	stream := v.GetChannel().SendMultiRequest(request)
+	for {
+		response := &ipsec.IpsecSaV3Details{}
+		stop, err := stream.ReceiveReply(response)
+		if err != nil {
+			return nil, errors.Wrap(err, "error listing IPsec SAs")
+		}
+		if stop {
+			return sas, nil
+		}
+		sas = append(sas, types.IPsecSA{
+			SadID:          response.Entry.SadID,
+			Spi:            response.Entry.Spi,
+			SwIfIndex:      uint32(response.SwIfIndex),
+			StatIndex:      response.StatIndex,
+			SeqOutbound:    response.SeqOutbound,
+			LastSeqInbound: response.LastSeqInbound,
+			ReplayWindow:   response.ReplayWindow,
+		})
+	}
+}
+
 func (v *VppLink) SetIPsecAsyncMode(enable bool) error {
 	v.Lock()
 	defer v.Unlock()
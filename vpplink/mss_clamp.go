@@ -0,0 +1,54 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vpplink
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/projectcalico/vpp-dataplane/vpplink/binapi/vppapi/interface_types"
+	"github.com/projectcalico/vpp-dataplane/vpplink/binapi/vppapi/mss_clamp"
+)
+
+func (v *VppLink) setMSSClamp(swIfIndex uint32, ip4Mss uint16, ip6Mss uint16, direction mss_clamp.MssClampDir) error {
+	v.Lock()
+	defer v.Unlock()
+
+	response := &mss_clamp.MssClampEnableDisableReply{}
+	request := &mss_clamp.MssClampEnableDisable{
+		SwIfIndex:     interface_types.InterfaceIndex(swIfIndex),
+		IPv4Mss:       ip4Mss,
+		IPv6Mss:       ip6Mss,
+		IPv4Direction: direction,
+		IPv6Direction: direction,
+	}
+	err := v.GetChannel().SendRequest(request).ReceiveReply(response)
+	if err != nil {
+		return errors.Wrapf(err, "MssClampEnableDisable failed")
+	} else if response.Retval != 0 {
+		return fmt.Errorf("MssClampEnableDisable failed with retval %d", response.Retval)
+	}
+	return nil
+}
+
+/* EnableMSSClamp clamps the MSS of TCP SYNs sent on swIfIndex */
+func (v *VppLink) EnableMSSClamp(swIfIndex uint32, ip4Mss uint16, ip6Mss uint16) error {
+	return v.setMSSClamp(swIfIndex, ip4Mss, ip6Mss, mss_clamp.MSS_CLAMP_DIR_TX)
+}
+
+func (v *VppLink) DisableMSSClamp(swIfIndex uint32) error {
+	return v.setMSSClamp(swIfIndex, 0, 0, mss_clamp.MSS_CLAMP_DIR_NONE)
+}
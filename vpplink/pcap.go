@@ -0,0 +1,76 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vpplink
+
+import (
+	"fmt"
+	"strings"
+
+	types2 "git.fd.io/govpp.git/api/v0"
+	"github.com/pkg/errors"
+)
+
+/*
+ * VPP only exposes pcap tracing through its cli, and always
+ * writes the capture file under its own /tmp directory
+ */
+func (v *VppLink) PcapTraceStart(swIfIndex uint32, maxPackets uint32, fileName string) (reply string, err error) {
+	if strings.Contains(fileName, "/") {
+		return "", fmt.Errorf("PcapTraceStart invalid file name %s", fileName)
+	}
+	iface, err := v.GetInterfaceDetails(&types2.Interface{SwIfIndex: swIfIndex})
+	if err != nil {
+		return "", errors.Wrapf(err, "PcapTraceStart cannot find interface %d", swIfIndex)
+	}
+	reply, err = v.RunCli(fmt.Sprintf("pcap trace rx tx drop max %d intfc %s file %s", maxPackets, iface.Name, fileName))
+	if err != nil {
+		return "", errors.Wrap(err, "PcapTraceStart failed")
+	}
+	return strings.TrimSpace(reply), nil
+}
+
+const pcapNoPacketsReply = "No packets captured"
+
+/*
+ * parsePcapTraceStopReply checks the output of "pcap trace off", which
+ * is "Write <n> packets to <file>, and stop capture..." when a file was
+ * written, and returns the number of packets written. When nothing was
+ * captured VPP writes no file, and we return an empty fileName.
+ */
+func parsePcapTraceStopReply(reply string) (nPackets uint32, fileName string, err error) {
+	reply = strings.TrimSpace(reply)
+	if strings.HasPrefix(reply, pcapNoPacketsReply) {
+		return 0, "", nil
+	}
+	_, err = fmt.Sscanf(reply, "Write %d packets to %s", &nPackets, &fileName)
+	if err != nil {
+		return 0, "", fmt.Errorf("unexpected reply '%s'", reply)
+	}
+	return nPackets, strings.TrimSuffix(fileName, ","), nil
+}
+
+/* PcapTraceStop stops the capture and returns the path of the file VPP wrote, if any */
+func (v *VppLink) PcapTraceStop() (nPackets uint32, fileName string, err error) {
+	reply, err := v.RunCli("pcap trace off")
+	if err != nil {
+		return 0, "", errors.Wrap(err, "PcapTraceStop failed")
+	}
+	nPackets, fileName, err = parsePcapTraceStopReply(reply)
+	if err != nil {
+		return 0, "", errors.Wrap(err, "PcapTraceStop failed")
+	}
+	return nPackets, fileName, nil
+}
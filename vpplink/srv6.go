@@ -7,6 +7,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/projectcalico/vpp-dataplane/vpplink/binapi/vppapi/interface_types"
 	"github.com/projectcalico/vpp-dataplane/vpplink/binapi/vppapi/sr"
+	"github.com/projectcalico/vpp-dataplane/vpplink/binapi/vppapi/sr_types"
 	"github.com/projectcalico/vpp-dataplane/vpplink/types"
 )
 
@@ -98,6 +99,35 @@ func (v *VppLink) AddSRv6Policy(policy *types.SrPolicy) (err error) {
 	} else if response.Retval != 0 {
 		return fmt.Errorf("Add SRv6Policy failed with retval %d", response.Retval)
 	}
+	/* The other segment lists are added to the policy one by one */
+	for i := 1; i < len(policy.SidLists); i++ {
+		err = v.addSRv6PolicySidList(policy, &policy.SidLists[i])
+		if err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (v *VppLink) addSRv6PolicySidList(policy *types.SrPolicy, sidList *types.Srv6SidList) (err error) {
+	response := &sr.SrPolicyModReply{}
+	request := &sr.SrPolicyMod{
+		BsidAddr:  policy.Bsid,
+		FibTable:  policy.FibTable,
+		Operation: sr_types.SR_POLICY_OP_API_ADD,
+		Weight:    sidList.Weight,
+		Sids: sr.Srv6SidList{
+			NumSids: sidList.NumSids,
+			Weight:  sidList.Weight,
+			Sids:    sidList.Sids,
+		},
+	}
+	err = v.GetChannel().SendRequest(request).ReceiveReply(response)
+	if err != nil {
+		return errors.Wrap(err, "Add SRv6Policy segment list failed")
+	} else if response.Retval != 0 {
+		return fmt.Errorf("Add SRv6Policy segment list failed with retval %d", response.Retval)
+	}
 	return err
 }
 
@@ -17,11 +17,13 @@ package vpplink
 
 import (
 	"fmt"
+	"strings"
 
 	"git.fd.io/govpp.git/adapter"
 	"git.fd.io/govpp.git/adapter/statsclient"
 	"github.com/pkg/errors"
 	interfaces "github.com/projectcalico/vpp-dataplane/vpplink/binapi/vppapi/interface"
+	"github.com/projectcalico/vpp-dataplane/vpplink/types"
 )
 
 func GetInterfaceStats(sc *statsclient.StatsClient) (ifNames adapter.NameStat, dumpStats []adapter.StatEntry, err error) {
@@ -55,3 +57,106 @@ func (v *VppLink) GetBufferStats() (uint32, uint32, uint32, error) {
 	}
 	return response.AvailableBuffers, response.CachedBuffers, response.UsedBuffers, nil
 }
+
+/* GetIPsecSAStats returns the counters of the IPsec SAs by stat index */
+func GetIPsecSAStats(sc *statsclient.StatsClient) (map[uint32]types.IPsecSACounters, error) {
+	dumpStats, err := sc.DumpStats("/net/ipsec/sa")
+	if err != nil {
+		return nil, errors.Wrapf(err, "dump stats failed")
+	}
+	counters := make(map[uint32]types.IPsecSACounters)
+	for _, sta := range dumpStats {
+		switch string(sta.Name) {
+		case "/net/ipsec/sa":
+			values, ok := sta.Data.(adapter.CombinedCounterStat)
+			if !ok {
+				continue
+			}
+			for worker := range values {
+				for statIndex, value := range values[worker] {
+					c := counters[uint32(statIndex)]
+					c.Packets += value[0]
+					c.Bytes += value[1]
+					counters[uint32(statIndex)] = c
+				}
+			}
+		case "/net/ipsec/sa/lost":
+			values, ok := sta.Data.(adapter.SimpleCounterStat)
+			if !ok {
+				continue
+			}
+			for worker := range values {
+				for statIndex, value := range values[worker] {
+					c := counters[uint32(statIndex)]
+					c.LostPackets += uint64(value)
+					counters[uint32(statIndex)] = c
+				}
+			}
+		}
+	}
+	return counters, nil
+}
+
+/**
+ * GetIPsecReplayDropStats returns how many packets the ESP decrypt nodes
+ * dropped as replayed. VPP only counts these per node, not per SA.
+ */
+func GetIPsecReplayDropStats(sc *statsclient.StatsClient) (ip4 uint64, ip6 uint64, err error) {
+	dumpStats, err := sc.DumpStats("/err/esp4-decrypt", "/err/esp6-decrypt")
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "dump stats failed")
+	}
+	for _, sta := range dumpStats {
+		name := string(sta.Name)
+		if !strings.HasSuffix(name, "/SA replayed packet") {
+			continue
+		}
+		values, ok := sta.Data.(adapter.ErrorStat)
+		if !ok {
+			continue
+		}
+		for _, value := range values {
+			if strings.HasPrefix(name, "/err/esp6") {
+				ip6 += uint64(value)
+			} else {
+				ip4 += uint64(value)
+			}
+		}
+	}
+	return ip4, ip6, nil
+}
+
+/* Interface counters of the packets VPP dropped on a tunnel, e.g. as too big for its MTU */
+var tunnelErrorCounters = []string{"/if/drops", "/if/tx-error"}
+
+/**
+ * GetTunnelErrorStats sums, per interface, the packets VPP dropped on the
+ * tunnels in dumpStats (as returned by GetInterfaceStats). VPP answers the
+ * packets too big for a tunnel with ICMP(v6) too big and counts them here.
+ */
+func GetTunnelErrorStats(dumpStats []adapter.StatEntry, swIfIndexes []uint32) map[uint32]uint64 {
+	errorStats := make(map[uint32]uint64, len(swIfIndexes))
+	for _, sta := range dumpStats {
+		if sta.Type != adapter.SimpleCounterVector {
+			continue
+		}
+		found := false
+		for _, name := range tunnelErrorCounters {
+			if string(sta.Name) == name {
+				found = true
+			}
+		}
+		if !found {
+			continue
+		}
+		values := sta.Data.(adapter.SimpleCounterStat)
+		for worker := range values {
+			for _, swIfIndex := range swIfIndexes {
+				if int(swIfIndex) < len(values[worker]) {
+					errorStats[swIfIndex] += uint64(values[worker][swIfIndex])
+				}
+			}
+		}
+	}
+	return errorStats
+}
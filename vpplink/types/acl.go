@@ -26,6 +26,8 @@ type ACLRule struct {
 	SrcPort uint16
 	DstPort uint16
 	Proto   IPProto
+	/* Also permit the return traffic of the sessions this rule matches */
+	Reflect bool
 }
 
 func (r *ACLRule) ToVppACLRule() acl_types.ACLRule {
@@ -39,6 +41,9 @@ func (r *ACLRule) ToVppACLRule() acl_types.ACLRule {
 		DstportOrIcmpcodeFirst: r.DstPort,
 		DstportOrIcmpcodeLast:  r.DstPort,
 	}
+	if r.Reflect {
+		rule.IsPermit = acl_types.ACL_ACTION_API_PERMIT_REFLECT
+	}
 	if r.SrcPort == 0 {
 		rule.SrcportOrIcmptypeLast = ^uint16(0)
 	}
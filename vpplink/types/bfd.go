@@ -0,0 +1,61 @@
+// Copyright (C) 2022 Cisco Systems Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"fmt"
+	"net"
+)
+
+type BFDState uint32
+
+const (
+	BFDStateAdminDown BFDState = iota
+	BFDStateDown
+	BFDStateInit
+	BFDStateUp
+)
+
+func (s BFDState) String() string {
+	switch s {
+	case BFDStateAdminDown:
+		return "admin-down"
+	case BFDStateDown:
+		return "down"
+	case BFDStateInit:
+		return "init"
+	case BFDStateUp:
+		return "up"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint32(s))
+	}
+}
+
+/* Timers are in microseconds, multihop sessions have an InvalidID SwIfIndex */
+type BFDSession struct {
+	SwIfIndex     uint32
+	LocalAddr     net.IP
+	PeerAddr      net.IP
+	DesiredMinTx  uint32
+	RequiredMinRx uint32
+	DetectMult    uint8
+	State         BFDState
+}
+
+func (s *BFDSession) String() string {
+	return fmt.Sprintf("[%d] %s->%s tx=%dus rx=%dus mult=%d state=%s",
+		s.SwIfIndex, s.LocalAddr, s.PeerAddr, s.DesiredMinTx, s.RequiredMinRx, s.DetectMult, s.State.String())
+}
@@ -16,6 +16,7 @@
 package types
 
 import (
+	"encoding/binary"
 	"fmt"
 	"net"
 	"strings"
@@ -50,6 +51,49 @@ const (
 	FlowHashFlowLabel IPFlowHash = IPFlowHash(vppip.IP_API_FLOW_HASH_FLOW_LABEL)
 )
 
+var ipFlowHashNames = []struct {
+	name string
+	hash IPFlowHash
+}{
+	{"src-ip", FlowHashSrcIP},
+	{"dst-ip", FlowHashDstIP},
+	{"src-port", FlowHashSrcPort},
+	{"dst-port", FlowHashDstPort},
+	{"proto", FlowHashProto},
+	{"reverse", FlowHashReverse},
+	{"symmetric", FlowHashSymetric},
+	{"flowlabel", FlowHashFlowLabel},
+}
+
+/* ParseIPFlowHash parses a comma separated list of fields, e.g. src-ip,dst-ip,proto */
+func ParseIPFlowHash(str string) (IPFlowHash, error) {
+	var ipFlowHash IPFlowHash
+	for _, field := range strings.Split(str, ",") {
+		found := false
+		for _, fh := range ipFlowHashNames {
+			if fh.name == strings.TrimSpace(field) {
+				ipFlowHash |= fh.hash
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown flow hash field %s", field)
+		}
+	}
+	return ipFlowHash, nil
+}
+
+func (h IPFlowHash) String() string {
+	fields := make([]string, 0)
+	for _, fh := range ipFlowHashNames {
+		if h&fh.hash != 0 {
+			fields = append(fields, fh.name)
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
 const (
 	// Family type definitions
 	FAMILY_ALL = unix.AF_UNSPEC
@@ -68,6 +112,40 @@ type IpPuntRedirect struct {
 	Paths       []RoutePath
 }
 
+/**
+ * Packets exchanged on punt sockets start with a descriptor, in host
+ * byte order. VPP fills it with the RX interface of punted packets,
+ * injected packets give the interface to send them on (L2) or whose
+ * VRF they are routed in.
+ */
+const (
+	PuntSocketHeaderVersion = 1
+	PuntPacketDescSize      = 8
+)
+
+type PuntAction uint32
+
+const (
+	PuntL2        PuntAction = 0
+	PuntIP4Routed PuntAction = 1
+	PuntIP6Routed PuntAction = 2
+)
+
+type PuntPacketDesc struct {
+	SwIfIndex uint32
+	Action    PuntAction
+}
+
+func (d *PuntPacketDesc) Encode(b []byte) {
+	binary.LittleEndian.PutUint32(b[0:4], d.SwIfIndex)
+	binary.LittleEndian.PutUint32(b[4:8], uint32(d.Action))
+}
+
+func (d *PuntPacketDesc) Decode(b []byte) {
+	d.SwIfIndex = binary.LittleEndian.Uint32(b[0:4])
+	d.Action = PuntAction(binary.LittleEndian.Uint32(b[4:8]))
+}
+
 type VRF struct {
 	Name  string
 	VrfID uint32
@@ -16,6 +16,7 @@
 package types
 
 import (
+	"math/bits"
 	"net"
 )
 
@@ -25,3 +26,57 @@ type IPsecTunnelProtection struct {
 	OutSAIndex  uint32
 	InSAIndices []uint32
 }
+
+/* IPsecSA is an ESP SA as dumped by VPP, with the sequence numbers used for anti-replay */
+type IPsecSA struct {
+	SadID          uint32
+	Spi            uint32
+	SwIfIndex      uint32
+	StatIndex      uint32
+	SeqOutbound    uint64
+	LastSeqInbound uint64
+	ReplayWindow   uint64
+}
+
+const IPsecReplayWindowSize = 64
+
+/**
+ * ReplayWindowMissed is how many of the sequence numbers in the anti-replay
+ * window, the last IPsecReplayWindowSize up to LastSeqInbound (bit 0 of
+ * ReplayWindow), were not received. Those received after leaving the
+ * window are dropped as replays.
+ */
+func (sa *IPsecSA) ReplayWindowMissed() int {
+	size := uint64(IPsecReplayWindowSize)
+	if sa.LastSeqInbound < size {
+		size = sa.LastSeqInbound
+	}
+	mask := ^uint64(0)
+	if size < IPsecReplayWindowSize {
+		mask = (uint64(1) << size) - 1
+	}
+	return int(size) - bits.OnesCount64(sa.ReplayWindow&mask)
+}
+
+/* IPsecSACounters are the per SA counters from the stats segment, summed over workers */
+type IPsecSACounters struct {
+	Packets     uint64
+	Bytes       uint64
+	LostPackets uint64
+}
+
+type IKEv2SA struct {
+	SaIndex      uint32
+	ProfileIndex uint32
+	Ispi         uint64
+	Rspi         uint64
+	Iaddr        net.IP
+	Raddr        net.IP
+
+	NKeepalives       uint16
+	NRekeyReq         uint16
+	NSaInitReq        uint16
+	NSaAuthReq        uint16
+	NRetransmit       uint16
+	NInitSaRetransmit uint16
+}
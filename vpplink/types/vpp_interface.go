@@ -98,3 +98,11 @@ func GetPciIdInt(PciIdStr string) (id uint32, err error) {
 	id = binary.LittleEndian.Uint32(b)
 	return id, nil
 }
+
+/* State change of an interface, sent by VPP on interface events */
+type InterfaceEvent struct {
+	SwIfIndex uint32
+	IsAdminUp bool
+	IsLinkUp  bool
+	Deleted   bool
+}
@@ -42,6 +42,9 @@ type WireguardPeer struct {
 	SwIfIndex           uint32
 	Index               uint32
 	AllowedIps          []net.IPNet
+	/* Handshake status, only filled when listing peers */
+	IsDead        bool
+	IsEstablished bool
 }
 
 func (t *WireguardPeer) allowedIpsMap() map[string]bool {
@@ -72,3 +72,21 @@ func (v *VppLink) GetNumVPPWorkers() (numVPPWorkers int, err error) {
 	}
 	return int(response.Count - 1), nil
 }
+
+/* Runs a VPP cli command and returns its output */
+func (v *VppLink) RunCli(cmd string) (reply string, err error) {
+	v.Lock()
+	defer v.Unlock()
+
+	response := &vlib.CliInbandReply{}
+	request := &vlib.CliInband{
+		Cmd: cmd,
+	}
+	err = v.GetChannel().SendRequest(request).ReceiveReply(response)
+	if err != nil {
+		return "", errors.Wrapf(err, "RunCli failed for '%s'", cmd)
+	} else if response.Retval != 0 {
+		return "", fmt.Errorf("RunCli failed for '%s' with retval %d", cmd, response.Retval)
+	}
+	return response.Reply, nil
+}
@@ -20,6 +20,7 @@ import (
 
 	"git.fd.io/govpp.git/binapi/vpe"
 	"github.com/pkg/errors"
+	"github.com/projectcalico/vpp-dataplane/vpplink/binapi/vppapi/memclnt"
 )
 
 func (v *VppLink) GetVPPVersion() (version string, err error) {
@@ -37,3 +38,20 @@ func (v *VppLink) GetVPPVersion() (version string, err error) {
 	}
 	return response.Version, nil
 }
+
+/* GetVPPPid returns the pid of the VPP process, as seen from the host */
+func (v *VppLink) GetVPPPid() (pid uint32, err error) {
+	v.Lock()
+	defer v.Unlock()
+
+	response := &memclnt.ControlPingReply{}
+	request := &memclnt.ControlPing{}
+
+	err = v.GetChannel().SendRequest(request).ReceiveReply(response)
+	if err != nil {
+		return 0, errors.Wrapf(err, "ControlPing failed: req %+v reply %+v", request, response)
+	} else if response.Retval != 0 {
+		return 0, fmt.Errorf("ControlPing failed (retval %d). Request: %+v", response.Retval, request)
+	}
+	return response.VpePID, nil
+}
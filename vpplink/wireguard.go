@@ -143,6 +143,8 @@ func (v *VppLink) ListWireguardPeers() ([]*types.WireguardPeer, error) {
 			SwIfIndex:           uint32(response.Peer.SwIfIndex),
 			PublicKey:           response.Peer.PublicKey,
 			AllowedIps:          allowedIps,
+			IsDead:              response.Peer.Flags&wireguard.WIREGUARD_PEER_STATUS_DEAD != 0,
+			IsEstablished:       response.Peer.Flags&wireguard.WIREGUARD_PEER_ESTABLISHED != 0,
 		})
 	}
 	return tunnels, nil